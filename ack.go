@@ -0,0 +1,305 @@
+package stomper
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AckHeader on SUBSCRIBE chooses how the client acknowledges messages: AckAuto (the default), AckClient
+// (cumulative) or AckClientIndividual. Messages to client-ack subscriptions carry an ack header with the id
+// to ACK or NACK.
+const AckHeader = "ack"
+
+const (
+	AckAuto             = "auto"
+	AckClient           = "client"
+	AckClientIndividual = "client-individual"
+)
+
+const defaultAckTimeout = 30 * time.Second
+
+// Acknowledger settles a message taken from an ack-capable source, such as a JetStream consumer, so the
+// source redelivers it unless stomper's subscribers have taken it.
+type Acknowledger interface {
+	Ack() error
+	Nack() error
+}
+
+// ackGroup tracks the client acknowledgements one published message is waiting for.
+type ackGroup struct {
+	source      Acknowledger
+	ids         []string
+	outstanding int
+	sealed      bool
+	settled     bool
+	done        chan struct{}
+}
+
+// finish marks the group settled, stopping its timeout; callers must hold the lock.
+func (group *ackGroup) finish() {
+	group.settled = true
+	if group.done != nil {
+		close(group.done)
+	}
+}
+
+type pendingAck struct {
+	group      *ackGroup
+	client     uint64
+	subId      string
+	seq        uint64
+	cumulative bool
+}
+
+// pendingAcks holds every delivered message a client hasn't acknowledged yet, by ack id, and indexed by
+// client uid and subscription id so a cumulative ACK or an UNSUBSCRIBE only visits that subscription's.
+type pendingAcks struct {
+	mux      sync.Mutex
+	seq      uint64
+	pending  map[string]*pendingAck
+	byClient map[uint64]map[string]map[string]*pendingAck
+}
+
+// track records an ack id a client owes; callers must hold the lock.
+func (acks *pendingAcks) track(id string, entry *pendingAck) {
+	if acks.pending == nil {
+		acks.pending = make(map[string]*pendingAck)
+		acks.byClient = make(map[uint64]map[string]map[string]*pendingAck)
+	}
+
+	acks.pending[id] = entry
+	subs, ok := acks.byClient[entry.client]
+	if !ok {
+		subs = make(map[string]map[string]*pendingAck)
+		acks.byClient[entry.client] = subs
+	}
+
+	if subs[entry.subId] == nil {
+		subs[entry.subId] = make(map[string]*pendingAck)
+	}
+
+	subs[entry.subId][id] = entry
+}
+
+// untrack stops tracking an ack id; callers must hold the lock.
+func (acks *pendingAcks) untrack(id string) {
+	entry, ok := acks.pending[id]
+	if !ok {
+		return
+	}
+
+	delete(acks.pending, id)
+	subs := acks.byClient[entry.client]
+	delete(subs[entry.subId], id)
+	if len(subs[entry.subId]) == 0 {
+		delete(subs, entry.subId)
+	}
+
+	if len(subs) == 0 {
+		delete(acks.byClient, entry.client)
+	}
+}
+
+// PublishWithAck broadcasts like SendMessageWithHeaders, then settles ack once the message is taken: acked
+// when every client-ack subscriber has acknowledged it (or straight away if there are none), and nacked as
+// soon as one of them NACKs, disconnects or doesn't answer within AckTimeout.
+func (server *Server) PublishWithAck(topic string, contentType string, body string, extraHeaders map[string]string, ack Acknowledger) {
+	server.publish(context.Background(), topic, contentType, body, extraHeaders, nil, &ackGroup{source: ack}, nil)
+}
+
+// rememberAckMode records a SUBSCRIBE's ack mode, for ackMode.
+func (server *Server) rememberAckMode(client *Client, subId string, headers map[string]string) {
+	switch headers[AckHeader] {
+	case AckClient:
+		client.ackModes.Store(subId, true)
+	case AckClientIndividual:
+		client.ackModes.Store(subId, false)
+	default:
+		client.ackModes.Delete(subId)
+	}
+}
+
+// ackMode reports whether a subscription acknowledges messages, and if so whether cumulatively.
+func (client *Client) ackMode(subId string) (cumulative bool, acked bool) {
+	value, ok := client.ackModes.Load(subId)
+	if !ok {
+		return false, false
+	}
+
+	return value.(bool), true
+}
+
+// expectAck allocates the ack id of a message to a client-ack subscription, tracking it if the message
+// came with an Acknowledger.
+func (server *Server) expectAck(group *ackGroup, client *Client, subId string, cumulative bool) string {
+	acks := &server.acks
+	acks.mux.Lock()
+	defer acks.mux.Unlock()
+
+	acks.seq++
+	id := strconv.FormatUint(acks.seq, 10)
+	if group == nil {
+		return id
+	}
+
+	acks.track(id, &pendingAck{group: group, client: client.Uid, subId: subId, seq: acks.seq, cumulative: cumulative})
+	group.ids = append(group.ids, id)
+	group.outstanding++
+	return id
+}
+
+// sealAck is called once a message has been handed to every subscriber, settling it if nothing is
+// outstanding and otherwise nacking it if it's still outstanding after AckTimeout.
+func (server *Server) sealAck(group *ackGroup) {
+	acks := &server.acks
+	acks.mux.Lock()
+	group.sealed = true
+	complete := group.outstanding == 0 && !group.settled
+	if complete {
+		group.finish()
+	} else if !group.settled {
+		group.done = make(chan struct{})
+	}
+	acks.mux.Unlock()
+
+	if complete {
+		server.settle(group, true)
+		return
+	}
+
+	if group.done == nil {
+		return
+	}
+
+	timeout := server.AckTimeout
+	if timeout <= 0 {
+		timeout = defaultAckTimeout
+	}
+
+	go func() {
+		timer := server.clock().NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case <-group.done:
+			return
+		case <-timer.C():
+		}
+
+		acks.mux.Lock()
+		expired := !group.settled
+		if expired {
+			group.finish()
+			acks.forget(group)
+		}
+		acks.mux.Unlock()
+
+		if expired {
+			server.Sugar.Debugf("message not acknowledged within %s", timeout)
+			server.settle(group, false)
+		}
+	}()
+}
+
+// acknowledge handles an ACK or NACK; in client mode it covers every earlier message to the subscription.
+// Ids the server isn't tracking, such as those of messages that didn't come from an ack-capable source,
+// are ignored.
+func (server *Server) acknowledge(client *Client, id string, ack bool) {
+	acks := &server.acks
+	acks.mux.Lock()
+	entry, ok := acks.pending[id]
+	if !ok || entry.client != client.Uid {
+		acks.mux.Unlock()
+		server.Sugar.Debugf("[%d] ignored acknowledgement of untracked message '%s'", client.Uid, id)
+		return
+	}
+
+	entries := []*pendingAck{entry}
+	if entry.cumulative {
+		for _, pending := range acks.byClient[client.Uid][entry.subId] {
+			if pending.seq < entry.seq {
+				entries = append(entries, pending)
+			}
+		}
+	}
+
+	settled := acks.resolve(entries, ack)
+	acks.mux.Unlock()
+
+	for group, ok := range settled {
+		server.settle(group, ok)
+	}
+}
+
+// dropAcks nacks whatever a client hasn't acknowledged on a subscription, or on all of them with an empty
+// subId, because it unsubscribed or disconnected.
+func (server *Server) dropAcks(client *Client, subId string) {
+	acks := &server.acks
+	acks.mux.Lock()
+	var entries []*pendingAck
+	for other, subAcks := range acks.byClient[client.Uid] {
+		if subId != "" && other != subId {
+			continue
+		}
+
+		for _, pending := range subAcks {
+			entries = append(entries, pending)
+		}
+	}
+
+	settled := acks.resolve(entries, false)
+	acks.mux.Unlock()
+
+	for group, ok := range settled {
+		server.settle(group, ok)
+	}
+}
+
+// resolve applies acknowledgements, returning the groups they settle; callers must hold the lock.
+func (acks *pendingAcks) resolve(entries []*pendingAck, ack bool) map[*ackGroup]bool {
+	settled := make(map[*ackGroup]bool)
+	for _, entry := range entries {
+		group := entry.group
+		if group.settled {
+			continue
+		}
+
+		if !ack {
+			group.finish()
+			acks.forget(group)
+			settled[group] = false
+			continue
+		}
+
+		acks.untrack(strconv.FormatUint(entry.seq, 10))
+		group.outstanding--
+		if group.sealed && group.outstanding == 0 {
+			group.finish()
+			settled[group] = true
+		}
+	}
+
+	return settled
+}
+
+// forget stops tracking a settled group's remaining ack ids; callers must hold the lock.
+func (acks *pendingAcks) forget(group *ackGroup) {
+	for _, id := range group.ids {
+		acks.untrack(id)
+	}
+}
+
+func (server *Server) settle(group *ackGroup, ack bool) {
+	var err error
+	if ack {
+		err = group.source.Ack()
+	} else {
+		err = group.source.Nack()
+	}
+
+	if err != nil {
+		server.Sugar.Warnf("unable to settle message with its source: %v", err)
+	}
+}
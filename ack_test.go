@@ -0,0 +1,89 @@
+package stomper
+
+import (
+	"testing"
+	"time"
+)
+
+// recordingAck is an Acknowledger reporting how it was settled on settled.
+type recordingAck struct {
+	settled chan bool
+}
+
+func newRecordingAck() *recordingAck {
+	return &recordingAck{settled: make(chan bool, 1)}
+}
+
+func (ack *recordingAck) Ack() error {
+	ack.settled <- true
+	return nil
+}
+
+func (ack *recordingAck) Nack() error {
+	ack.settled <- false
+	return nil
+}
+
+func (ack *recordingAck) expect(t *testing.T, want bool) {
+	t.Helper()
+	select {
+	case got := <-ack.settled:
+		if got != want {
+			t.Fatalf("expected the message settled with %v, got %v", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the message to be settled")
+	}
+}
+
+// publishAcked publishes count messages with their own acknowledgers, returning them and the ack ids the
+// client received.
+func publishAcked(t *testing.T, server *Server, c *testClient, count int) ([]*recordingAck, []string) {
+	t.Helper()
+	var acks []*recordingAck
+	var ids []string
+	for i := 0; i < count; i++ {
+		ack := newRecordingAck()
+		server.PublishWithAck("/topic/jobs", "text/plain", "job", nil, ack)
+		frame := c.read()
+		if frame.Command != Message || frame.Headers[AckHeader] == "" {
+			t.Fatalf("expected a message with an ack id, got %s %v", frame.Command, frame.Headers)
+		}
+
+		acks = append(acks, ack)
+		ids = append(ids, frame.Headers[AckHeader])
+	}
+
+	return acks, ids
+}
+
+func TestCumulativeAckSettlesEarlierMessages(t *testing.T) {
+	server, addr := newTestServer(t, nil)
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("jobs", "/topic/jobs", AckHeader+":"+AckClient)
+
+	acks, ids := publishAcked(t, server, c, 3)
+	c.send(Ack, []string{"id:" + ids[2]}, "")
+	for _, ack := range acks {
+		ack.expect(t, true)
+	}
+
+	server.acks.mux.Lock()
+	defer server.acks.mux.Unlock()
+	if len(server.acks.pending) != 0 || len(server.acks.byClient) != 0 {
+		t.Fatalf("expected nothing left pending, got %v", server.acks.byClient)
+	}
+}
+
+func TestUnsubscribingNacksOutstandingMessages(t *testing.T) {
+	server, addr := newTestServer(t, nil)
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("jobs", "/topic/jobs", AckHeader+":"+AckClientIndividual)
+
+	acks, ids := publishAcked(t, server, c, 2)
+	c.send(Ack, []string{"id:" + ids[0]}, "")
+	acks[0].expect(t, true)
+
+	c.send(Unsubscribe, []string{"id:jobs"}, "")
+	acks[1].expect(t, false)
+}
@@ -0,0 +1,137 @@
+package stomper
+
+import (
+	"fmt"
+)
+
+const (
+	ActionSubscribe = "subscribe"
+	ActionSend      = "send"
+)
+
+// Authorizer decides whether a client may perform an action (ActionSubscribe or ActionSend) on a destination.
+// Denied frames are rejected with an ERROR.
+type Authorizer interface {
+	Authorize(client *Client, action string, destination string) bool
+}
+
+type AuthorizerFunc func(client *Client, action string, destination string) bool
+
+func (f AuthorizerFunc) Authorize(client *Client, action string, destination string) bool {
+	return f(client, action, destination)
+}
+
+// ACLRule grants actions on destinations matching a DestinationTemplate, e.g. `/topic/admin/{rest*}`, to
+// identities with one of Roles or one of Principals. A rule without either applies to everyone, and one
+// without Actions to every action.
+type ACLRule struct {
+	Destination string
+	Actions     []string
+	Roles       []string
+	Principals  []string
+}
+
+// ACL is an Authorizer built from rules. The first rule matching the destination and action decides;
+// when none match, DefaultDeny decides.
+type ACL struct {
+	rules       []aclRule
+	DefaultDeny bool
+}
+
+type aclRule struct {
+	ACLRule
+	template *DestinationTemplate
+}
+
+func NewACL(rules ...ACLRule) (*ACL, error) {
+	acl := &ACL{}
+	for _, rule := range rules {
+		template, err := ParseDestinationTemplate(rule.Destination)
+		if err != nil {
+			return nil, fmt.Errorf("invalid acl rule (%s): %v", rule.Destination, err)
+		}
+
+		acl.rules = append(acl.rules, aclRule{ACLRule: rule, template: template})
+	}
+
+	return acl, nil
+}
+
+func (acl *ACL) Authorize(client *Client, action string, destination string) bool {
+	for _, rule := range acl.rules {
+		if !rule.appliesTo(action) {
+			continue
+		}
+
+		if _, ok := rule.template.Match(destination); !ok {
+			continue
+		}
+
+		return rule.grants(client.Identity)
+	}
+
+	return !acl.DefaultDeny
+}
+
+func (rule *aclRule) appliesTo(action string) bool {
+	if len(rule.Actions) == 0 {
+		return true
+	}
+
+	for _, a := range rule.Actions {
+		if a == action {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (rule *aclRule) grants(identity *Identity) bool {
+	if len(rule.Roles) == 0 && len(rule.Principals) == 0 {
+		return true
+	}
+
+	if identity == nil {
+		return false
+	}
+
+	for _, role := range rule.Roles {
+		if identity.HasRole(role) {
+			return true
+		}
+	}
+
+	for _, principal := range rule.Principals {
+		if identity.Principal == principal {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authorize returns a FrameError if the client's endpoint or the Authorizer denies the action.
+func (server *Server) authorize(client *Client, action string, destination string) error {
+	if !client.endpoint.allows(action, destination) {
+		return &FrameError{
+			Code:    ErrorCodeUnauthorized,
+			Message: fmt.Sprintf("endpoint doesn't allow %s to '%s'", action, destination),
+			Err:     ErrUnauthorized,
+		}
+	}
+
+	server.reloadMux.RLock()
+	authorizer := server.Authorizer
+	server.reloadMux.RUnlock()
+
+	if authorizer == nil || authorizer.Authorize(client, action, destination) {
+		return nil
+	}
+
+	return &FrameError{
+		Code:    ErrorCodeUnauthorized,
+		Message: fmt.Sprintf("not authorized to %s to '%s'", action, destination),
+		Err:     ErrUnauthorized,
+	}
+}
@@ -0,0 +1,110 @@
+package stomper
+
+import "encoding/json"
+
+const (
+	AdvisoryUnsubscribed    = "unsubscribed"
+	AdvisoryRetainedCleared = "retained-cleared"
+	AdvisorySnapshotRefresh = "snapshot-refresh"
+)
+
+// subscribersOf lists the (non-wildcard) subscriptions to destination.
+func (server *Server) subscribersOf(destination string) []activeSubscription {
+	shard := server.subscriptionShard(destination)
+	shard.mux.RLock()
+	defer shard.mux.RUnlock()
+
+	var subscribers []activeSubscription
+	for _, clientSubs := range shard.topics[destination] {
+		for subId, client := range clientSubs {
+			subscribers = append(subscribers, activeSubscription{client: client, topic: destination, subId: subId})
+		}
+	}
+
+	return subscribers
+}
+
+// patternSubscribersOf lists the wildcard subscriptions matching destination.
+func (server *Server) patternSubscribersOf(destination string) []activeSubscription {
+	server.patterns.mux.RLock()
+	defer server.patterns.mux.RUnlock()
+
+	var subscribers []activeSubscription
+	server.patterns.match(destination, func(subs map[uint64]map[string]*Client) {
+		for uid, clientSubs := range subs {
+			for subId, client := range clientSubs {
+				pattern := server.patterns.byClient[uid][subId]
+				subscribers = append(subscribers, activeSubscription{client: client, topic: pattern, subId: subId})
+			}
+		}
+	})
+
+	return subscribers
+}
+
+// UnsubscribeAll ends every subscription to destination, telling each client with an unsubscribed advisory,
+// and returns how many were ended. Use it to stop a destination carrying bad data.
+func (server *Server) UnsubscribeAll(destination string) int {
+	server.init()
+	ended := 0
+	for _, sub := range server.subscribersOf(destination) {
+		if !server.endSubscription(sub.client, sub.topic, sub.subId) {
+			continue
+		}
+
+		ended++
+		for _, handler := range server.unsubscribeHandlers {
+			handler(sub.client, sub.topic)
+		}
+
+		server.sendAdvisory(sub.client, sub.topic, sub.subId, AdvisoryUnsubscribed, "unsubscribed by an administrator")
+	}
+
+	server.Sugar.Infof("unsubscribed %d subscriptions from '%s'", ended, destination)
+	return ended
+}
+
+// ClearRetained forgets the values retained for a composite destination, or the message retained for one of
+// RetainDestinations, so new subscribers get no snapshot until it's published to again, and notifies current
+// subscribers with a retained-cleared advisory. It returns false if destination is neither.
+func (server *Server) ClearRetained(destination string) bool {
+	server.init()
+	c, ok := server.composites[destination]
+	if ok {
+		c.mux.Lock()
+		c.values = make(map[string]json.RawMessage)
+		c.current = nil
+		c.mux.Unlock()
+	} else if server.isRetained(destination) {
+		if err := server.RetentionStore.Clear(destination); err != nil {
+			server.Sugar.Warnf("unable to clear retained message of '%s': %v", destination, err)
+			return false
+		}
+	} else {
+		return false
+	}
+
+	for _, sub := range server.subscribersOf(destination) {
+		server.sendAdvisory(sub.client, sub.topic, sub.subId, AdvisoryRetainedCleared, "retained values cleared")
+	}
+
+	server.Sugar.Infof("cleared retained values of '%s'", destination)
+	return true
+}
+
+// RefreshSnapshots resends the current value of a composite destination to all of its subscribers, each
+// preceded by a snapshot-refresh advisory, and returns how many were refreshed.
+func (server *Server) RefreshSnapshots(destination string) int {
+	server.init()
+	if _, ok := server.composites[destination]; !ok {
+		return 0
+	}
+
+	subscribers := server.subscribersOf(destination)
+	for _, sub := range subscribers {
+		server.sendAdvisory(sub.client, sub.topic, sub.subId, AdvisorySnapshotRefresh, "snapshot refreshed")
+		server.sendCompositeSnapshot(sub.client, sub.topic, sub.subId)
+	}
+
+	return len(subscribers)
+}
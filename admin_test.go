@@ -0,0 +1,56 @@
+package stomper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUnsubscribeAllStopsLiveQueries(t *testing.T) {
+	query := newLiveQuery()
+	server, addr := newTestServer(t, func(server *Server) {
+		server.QueryProvider = query
+	})
+
+	c := dialTestClient(t, addr).connect()
+	subscribeQuery(t, c, query)
+
+	if ended := server.UnsubscribeAll("/query/orders?status=open"); ended != 1 {
+		t.Fatalf("expected one subscription ended, got %d", ended)
+	}
+
+	expectAdvisory(t, c, "q", AdvisoryUnsubscribed)
+	query.stopped(t)
+	c.quiet(50 * time.Millisecond)
+}
+
+// onlyClient returns the one client connected to server.
+func onlyClient(t *testing.T, server *Server) *Client {
+	t.Helper()
+	server.clientMux.RLock()
+	defer server.clientMux.RUnlock()
+	if len(server.clients) != 1 {
+		t.Fatalf("expected one client, got %d", len(server.clients))
+	}
+
+	for _, client := range server.clients {
+		return client
+	}
+
+	return nil
+}
+
+func TestUnsubscribeAllStopsDigests(t *testing.T) {
+	server, addr := newTestServer(t, nil)
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("d", "/topic/ticks", DigestHeader+":60")
+	client := onlyClient(t, server)
+	if client.digestCount.Load() != 1 {
+		t.Fatal("expected a digest subscription")
+	}
+
+	server.UnsubscribeAll("/topic/ticks")
+	expectAdvisory(t, c, "d", AdvisoryUnsubscribed)
+	if _, ok := client.digests.Load("d"); ok || client.digestCount.Load() != 0 {
+		t.Fatal("expected the digest to be stopped")
+	}
+}
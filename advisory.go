@@ -0,0 +1,40 @@
+package stomper
+
+import "strconv"
+
+// AdvisoryHeader is set on MESSAGE frames the server generates itself to tell a client about a change to
+// its session, rather than frames carrying published data.
+const AdvisoryHeader = "advisory"
+
+const (
+	AdvisorySubscriptionRevoked = "subscription-revoked"
+)
+
+// sendAdvisory sends an advisory MESSAGE on one of the client's subscriptions.
+func (server *Server) sendAdvisory(client *Client, destination string, subId string, advisory string, text string) {
+	server.sendAdvisoryWithHeaders(client, destination, subId, advisory, text, nil)
+}
+
+func (server *Server) sendAdvisoryWithHeaders(client *Client, destination string, subId string, advisory string, text string, extraHeaders map[string]string) {
+	body := []byte(text)
+	headers := make(map[string]string, len(extraHeaders)+5)
+	for k, v := range extraHeaders {
+		headers[k] = v
+	}
+
+	headers[AdvisoryHeader] = advisory
+	headers["subscription"] = subId
+	headers["destination"] = destination
+	headers["content-type"] = "text/plain"
+	headers["content-length"] = strconv.Itoa(len(body))
+
+	message := StompMessage{
+		Command: Message,
+		Headers: headers,
+		Body:    &body,
+	}
+
+	if err := server.writeFrame(client, message.payload(client.Version)); err != nil {
+		server.Sugar.Warnf("[%d] unable to write %s advisory: %v", client.Uid, advisory, err)
+	}
+}
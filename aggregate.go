@@ -0,0 +1,82 @@
+package stomper
+
+import (
+	"fmt"
+	"sort"
+)
+
+// AggregateSourceHeader names the destination a message was originally published to when it is delivered
+// through an aggregate destination.
+const AggregateSourceHeader = "aggregate-source"
+
+// AddAggregate defines a virtual destination which receives everything published to each of the sources,
+// e.g. AddAggregate("/topic/all-orders", "/topic/orders.eu", "/topic/orders.us"). Sources may themselves be
+// aggregates, but not in a way that makes an aggregate include itself.
+func (server *Server) AddAggregate(destination string, sources ...string) error {
+	if server.setup {
+		return fmt.Errorf("unable to add aggregate after %w", ErrAlreadySetup)
+	}
+
+	if len(sources) == 0 {
+		return fmt.Errorf("aggregate '%s' has no sources", destination)
+	}
+
+	if server.aggregates == nil {
+		server.aggregates = make(map[string][]string)
+	}
+
+	for _, source := range sources {
+		if source == destination || server.aggregateIncludes(source, destination, make(map[string]bool)) {
+			return fmt.Errorf("aggregate '%s' would include itself through '%s'", destination, source)
+		}
+	}
+
+	for _, source := range sources {
+		server.aggregates[source] = append(server.aggregates[source], destination)
+	}
+
+	return nil
+}
+
+// aggregateIncludes reports whether aggregate already receives (directly or transitively) from source.
+func (server *Server) aggregateIncludes(aggregate string, source string, visited map[string]bool) bool {
+	if visited[source] {
+		return false
+	}
+
+	visited[source] = true
+	for _, target := range server.aggregates[source] {
+		if target == aggregate || server.aggregateIncludes(aggregate, target, visited) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// aggregatesOf returns every aggregate destination a message published to topic must also reach.
+func (server *Server) aggregatesOf(topic string) []string {
+	if len(server.aggregates) == 0 {
+		return nil
+	}
+
+	seen := map[string]bool{topic: true}
+	queue := []string{topic}
+	var result []string
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, aggregate := range server.aggregates[current] {
+			if seen[aggregate] {
+				continue
+			}
+
+			seen[aggregate] = true
+			result = append(result, aggregate)
+			queue = append(queue, aggregate)
+		}
+	}
+
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,73 @@
+package stomper
+
+import (
+	"sync"
+)
+
+// MovedToHeader on a destination-moved advisory names the destination to subscribe to instead.
+const MovedToHeader = "x-moved-to"
+
+const AdvisoryDestinationMoved = "destination-moved"
+
+const maxAliasDepth = 8
+
+type destinationAliases struct {
+	mux     sync.RWMutex
+	aliases map[string]string
+}
+
+// MoveDestination renames a destination during a rollout. Messages sent to from are delivered to to instead,
+// and each subscription to from is ended with a final destination-moved advisory carrying an x-moved-to
+// header, so clients can resubscribe to the new name; later subscriptions to from get the same advisory.
+// Wildcard subscriptions matching from are ended the same way. It returns how many subscriptions were ended.
+func (server *Server) MoveDestination(from string, to string) int {
+	server.init()
+	server.aliases.mux.Lock()
+	if server.aliases.aliases == nil {
+		server.aliases.aliases = make(map[string]string)
+	}
+
+	server.aliases.aliases[from] = to
+	server.aliases.mux.Unlock()
+
+	ended := 0
+	subscriptions := append(server.subscribersOf(from), server.patternSubscribersOf(from)...)
+	for _, sub := range subscriptions {
+		if !server.endSubscription(sub.client, sub.topic, sub.subId) {
+			continue
+		}
+
+		ended++
+		for _, handler := range server.unsubscribeHandlers {
+			handler(sub.client, sub.topic)
+		}
+
+		server.sendMovedAdvisory(sub.client, sub.topic, sub.subId, to)
+	}
+
+	server.Sugar.Infof("moved '%s' to '%s', ending %d subscriptions", from, to, ended)
+	return ended
+}
+
+// resolveAlias follows moved destinations to the current name.
+func (server *Server) resolveAlias(destination string) string {
+	server.aliases.mux.RLock()
+	defer server.aliases.mux.RUnlock()
+
+	for i := 0; i < maxAliasDepth; i++ {
+		to, ok := server.aliases.aliases[destination]
+		if !ok {
+			break
+		}
+
+		destination = to
+	}
+
+	return destination
+}
+
+func (server *Server) sendMovedAdvisory(client *Client, destination string, subId string, to string) {
+	server.sendAdvisoryWithHeaders(client, destination, subId, AdvisoryDestinationMoved, "destination moved to "+to, map[string]string{
+		MovedToHeader: to,
+	})
+}
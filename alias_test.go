@@ -0,0 +1,37 @@
+package stomper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMoveDestinationEndsExactAndWildcardSubscriptions(t *testing.T) {
+	server, addr := newTestServer(t, nil)
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("d", "/topic/old", DigestHeader+":60")
+	c.subscribe("w", "/topic/*")
+	client := onlyClient(t, server)
+
+	if ended := server.MoveDestination("/topic/old", "/topic/new"); ended != 2 {
+		t.Fatalf("expected two subscriptions ended, got %d", ended)
+	}
+
+	for _, subId := range []string{"d", "w"} {
+		if frame := expectAdvisory(t, c, subId, AdvisoryDestinationMoved); frame.Headers[MovedToHeader] != "/topic/new" {
+			t.Fatalf("expected %s to point at /topic/new, got %v", subId, frame.Headers)
+		}
+	}
+
+	if client.digestCount.Load() != 0 {
+		t.Fatal("expected the digest to be stopped")
+	}
+
+	server.patterns.mux.RLock()
+	patterns := len(server.patterns.byClient[client.Uid])
+	server.patterns.mux.RUnlock()
+	if patterns != 0 {
+		t.Fatalf("expected no wildcard subscriptions left, got %d", patterns)
+	}
+
+	c.quiet(50 * time.Millisecond)
+}
@@ -0,0 +1,118 @@
+package stomper
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AnalyticsEvent describes a sampled message without its contents. PayloadHash is a hex SHA-256 (or
+// HMAC-SHA256 with AnalyticsConfig.Key) of the body, so duplicates can be counted without seeing the data.
+type AnalyticsEvent struct {
+	Time        time.Time
+	Destination string
+	ContentType string
+	Size        int
+	PayloadHash string
+
+	// SampleRate is N when this event stands for every Nth message to the destination.
+	SampleRate int
+}
+
+// AnalyticsSink receives sampled events, e.g. to feed a metrics pipeline. It is called on the publishing
+// goroutine, so it should hand events off rather than block.
+type AnalyticsSink interface {
+	Observe(event AnalyticsEvent)
+}
+
+type AnalyticsSinkFunc func(event AnalyticsEvent)
+
+func (f AnalyticsSinkFunc) Observe(event AnalyticsEvent) {
+	f(event)
+}
+
+type AnalyticsRule struct {
+	// Destinations with this prefix are sampled every Every messages. Zero or less turns sampling off.
+	Prefix string
+	Every  int
+}
+
+type AnalyticsConfig struct {
+	Sink AnalyticsSink
+
+	// Every is the sample rate for destinations no rule matches. Zero or less samples nothing.
+	Every int
+
+	// Rules override Every for destination prefixes; the longest matching prefix wins.
+	Rules []AnalyticsRule
+
+	// Key, when set, keys the payload hash so that it can't be reversed by hashing guessed payloads.
+	Key []byte
+
+	mux    sync.Mutex
+	counts map[string]int
+}
+
+func (config *AnalyticsConfig) rate(destination string) int {
+	every, matched := config.Every, -1
+	for _, rule := range config.Rules {
+		if strings.HasPrefix(destination, rule.Prefix) && len(rule.Prefix) > matched {
+			every, matched = rule.Every, len(rule.Prefix)
+		}
+	}
+
+	return every
+}
+
+// sampled counts a message to destination and reports whether it is the Nth one.
+func (config *AnalyticsConfig) sampled(destination string, every int) bool {
+	config.mux.Lock()
+	defer config.mux.Unlock()
+	if config.counts == nil {
+		config.counts = make(map[string]int)
+	}
+
+	count := config.counts[destination] + 1
+	if count >= every {
+		count = 0
+	}
+
+	config.counts[destination] = count
+	return count == 0
+}
+
+func (config *AnalyticsConfig) hash(body string) string {
+	if len(config.Key) == 0 {
+		sum := sha256.Sum256([]byte(body))
+		return hex.EncodeToString(sum[:])
+	}
+
+	mac := hmac.New(sha256.New, config.Key)
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sampleAnalytics passes every Nth message published to a destination to the analytics sink.
+func (server *Server) sampleAnalytics(destination string, contentType string, body string) {
+	config := server.Analytics
+	if config == nil || config.Sink == nil {
+		return
+	}
+
+	every := config.rate(destination)
+	if every <= 0 || !config.sampled(destination, every) {
+		return
+	}
+
+	config.Sink.Observe(AnalyticsEvent{
+		Time:        server.clock().Now(),
+		Destination: destination,
+		ContentType: contentType,
+		Size:        len(body),
+		PayloadHash: config.hash(body),
+		SampleRate:  every,
+	})
+}
@@ -0,0 +1,117 @@
+package stomper
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MinimumVersionHeader is set on the upgrade-required ERROR, and on CONNECTED for read-only clients, to the
+// minimum version.
+const MinimumVersionHeader = "min-app-version"
+
+// ClientVersionPolicy gates clients by the application version they send on CONNECT, to coordinate breaking
+// frontend changes. Versions are compared numerically segment by segment, so 1.10 is newer than 1.9; anything
+// after a '-' or '+' is ignored.
+type ClientVersionPolicy struct {
+	Minimum string
+
+	// Header is the CONNECT header holding the version (default app-version)
+	Header string
+
+	// AllowMissing lets clients which don't send a version in
+	AllowMissing bool
+
+	// ReadOnly lets outdated clients connect and subscribe; only their SENDs are refused, with an
+	// upgrade-required ERROR that leaves the connection open
+	ReadOnly bool
+}
+
+func (policy *ClientVersionPolicy) header() string {
+	if policy.Header == "" {
+		return "app-version"
+	}
+
+	return policy.Header
+}
+
+// outdated reports whether a client with the given CONNECT headers is older than the minimum version.
+func (policy *ClientVersionPolicy) outdated(headers map[string]string) bool {
+	version, ok := headers[policy.header()]
+	if !ok || version == "" {
+		return !policy.AllowMissing
+	}
+
+	return compareVersions(version, policy.Minimum) < 0
+}
+
+func (policy *ClientVersionPolicy) upgradeRequired(version string) *FrameError {
+	message := fmt.Sprintf("app version %s is older than %s, upgrade required", version, policy.Minimum)
+	if version == "" {
+		message = fmt.Sprintf("app version %s or newer required", policy.Minimum)
+	}
+
+	return &FrameError{
+		Code:    ErrorCodeUpgradeRequired,
+		Message: message,
+		Headers: map[string]string{MinimumVersionHeader: policy.Minimum},
+		Err:     ErrUpgradeRequired,
+	}
+}
+
+// checkClientVersion applies Server.ClientVersion on CONNECT, returning the ERROR to refuse the client with.
+func (server *Server) checkClientVersion(client *Client) *FrameError {
+	policy := server.ClientVersion
+	if policy == nil || !policy.outdated(client.Headers) {
+		return nil
+	}
+
+	version := client.Headers[policy.header()]
+	if policy.ReadOnly {
+		server.Sugar.Debugf("[%d] app version '%s' is outdated, connecting read-only", client.Uid, version)
+		client.readOnly = true
+		return nil
+	}
+
+	return policy.upgradeRequired(version)
+}
+
+// compareVersions compares dotted numeric versions, returning -1, 0 or 1; non-numeric segments count as 0.
+func compareVersions(a string, b string) int {
+	as, bs := versionSegments(a), versionSegments(b)
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x = as[i]
+		}
+
+		if i < len(bs) {
+			y = bs[i]
+		}
+
+		if x != y {
+			if x < y {
+				return -1
+			}
+
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func versionSegments(version string) []int {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+
+	var segments []int
+	for _, segment := range strings.Split(version, ".") {
+		n, _ := strconv.Atoi(segment)
+		segments = append(segments, n)
+	}
+
+	return segments
+}
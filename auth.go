@@ -0,0 +1,102 @@
+package stomper
+
+import (
+	"net/http"
+	"time"
+)
+
+// Identity is who a client authenticated as. It is stored on Client.Identity for later handlers.
+type Identity struct {
+	Principal string
+	Roles     []string
+
+	// ExpiresAt, when set, ends the session: the client is sent an ERROR and disconnected once it passes.
+	ExpiresAt time.Time
+}
+
+func (identity *Identity) HasRole(role string) bool {
+	if identity == nil {
+		return false
+	}
+
+	for _, r := range identity.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Credentials are what a client presented when connecting.
+type Credentials struct {
+	Login    string
+	Passcode string
+
+	// Headers are the CONNECT frame headers and Request the websocket upgrade request, for authenticators
+	// that use something other than login and passcode.
+	Headers map[string]string
+	Request *http.Request
+
+	// Clock is the server's, for authenticators checking expiry.
+	Clock Clock
+}
+
+// Authenticator decides whether a CONNECT is allowed, before any connect handlers run. Returning an error
+// refuses the connection with an ERROR frame.
+type Authenticator interface {
+	Authenticate(client *Client, credentials Credentials) (*Identity, error)
+}
+
+type AuthenticatorFunc func(client *Client, credentials Credentials) (*Identity, error)
+
+func (f AuthenticatorFunc) Authenticate(client *Client, credentials Credentials) (*Identity, error) {
+	return f(client, credentials)
+}
+
+// authenticate runs the Authenticator, if any, storing the identity on the client. The client's endpoint may
+// have its own Authenticator, and may require an identity.
+func (server *Server) authenticate(client *Client, request *http.Request, headers map[string]string) error {
+	authenticator := server.Authenticator
+	if client.endpoint != nil && client.endpoint.config.Authenticator != nil {
+		authenticator = client.endpoint.config.Authenticator
+	}
+
+	if authenticator == nil {
+		return client.endpoint.checkIdentity(client)
+	}
+
+	identity, err := authenticator.Authenticate(client, Credentials{
+		Login:    headers["login"],
+		Passcode: headers["passcode"],
+		Headers:  headers,
+		Request:  request,
+		Clock:    server.clock(),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	client.Identity = identity
+	return client.endpoint.checkIdentity(client)
+}
+
+// expireSession disconnects the client when its identity expires, unless it has already gone.
+func (server *Server) expireSession(client *Client, expiresAt time.Time) {
+	timer := server.clock().NewTimer(expiresAt.Sub(server.clock().Now()))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+	case <-client.done:
+		return
+	}
+
+	server.Sugar.Infof("[%d] session expired", client.Uid)
+	expired := &FrameError{Code: ErrorCodeUnauthorized, Message: "session expired", Err: ErrUnauthorized}
+	server.reportError(client, expired)
+	server.sendFrameError(client, expired, nil)
+	server.flush(client, time.Second)
+	_ = client.transport.Close()
+}
@@ -0,0 +1,63 @@
+package stomper
+
+import (
+	"context"
+	"time"
+)
+
+const capacityCheckInterval = 100 * time.Millisecond
+
+// saturated reports whether at least SourceBackpressure of the connected clients have congested outbound
+// queues.
+func (server *Server) saturated() bool {
+	if server.SourceBackpressure <= 0 {
+		return false
+	}
+
+	server.clientMux.RLock()
+	defer server.clientMux.RUnlock()
+	if len(server.clients) == 0 {
+		return false
+	}
+
+	congested := 0
+	for _, client := range server.clients {
+		if client.congested.Load() {
+			congested++
+		}
+	}
+
+	return float64(congested) >= server.SourceBackpressure*float64(len(server.clients))
+}
+
+// WaitForCapacity returns once the server can take more messages from a data source: straight away, unless
+// SourceBackpressure is set and that share of clients are falling behind, in which case it waits until
+// enough have caught up, or returns ctx's error if ctx is done first. Sources which pull from upstream call
+// it before each pull, so that messages wait upstream rather than in the queues of clients which can't keep
+// up; the pauses are counted in the stomper_source_pauses_total and stomper_source_paused_seconds_total
+// metrics.
+func (server *Server) WaitForCapacity(ctx context.Context) error {
+	if !server.saturated() {
+		return nil
+	}
+
+	start := server.clock().Now()
+	server.Sugar.Debugf("pausing data source, too many clients are falling behind")
+	defer func() {
+		server.metrics.sourcePaused(server.clock().Now().Sub(start))
+	}()
+
+	ticker := server.clock().NewTicker(capacityCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C():
+		}
+
+		if !server.saturated() {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package stomper
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitForCapacityPausesWhileClientsFallBehind(t *testing.T) {
+	server, addr := newTestServer(t, func(server *Server) {
+		server.SourceBackpressure = 0.5
+	})
+
+	dialTestClient(t, addr).connect()
+	client := onlyClient(t, server)
+	if err := server.WaitForCapacity(context.Background()); err != nil {
+		t.Fatalf("expected no wait while the client keeps up, got %v", err)
+	}
+
+	client.congested.Store(true)
+	waited := make(chan error, 1)
+	go func() {
+		waited <- server.WaitForCapacity(context.Background())
+	}()
+
+	select {
+	case err := <-waited:
+		t.Fatalf("expected the source to be paused, got %v", err)
+	case <-time.After(250 * time.Millisecond):
+	}
+
+	client.congested.Store(false)
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the source to resume once the client caught up")
+	}
+
+	var metrics strings.Builder
+	server.writeMetrics(&metrics)
+	if !strings.Contains(metrics.String(), "stomper_source_pauses_total 1\n") {
+		t.Fatalf("expected the pause to be counted, got\n%s", metrics.String())
+	}
+}
+
+func TestWaitForCapacityGivesUpWithItsContext(t *testing.T) {
+	server, addr := newTestServer(t, func(server *Server) {
+		server.SourceBackpressure = 1
+	})
+
+	dialTestClient(t, addr).connect()
+	onlyClient(t, server).congested.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := server.WaitForCapacity(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected the context's error, got %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package stomper
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HopsHeader counts how many times a message has been relayed by the simple broker, so that clients or
+// handlers which re-publish what they receive can't bounce a message around forever.
+const HopsHeader = "stomper-hops"
+
+const defaultMaxBrokerHops = 8
+
+// frame headers that describe the SEND itself rather than the message, and so aren't relayed to subscribers
+var sendOnlyHeaders = map[string]bool{
+	"destination":    true,
+	"content-type":   true,
+	"content-length": true,
+	"receipt":        true,
+	"transaction":    true,
+}
+
+// headers the server sets on the messages it delivers, which a client mustn't be able to forge on a SEND
+var reservedHeaders = map[string]bool{
+	"subscription":          true,
+	"message-id":            true,
+	AckHeader:               true,
+	AdvisoryHeader:          true,
+	AggregateSourceHeader:   true,
+	DigestCountHeader:       true,
+	DigestDroppedHeader:     true,
+	EventIdHeader:           true,
+	MovedToHeader:           true,
+	OutboxIdHeader:          true,
+	ReplayedHeader:          true,
+	RetainedHeader:          true,
+	ServerReceiveTimeHeader: true,
+	ServerTimeHeader:        true,
+	ShadowOfHeader:          true,
+}
+
+// stripReservedHeaders removes the reserved headers from an inbound SEND, before message handlers, the hop
+// count checks or the relay see it.
+func stripReservedHeaders(headers map[string]string) {
+	for name := range reservedHeaders {
+		delete(headers, name)
+	}
+}
+
+// EnableSimpleBroker relays SEND frames whose destination starts with one of the prefixes to that destination's
+// subscribers, after the message handlers have run.
+func (server *Server) EnableSimpleBroker(prefixes ...string) error {
+	if server.setup {
+		return fmt.Errorf("unable to enable simple broker after %w", ErrAlreadySetup)
+	}
+
+	server.brokerPrefixes = append(server.brokerPrefixes, prefixes...)
+	return nil
+}
+
+func (server *Server) isBrokerDestination(destination string) bool {
+	for _, prefix := range server.brokerPrefixes {
+		if strings.HasPrefix(destination, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NextHop returns headers for re-publishing a received message with SendMessageWithHeaders, carrying its hop
+// count on so the broker's loop detection still applies. The message's own headers aren't copied.
+func NextHop(message *StompMessage, headers map[string]string) map[string]string {
+	next := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		next[k] = v
+	}
+
+	if hops, err := hopsOf(message.Headers); err == nil {
+		next[HopsHeader] = strconv.Itoa(hops + 1)
+	} else {
+		// an invalid count is passed on as it is, for publish to drop, rather than reset
+		next[HopsHeader] = message.Headers[HopsHeader]
+	}
+
+	return next
+}
+
+func hopsOf(headers map[string]string) (int, error) {
+	value, ok := headers[HopsHeader]
+	if !ok {
+		return 0, nil
+	}
+
+	hops, err := strconv.Atoi(value)
+	if err != nil || hops < 0 {
+		return 0, fmt.Errorf("invalid %s header (%s)", HopsHeader, value)
+	}
+
+	return hops, nil
+}
+
+func (server *Server) maxBrokerHops() int {
+	if server.MaxBrokerHops <= 0 {
+		return defaultMaxBrokerHops
+	}
+
+	return server.MaxBrokerHops
+}
+
+// checkHops stops publish broadcasting a message which has already been relayed more than MaxBrokerHops times,
+// whether by the simple broker or by a handler re-publishing with NextHop.
+func (server *Server) checkHops(topic string, headers map[string]string) bool {
+	hops, err := hopsOf(headers)
+	if err != nil {
+		server.Sugar.Warnf("%v, not broadcasting to '%s'", err, topic)
+		return false
+	}
+
+	if hops > server.maxBrokerHops() {
+		server.Sugar.Warnf("loop detected, dropping message to '%s' after %d hops", topic, hops)
+		return false
+	}
+
+	return true
+}
+
+func (server *Server) relay(client *Client, destination string, message *StompMessage) {
+	if !server.isBrokerDestination(destination) {
+		return
+	}
+
+	hops, err := hopsOf(message.Headers)
+	if err != nil {
+		server.Sugar.Warnf("[%d] %v, not relaying to '%s'", client.Uid, err, destination)
+		return
+	}
+
+	if hops >= server.maxBrokerHops() {
+		server.Sugar.Warnf("[%d] loop detected, dropping message to '%s' after %d hops", client.Uid, destination, hops)
+		return
+	}
+
+	headers := make(map[string]string)
+	for k, v := range message.Headers {
+		if !sendOnlyHeaders[k] {
+			headers[k] = v
+		}
+	}
+
+	headers[HopsHeader] = strconv.Itoa(hops + 1)
+
+	var check func(*Client) bool
+	if server.BrokerExcludeSender {
+		check = func(recipient *Client) bool {
+			return recipient != client
+		}
+	}
+
+	contentType, ok := message.Headers["content-type"]
+	if !ok {
+		contentType = "text/plain"
+	}
+
+	var body string
+	if message.Body != nil {
+		body = string(*message.Body)
+	}
+
+	server.Sugar.Debugf("[%d] relaying to '%s'", client.Uid, destination)
+	server.SendMessageWithHeaders(destination, contentType, body, headers, check)
+}
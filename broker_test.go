@@ -0,0 +1,123 @@
+package stomper
+
+import (
+	"testing"
+	"time"
+)
+
+// echo subscribes to destination and sends every MESSAGE it receives back to sendTo, hop count and all, returning
+// the hop counts it saw once the messages stop.
+func echo(c *testClient, destination string, sendTo string) []string {
+	c.subscribe("echo", destination)
+	c.send("SEND", []string{"destination:" + sendTo}, "ping")
+
+	var hops []string
+	for {
+		frame, err := c.next(200 * time.Millisecond)
+		if err != nil {
+			return hops
+		}
+
+		if frame.Command != Message {
+			continue
+		}
+
+		hops = append(hops, frame.Headers[HopsHeader])
+		if len(hops) > 20 {
+			return hops
+		}
+
+		c.send("SEND", []string{"destination:" + sendTo, HopsHeader + ":" + frame.Headers[HopsHeader]}, "ping")
+	}
+}
+
+func TestSimpleBrokerStopsRelayLoops(t *testing.T) {
+	_, addr := newTestServer(t, func(server *Server) {
+		server.MaxBrokerHops = 3
+		_ = server.EnableSimpleBroker("/topic/")
+	})
+
+	hops := echo(dialTestClient(t, addr).connect(), "/topic/ping", "/topic/ping")
+	if len(hops) != 3 || hops[0] != "1" || hops[2] != "3" {
+		t.Fatalf("expected the message relayed 3 times, got hops %v", hops)
+	}
+}
+
+func TestHandlersRepublishingWithNextHopStopLoops(t *testing.T) {
+	_, addr := newTestServer(t, func(server *Server) {
+		server.MaxBrokerHops = 3
+		_ = server.AddMessageHandler(func(client *Client, destination string, message *StompMessage) {
+			if destination == "/app/ping" {
+				server.SendMessageWithHeaders("/topic/pong", "text/plain", "pong", NextHop(message, nil), nil)
+			}
+		})
+	})
+
+	hops := echo(dialTestClient(t, addr).connect(), "/topic/pong", "/app/ping")
+	if len(hops) != 3 || hops[0] != "1" || hops[2] != "3" {
+		t.Fatalf("expected the message re-published 3 times, got hops %v", hops)
+	}
+}
+
+func TestPublishDropsInvalidHopCounts(t *testing.T) {
+	server, addr := newTestServer(t, nil)
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("sub", "/topic/pong")
+
+	message := &StompMessage{Headers: map[string]string{HopsHeader: "many"}}
+	server.SendMessageWithHeaders("/topic/pong", "text/plain", "pong", NextHop(message, map[string]string{"x": "y"}), nil)
+	c.quiet(100 * time.Millisecond)
+}
+
+func TestSimpleBrokerDropsForgedServerHeaders(t *testing.T) {
+	_, addr := newTestServer(t, func(server *Server) {
+		_ = server.EnableSimpleBroker("/topic/")
+	})
+
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("chat", "/topic/chat")
+	c.send("SEND", []string{
+		"destination:/topic/chat",
+		"x-colour:red",
+		AdvisoryHeader + ":" + AdvisorySubscriptionRevoked,
+		AckHeader + ":forged",
+		RetainedHeader + ":true",
+		MovedToHeader + ":/topic/elsewhere",
+		AggregateSourceHeader + ":/topic/other",
+		DigestCountHeader + ":100",
+		ServerTimeHeader + ":0",
+		"message-id:forged",
+	}, "hello")
+
+	frame := c.read()
+	if frame.Command != Message || frame.Headers["x-colour"] != "red" {
+		t.Fatalf("expected the relayed message, got %s %v", frame.Command, frame.Headers)
+	}
+
+	for name := range reservedHeaders {
+		if value, ok := frame.Headers[name]; ok && name != "subscription" {
+			t.Errorf("expected no %s header, got %q", name, value)
+		}
+	}
+}
+
+func TestMessageHandlersDontSeeForgedServerHeaders(t *testing.T) {
+	received := make(chan map[string]string, 1)
+	_, addr := newTestServer(t, func(server *Server) {
+		_ = server.AddMessageHandler(func(client *Client, destination string, message *StompMessage) {
+			received <- message.Headers
+		})
+	})
+
+	c := dialTestClient(t, addr).connect()
+	c.send("SEND", []string{"destination:/app/ping", "x-colour:red", AdvisoryHeader + ":forged", RetainedHeader + ":true"}, "ping")
+
+	select {
+	case headers := <-received:
+		if headers["x-colour"] != "red" || headers[AdvisoryHeader] != "" || headers[RetainedHeader] != "" {
+			t.Fatalf("expected only the client's own headers, got %v", headers)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the message handler to run")
+	}
+}
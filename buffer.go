@@ -0,0 +1,63 @@
+package stomper
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+var nullTerminator = []byte{0x00}
+
+// buffers larger than this aren't returned to the pool, so one huge message doesn't pin memory forever
+const maxPooledBufferSize = 1 << 20
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		data := make([]byte, 0, 1024)
+		return &data
+	},
+}
+
+// sharedBuffer is a pooled, reference counted message body shared by every recipient of a broadcast, so a
+// body is copied once per broadcast rather than once per subscriber. Each holder calls release once it has
+// written the body; the last release returns the buffer to the pool.
+type sharedBuffer struct {
+	data *[]byte
+	refs atomic.Int32
+
+	// binary is set for bodies which aren't UTF-8 and so need a binary websocket message
+	binary bool
+}
+
+func newSharedBuffer(body string) *sharedBuffer {
+	data := bufferPool.Get().(*[]byte)
+	*data = append((*data)[:0], body...)
+
+	buffer := &sharedBuffer{data: data}
+	buffer.refs.Store(1)
+	return buffer
+}
+
+func (buffer *sharedBuffer) bytes() []byte {
+	return *buffer.data
+}
+
+func (buffer *sharedBuffer) retain() {
+	buffer.refs.Add(1)
+}
+
+func (buffer *sharedBuffer) release() {
+	refs := buffer.refs.Add(-1)
+	if refs > 0 {
+		return
+	}
+
+	if refs < 0 {
+		panic("stomper: shared buffer released too many times")
+	}
+
+	if cap(*buffer.data) <= maxPooledBufferSize {
+		bufferPool.Put(buffer.data)
+	}
+
+	buffer.data = nil
+}
@@ -0,0 +1,151 @@
+package stomper
+
+import (
+	"bufio"
+	"bytes"
+	"go.uber.org/zap"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer sets up a server, configured by configure, listening for TCP clients on a local port.
+func newTestServer(t *testing.T, configure func(server *Server)) (*Server, string) {
+	t.Helper()
+	server := &Server{Sugar: zap.NewNop().Sugar()}
+	if configure != nil {
+		configure(server)
+	}
+
+	server.Setup()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go server.ServeTCP(listener)
+	return server, listener.Addr().String()
+}
+
+// testClient is a raw STOMP client over TCP.
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialTestClient(t *testing.T, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("unable to dial: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// connect sends a CONNECT and waits for CONNECTED.
+func (c *testClient) connect(headers ...string) *testClient {
+	c.t.Helper()
+	c.send("CONNECT", append([]string{"accept-version:1.2"}, headers...), "")
+	if frame := c.read(); frame.Command != Connected {
+		c.t.Fatalf("expected CONNECTED, got %s %v", frame.Command, frame.Headers)
+	}
+
+	return c
+}
+
+// subscribe subscribes with a receipt, and waits for it.
+func (c *testClient) subscribe(id string, destination string, headers ...string) {
+	c.t.Helper()
+	c.send("SUBSCRIBE", append([]string{"id:" + id, "destination:" + destination, "receipt:sub-" + id}, headers...), "")
+	if frame := c.read(); frame.Command != Receipt {
+		c.t.Fatalf("expected RECEIPT, got %s %v", frame.Command, frame.Headers)
+	}
+}
+
+func (c *testClient) send(command string, headers []string, body string) {
+	c.t.Helper()
+	frame := command + "\n" + strings.Join(headers, "\n")
+	if len(headers) > 0 {
+		frame += "\n"
+	}
+
+	if _, err := c.conn.Write([]byte(frame + "\n" + body + "\x00")); err != nil {
+		c.t.Fatalf("unable to write: %v", err)
+	}
+}
+
+// read returns the next frame, failing the test if none arrives within a second.
+func (c *testClient) read() *StompMessage {
+	c.t.Helper()
+	frame, err := c.next(time.Second)
+	if err != nil {
+		c.t.Fatalf("expected a frame: %v", err)
+	}
+
+	return frame
+}
+
+// quiet fails the test if a frame arrives within d.
+func (c *testClient) quiet(d time.Duration) {
+	c.t.Helper()
+	if frame, err := c.next(d); err == nil {
+		c.t.Fatalf("expected no frame, got %s %v", frame.Command, frame.Headers)
+	}
+}
+
+// closed fails the test unless the server closes the connection within a second, skipping frames until then.
+func (c *testClient) closed() {
+	c.t.Helper()
+	for {
+		if _, err := c.next(time.Second); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				c.t.Fatalf("expected the connection to close")
+			}
+
+			return
+		}
+	}
+}
+
+func (c *testClient) next(d time.Duration) (*StompMessage, error) {
+	_ = c.conn.SetReadDeadline(time.Now().Add(d))
+	for {
+		raw, err := c.reader.ReadBytes(0)
+		if err != nil {
+			return nil, err
+		}
+
+		raw = bytes.TrimLeft(raw, "\r\n")
+		if len(raw) <= 1 {
+			continue
+		}
+
+		return parseTestFrame(raw), nil
+	}
+}
+
+// parseTestFrame parses a frame from the server without unescaping its headers.
+func parseTestFrame(raw []byte) *StompMessage {
+	head, body, _ := bytes.Cut(bytes.TrimSuffix(raw, []byte{0}), []byte("\n\n"))
+	lines := strings.Split(string(head), "\n")
+	headers := make(map[string]string)
+	for _, line := range lines[1:] {
+		name, value, _ := strings.Cut(line, ":")
+		if _, ok := headers[name]; !ok {
+			headers[name] = value
+		}
+	}
+
+	return &StompMessage{Command: StompCommand(lines[0]), Headers: headers, Body: &body}
+}
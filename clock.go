@@ -0,0 +1,93 @@
+package stomper
+
+import "time"
+
+// Clock is the source of time for heart-beats, TTLs, delays and rate limiting. It defaults to the system clock;
+// tests can substitute stompertest.FakeClock to drive time-dependent behaviour deterministically.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+type systemClock struct{}
+
+// SystemClock is the Clock backed by the time package.
+var SystemClock Clock = systemClock{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// clockUser is implemented by the server's collaborators which keep time of their own, so Setup can hand them
+// the server's Clock when they weren't given one.
+type clockUser interface {
+	useClock(clock Clock)
+}
+
+// shareClock gives the server's Clock to the authenticator, session store and recorder.
+func (server *Server) shareClock() {
+	for _, collaborator := range []any{server.Authenticator, server.SessionStore, server.Recorder} {
+		if user, ok := collaborator.(clockUser); ok {
+			user.useClock(server.clock())
+		}
+	}
+}
+
+// clockOf returns the Clock of a data source's publisher, when it has one.
+func clockOf(publisher Publisher) Clock {
+	if server, ok := publisher.(*Server); ok {
+		return server.clock()
+	}
+
+	return SystemClock
+}
+
+func (server *Server) clock() Clock {
+	if server.Clock == nil {
+		return SystemClock
+	}
+
+	return server.Clock
+}
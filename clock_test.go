@@ -0,0 +1,221 @@
+package stomper_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/hfoxy/stomper"
+	"github.com/hfoxy/stomper/stompertest"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var epoch = time.Unix(1_700_000_000, 0)
+
+// waitForWaiters waits until code under test has armed n timers or tickers on clock.
+func waitForWaiters(t *testing.T, clock *stompertest.FakeClock, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for clock.Waiters() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d timers to be armed, got %d", n, clock.Waiters())
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHeartBeatsFollowTheServerClock(t *testing.T) {
+	clock := stompertest.NewFakeClock(epoch)
+	server := &stomper.Server{Sugar: zap.NewNop().Sugar(), Clock: clock, HeartBeatSend: time.Second, HeartBeatReceive: time.Second}
+	server.Setup()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go server.ServeTCP(listener)
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	waiters := clock.Waiters()
+	reader := bufio.NewReader(conn)
+	_, _ = conn.Write([]byte("CONNECT\naccept-version:1.2\nheart-beat:1000,1000\n\n\x00"))
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	connected, err := reader.ReadString(0)
+	if err != nil || !strings.Contains(connected, "\nheart-beat:1000,1000\n") {
+		t.Fatalf("expected CONNECTED agreeing to heart-beats every second, got %q (%v)", connected, err)
+	}
+
+	waitForWaiters(t, clock, waiters+1)
+	clock.Advance(600 * time.Millisecond)
+	if b, err := reader.ReadByte(); err != nil || b != '\n' {
+		t.Fatalf("expected a heart-beat, got %q (%v)", b, err)
+	}
+
+	// silent for longer than the receive interval plus the grace period
+	clock.Advance(2 * time.Second)
+	for {
+		if _, err = reader.ReadByte(); err != nil {
+			break
+		}
+	}
+
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("expected the connection to be closed, got %v", err)
+	}
+}
+
+func TestTicketsExpireByTheClock(t *testing.T) {
+	clock := stompertest.NewFakeClock(epoch)
+	auth := &stomper.TicketAuthenticator{
+		Identify: func(*http.Request) (*stomper.Identity, error) {
+			return &stomper.Identity{Principal: "alice"}, nil
+		},
+		TTL:   30 * time.Second,
+		Clock: clock,
+	}
+
+	issue := func() string {
+		recorder := httptest.NewRecorder()
+		auth.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/ticket", nil))
+		var response struct {
+			Ticket string `json:"ticket"`
+		}
+
+		if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil || response.Ticket == "" {
+			t.Fatalf("expected a ticket, got %q (%v)", recorder.Body.String(), err)
+		}
+
+		return response.Ticket
+	}
+
+	redeem := func(ticket string) error {
+		_, err := auth.Authenticate(&stomper.Client{}, stomper.Credentials{Headers: map[string]string{stomper.TicketHeader: ticket}})
+		return err
+	}
+
+	fresh, stale := issue(), issue()
+	clock.Advance(29 * time.Second)
+	if err := redeem(fresh); err != nil {
+		t.Fatalf("expected the ticket to be redeemed within its TTL: %v", err)
+	}
+
+	if err := redeem(fresh); err == nil {
+		t.Fatal("expected a ticket to be redeemed only once")
+	}
+
+	clock.Advance(2 * time.Second)
+	if err := redeem(stale); err == nil {
+		t.Fatal("expected the ticket to have expired")
+	}
+}
+
+func TestSessionStoreRetentionFollowsTheClock(t *testing.T) {
+	clock := stompertest.NewFakeClock(epoch)
+	store := &stomper.MemorySessionStore{Retention: time.Hour, Clock: clock}
+	if err := store.SaveSession(stomper.SessionTimeline{Session: 1, Ended: epoch}); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(59 * time.Minute)
+	if _, ok, _ := store.Session(1); !ok {
+		t.Fatal("expected the session within its retention")
+	}
+
+	clock.Advance(2 * time.Minute)
+	if _, ok, _ := store.Session(1); ok {
+		t.Fatal("expected the session to have expired")
+	}
+}
+
+func TestSetupSharesTheServerClock(t *testing.T) {
+	clock := stompertest.NewFakeClock(epoch)
+	store, recorder := &stomper.MemorySessionStore{}, &stomper.FileRecorder{}
+	auth := &stomper.TicketAuthenticator{}
+	server := &stomper.Server{Sugar: zap.NewNop().Sugar(), Clock: clock, SessionStore: store, Recorder: recorder, Authenticator: auth}
+	server.Setup()
+
+	if store.Clock != clock || recorder.Clock != clock || auth.Clock != clock {
+		t.Fatal("expected Setup to hand the server's clock to the session store, recorder and authenticator")
+	}
+}
+
+func TestRecordingsAreStampedByTheClock(t *testing.T) {
+	clock := stompertest.NewFakeClock(epoch)
+	recorder, err := stomper.NewFileRecorder(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder.Clock = clock
+	client := &stomper.Client{Uid: 7}
+	recorder.Record(client, stomper.DirectionInbound, []byte("CONNECT\n\n\x00"))
+	recorder.Close(client)
+
+	data, err := os.ReadFile(filepath.Join(recorder.Dir, "session-7-1700000000.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var frame stomper.RecordedFrame
+	if err = json.Unmarshal(data, &frame); err != nil || !frame.Time.Equal(epoch) {
+		t.Fatalf("expected the frame stamped %s, got %+v (%v)", epoch, frame, err)
+	}
+}
+
+type failingRunner chan struct{}
+
+func (runner failingRunner) Run(context.Context) error {
+	runner <- struct{}{}
+	return errors.New("unavailable")
+}
+
+func TestDataSourceBackoffFollowsTheClock(t *testing.T) {
+	clock := stompertest.NewFakeClock(epoch)
+	server := &stomper.Server{Sugar: zap.NewNop().Sugar(), Clock: clock}
+	runs := make(failingRunner, 1)
+	source := stomper.RunDataSource("flaky", runs)
+	if err := source.Start(context.Background(), server); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(source.Stop)
+
+	<-runs
+	for _, backoff := range []time.Duration{time.Second, 2 * time.Second} {
+		waitForWaiters(t, clock, 1)
+		clock.Advance(backoff - time.Millisecond)
+		select {
+		case <-runs:
+			t.Fatalf("expected the source to wait %s before restarting", backoff)
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		clock.Advance(time.Millisecond)
+		select {
+		case <-runs:
+		case <-time.After(time.Second):
+			t.Fatalf("expected the source to restart after %s", backoff)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package stomper
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const cloudEventsSpecVersion = "1.0"
+
+type CloudEventsConfig struct {
+	// Source is the ce-source of events published by this server, e.g. "//stomper.example.com".
+	Source string
+
+	// Type is the ce-type of events which don't already carry one. Defaults to "stomper.message".
+	Type string
+}
+
+// CloudEvent is a CloudEvents 1.0 event in structured JSON mode.
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            *time.Time      `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      string          `json:"data_base64,omitempty"`
+}
+
+// ParseCloudEvent decodes and validates a structured-mode JSON CloudEvent, e.g. one received by a data source.
+func ParseCloudEvent(data []byte) (*CloudEvent, error) {
+	var event CloudEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, fmt.Errorf("invalid cloud event: %v", err)
+	}
+
+	if event.SpecVersion != cloudEventsSpecVersion {
+		return nil, fmt.Errorf("unsupported cloud event specversion (%s)", event.SpecVersion)
+	}
+
+	if event.ID == "" || event.Source == "" || event.Type == "" {
+		return nil, fmt.Errorf("cloud event missing id, source or type")
+	}
+
+	return &event, nil
+}
+
+// Payload returns the event's data and its content type, decoding data_base64 and unwrapping JSON strings
+// for non-JSON content types.
+func (event *CloudEvent) Payload() ([]byte, string, error) {
+	contentType := event.DataContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+
+	if event.DataBase64 != "" {
+		data, err := base64.StdEncoding.DecodeString(event.DataBase64)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid cloud event data_base64: %v", err)
+		}
+
+		return data, contentType, nil
+	}
+
+	if !strings.Contains(contentType, "json") && len(event.Data) > 0 && event.Data[0] == '"' {
+		var text string
+		if err := json.Unmarshal(event.Data, &text); err != nil {
+			return nil, "", fmt.Errorf("invalid cloud event data: %v", err)
+		}
+
+		return []byte(text), contentType, nil
+	}
+
+	return event.Data, contentType, nil
+}
+
+// Headers returns the event's attributes as CloudEvents STOMP binary-mode headers.
+func (event *CloudEvent) Headers() map[string]string {
+	headers := map[string]string{
+		"ce-specversion": event.SpecVersion,
+		"ce-id":          event.ID,
+		"ce-source":      event.Source,
+		"ce-type":        event.Type,
+	}
+
+	if event.Subject != "" {
+		headers["ce-subject"] = event.Subject
+	}
+
+	if event.Time != nil {
+		headers["ce-time"] = event.Time.UTC().Format(time.RFC3339Nano)
+	}
+
+	return headers
+}
+
+// SendCloudEvent broadcasts the event's data to the destination, carrying its attributes as ce-* headers.
+func (server *Server) SendCloudEvent(destination string, event *CloudEvent) error {
+	if !server.setup {
+		return ErrNotSetup
+	}
+
+	payload, contentType, err := event.Payload()
+	if err != nil {
+		return err
+	}
+
+	server.SendMessageWithHeaders(destination, contentType, string(payload), event.Headers(), nil)
+	return nil
+}
+
+// cloudEventHeaders adds ce-* headers for the configured CloudEvents source, keeping any the caller already set.
+func (server *Server) cloudEventHeaders(destination string, headers map[string]string) map[string]string {
+	config := server.CloudEvents
+	if config == nil {
+		return headers
+	}
+
+	eventType := config.Type
+	if eventType == "" {
+		eventType = "stomper.message"
+	}
+
+	defaults := map[string]string{
+		"ce-specversion": cloudEventsSpecVersion,
+		"ce-id":          newEventId(),
+		"ce-source":      config.Source,
+		"ce-type":        eventType,
+		"ce-subject":     destination,
+		"ce-time":        server.clock().Now().UTC().Format(time.RFC3339Nano),
+	}
+
+	for k, v := range headers {
+		defaults[k] = v
+	}
+
+	return defaults
+}
+
+func newEventId() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
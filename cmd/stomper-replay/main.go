@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"github.com/gorilla/websocket"
+	"github.com/hfoxy/stomper"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+var url = flag.String("url", "ws://localhost:8448/wss/websocket", "websocket url of the server to replay against")
+var file = flag.String("file", "", "session recording (jsonl) to replay")
+var speed = flag.Float64("speed", 1, "replay speed multiplier; 0 sends frames as fast as possible")
+var quiet = flag.Bool("quiet", false, "don't print frames received from the server")
+
+func readRecording(path string) ([]stomper.RecordedFrame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var frames []stomper.RecordedFrame
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var frame stomper.RecordedFrame
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			return nil, err
+		}
+
+		if frame.Direction == stomper.DirectionInbound {
+			frames = append(frames, frame)
+		}
+	}
+
+	return frames, scanner.Err()
+}
+
+func printable(payload []byte) string {
+	return strings.ReplaceAll(string(payload), "\x00", "^@")
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.Ltime | log.Lmicroseconds)
+
+	if *file == "" {
+		log.Fatal("-file is required")
+	}
+
+	frames, err := readRecording(*file)
+	if err != nil {
+		log.Fatalf("unable to read recording: %v", err)
+	}
+
+	dialer := websocket.Dialer{Subprotocols: []string{"v12.stomp", "v11.stomp", "v10.stomp"}}
+	conn, _, err := dialer.Dial(*url, nil)
+	if err != nil {
+		log.Fatalf("unable to connect: %v", err)
+	}
+
+	defer conn.Close()
+
+	go func() {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				log.Printf("connection closed: %v", err)
+				os.Exit(0)
+			}
+
+			if !*quiet {
+				log.Printf("<<< %s", printable(message))
+			}
+		}
+	}()
+
+	var previous time.Time
+	for _, frame := range frames {
+		if *speed > 0 && !previous.IsZero() {
+			time.Sleep(time.Duration(float64(frame.Time.Sub(previous)) / *speed))
+		}
+
+		previous = frame.Time
+		log.Printf(">>> %s", printable([]byte(frame.Payload)))
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(frame.Payload)); err != nil {
+			log.Fatalf("unable to write: %v", err)
+		}
+	}
+
+	// give the server a moment to answer the final frames before hanging up
+	time.Sleep(time.Second)
+}
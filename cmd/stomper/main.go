@@ -0,0 +1,153 @@
+// Command stomper provides tooling for projects built on the stomper library.
+//
+//	stomper init [-module example.com/app] [-force] <dir>
+//
+// init scaffolds a ready-to-run server wired with the recommended options.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+const usage = `usage: stomper <command> [arguments]
+
+commands:
+  init [-module path] [-force] <dir>   scaffold a new stomper server in dir
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "init":
+		if err := initProject(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "stomper init: %v\n", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+type scaffold struct {
+	Module string
+}
+
+func initProject(args []string) error {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	module := flags.String("module", "", "module path for the generated go.mod (defaults to the directory name)")
+	force := flags.Bool("force", false, "overwrite existing files")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected exactly one directory")
+	}
+
+	dir := flags.Arg(0)
+	data := scaffold{Module: *module}
+	if data.Module == "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+
+		data.Module = filepath.Base(abs)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	for name, content := range scaffoldFiles {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil && !*force {
+			return fmt.Errorf("%s already exists, use -force to overwrite", path)
+		}
+
+		tmpl, err := template.New(name).Parse(content)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+
+		err = tmpl.Execute(file, data)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("created %s\n", path)
+	}
+
+	fmt.Printf("\nnext steps:\n  cd %s\n  go mod tidy\n  go run .\n", dir)
+	return nil
+}
+
+var scaffoldFiles = map[string]string{
+	"go.mod": `module {{.Module}}
+
+go 1.20
+`,
+	"main.go": `package main
+
+import (
+	"flag"
+	"github.com/hfoxy/stomper"
+	"log"
+	"net/http"
+	"strings"
+)
+
+var addr = flag.String("addr", "localhost:8448", "http service address")
+
+func main() {
+	flag.Parse()
+
+	server := &stomper.Server{
+		Compression:      true,
+		Strict:           true,
+		TrustedProxies:   []string{"127.0.0.1", "::1"},
+		ConnectRateLimit: &stomper.ConnectRateLimit{Attempts: 30},
+	}
+
+	// relay client SENDs on /topic/ straight to subscribers; handle anything else in AddMessageHandler
+	_ = server.EnableSimpleBroker("/topic/")
+
+	_ = server.AddConnectHandler(func(client *stomper.Client, header http.Header, message *stomper.StompMessage) bool {
+		// authenticate the client here, e.g. with message.Headers["login"] and message.Headers["passcode"]
+		return true
+	})
+
+	_ = server.AddSubscribeHandler(func(client *stomper.Client, destination string) bool {
+		// authorize subscriptions here
+		return strings.HasPrefix(destination, "/topic/")
+	})
+
+	server.Setup()
+
+	http.HandleFunc("/ws", server.WssHandler)
+	http.HandleFunc("/version", stomper.VersionHandler)
+	http.HandleFunc("/health", func(writer http.ResponseWriter, _ *http.Request) {
+		_, _ = writer.Write([]byte("ok"))
+	})
+
+	log.Printf("listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+`,
+}
@@ -0,0 +1,336 @@
+package stomper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AcceptHeader lists, on CONNECT or SUBSCRIBE, the content types a client prefers JSON messages in, most
+// preferred first, e.g. `accept:application/msgpack`. A SUBSCRIBE's accept header overrides the CONNECT one.
+const AcceptHeader = "accept"
+
+// Codec re-encodes JSON message bodies for clients which prefer another content type. Encode is given the
+// body as decoded by encoding/json with UseNumber: nil, bool, string, json.Number, []any and map[string]any.
+type Codec interface {
+	ContentType() string
+	Encode(value any) ([]byte, error)
+}
+
+var (
+	// MsgpackCodec encodes JSON bodies as MessagePack, application/msgpack.
+	MsgpackCodec Codec = msgpackCodec{}
+
+	// CBORCodec encodes JSON bodies as CBOR, application/cbor.
+	CBORCodec Codec = cborCodec{}
+)
+
+func isJSONContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// codecFor picks the codec a JSON body is re-encoded with for a subscription, or nil to send it as it is.
+func (server *Server) codecFor(client *Client, subId string, contentType string) Codec {
+	if len(server.Codecs) == 0 || !isJSONContentType(contentType) {
+		return nil
+	}
+
+	accept := client.Headers[AcceptHeader]
+	if value, ok := client.accepts.Load(subId); ok {
+		accept = value.(string)
+	}
+
+	for _, preferred := range strings.Split(accept, ",") {
+		preferred, _, _ = strings.Cut(preferred, ";")
+		preferred = strings.TrimSpace(preferred)
+		if isJSONContentType(preferred) || preferred == "*/*" {
+			return nil
+		}
+
+		for _, codec := range server.Codecs {
+			if strings.EqualFold(codec.ContentType(), preferred) {
+				return codec
+			}
+		}
+	}
+
+	return nil
+}
+
+// rememberAccept records a SUBSCRIBE's accept header, for codecFor. Where codecs aren't enabled, an empty
+// accept is recorded instead, so the subscription gets messages as they were sent.
+func (server *Server) rememberAccept(client *Client, destination string, subId string, headers map[string]string) {
+	if len(server.Codecs) == 0 {
+		client.accepts.Delete(subId)
+	} else if !server.extensionEnabled(FeatureCodecs, destination, client) {
+		client.accepts.Store(subId, "")
+	} else if accept, ok := headers[AcceptHeader]; ok {
+		client.accepts.Store(subId, accept)
+	} else {
+		client.accepts.Delete(subId)
+	}
+}
+
+// transcode re-encodes a JSON body with a codec.
+func transcode(codec Codec, body []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("invalid json: %v", err)
+	}
+
+	return codec.Encode(value)
+}
+
+// encode returns the broadcast's body re-encoded with a codec, encoding it once for every recipient; nil if it
+// can't be, in which case the body is sent as JSON.
+func (b *broadcast) encode(server *Server, codec Codec) *sharedBuffer {
+	contentType := codec.ContentType()
+	if encoded, ok := b.encoded[contentType]; ok {
+		return encoded
+	}
+
+	if b.encoded == nil {
+		b.encoded = make(map[string]*sharedBuffer)
+	}
+
+	var encoded *sharedBuffer
+	if body, err := transcode(codec, b.body.bytes()); err != nil {
+		server.Sugar.Debugf("unable to encode message as %s: %v", contentType, err)
+	} else {
+		encoded = newSharedBuffer(string(body))
+		encoded.binary = true
+	}
+
+	b.encoded[contentType] = encoded
+	return encoded
+}
+
+// jsonNumber converts a json.Number to an int64, a uint64 or, failing those, a float64.
+func jsonNumber(number json.Number) (any, error) {
+	if i, err := strconv.ParseInt(string(number), 10, 64); err == nil {
+		return i, nil
+	}
+
+	if u, err := strconv.ParseUint(string(number), 10, 64); err == nil {
+		return u, nil
+	}
+
+	return strconv.ParseFloat(string(number), 64)
+}
+
+func objectKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+func (codec msgpackCodec) Encode(value any) ([]byte, error) {
+	return codec.append(nil, value)
+}
+
+func (codec msgpackCodec) append(data []byte, value any) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(data, 0xc0), nil
+	case bool:
+		if v {
+			return append(data, 0xc3), nil
+		}
+
+		return append(data, 0xc2), nil
+	case json.Number:
+		number, err := jsonNumber(v)
+		if err != nil {
+			return nil, err
+		}
+
+		return codec.append(data, number)
+	case int64:
+		return msgpackInt(data, v), nil
+	case uint64:
+		return binary.BigEndian.AppendUint64(append(data, 0xcf), v), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(data, 0xcb), math.Float64bits(v)), nil
+	case string:
+		data = msgpackLength(data, len(v), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		return append(data, v...), nil
+	case []any:
+		data = msgpackLength(data, len(v), 0x90, 16, 0, 0xdc, 0xdd)
+		var err error
+		for _, item := range v {
+			if data, err = codec.append(data, item); err != nil {
+				return nil, err
+			}
+		}
+
+		return data, nil
+	case map[string]any:
+		data = msgpackLength(data, len(v), 0x80, 16, 0, 0xde, 0xdf)
+		var err error
+		for _, key := range objectKeys(v) {
+			data = codec.appendString(data, key)
+			if data, err = codec.append(data, v[key]); err != nil {
+				return nil, err
+			}
+		}
+
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("unable to encode %T as msgpack", value)
+}
+
+func (codec msgpackCodec) appendString(data []byte, value string) []byte {
+	data, _ = codec.append(data, value)
+	return data
+}
+
+func msgpackInt(data []byte, v int64) []byte {
+	switch {
+	case v >= 0 && v <= math.MaxInt8:
+		return append(data, byte(v))
+	case v >= -32 && v < 0:
+		return append(data, byte(v))
+	case v >= 0 && v <= math.MaxUint8:
+		return append(data, 0xcc, byte(v))
+	case v >= 0 && v <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(data, 0xcd), uint16(v))
+	case v >= 0 && v <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(data, 0xce), uint32(v))
+	case v >= 0:
+		return binary.BigEndian.AppendUint64(append(data, 0xcf), uint64(v))
+	case v >= math.MinInt8:
+		return append(data, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(data, 0xd1), uint16(v))
+	case v >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(data, 0xd2), uint32(v))
+	}
+
+	return binary.BigEndian.AppendUint64(append(data, 0xd3), uint64(v))
+}
+
+// msgpackLength appends a string, array or map header: the fix format for lengths under fixLimit, otherwise
+// the 8 bit (strings only), 16 bit or 32 bit format.
+func msgpackLength(data []byte, length int, fix byte, fixLimit int, format8 byte, format16 byte, format32 byte) []byte {
+	switch {
+	case length < fixLimit:
+		return append(data, fix|byte(length))
+	case format8 != 0 && length <= math.MaxUint8:
+		return append(data, format8, byte(length))
+	case length <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(data, format16), uint16(length))
+	}
+
+	return binary.BigEndian.AppendUint32(append(data, format32), uint32(length))
+}
+
+type cborCodec struct{}
+
+func (cborCodec) ContentType() string {
+	return "application/cbor"
+}
+
+func (codec cborCodec) Encode(value any) ([]byte, error) {
+	return codec.append(nil, value)
+}
+
+// CBOR major types
+const (
+	cborUnsigned = 0
+	cborNegative = 1
+	cborText     = 3
+	cborArray    = 4
+	cborMap      = 5
+)
+
+func (codec cborCodec) append(data []byte, value any) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(data, 0xf6), nil
+	case bool:
+		if v {
+			return append(data, 0xf5), nil
+		}
+
+		return append(data, 0xf4), nil
+	case json.Number:
+		number, err := jsonNumber(v)
+		if err != nil {
+			return nil, err
+		}
+
+		return codec.append(data, number)
+	case int64:
+		if v < 0 {
+			return cborHead(data, cborNegative, uint64(-(v + 1))), nil
+		}
+
+		return cborHead(data, cborUnsigned, uint64(v)), nil
+	case uint64:
+		return cborHead(data, cborUnsigned, v), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(data, 0xfb), math.Float64bits(v)), nil
+	case string:
+		return append(cborHead(data, cborText, uint64(len(v))), v...), nil
+	case []any:
+		data = cborHead(data, cborArray, uint64(len(v)))
+		var err error
+		for _, item := range v {
+			if data, err = codec.append(data, item); err != nil {
+				return nil, err
+			}
+		}
+
+		return data, nil
+	case map[string]any:
+		data = cborHead(data, cborMap, uint64(len(v)))
+		var err error
+		for _, key := range objectKeys(v) {
+			data = append(cborHead(data, cborText, uint64(len(key))), key...)
+			if data, err = codec.append(data, v[key]); err != nil {
+				return nil, err
+			}
+		}
+
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("unable to encode %T as cbor", value)
+}
+
+func cborHead(data []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(data, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(data, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(data, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(data, major|26), uint32(n))
+	}
+
+	return binary.BigEndian.AppendUint64(append(data, major|27), n)
+}
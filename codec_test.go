@@ -0,0 +1,68 @@
+package stomper
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCodecsEncodeJSON(t *testing.T) {
+	body := []byte(`{"e":300,"b":[true,null],"a":-1,"c":"x","d":1.5}`)
+	tests := map[Codec][]byte{
+		MsgpackCodec: {
+			0x85,
+			0xa1, 'a', 0xff,
+			0xa1, 'b', 0x92, 0xc3, 0xc0,
+			0xa1, 'c', 0xa1, 'x',
+			0xa1, 'd', 0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+			0xa1, 'e', 0xcd, 0x01, 0x2c,
+		},
+		CBORCodec: {
+			0xa5,
+			0x61, 'a', 0x20,
+			0x61, 'b', 0x82, 0xf5, 0xf6,
+			0x61, 'c', 0x61, 'x',
+			0x61, 'd', 0xfb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+			0x61, 'e', 0x19, 0x01, 0x2c,
+		},
+	}
+
+	for codec, want := range tests {
+		encoded, err := transcode(codec, body)
+		if err != nil {
+			t.Fatalf("%s: %v", codec.ContentType(), err)
+		}
+
+		if !bytes.Equal(encoded, want) {
+			t.Errorf("%s: expected % x, got % x", codec.ContentType(), want, encoded)
+		}
+	}
+
+	if _, err := transcode(MsgpackCodec, []byte("{")); err == nil {
+		t.Error("expected invalid JSON to be refused")
+	}
+}
+
+func TestCodecFollowsAcceptHeaders(t *testing.T) {
+	server, addr := newTestServer(t, func(server *Server) {
+		server.Codecs = []Codec{MsgpackCodec, CBORCodec}
+	})
+
+	c := dialTestClient(t, addr).connect("accept:application/cbor")
+	c.subscribe("cbor", "/topic/a")
+	c.subscribe("msgpack", "/topic/b", "accept:application/msgpack")
+	c.subscribe("json", "/topic/c", "accept:application/json")
+
+	want := map[string]string{"/topic/a": "application/cbor", "/topic/b": "application/msgpack", "/topic/c": "application/json"}
+	for destination, contentType := range want {
+		server.SendMessage(destination, "application/json", `{"id":1}`)
+		if frame := c.read(); frame.Headers["content-type"] != contentType {
+			t.Errorf("%s: expected %s, got %v", destination, contentType, frame.Headers)
+		}
+	}
+
+	// only JSON is re-encoded
+	server.SendMessage("/topic/a", "text/plain", "hello")
+	if frame := c.read(); frame.Headers["content-type"] != "text/plain" || string(*frame.Body) != "hello" {
+		t.Errorf("expected plain text as sent, got %v %q", frame.Headers, *frame.Body)
+	}
+}
@@ -0,0 +1,120 @@
+package stomper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// composite is a destination whose value is a JSON object joining the latest value of several sources.
+type composite struct {
+	destination string
+	fields      map[string]string
+	mux         sync.Mutex
+	values      map[string]json.RawMessage
+	current     []byte
+}
+
+// AddComposite defines a destination whose value is a JSON object assembled from the most recent message on
+// each source, e.g. AddComposite("/topic/dashboard", map[string]string{"cpu": "/topic/cpu", "mem": "/topic/mem"})
+// publishes {"cpu":...,"mem":...} whenever either source changes. New subscribers get the current value
+// straight away. Non-JSON source messages are included as JSON strings.
+func (server *Server) AddComposite(destination string, fields map[string]string) error {
+	if server.setup {
+		return fmt.Errorf("unable to add composite after %w", ErrAlreadySetup)
+	}
+
+	if len(fields) == 0 {
+		return fmt.Errorf("composite '%s' has no sources", destination)
+	}
+
+	if server.composites == nil {
+		server.composites = make(map[string]*composite)
+		server.compositeSources = make(map[string][]*composite)
+	}
+
+	if _, ok := server.composites[destination]; ok {
+		return fmt.Errorf("composite '%s' already exists", destination)
+	}
+
+	for _, source := range fields {
+		if _, ok := server.composites[source]; ok || source == destination {
+			return fmt.Errorf("composite '%s' can't use composite '%s' as a source", destination, source)
+		}
+	}
+
+	c := &composite{destination: destination, fields: fields, values: make(map[string]json.RawMessage)}
+	server.composites[destination] = c
+	for _, source := range fields {
+		server.compositeSources[source] = append(server.compositeSources[source], c)
+	}
+
+	return nil
+}
+
+// update stores the latest value of a source and returns the new joined value; callers must hold c.mux.
+func (c *composite) update(source string, body string) ([]byte, error) {
+	value := json.RawMessage(body)
+	if !json.Valid(value) {
+		quoted, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+
+		value = quoted
+	}
+
+	for field, fieldSource := range c.fields {
+		if fieldSource == source {
+			c.values[field] = value
+		}
+	}
+
+	joined, err := json.Marshal(c.values)
+	if err != nil {
+		return nil, err
+	}
+
+	c.current = joined
+	return joined, nil
+}
+
+func (c *composite) snapshot() []byte {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.current
+}
+
+func (server *Server) updateComposites(topic string, body string) {
+	for _, c := range server.compositeSources[topic] {
+		// hold the composite while broadcasting so concurrent updates go out in the order they were joined
+		c.mux.Lock()
+		joined, err := c.update(topic, body)
+		if err != nil {
+			c.mux.Unlock()
+			server.Sugar.Warnf("unable to update composite '%s': %v", c.destination, err)
+			continue
+		}
+
+		server.broadcast(context.Background(), c.destination, "application/json", string(joined), nil, nil, nil, nil)
+		c.mux.Unlock()
+	}
+}
+
+// sendCompositeSnapshot gives a new subscriber of a composite destination its current value.
+func (server *Server) sendCompositeSnapshot(client *Client, destination string, subId string) {
+	c, ok := server.composites[destination]
+	if !ok {
+		return
+	}
+
+	current := c.snapshot()
+	if current == nil {
+		return
+	}
+
+	if err := server.sendToSubscription(client, destination, subId, "application/json", current, nil); err != nil {
+		server.Sugar.Warnf("[%d] unable to write composite snapshot: %v", client.Uid, err)
+	}
+}
@@ -0,0 +1,49 @@
+package stomper
+
+import (
+	"context"
+	"sync"
+)
+
+// conflationSlot is a subscription's place in a client's outbound queue under Server.Conflate. It holds the
+// newest MESSAGE for the subscription until the place comes up for writing.
+type conflationSlot struct {
+	mux    sync.Mutex
+	frame  outboundFrame
+	queued bool
+}
+
+// take empties the slot, returning the frame waiting in it, if any.
+func (slot *conflationSlot) take() (outboundFrame, bool) {
+	slot.mux.Lock()
+	defer slot.mux.Unlock()
+	if !slot.queued {
+		return outboundFrame{}, false
+	}
+
+	frame := slot.frame
+	slot.frame, slot.queued = outboundFrame{}, false
+	return frame, true
+}
+
+// enqueueConflated queues a MESSAGE for a subscription, replacing the one already waiting for it in place
+// rather than queueing another.
+func (server *Server) enqueueConflated(ctx context.Context, client *Client, subId string, frame outboundFrame) error {
+	value, _ := client.conflation.LoadOrStore(subId, &conflationSlot{})
+	slot := value.(*conflationSlot)
+
+	slot.mux.Lock()
+	if slot.queued {
+		replaced := slot.frame
+		slot.frame = frame
+		slot.mux.Unlock()
+
+		replaced.release()
+		server.metrics.conflated.Add(1)
+		return nil
+	}
+
+	slot.frame, slot.queued = frame, true
+	slot.mux.Unlock()
+	return server.enqueueContext(ctx, client, outboundFrame{conflated: slot})
+}
@@ -0,0 +1,76 @@
+package stomper
+
+import "strconv"
+
+// CongestionDestination may be subscribed to by clients which can ease off when they fall behind, e.g. by
+// asking for conflated or digest subscriptions. They're sent an AdvisoryCongestion once their outbound
+// queue passes Server.CongestionHighWater, and an AdvisoryCongestionCleared once it drains to
+// CongestionLowWater, both with the queue's length and capacity in the queued and capacity headers.
+const CongestionDestination = "/stomper/advisory/congestion"
+
+const (
+	AdvisoryCongestion        = "congestion"
+	AdvisoryCongestionCleared = "congestion-cleared"
+)
+
+// congestionMarks returns the high and low water marks of the outbound queue; a high mark of zero disables
+// congestion advisories.
+func (server *Server) congestionMarks() (int, int) {
+	size := server.outboundQueueSize()
+	high := server.CongestionHighWater
+	if high == 0 {
+		high = size * 3 / 4
+	} else if high < 0 {
+		return 0, 0
+	}
+
+	low := server.CongestionLowWater
+	if low <= 0 || low >= high {
+		low = high / 3
+	}
+
+	return high, low
+}
+
+// checkCongestion is called after a frame is queued, advising the client once its queue passes the high
+// water mark.
+func (server *Server) checkCongestion(client *Client) {
+	high, _ := server.congestionMarks()
+	queued := len(client.outbound)
+	if high == 0 || queued < high || !client.congested.CompareAndSwap(false, true) {
+		return
+	}
+
+	server.Sugar.Debugf("[%d] outbound queue congested (%d queued)", client.Uid, queued)
+	server.sendCongestionAdvisory(client, AdvisoryCongestion, "outbound queue is congested", queued)
+}
+
+// checkCongestionCleared is called after a frame is written, advising a congested client once its queue
+// has drained to the low water mark.
+func (server *Server) checkCongestionCleared(client *Client) {
+	if !client.congested.Load() {
+		return
+	}
+
+	_, low := server.congestionMarks()
+	queued := len(client.outbound)
+	if queued > low || !client.congested.CompareAndSwap(true, false) {
+		return
+	}
+
+	server.Sugar.Debugf("[%d] outbound queue congestion cleared", client.Uid)
+
+	// this runs on the write pump, which mustn't wait on its own queue
+	go server.sendCongestionAdvisory(client, AdvisoryCongestionCleared, "outbound queue congestion has cleared", queued)
+}
+
+func (server *Server) sendCongestionAdvisory(client *Client, advisory string, text string, queued int) {
+	headers := map[string]string{
+		"queued":   strconv.Itoa(queued),
+		"capacity": strconv.Itoa(cap(client.outbound)),
+	}
+
+	for _, subId := range server.subscriptionIds(client, CongestionDestination) {
+		server.sendAdvisoryWithHeaders(client, CongestionDestination, subId, advisory, text, headers)
+	}
+}
@@ -0,0 +1,259 @@
+package stomper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const maxSourceBackoff = time.Minute
+
+// Publisher is what a DataSource broadcasts through; *Server is one.
+type Publisher interface {
+	SendMessageWithHeaders(topic string, contentType string, body string, extraHeaders map[string]string, check func(client *Client) bool)
+	PublishWithAck(topic string, contentType string, body string, extraHeaders map[string]string, ack Acknowledger)
+	Logger() Logger
+}
+
+// DataSource feeds messages from outside into the server. Sources added with AddDataSource are started by
+// Setup and stopped by Shutdown: Start should return once the source is running, publishing until ctx is
+// cancelled or Stop is called, and Stop should return once it has stopped.
+type DataSource interface {
+	Start(ctx context.Context, publisher Publisher) error
+	Stop()
+}
+
+type dataSources struct {
+	mux         sync.Mutex
+	sources     []DataSource
+	subscribing []SubscribingSource
+	cancel      context.CancelFunc
+}
+
+// Logger returns the server's logger, for data sources.
+func (server *Server) Logger() Logger {
+	return server.Sugar
+}
+
+// AddDataSource registers a source to be started by Setup and stopped by Shutdown. A SubscribingSource is
+// also told which destinations have subscribers.
+func (server *Server) AddDataSource(source DataSource) error {
+	if server.setup {
+		return fmt.Errorf("unable to add data source after %w", ErrAlreadySetup)
+	}
+
+	server.dataSources.mux.Lock()
+	server.dataSources.sources = append(server.dataSources.sources, source)
+	if subscribing, ok := source.(SubscribingSource); ok {
+		server.dataSources.subscribing = append(server.dataSources.subscribing, subscribing)
+	}
+
+	server.dataSources.mux.Unlock()
+	return nil
+}
+
+// startDataSources starts every registered source, logging those which fail to start.
+func (server *Server) startDataSources() {
+	sources := &server.dataSources
+	sources.mux.Lock()
+	defer sources.mux.Unlock()
+
+	var ctx context.Context
+	ctx, sources.cancel = context.WithCancel(context.Background())
+	for _, source := range sources.sources {
+		if err := source.Start(ctx, server); err != nil {
+			server.Sugar.Errorf("unable to start data source %T: %v", source, err)
+		}
+	}
+}
+
+// Shutdown stops the server's data sources, returning ctx's error if they haven't all stopped before it's
+// done. Connected clients are left alone.
+func (server *Server) Shutdown(ctx context.Context) error {
+	sources := &server.dataSources
+	sources.mux.Lock()
+	if sources.cancel != nil {
+		sources.cancel()
+	}
+
+	stopping := append([]DataSource(nil), sources.sources...)
+	sources.mux.Unlock()
+
+	var wg sync.WaitGroup
+	for _, source := range stopping {
+		wg.Add(1)
+		go func(source DataSource) {
+			defer wg.Done()
+			source.Stop()
+		}(source)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Runner is a source which publishes until ctx is cancelled, such as postgres.Source. It may also report
+// its health with a Health() error method, as SourceHealth helps with.
+type Runner interface {
+	Run(ctx context.Context) error
+}
+
+// RunDataSource adapts a Runner into a DataSource which runs it in the background until stopped, restarting
+// it with exponential backoff (up to a minute) whenever it fails.
+func RunDataSource(name string, runner Runner) DataSource {
+	return &runDataSource{name: name, runner: runner}
+}
+
+type runDataSource struct {
+	name   string
+	runner Runner
+	cancel context.CancelFunc
+	done   chan struct{}
+	health SourceHealth
+}
+
+func (source *runDataSource) Name() string {
+	return source.name
+}
+
+func (source *runDataSource) Start(ctx context.Context, publisher Publisher) error {
+	if source.done != nil {
+		return fmt.Errorf("data source %s already started", source.name)
+	}
+
+	ctx, source.cancel = context.WithCancel(ctx)
+	source.done = make(chan struct{})
+	source.health.SetHealth(nil)
+	go source.supervise(ctx, publisher.Logger(), clockOf(publisher))
+	return nil
+}
+
+func (source *runDataSource) supervise(ctx context.Context, logger Logger, clock Clock) {
+	defer close(source.done)
+	backoff := time.Second
+	for {
+		started := clock.Now()
+		err := source.runner.Run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err == nil {
+			err = errors.New("stopped unexpectedly")
+		}
+
+		// a source which ran for a while before failing starts backing off afresh
+		if clock.Now().Sub(started) > maxSourceBackoff {
+			backoff = time.Second
+		}
+
+		source.health.SetHealth(err)
+		logger.Errorf("data source %s failed: %v, restarting in %s", source.name, err, backoff)
+		timer := clock.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		source.health.SetHealth(nil)
+		if backoff *= 2; backoff > maxSourceBackoff {
+			backoff = maxSourceBackoff
+		}
+	}
+}
+
+// Health reports a failed runner until it's restarted, and otherwise the runner's own health.
+func (source *runDataSource) Health() error {
+	if err := source.health.Health(); err != nil {
+		return err
+	}
+
+	if reporter, ok := source.runner.(interface{ Health() error }); ok {
+		return reporter.Health()
+	}
+
+	return nil
+}
+
+func (source *runDataSource) Stop() {
+	if source.done == nil {
+		return
+	}
+
+	source.cancel()
+	<-source.done
+}
+
+// SourceHealth records whether a source is working, for it to report through a Health method. It reports
+// an error until SetHealth(nil) is first called, e.g. once the source has connected.
+type SourceHealth struct {
+	mux sync.Mutex
+	err error
+	set bool
+}
+
+var errSourceNotConnected = errors.New("not connected yet")
+
+func (health *SourceHealth) SetHealth(err error) {
+	health.mux.Lock()
+	health.err, health.set = err, true
+	health.mux.Unlock()
+}
+
+func (health *SourceHealth) Health() error {
+	health.mux.Lock()
+	defer health.mux.Unlock()
+	if !health.set {
+		return errSourceNotConnected
+	}
+
+	return health.err
+}
+
+// DataSourceStatus is the health of one data source. Sources are named by a Name() string method, or by
+// their type.
+type DataSourceStatus struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// DataSourceStatuses reports the health of every data source added with AddDataSource; sources without a
+// Health() error method are assumed healthy.
+func (server *Server) DataSourceStatuses() []DataSourceStatus {
+	server.dataSources.mux.Lock()
+	sources := append([]DataSource(nil), server.dataSources.sources...)
+	server.dataSources.mux.Unlock()
+
+	statuses := make([]DataSourceStatus, 0, len(sources))
+	for _, source := range sources {
+		status := DataSourceStatus{Name: fmt.Sprintf("%T", source), Healthy: true}
+		if named, ok := source.(interface{ Name() string }); ok {
+			status.Name = named.Name()
+		}
+
+		if reporter, ok := source.(interface{ Health() error }); ok {
+			if err := reporter.Health(); err != nil {
+				status.Healthy, status.Error = false, err.Error()
+			}
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
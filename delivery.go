@@ -0,0 +1,254 @@
+package stomper
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// delivery is one MESSAGE frame bound for one client: either a header plus shared body, or a prepared frame.
+// conflate is the subscription whose waiting MESSAGE it replaces, under Server.Conflate.
+type delivery struct {
+	client   *Client
+	subId    string
+	conflate string
+	header   []byte
+	body     *sharedBuffer
+	prepared *preparedFrame
+	expires  time.Time
+}
+
+// deliveryBatch is the part of a broadcast's deliveries handled by one shard, which records the outcome of
+// each in the broadcast's outcomes, at the index the delivery had there.
+type deliveryBatch struct {
+	ctx        context.Context
+	deliveries []delivery
+	indexes    []int
+	outcomes   *deliveryOutcomes
+	done       *sync.WaitGroup
+}
+
+// deliveryOutcomes holds the outcome of each of a broadcast's deliveries as the shards settle them, so a
+// caller that stops waiting can still see how far they got.
+type deliveryOutcomes struct {
+	mux     sync.Mutex
+	errs    []error
+	settled []bool
+}
+
+func newDeliveryOutcomes(count int) *deliveryOutcomes {
+	return &deliveryOutcomes{errs: make([]error, count), settled: make([]bool, count)}
+}
+
+func (outcomes *deliveryOutcomes) settle(i int, err error) {
+	outcomes.mux.Lock()
+	outcomes.errs[i], outcomes.settled[i] = err, true
+	outcomes.mux.Unlock()
+}
+
+// snapshot returns the outcomes so far, with unsettled for the deliveries not settled yet.
+func (outcomes *deliveryOutcomes) snapshot(unsettled error) []error {
+	outcomes.mux.Lock()
+	defer outcomes.mux.Unlock()
+
+	errs := make([]error, len(outcomes.errs))
+	for i, err := range outcomes.errs {
+		if !outcomes.settled[i] {
+			err = unsettled
+		}
+
+		errs[i] = err
+	}
+
+	return errs
+}
+
+// SubscriptionKey identifies one subscription of one client.
+type SubscriptionKey struct {
+	Uid   uint64
+	SubId string
+}
+
+// DeliveryReport is the outcome of a broadcast: how many subscriptions a MESSAGE was queued for, how many
+// copies were dropped because the client wasn't keeping up (see SlowConsumerPolicy), and the error for each
+// subscription a copy couldn't be queued for, dropped ones included.
+type DeliveryReport struct {
+	Delivered int
+	Dropped   int
+	Errors    map[SubscriptionKey]error
+}
+
+func (report *DeliveryReport) add(deliveries []delivery, errs []error) {
+	for i, err := range errs {
+		if err == nil {
+			report.Delivered++
+			continue
+		}
+
+		if errors.Is(err, ErrBackpressure) {
+			report.Dropped++
+		}
+
+		report.Errors[SubscriptionKey{Uid: deliveries[i].client.Uid, SubId: deliveries[i].subId}] = err
+	}
+}
+
+// deliveryShard owns writes for the clients hashed to it, so a broadcast is spread across a fixed set of
+// goroutines while every frame for a given client is still written from a single one, in order.
+type deliveryShard struct {
+	batches   chan deliveryBatch
+	pending   atomic.Int64
+	delivered atomic.Uint64
+	failed    atomic.Uint64
+}
+
+type ShardStats struct {
+	Shard     int
+	Clients   int
+	Pending   int64
+	Delivered uint64
+	Failed    uint64
+}
+
+func (server *Server) startDeliveryShards() {
+	count := server.DeliveryShards
+	if count <= 0 {
+		count = runtime.GOMAXPROCS(0)
+	}
+
+	server.deliveryShards = make([]*deliveryShard, count)
+	for i := range server.deliveryShards {
+		shard := &deliveryShard{batches: make(chan deliveryBatch, 64)}
+		server.deliveryShards[i] = shard
+		go server.runDeliveryShard(shard)
+	}
+}
+
+func (server *Server) shardFor(client *Client) *deliveryShard {
+	return server.deliveryShards[client.Uid%uint64(len(server.deliveryShards))]
+}
+
+func (server *Server) runDeliveryShard(shard *deliveryShard) {
+	for batch := range shard.batches {
+		for i, d := range batch.deliveries {
+			frame := outboundFrame{prepared: d.prepared, expires: d.expires}
+			if d.prepared == nil {
+				frame = outboundFrame{parts: [][]byte{d.header, d.body.bytes(), nullTerminator}, shared: d.body, expires: d.expires}
+			}
+
+			var err error
+			if d.conflate != "" {
+				err = server.enqueueConflated(batch.ctx, d.client, d.conflate, frame)
+			} else {
+				err = server.enqueueContext(batch.ctx, d.client, frame)
+			}
+
+			batch.outcomes.settle(batch.indexes[i], err)
+			if errors.Is(err, ErrBackpressure) || (err != nil && err == batch.ctx.Err()) {
+				// dropped under the SlowConsumerPolicy, which counts it, or given up on by the caller
+				shard.failed.Add(1)
+			} else if err != nil {
+				shard.failed.Add(1)
+				server.Sugar.Errorf("unable to write message: %v", err)
+				server.reportError(d.client, err)
+			} else {
+				shard.delivered.Add(1)
+			}
+		}
+
+		shard.pending.Add(-int64(len(batch.deliveries)))
+		batch.done.Done()
+	}
+}
+
+// deliver hands each delivery to its client's shard and waits until all of them have been queued, returning
+// the outcome of each in the same order. If ctx is done first it returns ctx's error, along with the outcomes
+// so far and ctx's error for the deliveries that hadn't been queued yet.
+func (server *Server) deliver(ctx context.Context, deliveries []delivery) ([]error, error) {
+	if len(deliveries) == 0 || len(server.deliveryShards) == 0 {
+		return nil, nil
+	}
+
+	batches := make(map[*deliveryShard]*deliveryBatch)
+	outcomes := newDeliveryOutcomes(len(deliveries))
+	for i, d := range deliveries {
+		shard := server.shardFor(d.client)
+		batch, ok := batches[shard]
+		if !ok {
+			batch = &deliveryBatch{ctx: ctx, outcomes: outcomes}
+			batches[shard] = batch
+		}
+
+		batch.deliveries = append(batch.deliveries, d)
+		batch.indexes = append(batch.indexes, i)
+	}
+
+	var done sync.WaitGroup
+	done.Add(len(batches))
+	for shard, batch := range batches {
+		batch.done = &done
+		shard.pending.Add(int64(len(batch.deliveries)))
+		select {
+		case shard.batches <- *batch:
+		case <-ctx.Done():
+			for i, d := range batch.deliveries {
+				if d.body != nil {
+					d.body.release()
+				}
+
+				outcomes.settle(batch.indexes[i], ctx.Err())
+			}
+
+			shard.pending.Add(-int64(len(batch.deliveries)))
+			done.Done()
+		}
+	}
+
+	if ctx.Done() == nil {
+		done.Wait()
+		return outcomes.errs, nil
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		done.Wait()
+		close(waited)
+	}()
+
+	select {
+	case <-waited:
+		return outcomes.errs, nil
+	case <-ctx.Done():
+		return outcomes.snapshot(ctx.Err()), ctx.Err()
+	}
+}
+
+// ShardStats reports, per delivery shard, how many connected clients it serves, how many frames are waiting
+// to be written and how many have been written or failed.
+func (server *Server) ShardStats() []ShardStats {
+	server.init()
+	stats := make([]ShardStats, len(server.deliveryShards))
+	for i, shard := range server.deliveryShards {
+		stats[i] = ShardStats{
+			Shard:     i,
+			Pending:   shard.pending.Load(),
+			Delivered: shard.delivered.Load(),
+			Failed:    shard.failed.Load(),
+		}
+	}
+
+	if len(stats) == 0 {
+		return stats
+	}
+
+	server.clientMux.RLock()
+	defer server.clientMux.RUnlock()
+	for uid := range server.clients {
+		stats[uid%uint64(len(stats))].Clients++
+	}
+
+	return stats
+}
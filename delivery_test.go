@@ -0,0 +1,53 @@
+package stomper
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDeliveryReportIsKeyedBySubscription(t *testing.T) {
+	server, addr := newTestServer(t, func(server *Server) {
+		server.DeliveryShards = 2
+	})
+
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("a", "/topic/t")
+	c.subscribe("b", "/topic/t")
+	client := onlyClient(t, server)
+
+	// a client on the other shard whose queue is never drained
+	stuck := &Client{Uid: client.Uid + 1, Version: "1.2", outbound: make(chan outboundFrame), done: make(chan struct{})}
+	server.subscribe(stuck, "/topic/t", "s", nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	report, err := server.SendMessageWithReport(ctx, "/topic/t", "text/plain", "hello", nil, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to pass, got %v", err)
+	}
+
+	if report.Delivered != 2 {
+		t.Fatalf("expected the two deliveries made before the deadline in the report, got %+v", report)
+	}
+
+	if err := report.Errors[SubscriptionKey{Uid: stuck.Uid, SubId: "s"}]; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the stuck subscription to have the context's error, got %v", report.Errors)
+	}
+
+	if len(report.Errors) != 1 {
+		t.Fatalf("expected only the stuck subscription to have an error, got %v", report.Errors)
+	}
+
+	received := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		if frame := c.read(); frame.Command == Message {
+			received[frame.Headers["subscription"]] = true
+		}
+	}
+
+	if !received["a"] || !received["b"] {
+		t.Fatalf("expected a message on each of a and b, got %v", received)
+	}
+}
@@ -0,0 +1,306 @@
+package stomper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDevBodyLimit = 200
+	devHistorySize      = 200
+)
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+)
+
+// DevConsole pretty-prints every frame for local development: colored, with long bodies truncated and
+// headers shown as a diff against the previous frame with the same command in the same direction. Handler
+// serves a live frame viewer page. Not meant for production traffic.
+type DevConsole struct {
+	// Output defaults to stderr.
+	Output io.Writer
+
+	// BodyLimit truncates printed bodies (default 200 bytes); negative prints them in full.
+	BodyLimit int
+
+	NoColor bool
+
+	// Clock stamps frames; Setup gives it the server's, and otherwise nil uses SystemClock
+	Clock Clock
+
+	mux      sync.Mutex
+	last     map[string]map[string]string
+	history  []devFrame
+	watchers map[chan devFrame]struct{}
+}
+
+type devFrame struct {
+	Time      time.Time         `json:"time"`
+	Session   uint64            `json:"session"`
+	Direction string            `json:"dir"`
+	Command   string            `json:"command"`
+	Headers   map[string]string `json:"headers"`
+	Body      string            `json:"body"`
+}
+
+func (console *DevConsole) clock() Clock {
+	if console.Clock == nil {
+		return SystemClock
+	}
+
+	return console.Clock
+}
+
+func (console *DevConsole) useClock(clock Clock) {
+	if console.Clock == nil {
+		console.Clock = clock
+	}
+}
+
+func (console *DevConsole) Record(client *Client, direction string, payload []byte) {
+	frame := devFrame{Time: console.clock().Now(), Session: client.Uid, Direction: direction, Headers: map[string]string{}}
+	if isHeartBeat(payload) {
+		frame.Command = "heart-beat"
+	} else {
+		head, body, _ := bytes.Cut(payload, []byte("\n\n"))
+		lines := strings.Split(strings.ReplaceAll(string(head), "\r", ""), "\n")
+		frame.Command = lines[0]
+		for _, line := range lines[1:] {
+			if name, value, ok := strings.Cut(line, ":"); ok {
+				frame.Headers[name] = value
+			}
+		}
+
+		frame.Body = string(bytes.TrimSuffix(body, nullTerminator))
+	}
+
+	console.mux.Lock()
+	defer console.mux.Unlock()
+
+	console.print(frame)
+	console.history = append(console.history, frame)
+	if len(console.history) > devHistorySize {
+		console.history = console.history[len(console.history)-devHistorySize:]
+	}
+
+	for watcher := range console.watchers {
+		select {
+		case watcher <- frame:
+		default:
+		}
+	}
+}
+
+func (console *DevConsole) Close(client *Client) {
+	console.mux.Lock()
+	defer console.mux.Unlock()
+
+	prefix := fmt.Sprintf("%d/", client.Uid)
+	for key := range console.last {
+		if strings.HasPrefix(key, prefix) {
+			delete(console.last, key)
+		}
+	}
+}
+
+func (console *DevConsole) color(code string, text string) string {
+	if console.NoColor {
+		return text
+	}
+
+	return code + text + ansiReset
+}
+
+// print writes a frame to the output; callers must hold console.mux.
+func (console *DevConsole) print(frame devFrame) {
+	var out strings.Builder
+	arrow := console.color(ansiGreen, "→")
+	if frame.Direction == DirectionOutbound {
+		arrow = console.color(ansiCyan, "←")
+	}
+
+	fmt.Fprintf(&out, "%s [%d] %s %s\n", frame.Time.Format("15:04:05.000"), frame.Session, arrow, frame.Command)
+	if frame.Command != "heart-beat" {
+		console.printHeaders(&out, frame)
+		console.printBody(&out, frame.Body)
+	}
+
+	output := console.Output
+	if output == nil {
+		output = os.Stderr
+	}
+
+	_, _ = io.WriteString(output, out.String())
+}
+
+func (console *DevConsole) printHeaders(out *strings.Builder, frame devFrame) {
+	if console.last == nil {
+		console.last = make(map[string]map[string]string)
+	}
+
+	key := fmt.Sprintf("%d/%s/%s", frame.Session, frame.Direction, frame.Command)
+	previous, seen := console.last[key]
+	console.last[key] = frame.Headers
+
+	names := make([]string, 0, len(frame.Headers))
+	for name := range frame.Headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	unchanged := 0
+	for _, name := range names {
+		value := frame.Headers[name]
+		old, existed := previous[name]
+		switch {
+		case !seen:
+			fmt.Fprintf(out, "    %s:%s\n", name, value)
+		case !existed:
+			fmt.Fprintf(out, "    %s\n", console.color(ansiGreen, "+ "+name+":"+value))
+		case old != value:
+			fmt.Fprintf(out, "    %s\n", console.color(ansiYellow, "~ "+name+":"+value))
+		default:
+			unchanged++
+		}
+	}
+
+	for name, value := range previous {
+		if _, ok := frame.Headers[name]; !ok {
+			fmt.Fprintf(out, "    %s\n", console.color(ansiRed, "- "+name+":"+value))
+		}
+	}
+
+	if unchanged > 0 {
+		fmt.Fprintf(out, "    %s\n", console.color(ansiDim, fmt.Sprintf("(%d unchanged)", unchanged)))
+	}
+}
+
+func (console *DevConsole) printBody(out *strings.Builder, body string) {
+	if body == "" {
+		return
+	}
+
+	limit := console.BodyLimit
+	if limit == 0 {
+		limit = defaultDevBodyLimit
+	}
+
+	if limit > 0 && len(body) > limit {
+		body = body[:limit] + console.color(ansiDim, fmt.Sprintf("… (%d more bytes)", len(body)-limit))
+	}
+
+	fmt.Fprintf(out, "    %s\n", body)
+}
+
+// Handler serves the live frame viewer: the page itself, and with ?events a server-sent event stream of
+// frames, starting with the most recent ones.
+func (console *DevConsole) Handler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if !request.URL.Query().Has("events") {
+			writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = io.WriteString(writer, devViewerPage)
+			return
+		}
+
+		flusher, ok := writer.(http.Flusher)
+		if !ok {
+			http.Error(writer, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		frames := make(chan devFrame, devHistorySize)
+		console.mux.Lock()
+		for _, frame := range console.history {
+			frames <- frame
+		}
+
+		if console.watchers == nil {
+			console.watchers = make(map[chan devFrame]struct{})
+		}
+
+		console.watchers[frames] = struct{}{}
+		console.mux.Unlock()
+
+		defer func() {
+			console.mux.Lock()
+			delete(console.watchers, frames)
+			console.mux.Unlock()
+		}()
+
+		writer.Header().Set("Content-Type", "text/event-stream")
+		writer.Header().Set("Cache-Control", "no-cache")
+		for {
+			select {
+			case frame := <-frames:
+				data, err := json.Marshal(frame)
+				if err != nil {
+					continue
+				}
+
+				if _, err = fmt.Fprintf(writer, "data: %s\n\n", data); err != nil {
+					return
+				}
+
+				flusher.Flush()
+			case <-request.Context().Done():
+				return
+			}
+		}
+	})
+}
+
+const devViewerPage = `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>stomper frames</title>
+<style>
+body { font: 13px monospace; margin: 0; background: #111; color: #ddd; }
+#frames { padding: 8px; }
+.frame { border-bottom: 1px solid #333; padding: 4px 0; }
+.in .command { color: #6c6; }
+.out .command { color: #6cc; }
+.headers { color: #999; white-space: pre; }
+.body { white-space: pre-wrap; word-break: break-all; }
+</style>
+</head>
+<body>
+<div id="frames"></div>
+<script>
+const frames = document.getElementById("frames");
+const events = new EventSource("?events");
+events.onmessage = (event) => {
+	const frame = JSON.parse(event.data);
+	const div = document.createElement("div");
+	div.className = "frame " + frame.dir;
+	const title = document.createElement("div");
+	title.className = "command";
+	title.textContent = frame.time + " [" + frame.session + "] " + (frame.dir === "in" ? "→ " : "← ") + frame.command;
+	const headers = document.createElement("div");
+	headers.className = "headers";
+	headers.textContent = Object.entries(frame.headers).map(([k, v]) => "  " + k + ":" + v).join("\n");
+	const body = document.createElement("div");
+	body.className = "body";
+	body.textContent = frame.body;
+	div.append(title, headers, body);
+	frames.prepend(div);
+	while (frames.childElementCount > 500) frames.lastChild.remove();
+};
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,141 @@
+package stomper
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DigestHeader on a SUBSCRIBE asks for messages to be batched: instead of one MESSAGE per message, the
+// subscription gets a single MESSAGE every so many seconds holding a JSON array of the payloads received
+// since the last one, with a DigestCountHeader. JSON payloads are embedded as they are, others as strings.
+const DigestHeader = "digest"
+
+const (
+	DigestCountHeader   = "digest-count"
+	DigestDroppedHeader = "digest-dropped"
+)
+
+const defaultDigestLimit = 1000
+
+// digest accumulates the messages for one digest subscription between deliveries.
+type digest struct {
+	mux      sync.Mutex
+	payloads []json.RawMessage
+	dropped  int
+	limit    int
+	stop     chan struct{}
+}
+
+func (d *digest) add(contentType string, body []byte) {
+	var payload json.RawMessage
+	if isJSONContentType(contentType) && json.Valid(body) {
+		// the broadcast body is pooled, so keep a copy
+		payload = append(payload, body...)
+	} else {
+		payload, _ = json.Marshal(string(body))
+	}
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if len(d.payloads) >= d.limit {
+		d.payloads = d.payloads[1:]
+		d.dropped++
+	}
+
+	d.payloads = append(d.payloads, payload)
+}
+
+func (d *digest) take() ([]json.RawMessage, int) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	payloads, dropped := d.payloads, d.dropped
+	d.payloads, d.dropped = nil, 0
+	return payloads, dropped
+}
+
+// startDigest turns a new subscription with a digest header into a digest subscription.
+func (server *Server) startDigest(client *Client, destination string, subId string, headers map[string]string) {
+	server.stopDigest(client, subId)
+	value, ok := headers[DigestHeader]
+	if !ok {
+		return
+	}
+
+	if !server.extensionEnabled(FeatureDigest, destination, client) {
+		server.Sugar.Debugf("[%d] digests aren't enabled for '%s'", client.Uid, destination)
+		return
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		server.Sugar.Infof("[%d] ignoring invalid digest interval '%s' for '%s'", client.Uid, value, destination)
+		return
+	}
+
+	limit := server.DigestLimit
+	if limit <= 0 {
+		limit = defaultDigestLimit
+	}
+
+	d := &digest{limit: limit, stop: make(chan struct{})}
+	client.digests.Store(subId, d)
+	client.digestCount.Add(1)
+	go server.digestLoop(client, destination, subId, d, time.Duration(seconds)*time.Second)
+}
+
+func (server *Server) stopDigest(client *Client, subId string) {
+	if d, ok := client.digests.LoadAndDelete(subId); ok {
+		client.digestCount.Add(-1)
+		close(d.(*digest).stop)
+	}
+}
+
+// digestFor returns the digest collecting a subscription's messages, or nil if it isn't a digest subscription.
+func (client *Client) digestFor(subId string) *digest {
+	if client.digestCount.Load() == 0 {
+		return nil
+	}
+
+	if d, ok := client.digests.Load(subId); ok {
+		return d.(*digest)
+	}
+
+	return nil
+}
+
+func (server *Server) digestLoop(client *Client, destination string, subId string, d *digest, interval time.Duration) {
+	ticker := server.clock().NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-client.done:
+			return
+		case <-d.stop:
+			return
+		case <-ticker.C():
+		}
+
+		payloads, dropped := d.take()
+		if len(payloads) == 0 {
+			continue
+		}
+
+		body, err := json.Marshal(payloads)
+		if err != nil {
+			server.Sugar.Errorf("[%d] unable to encode digest: %v", client.Uid, err)
+			continue
+		}
+
+		headers := map[string]string{DigestCountHeader: strconv.Itoa(len(payloads))}
+		if dropped > 0 {
+			headers[DigestDroppedHeader] = strconv.Itoa(dropped)
+		}
+
+		if err := server.sendToSubscription(client, destination, subId, "application/json", body, headers); err != nil {
+			server.Sugar.Debugf("[%d] unable to send digest: %v", client.Uid, err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package stomper
+
+import (
+	"fmt"
+)
+
+// SendToClient sends a MESSAGE to one client outside of a broadcast, on each of its subscriptions matching
+// destination, or only on the subscription named by a `subscription` entry in headers. It returns
+// ErrNotSubscribed if there is no subscription to send it on, including when the named one has ended.
+func (server *Server) SendToClient(client *Client, destination string, contentType string, body []byte, headers map[string]string) error {
+	server.init()
+	extraHeaders := make(map[string]string, len(headers))
+	for k, v := range headers {
+		extraHeaders[k] = v
+	}
+
+	subIds := server.subscriptionIds(client, destination)
+	if subId, ok := extraHeaders["subscription"]; ok {
+		delete(extraHeaders, "subscription")
+		subIds = onlySubscription(subIds, subId)
+	}
+
+	if len(subIds) == 0 {
+		return fmt.Errorf("unable to send to '%s': %w", destination, ErrNotSubscribed)
+	}
+
+	for _, subId := range subIds {
+		if err := server.sendToSubscription(client, destination, subId, contentType, body, extraHeaders); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// onlySubscription narrows subIds to subId, or to nothing if the client isn't subscribed with it.
+func onlySubscription(subIds []string, subId string) []string {
+	for _, id := range subIds {
+		if id == subId {
+			return []string{subId}
+		}
+	}
+
+	return nil
+}
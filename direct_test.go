@@ -0,0 +1,64 @@
+package stomper
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// lingeringQuery is a QueryProvider handing out its publish function, which keeps working after the query
+// is stopped.
+type lingeringQuery struct {
+	publish chan func(contentType string, body []byte)
+}
+
+func (query *lingeringQuery) Run(ctx context.Context, _ Query, publish func(contentType string, body []byte)) error {
+	query.publish <- publish
+	<-ctx.Done()
+	return nil
+}
+
+func TestQueryResultsAfterUnsubscribeAreDropped(t *testing.T) {
+	query := &lingeringQuery{publish: make(chan func(string, []byte), 1)}
+	_, addr := newTestServer(t, func(server *Server) {
+		server.QueryProvider = query
+	})
+
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("q", "/query/orders?status=open")
+	publish := <-query.publish
+
+	c.send(Unsubscribe, []string{"id:q", "receipt:unsub"}, "")
+	if frame := c.read(); frame.Command != Receipt {
+		t.Fatalf("expected a receipt, got %s %v", frame.Command, frame.Headers)
+	}
+
+	publish("text/plain", []byte("late"))
+	c.quiet(50 * time.Millisecond)
+}
+
+func TestSendToClientChecksSubscription(t *testing.T) {
+	server, addr := newTestServer(t, nil)
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("a", "/topic/a")
+	client := onlyClient(t, server)
+
+	err := server.SendToClient(client, "/topic/a", "text/plain", []byte("x"), map[string]string{"subscription": "b"})
+	if !errors.Is(err, ErrNotSubscribed) {
+		t.Fatalf("expected ErrNotSubscribed for an unknown subscription, got %v", err)
+	}
+
+	err = server.SendToClient(client, "/topic/b", "text/plain", []byte("x"), map[string]string{"subscription": "a"})
+	if !errors.Is(err, ErrNotSubscribed) {
+		t.Fatalf("expected ErrNotSubscribed for another destination, got %v", err)
+	}
+
+	if err := server.SendToClient(client, "/topic/a", "text/plain", []byte("x"), map[string]string{"subscription": "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if frame := c.read(); frame.Command != Message || frame.Headers["subscription"] != "a" {
+		t.Fatalf("expected a message on a, got %s %v", frame.Command, frame.Headers)
+	}
+}
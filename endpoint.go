@@ -0,0 +1,152 @@
+package stomper
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// EndpointConfig describes a websocket endpoint with its own policies, e.g. a public read-only endpoint
+// alongside an internal one with full access. Clients of every endpoint share the server's subscriptions.
+type EndpointConfig struct {
+	// Name identifies the endpoint in logs and on Client.Endpoint
+	Name string
+
+	// AllowedOrigins and CheckOrigin replace the server's for this endpoint; with neither set the server's
+	// origin policy applies
+	AllowedOrigins []string
+	CheckOrigin    func(*http.Request) bool
+
+	// Authenticator replaces the server's for this endpoint when set, and RequireIdentity refuses CONNECTs
+	// which aren't authenticated as anyone
+	Authenticator   Authenticator
+	RequireIdentity bool
+
+	// SubscribeDestinations and SendDestinations, when set, are the destination prefixes clients may
+	// SUBSCRIBE and SEND to, e.g. /topic/public/; ReadOnly refuses every SEND. These apply before
+	// Server.Authorizer.
+	SubscribeDestinations []string
+	SendDestinations      []string
+	ReadOnly              bool
+
+	// MaxFrameSize replaces the server's when set, and MaxConnections, when set, limits the clients
+	// connected through the endpoint at once
+	MaxFrameSize   int64
+	MaxConnections int
+}
+
+// Endpoint serves websocket clients held to an EndpointConfig.
+type Endpoint struct {
+	server      *Server
+	config      EndpointConfig
+	connections atomic.Int64
+
+	once      sync.Once
+	_upgrader websocket.Upgrader
+}
+
+// Endpoint returns a new endpoint on the server with the given config; see Endpoint.Handler.
+func (server *Server) Endpoint(config EndpointConfig) *Endpoint {
+	return &Endpoint{server: server, config: config}
+}
+
+// Handler serves the endpoint's websocket upgrades, like Server.WssHandler.
+func (endpoint *Endpoint) Handler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		endpoint.server.serveWebsocket(writer, request, endpoint)
+	})
+}
+
+// upgrader returns the server's upgrader with the endpoint's origin policy, once the server is set up.
+func (endpoint *Endpoint) upgrader() *websocket.Upgrader {
+	endpoint.once.Do(func() {
+		endpoint._upgrader = endpoint.server.upgrader
+		endpoint._upgrader.CheckOrigin = endpoint.checkOrigin
+	})
+
+	return &endpoint._upgrader
+}
+
+func (endpoint *Endpoint) checkOrigin(request *http.Request) bool {
+	if endpoint.config.CheckOrigin != nil {
+		return endpoint.config.CheckOrigin(request)
+	}
+
+	if len(endpoint.config.AllowedOrigins) == 0 {
+		return endpoint.server.checkOrigin(request)
+	}
+
+	return endpoint.server.allowOrigin(request, endpoint.config.AllowedOrigins)
+}
+
+// acquire counts a new connection, returning false if the endpoint is full.
+func (endpoint *Endpoint) acquire() bool {
+	count := endpoint.connections.Add(1)
+	if endpoint.config.MaxConnections > 0 && count > int64(endpoint.config.MaxConnections) {
+		endpoint.connections.Add(-1)
+		return false
+	}
+
+	return true
+}
+
+func (endpoint *Endpoint) release() {
+	endpoint.connections.Add(-1)
+}
+
+// Connections returns how many clients are connected through the endpoint.
+func (endpoint *Endpoint) Connections() int {
+	return int(endpoint.connections.Load())
+}
+
+// allows reports whether the endpoint's destination policy allows the action; a nil endpoint allows all.
+func (endpoint *Endpoint) allows(action string, destination string) bool {
+	if endpoint == nil {
+		return true
+	}
+
+	switch action {
+	case ActionSend:
+		return !endpoint.config.ReadOnly && matchPrefixes(endpoint.config.SendDestinations, destination)
+	case ActionSubscribe:
+		return matchPrefixes(endpoint.config.SubscribeDestinations, destination)
+	}
+
+	return true
+}
+
+// checkIdentity refuses an unauthenticated client when the endpoint requires an identity.
+func (endpoint *Endpoint) checkIdentity(client *Client) error {
+	if endpoint == nil || !endpoint.config.RequireIdentity || client.Identity != nil {
+		return nil
+	}
+
+	return fmt.Errorf("endpoint '%s' requires authentication", endpoint.config.Name)
+}
+
+// matchPrefixes reports whether the destination starts with one of prefixes, or true if there are none.
+func matchPrefixes(prefixes []string, destination string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(destination, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maxFrameSize is the frame size limit for the client, which its endpoint may override.
+func (server *Server) maxFrameSize(client *Client) int64 {
+	if client.endpoint != nil && client.endpoint.config.MaxFrameSize > 0 {
+		return client.endpoint.config.MaxFrameSize
+	}
+
+	return server.MaxFrameSize
+}
@@ -0,0 +1,96 @@
+package stomper
+
+import (
+	"github.com/gorilla/websocket"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dialEndpoint connects a STOMP 1.2 websocket client to an endpoint.
+func dialEndpoint(t *testing.T, endpoint *Endpoint) *websocket.Conn {
+	t.Helper()
+	httpServer := httptest.NewServer(endpoint.Handler())
+	t.Cleanup(httpServer.Close)
+
+	dialer := websocket.Dialer{Subprotocols: []string{"v12.stomp"}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("unable to dial: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return conn
+}
+
+func wsSend(t *testing.T, conn *websocket.Conn, frame string) {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+		t.Fatalf("unable to write: %v", err)
+	}
+}
+
+// wsNext returns the next frame, or nil if none arrives within d.
+func wsNext(t *testing.T, conn *websocket.Conn, d time.Duration) *StompMessage {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(d))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return nil
+	}
+
+	return parseTestFrame(data)
+}
+
+func wsSubscribe(t *testing.T, conn *websocket.Conn, destination string) {
+	t.Helper()
+	wsSend(t, conn, "CONNECT\naccept-version:1.2\n\n\x00")
+	if frame := wsNext(t, conn, time.Second); frame == nil || frame.Command != Connected {
+		t.Fatalf("expected CONNECTED, got %v", frame)
+	}
+
+	wsSend(t, conn, "SUBSCRIBE\nid:0\ndestination:"+destination+"\nreceipt:subscribed\n\n\x00")
+	if frame := wsNext(t, conn, time.Second); frame == nil || frame.Command != Receipt {
+		t.Fatalf("expected RECEIPT, got %v", frame)
+	}
+}
+
+func TestEndpointPolicyAppliesToWildcardMatches(t *testing.T) {
+	server, _ := newTestServer(t, nil)
+	conn := dialEndpoint(t, server.Endpoint(EndpointConfig{SubscribeDestinations: []string{"/topic/prices."}}))
+
+	// the pattern is within the endpoint's prefix, but # also matches /topic/prices itself
+	wsSubscribe(t, conn, "/topic/prices.#")
+	server.SendMessage("/topic/prices", "text/plain", "outside")
+	server.SendMessage("/topic/prices.eu", "text/plain", "inside")
+	if frame := wsNext(t, conn, time.Second); frame == nil || string(*frame.Body) != "inside" {
+		t.Fatalf("expected only the message inside the endpoint's destinations, got %v", frame)
+	}
+}
+
+func TestEndpointPolicyAppliesToQueueWildcards(t *testing.T) {
+	server, addr := newTestServer(t, func(server *Server) {
+		server.QueuePrefixes = []string{"/queue/"}
+	})
+
+	conn := dialEndpoint(t, server.Endpoint(EndpointConfig{SubscribeDestinations: []string{"/queue/jobs."}}))
+	wsSubscribe(t, conn, "/queue/jobs.#")
+
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("0", "/queue/jobs")
+	for i := 0; i < 4; i++ {
+		server.SendMessage("/queue/jobs", "text/plain", strconv.Itoa(i))
+		if frame := c.read(); string(*frame.Body) != strconv.Itoa(i) {
+			t.Fatalf("expected job %d, got %q", i, *frame.Body)
+		}
+	}
+
+	if frame := wsNext(t, conn, 50*time.Millisecond); frame != nil {
+		t.Fatalf("expected the endpoint's client not to be chosen, got %v", frame.Headers)
+	}
+}
@@ -0,0 +1,116 @@
+package stomper
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Attribute keys populated by the built-in enrichers.
+const (
+	AttributeCountry         = "country"
+	AttributeASN             = "asn"
+	AttributeASOrganization  = "as-org"
+	AttributeUserAgent       = "user-agent"
+	AttributeUserAgentFamily = "user-agent-family"
+)
+
+// EnrichHandler runs once per client before any connect handlers, attaching attributes to Client.Attributes.
+type EnrichHandler func(*Client, *http.Request)
+
+type GeoInfo struct {
+	Country        string
+	ASN            uint
+	ASOrganization string
+}
+
+// GeoResolver looks up location and network information for an IP, e.g. backed by a MaxMind database.
+type GeoResolver interface {
+	LookupIP(ip net.IP) (GeoInfo, error)
+}
+
+func (server *Server) AddEnrichHandler(handler EnrichHandler) error {
+	if server.setup {
+		return fmt.Errorf("unable to add enrich handler after %w", ErrAlreadySetup)
+	}
+
+	server.enrichHandlers = append(server.enrichHandlers, server.timedEnrichHandler(handler))
+	return nil
+}
+
+func (server *Server) enrich(client *Client, request *http.Request) {
+	for _, handler := range server.enrichHandlers {
+		handler(client, request)
+	}
+}
+
+// GeoIPEnricher resolves the client's RemoteAddr with the given resolver, setting country and ASN attributes.
+func (server *Server) GeoIPEnricher(resolver GeoResolver) EnrichHandler {
+	return func(client *Client, _ *http.Request) {
+		ip := net.ParseIP(client.RemoteAddr)
+		if ip == nil {
+			return
+		}
+
+		info, err := resolver.LookupIP(ip)
+		if err != nil {
+			server.Sugar.Debugf("[%d] unable to resolve geo info for %s: %v", client.Uid, ip, err)
+			return
+		}
+
+		if info.Country != "" {
+			client.Attributes[AttributeCountry] = info.Country
+		}
+
+		if info.ASN != 0 {
+			client.Attributes[AttributeASN] = strconv.FormatUint(uint64(info.ASN), 10)
+		}
+
+		if info.ASOrganization != "" {
+			client.Attributes[AttributeASOrganization] = info.ASOrganization
+		}
+	}
+}
+
+// UserAgentEnricher records the raw User-Agent and a coarse browser/library family for it.
+func UserAgentEnricher(client *Client, request *http.Request) {
+	userAgent := request.Header.Get("User-Agent")
+	if userAgent == "" {
+		return
+	}
+
+	client.Attributes[AttributeUserAgent] = userAgent
+	client.Attributes[AttributeUserAgentFamily] = UserAgentFamily(userAgent)
+}
+
+var userAgentFamilies = []struct {
+	token  string
+	family string
+}{
+	// order matters: Edge and Opera also claim to be Chrome, Chrome also claims to be Safari
+	{"Edg/", "edge"},
+	{"OPR/", "opera"},
+	{"Firefox/", "firefox"},
+	{"Chrome/", "chrome"},
+	{"CriOS/", "chrome"},
+	{"Safari/", "safari"},
+	{"okhttp/", "okhttp"},
+	{"Go-http-client/", "go"},
+	{"python", "python"},
+	{"curl/", "curl"},
+	{"bot", "bot"},
+}
+
+// UserAgentFamily maps a User-Agent header to a coarse family such as "chrome" or "firefox", or "other".
+func UserAgentFamily(userAgent string) string {
+	lower := strings.ToLower(userAgent)
+	for _, entry := range userAgentFamilies {
+		if strings.Contains(lower, strings.ToLower(entry.token)) {
+			return entry.family
+		}
+	}
+
+	return "other"
+}
@@ -0,0 +1,65 @@
+package stomper
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the server's APIs and passed to error handlers. Use errors.Is to classify them; a
+// *FrameError also matches ErrProtocol, or the more specific error it wraps.
+var (
+	// ErrNotSetup is returned when the server is used before Setup has been called.
+	ErrNotSetup = errors.New("server not setup")
+
+	// ErrAlreadySetup is returned when configuration is changed after Setup has been called.
+	ErrAlreadySetup = errors.New("server is setup")
+
+	// ErrProtocol means a client sent a frame that isn't valid STOMP.
+	ErrProtocol = errors.New("protocol violation")
+
+	// ErrFrameTooLarge means a client sent a frame larger than Server.MaxFrameSize.
+	ErrFrameTooLarge = errors.New("frame too large")
+
+	// ErrUnauthorized means a connect or subscribe handler refused the client.
+	ErrUnauthorized = errors.New("unauthorized")
+
+	// ErrUpgradeRequired means a client's app version is older than Server.ClientVersion allows.
+	ErrUpgradeRequired = errors.New("upgrade required")
+
+	// ErrPolicyViolation means a client sent a frame that breaks a DestinationPolicy.
+	ErrPolicyViolation = errors.New("destination policy violation")
+
+	// ErrQuotaExceeded means a subscription was refused because the client's principal is over quota, or a
+	// SEND because the client is over its SendQuota.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+
+	// ErrNotSubscribed means a message for one client couldn't be delivered as it has no matching subscription.
+	ErrNotSubscribed = errors.New("client is not subscribed")
+
+	// ErrBackpressure means a frame couldn't be queued because the client isn't keeping up; see
+	// SlowConsumerPolicy.
+	ErrBackpressure = errors.New("client is not keeping up")
+
+	// ErrClientGone means the client disconnected before a frame could be written to it.
+	ErrClientGone = errors.New("client disconnected")
+)
+
+// ErrorHandler is told about errors affecting a client: rejected frames, refused connects and subscriptions,
+// and failed writes. Client is nil for errors not tied to a connection.
+type ErrorHandler func(*Client, error)
+
+func (server *Server) AddErrorHandler(handler ErrorHandler) error {
+	if server.setup {
+		return fmt.Errorf("unable to add error handler after %w", ErrAlreadySetup)
+	}
+
+	server.errorHandlers = append(server.errorHandlers, handler)
+	return nil
+}
+
+func (server *Server) reportError(client *Client, err error) {
+	server.sessionEvent(client, SessionEventError, "", errorDetail(err))
+	for _, handler := range server.errorHandlers {
+		handler(client, err)
+	}
+}
@@ -0,0 +1,208 @@
+// Command chat is a small chat server built on stomper, showing rooms, presence, history replay and
+// login/passcode authentication together. Open http://localhost:8449/ in a couple of browser tabs.
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"github.com/hfoxy/stomper"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var addr = flag.String("addr", "localhost:8449", "http service address")
+var passcode = flag.String("passcode", "chat", "passcode every user must present on CONNECT")
+var historySize = flag.Int("history", 50, "messages kept per room for history replay")
+
+//go:embed index.html
+var indexPage []byte
+
+const (
+	roomPrefix     = "/topic/chat."
+	presencePrefix = "/topic/presence."
+	historyPrefix  = "/app/history."
+)
+
+type chatMessage struct {
+	User string    `json:"user"`
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+}
+
+type presenceEvent struct {
+	User   string   `json:"user"`
+	Event  string   `json:"event"`
+	Online []string `json:"online"`
+}
+
+type chat struct {
+	server  *stomper.Server
+	mux     sync.Mutex
+	history map[string][]chatMessage
+	members map[string]map[*stomper.Client]string
+}
+
+func newChat(server *stomper.Server) *chat {
+	return &chat{
+		server:  server,
+		history: make(map[string][]chatMessage),
+		members: make(map[string]map[*stomper.Client]string),
+	}
+}
+
+func user(client *stomper.Client) string {
+	return client.Headers["login"]
+}
+
+func (c *chat) authenticate(client *stomper.Client, _ http.Header, message *stomper.StompMessage) bool {
+	login := strings.TrimSpace(message.Headers["login"])
+	return login != "" && message.Headers["passcode"] == *passcode
+}
+
+// join records presence when a client subscribes to a room and announces it to the room's presence topic.
+func (c *chat) join(client *stomper.Client, destination string) bool {
+	if strings.HasPrefix(destination, presencePrefix) {
+		return true
+	}
+
+	room, ok := strings.CutPrefix(destination, roomPrefix)
+	if !ok || room == "" {
+		return false
+	}
+
+	c.mux.Lock()
+	members, ok := c.members[room]
+	if !ok {
+		members = make(map[*stomper.Client]string)
+		c.members[room] = members
+	}
+
+	_, resumed := members[client]
+	members[client] = user(client)
+	c.mux.Unlock()
+
+	if !resumed {
+		c.announce(room, user(client), "join")
+	}
+
+	return true
+}
+
+// resume hands a reloaded page's room memberships over to its new connection without announcing anything.
+func (c *chat) resume(previous *stomper.Client, current *stomper.Client) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for _, members := range c.members {
+		if name, ok := members[previous]; ok {
+			delete(members, previous)
+			members[current] = name
+		}
+	}
+}
+
+// leave announces a client unsubscribing from a room, which the server resolves from the subscription id.
+func (c *chat) leave(client *stomper.Client, destination string) {
+	room, ok := strings.CutPrefix(destination, roomPrefix)
+	if !ok {
+		return
+	}
+
+	c.mux.Lock()
+	delete(c.members[room], client)
+	c.mux.Unlock()
+
+	c.announce(room, user(client), "leave")
+}
+
+func (c *chat) disconnect(client *stomper.Client) {
+	c.mux.Lock()
+	var rooms []string
+	for room, members := range c.members {
+		if _, ok := members[client]; ok {
+			delete(members, client)
+			rooms = append(rooms, room)
+		}
+	}
+	c.mux.Unlock()
+
+	for _, room := range rooms {
+		c.announce(room, user(client), "leave")
+	}
+}
+
+func (c *chat) announce(room string, user string, event string) {
+	c.mux.Lock()
+	online := make([]string, 0, len(c.members[room]))
+	for _, name := range c.members[room] {
+		online = append(online, name)
+	}
+	c.mux.Unlock()
+
+	body, _ := json.Marshal(presenceEvent{User: user, Event: event, Online: online})
+	c.server.SendMessage(presencePrefix+room, "application/json", string(body))
+}
+
+// message handles SENDs to a room (stored and broadcast) and history requests (replayed to the sender only).
+func (c *chat) message(client *stomper.Client, destination string, message *stomper.StompMessage) {
+	if room, ok := strings.CutPrefix(destination, historyPrefix); ok {
+		c.mux.Lock()
+		history := append([]chatMessage(nil), c.history[room]...)
+		c.mux.Unlock()
+
+		for _, entry := range history {
+			body, _ := json.Marshal(entry)
+			c.server.SendMessageWithCheck(roomPrefix+room, "application/json", string(body), func(recipient *stomper.Client) bool {
+				return recipient == client
+			})
+		}
+
+		return
+	}
+
+	room, ok := strings.CutPrefix(destination, roomPrefix)
+	if !ok || message.Body == nil {
+		return
+	}
+
+	entry := chatMessage{User: user(client), Text: string(*message.Body), Time: time.Now()}
+	c.mux.Lock()
+	history := append(c.history[room], entry)
+	if len(history) > *historySize {
+		history = history[len(history)-*historySize:]
+	}
+
+	c.history[room] = history
+	c.mux.Unlock()
+
+	body, _ := json.Marshal(entry)
+	c.server.SendMessage(roomPrefix+room, "application/json", string(body))
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(0)
+
+	server := &stomper.Server{Strict: true, DisconnectGrace: 5 * time.Second}
+	app := newChat(server)
+
+	_ = server.AddConnectHandler(app.authenticate)
+	_ = server.AddSubscribeHandler(app.join)
+	_ = server.AddUnsubscribeHandler(app.leave)
+	_ = server.AddDisconnectHandler(app.disconnect)
+	_ = server.AddResumeHandler(app.resume)
+	_ = server.AddMessageHandler(app.message)
+	server.Setup()
+
+	http.HandleFunc("/ws", server.WssHandler)
+	http.HandleFunc("/", func(writer http.ResponseWriter, _ *http.Request) {
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = writer.Write(indexPage)
+	})
+
+	log.Printf("chat listening on http://%s/", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/hfoxy/stomper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var configFile = flag.String("config", "", "JSON config file, reloaded on change or SIGHUP; keys are flag names plus connect-rate-limit, destination-policies and acl")
+var logLevel = flag.String("log-level", "info", "debug, info, warn or error")
+
+// reloadable lists the config keys applied at runtime; every other key is a flag, read once at startup.
+var reloadable = map[string]bool{
+	"log-level":            true,
+	"origins":              true,
+	"connect-rate-limit":   true,
+	"destination-policies": true,
+	"acl":                  true,
+}
+
+type config struct {
+	values map[string]json.RawMessage
+}
+
+type rateLimitConfig struct {
+	Attempts    int      `json:"attempts"`
+	Window      duration `json:"window"`
+	BanAfter    int      `json:"ban-after"`
+	BanDuration duration `json:"ban-duration"`
+}
+
+type policyConfig struct {
+	Destination  string   `json:"destination"`
+	ContentTypes []string `json:"content-types"`
+	MaxBodySize  int      `json:"max-body-size"`
+}
+
+type aclConfig struct {
+	DefaultDeny bool `json:"default-deny"`
+	Rules       []struct {
+		Destination string   `json:"destination"`
+		Actions     []string `json:"actions"`
+		Roles       []string `json:"roles"`
+		Principals  []string `json:"principals"`
+	} `json:"rules"`
+}
+
+// duration reads a time.Duration written like 1m30s.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(value)
+	*d = duration(parsed)
+	return err
+}
+
+func loadConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &config{}
+	if err = json.Unmarshal(data, &c.values); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+
+	for key := range c.values {
+		if !reloadable[key] && flag.Lookup(key) == nil {
+			return nil, fmt.Errorf("unknown setting '%s' in %s", key, path)
+		}
+	}
+
+	return c, nil
+}
+
+// applyFlags sets the flags named in the config, except those given on the command line, which take precedence.
+func (c *config) applyFlags() error {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for key, raw := range c.values {
+		if explicit[key] || flag.Lookup(key) == nil {
+			continue
+		}
+
+		value, err := c.flagValue(key, raw)
+		if err != nil {
+			return err
+		}
+
+		if err = flag.Set(key, value); err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// flagValue returns a setting as it would be written on the command line; lists become comma separated.
+func (c *config) flagValue(key string, raw json.RawMessage) (string, error) {
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return strings.Join(list, ","), nil
+	}
+
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", fmt.Errorf("invalid %s: %w", key, err)
+	}
+
+	switch value.(type) {
+	case string, bool, float64:
+		return fmt.Sprint(value), nil
+	default:
+		return "", fmt.Errorf("invalid %s: expected a string, number, boolean or list of strings", key)
+	}
+}
+
+// restartRequired lists the settings which differ from the running ones but are only read at startup.
+func (c *config) restartRequired(running *config) []string {
+	keys := make(map[string]bool)
+	for key := range c.values {
+		keys[key] = true
+	}
+
+	for key := range running.values {
+		keys[key] = true
+	}
+
+	var changed []string
+	for key := range keys {
+		if !reloadable[key] && string(c.values[key]) != string(running.values[key]) {
+			changed = append(changed, key)
+		}
+	}
+
+	sort.Strings(changed)
+	return changed
+}
+
+func (c *config) decode(key string, target any) (bool, error) {
+	raw, ok := c.values[key]
+	if !ok {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(raw, target); err != nil {
+		return false, fmt.Errorf("invalid %s: %w", key, err)
+	}
+
+	return true, nil
+}
+
+// reloadable returns the runtime settings from the config, falling back to the flags for those it doesn't set.
+func (c *config) reloadable() (stomper.Reloadable, zapcore.Level, error) {
+	settings := stomper.Reloadable{AllowedOrigins: splitList(*origins)}
+	if _, err := c.decode("origins", &settings.AllowedOrigins); err != nil {
+		return settings, 0, err
+	}
+
+	level := *logLevel
+	if _, err := c.decode("log-level", &level); err != nil {
+		return settings, 0, err
+	}
+
+	parsedLevel, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return settings, 0, fmt.Errorf("invalid log-level: %w", err)
+	}
+
+	var rateLimit rateLimitConfig
+	if ok, err := c.decode("connect-rate-limit", &rateLimit); err != nil {
+		return settings, 0, err
+	} else if ok {
+		settings.ConnectRateLimit = &stomper.ConnectRateLimit{
+			Attempts:    rateLimit.Attempts,
+			Window:      time.Duration(rateLimit.Window),
+			BanAfter:    rateLimit.BanAfter,
+			BanDuration: time.Duration(rateLimit.BanDuration),
+		}
+	}
+
+	var policies []policyConfig
+	if _, err := c.decode("destination-policies", &policies); err != nil {
+		return settings, 0, err
+	}
+
+	for _, policy := range policies {
+		settings.DestinationPolicies = append(settings.DestinationPolicies, stomper.DestinationPolicy(policy))
+	}
+
+	var acl aclConfig
+	if ok, err := c.decode("acl", &acl); err != nil {
+		return settings, 0, err
+	} else if ok {
+		rules := make([]stomper.ACLRule, 0, len(acl.Rules))
+		for _, rule := range acl.Rules {
+			rules = append(rules, stomper.ACLRule(rule))
+		}
+
+		authorizer, err := stomper.NewACL(rules...)
+		if err != nil {
+			return settings, 0, err
+		}
+
+		authorizer.DefaultDeny = acl.DefaultDeny
+		settings.Authorizer = authorizer
+	}
+
+	return settings, parsedLevel, nil
+}
+
+// newLogger logs like the server's default logger, at a level which can be changed while it's running.
+func newLogger(level zap.AtomicLevel) *zap.SugaredLogger {
+	encoder := zap.NewProductionEncoderConfig()
+	encoder.EncodeTime = zapcore.ISO8601TimeEncoder
+	return zap.New(zapcore.NewCore(zapcore.NewConsoleEncoder(encoder), zapcore.AddSync(os.Stdout), level)).Sugar()
+}
+
+// reloader re-reads the config file when it changes or on demand, applying what it can to the running server.
+type reloader struct {
+	server  *stomper.Server
+	level   zap.AtomicLevel
+	path    string
+	running *config
+	modTime time.Time
+}
+
+func (r *reloader) reload() {
+	next, err := loadConfig(r.path)
+	if err == nil {
+		err = r.apply(next)
+	}
+
+	if err != nil {
+		r.server.Sugar.Errorf("unable to reload %s, keeping the running config: %v", r.path, err)
+		return
+	}
+
+	for _, key := range next.restartRequired(r.running) {
+		r.server.Sugar.Warnf("%s changed in %s, restart to apply it", key, r.path)
+	}
+
+	r.server.Sugar.Infof("reloaded %s", r.path)
+}
+
+func (r *reloader) apply(c *config) error {
+	settings, level, err := c.reloadable()
+	if err != nil {
+		return err
+	}
+
+	if err = r.server.Reload(settings); err != nil {
+		return err
+	}
+
+	r.level.SetLevel(level)
+	return nil
+}
+
+// watch reloads whenever the file's modification time changes, or a value arrives on signals.
+func (r *reloader) watch(signals <-chan os.Signal) {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-signals:
+		case <-ticker.C:
+			info, err := os.Stat(r.path)
+			if err != nil || info.ModTime().Equal(r.modTime) {
+				continue
+			}
+
+			r.modTime = info.ModTime()
+		}
+
+		r.reload()
+	}
+}
@@ -0,0 +1,69 @@
+package stomper
+
+import (
+	"strconv"
+	"time"
+)
+
+// TTLHeader on a message is how many milliseconds after it's published it expires; it's replaced by an
+// ExpiresHeader on publish. An expired message isn't delivered, whether it's waiting in an outbound queue, a
+// history buffer or a RetentionStore.
+const TTLHeader = "ttl"
+
+const defaultExpirySweepInterval = time.Minute
+
+// expiresAt returns when a message with these headers expires, or the zero time if it doesn't.
+func expiresAt(headers map[string]string) time.Time {
+	millis, err := strconv.ParseInt(headers[ExpiresHeader], 10, 64)
+	if err != nil || millis <= 0 {
+		return time.Time{}
+	}
+
+	return time.UnixMilli(millis)
+}
+
+func expired(expires time.Time, now time.Time) bool {
+	return !expires.IsZero() && !now.Before(expires)
+}
+
+// applyTTL returns the headers with a TTL replaced by the expires header it works out to.
+func (server *Server) applyTTL(headers map[string]string) map[string]string {
+	ttl, ok := headers[TTLHeader]
+	if !ok {
+		return headers
+	}
+
+	applied := make(map[string]string, len(headers))
+	for k, v := range headers {
+		if k != TTLHeader {
+			applied[k] = v
+		}
+	}
+
+	if millis, err := strconv.ParseInt(ttl, 10, 64); err == nil && millis > 0 && applied[ExpiresHeader] == "" {
+		expires := server.clock().Now().Add(time.Duration(millis) * time.Millisecond)
+		applied[ExpiresHeader] = strconv.FormatInt(expires.UnixMilli(), 10)
+	}
+
+	return applied
+}
+
+// expiryLoop purges expired messages from the RetentionStore every ExpirySweepInterval.
+func (server *Server) expiryLoop() {
+	interval := server.ExpirySweepInterval
+	if interval <= 0 {
+		interval = defaultExpirySweepInterval
+	}
+
+	ticker := server.clock().NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C() {
+		purged, err := server.RetentionStore.PurgeExpired(server.clock().Now())
+		if err != nil {
+			server.Sugar.Warnf("unable to purge expired retained messages: %v", err)
+		} else if purged > 0 {
+			server.Sugar.Debugf("purged %d expired retained messages", purged)
+		}
+	}
+}
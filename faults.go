@@ -0,0 +1,145 @@
+package stomper
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type FaultConfig struct {
+	// Enabled switches all faults on or off without losing the rest of the configuration.
+	Enabled bool `json:"enabled"`
+
+	// DropRate is the probability (0-1) that an outbound frame is silently discarded.
+	DropRate float64 `json:"dropRate"`
+
+	// Latency is added before every outbound frame, plus a random amount up to Jitter (nanoseconds in JSON).
+	Latency time.Duration `json:"latency"`
+	Jitter  time.Duration `json:"jitter"`
+
+	// DisconnectRate is the probability (0-1), per outbound frame, that the connection is closed instead.
+	DisconnectRate float64 `json:"disconnectRate"`
+
+	// HeartBeatDropRate is the probability (0-1) that a received heart-beat is ignored by liveness tracking.
+	HeartBeatDropRate float64 `json:"heartBeatDropRate"`
+}
+
+// FaultInjector makes the server misbehave on purpose so clients' reconnect and dedup logic can be exercised.
+// It is meant for test environments only and does nothing until enabled.
+type FaultInjector struct {
+	mux    sync.RWMutex
+	config FaultConfig
+	rand   *rand.Rand
+}
+
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (faults *FaultInjector) Config() FaultConfig {
+	faults.mux.RLock()
+	defer faults.mux.RUnlock()
+	return faults.config
+}
+
+func (faults *FaultInjector) Configure(config FaultConfig) {
+	faults.mux.Lock()
+	defer faults.mux.Unlock()
+	faults.config = config
+}
+
+func (faults *FaultInjector) chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+
+	faults.mux.Lock()
+	defer faults.mux.Unlock()
+	return faults.rand.Float64() < rate
+}
+
+type faultAction int
+
+const (
+	faultNone faultAction = iota
+	faultDrop
+	faultDisconnect
+)
+
+// outbound applies latency and decides what should happen to the next outbound frame.
+func (faults *FaultInjector) outbound(clock Clock) faultAction {
+	if faults == nil {
+		return faultNone
+	}
+
+	config := faults.Config()
+	if !config.Enabled {
+		return faultNone
+	}
+
+	delay := config.Latency
+	if config.Jitter > 0 {
+		faults.mux.Lock()
+		delay += time.Duration(faults.rand.Int63n(int64(config.Jitter)))
+		faults.mux.Unlock()
+	}
+
+	if delay > 0 {
+		clock.Sleep(delay)
+	}
+
+	if faults.chance(config.DisconnectRate) {
+		return faultDisconnect
+	}
+
+	if faults.chance(config.DropRate) {
+		return faultDrop
+	}
+
+	return faultNone
+}
+
+func (faults *FaultInjector) dropHeartBeat() bool {
+	if faults == nil {
+		return false
+	}
+
+	config := faults.Config()
+	return config.Enabled && faults.chance(config.HeartBeatDropRate)
+}
+
+// FaultsHandler exposes the injector as a small admin API: GET returns the current configuration, PUT or POST
+// replaces it with the JSON body, and DELETE disables all faults.
+func (server *Server) FaultsHandler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		server.init()
+		if server.Faults == nil {
+			http.Error(writer, "fault injection not configured", http.StatusNotFound)
+			return
+		}
+
+		switch request.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var config FaultConfig
+			if err := json.NewDecoder(request.Body).Decode(&config); err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			server.Faults.Configure(config)
+			server.Sugar.Warnf("fault injection updated: %+v", config)
+		case http.MethodDelete:
+			server.Faults.Configure(FaultConfig{})
+			server.Sugar.Warnf("fault injection disabled")
+		default:
+			http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(writer).Encode(server.Faults.Config())
+	})
+}
@@ -0,0 +1,122 @@
+package stomper
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The optional extensions a client asks for on SUBSCRIBE, which are gated per destination once FeatureFlags
+// are set.
+const (
+	// FeatureCodecs re-encodes JSON messages in the content type of the subscription's accept header.
+	FeatureCodecs = "codecs"
+
+	// FeatureDigest batches messages for subscriptions with a DigestHeader.
+	FeatureDigest = "digest"
+
+	// FeatureReplay replays the history a SUBSCRIBE asks for with ReplayHeader or LastEventIdHeader.
+	FeatureReplay = "replay"
+)
+
+// FeatureFlags is consulted before the server uses an optional or experimental behaviour for a client and
+// destination. Destination is empty for features that aren't destination specific.
+type FeatureFlags interface {
+	Enabled(feature string, destination string, client *Client) bool
+}
+
+// FeatureFlagsFunc adapts an ordinary function, such as a call into LaunchDarkly or Unleash, to FeatureFlags.
+type FeatureFlagsFunc func(feature string, destination string, client *Client) bool
+
+func (f FeatureFlagsFunc) Enabled(feature string, destination string, client *Client) bool {
+	return f(feature, destination, client)
+}
+
+type FeatureRule struct {
+	// Percentage of clients (0-100) the feature is rolled out to. Clients are bucketed by uid.
+	Percentage int
+
+	// Destinations limits the feature to destinations with one of these prefixes. Empty means all.
+	Destinations []string
+}
+
+// InMemoryFeatureFlags is a FeatureFlags backed by a map of rules which can be changed at runtime.
+type InMemoryFeatureFlags struct {
+	mux   sync.RWMutex
+	rules map[string]FeatureRule
+}
+
+func NewInMemoryFeatureFlags() *InMemoryFeatureFlags {
+	return &InMemoryFeatureFlags{rules: make(map[string]FeatureRule)}
+}
+
+func (flags *InMemoryFeatureFlags) Set(feature string, rule FeatureRule) {
+	flags.mux.Lock()
+	defer flags.mux.Unlock()
+	flags.rules[feature] = rule
+}
+
+func (flags *InMemoryFeatureFlags) Remove(feature string) {
+	flags.mux.Lock()
+	defer flags.mux.Unlock()
+	delete(flags.rules, feature)
+}
+
+func (flags *InMemoryFeatureFlags) Enabled(feature string, destination string, client *Client) bool {
+	flags.mux.RLock()
+	rule, ok := flags.rules[feature]
+	flags.mux.RUnlock()
+
+	if !ok || rule.Percentage <= 0 {
+		return false
+	}
+
+	if len(rule.Destinations) > 0 {
+		matched := false
+		for _, prefix := range rule.Destinations {
+			if strings.HasPrefix(destination, prefix) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	if rule.Percentage >= 100 {
+		return true
+	}
+
+	var uid uint64
+	if client != nil {
+		uid = client.Uid
+	}
+
+	return rolloutBucket(feature, uid) < rule.Percentage
+}
+
+// rolloutBucket places a client in a 0-99 bucket per feature, so the same client isn't always first in line.
+func rolloutBucket(feature string, uid uint64) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(feature))
+	_, _ = hash.Write([]byte(strconv.FormatUint(uid, 10)))
+	return int(hash.Sum32() % 100)
+}
+
+// FeatureEnabled reports whether the configured FeatureFlags enable a feature. Without flags, nothing is enabled.
+func (server *Server) FeatureEnabled(feature string, destination string, client *Client) bool {
+	if server.FeatureFlags == nil {
+		return false
+	}
+
+	return server.FeatureFlags.Enabled(feature, destination, client)
+}
+
+// extensionEnabled reports whether a client may use one of the optional extensions on a destination. They are
+// all available without FeatureFlags; once flags are set, each needs a rule enabling it.
+func (server *Server) extensionEnabled(feature string, destination string, client *Client) bool {
+	return server.FeatureFlags == nil || server.FeatureFlags.Enabled(feature, destination, client)
+}
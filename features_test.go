@@ -0,0 +1,48 @@
+package stomper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExtensionsFollowFeatureFlags(t *testing.T) {
+	flags := NewInMemoryFeatureFlags()
+	flags.Set(FeatureCodecs, FeatureRule{Percentage: 100, Destinations: []string{"/topic/binary"}})
+	server, addr := newTestServer(t, func(server *Server) {
+		server.Codecs = []Codec{MsgpackCodec}
+		server.FeatureFlags = flags
+		server.HistoryDestinations = []string{"/topic/json"}
+	})
+
+	server.SendMessage("/topic/json", "application/json", `{"id":0}`)
+
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("binary", "/topic/binary", "accept:application/msgpack")
+	c.subscribe("json", "/topic/json", "accept:application/msgpack", "digest:60", "replay:10")
+
+	// without a rule, the JSON subscription gets neither a replay nor a digest
+	server.SendMessage("/topic/json", "application/json", `{"id":1}`)
+	if frame := c.read(); frame.Headers["content-type"] != "application/json" || string(*frame.Body) != `{"id":1}` {
+		t.Fatalf("expected the message as sent, got %v %q", frame.Headers, *frame.Body)
+	}
+
+	server.SendMessage("/topic/binary", "application/json", `{"id":2}`)
+	if frame := c.read(); frame.Headers["content-type"] != "application/msgpack" {
+		t.Fatalf("expected the message re-encoded, got %v", frame.Headers)
+	}
+
+	c.quiet(50 * time.Millisecond)
+}
+
+func TestExtensionsWithoutFeatureFlags(t *testing.T) {
+	server, addr := newTestServer(t, func(server *Server) {
+		server.Codecs = []Codec{MsgpackCodec}
+	})
+
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("0", "/topic/json", "accept:application/msgpack")
+	server.SendMessage("/topic/json", "application/json", `{"id":1}`)
+	if frame := c.read(); frame.Headers["content-type"] != "application/msgpack" {
+		t.Fatalf("expected the message re-encoded, got %v", frame.Headers)
+	}
+}
@@ -0,0 +1,117 @@
+package stomper
+
+import (
+	"fmt"
+	"sync"
+)
+
+// SessionTokenHeader on CONNECT is a token the client keeps across page reloads, e.g. in sessionStorage.
+// With Server.DisconnectGrace set, a client reconnecting with the same token (and principal) soon after
+// dropping resumes its session instead of leaving and joining again.
+const SessionTokenHeader = "session-token"
+
+// ResumeHandler is called when a client resumes the session of a connection which dropped within
+// Server.DisconnectGrace; the disconnect handlers are then never called for the previous connection.
+type ResumeHandler func(previous *Client, current *Client)
+
+func (server *Server) AddResumeHandler(handler ResumeHandler) error {
+	if server.setup {
+		return fmt.Errorf("unable to add resume handler after %w", ErrAlreadySetup)
+	}
+
+	server.resumeHandlers = append(server.resumeHandlers, server.timedResumeHandler(handler))
+	return nil
+}
+
+// pendingDisconnects holds dropped connections, by session token, whose disconnect handlers are waiting
+// out the grace period.
+type pendingDisconnects struct {
+	mux     sync.Mutex
+	pending map[string]*pendingDisconnect
+}
+
+type pendingDisconnect struct {
+	client  *Client
+	resumed chan struct{}
+}
+
+func (server *Server) runDisconnectHandlers(client *Client) {
+	for _, handler := range server.disconnectHandlers {
+		handler(client)
+	}
+}
+
+// disconnected runs the disconnect handlers for a dropped client, after the grace period if it has a
+// session token it may come back with.
+func (server *Server) disconnected(client *Client) {
+	token := client.Headers[SessionTokenHeader]
+	if server.DisconnectGrace <= 0 || token == "" {
+		server.runDisconnectHandlers(client)
+		return
+	}
+
+	pending := &pendingDisconnect{client: client, resumed: make(chan struct{})}
+	disconnects := &server.pendingDisconnects
+	disconnects.mux.Lock()
+	if disconnects.pending == nil {
+		disconnects.pending = make(map[string]*pendingDisconnect)
+	}
+
+	// an earlier connection with the same token that never came back has left for good
+	previous := disconnects.pending[token]
+	disconnects.pending[token] = pending
+	disconnects.mux.Unlock()
+
+	if previous != nil {
+		close(previous.resumed)
+		server.runDisconnectHandlers(previous.client)
+	}
+
+	go func() {
+		timer := server.clock().NewTimer(server.DisconnectGrace)
+		defer timer.Stop()
+
+		select {
+		case <-pending.resumed:
+			return
+		case <-timer.C():
+		}
+
+		disconnects.mux.Lock()
+		expired := disconnects.pending[token] == pending
+		if expired {
+			delete(disconnects.pending, token)
+		}
+		disconnects.mux.Unlock()
+
+		if expired {
+			server.Sugar.Debugf("[%d] session not resumed within %s", client.Uid, server.DisconnectGrace)
+			server.runDisconnectHandlers(client)
+		}
+	}()
+}
+
+// resume continues the session of a recently dropped connection with the same session token and principal.
+func (server *Server) resume(client *Client) {
+	token := client.Headers[SessionTokenHeader]
+	if server.DisconnectGrace <= 0 || token == "" {
+		return
+	}
+
+	disconnects := &server.pendingDisconnects
+	disconnects.mux.Lock()
+	pending, ok := disconnects.pending[token]
+	if !ok || server.principal(pending.client) != server.principal(client) {
+		disconnects.mux.Unlock()
+		return
+	}
+
+	delete(disconnects.pending, token)
+	disconnects.mux.Unlock()
+
+	close(pending.resumed)
+	server.Sugar.Debugf("[%d] resumed session of [%d]", client.Uid, pending.client.Uid)
+	for _, handler := range server.resumeHandlers {
+		handler(pending.client, client)
+	}
+}
@@ -0,0 +1,240 @@
+package stomper
+
+import (
+	"bytes"
+	"context"
+	"github.com/hfoxy/stomper/stomperpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:generate protoc --go_out=. --go_opt=module=github.com/hfoxy/stomper --go-grpc_out=. --go-grpc_opt=module=github.com/hfoxy/stomper stomper.proto
+
+// GRPCService is the gRPC service served by GRPCHandler, described by stomper.proto.
+const GRPCService = "stomper.v1.Broker"
+
+const grpcSubscriptionId = "grpc"
+
+// grpcRequestKey holds the HTTP request a call came in on in its context.
+type grpcRequestKey struct{}
+
+// GRPCHandler serves the gRPC service in stomper.proto, for internal services which would rather not speak
+// STOMP: Publish broadcasts a message like PublishHandler, and Subscribe streams the MESSAGEs sent to a
+// destination, subscribing in the same registry as websocket clients. Mount it at "/"+GRPCService+"/" on a
+// server speaking HTTP/2, which net/http does over TLS. Calls are refused unless authenticate accepts them,
+// and requests are limited to MaxFrameSize (default 1MiB).
+func (server *Server) GRPCHandler(authenticate func(*http.Request) error) http.Handler {
+	limit := server.MaxFrameSize
+	if limit <= 0 {
+		limit = defaultPublishBodyLimit
+	}
+
+	check := func(ctx context.Context) error {
+		if !server.setup {
+			return status.Error(codes.Internal, ErrNotSetup.Error())
+		}
+
+		request, _ := ctx.Value(grpcRequestKey{}).(*http.Request)
+		if request == nil || authenticate == nil || authenticate(request) != nil {
+			return status.Error(codes.Unauthenticated, "unauthenticated")
+		}
+
+		return nil
+	}
+
+	grpcServer := grpc.NewServer(
+		grpc.MaxRecvMsgSize(int(limit)),
+		grpc.UnaryInterceptor(func(ctx context.Context, request any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if err := check(ctx); err != nil {
+				return nil, err
+			}
+
+			return handler(ctx, request)
+		}),
+		grpc.StreamInterceptor(func(service any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := check(stream.Context()); err != nil {
+				return err
+			}
+
+			return handler(service, stream)
+		}),
+	)
+
+	stomperpb.RegisterBrokerServer(grpcServer, &grpcBroker{server: server})
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		server.init()
+		if request.ProtoMajor != 2 || !strings.HasPrefix(request.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(writer, "grpc requires http/2 and application/grpc", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		grpcServer.ServeHTTP(writer, request.WithContext(context.WithValue(request.Context(), grpcRequestKey{}, request)))
+	})
+}
+
+// grpcBroker implements the Broker service for GRPCHandler.
+type grpcBroker struct {
+	stomperpb.UnimplementedBrokerServer
+	server *Server
+}
+
+// checkGRPCDestination refuses missing and user destinations.
+func checkGRPCDestination(destination string) error {
+	if destination == "" {
+		return status.Error(codes.InvalidArgument, "missing destination")
+	}
+
+	if isUserDestination(destination) {
+		return status.Error(codes.PermissionDenied, "user destinations aren't available over grpc")
+	}
+
+	return nil
+}
+
+func (broker *grpcBroker) Publish(_ context.Context, message *stomperpb.Message) (*stomperpb.PublishResponse, error) {
+	server := broker.server
+	if err := checkGRPCDestination(message.Destination); err != nil {
+		return nil, err
+	}
+
+	contentType := message.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	stompMsg := &StompMessage{Command: Send, Headers: map[string]string{"destination": message.Destination, "content-type": contentType}, Body: &message.Body}
+	if err := server.checkDestinationPolicy(message.Destination, stompMsg); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	server.Sugar.Debugf("published %d bytes to '%s' over grpc", len(message.Body), message.Destination)
+	server.SendMessageWithHeaders(message.Destination, contentType, string(message.Body), message.Headers, nil)
+	return &stomperpb.PublishResponse{}, nil
+}
+
+// Subscribe streams MESSAGEs to the destination until the caller goes away, through a client without a
+// STOMP connection.
+func (broker *grpcBroker) Subscribe(subscription *stomperpb.SubscribeRequest, stream stomperpb.Broker_SubscribeServer) error {
+	server := broker.server
+	if err := checkGRPCDestination(subscription.Destination); err != nil {
+		return err
+	}
+
+	request := stream.Context().Value(grpcRequestKey{}).(*http.Request)
+	transport := &grpcTransport{stream: stream, closed: make(chan struct{})}
+	client := newClient(transport, server.clientUid.Add(1), server.clientIP(request), make(map[string]string), server.clock().Now(), server.outboundQueueSize())
+	client.Version = "1.2"
+	if !server.authorizeSubscription(stream.Context(), client, subscription.Destination) {
+		return status.Errorf(codes.PermissionDenied, "subscription to '%s' refused", subscription.Destination)
+	}
+
+	pumped := make(chan struct{})
+	go func() {
+		defer close(pumped)
+		server.writePump(client)
+	}()
+
+	server.subscribe(client, subscription.Destination, grpcSubscriptionId, nil)
+	server.Sugar.Infof("[%d] subscribed to '%s' over grpc", client.Uid, subscription.Destination)
+
+	// headers tell the caller it's subscribed
+	if err := stream.SendHeader(nil); err != nil {
+		_ = transport.Close()
+	}
+
+	select {
+	case <-stream.Context().Done():
+	case <-transport.closed:
+	}
+
+	server.removeClient(client)
+	close(client.done)
+	<-pumped
+	server.Sugar.Infof("[%d] grpc subscription to '%s' ended", client.Uid, subscription.Destination)
+	return nil
+}
+
+// grpcTransport streams the MESSAGE frames written to a gRPC subscriber as protobuf messages; other frames
+// are dropped, as there's no STOMP connection to send them on.
+type grpcTransport struct {
+	stream    stomperpb.Broker_SubscribeServer
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (stream *grpcTransport) readFrame() ([]byte, error) {
+	<-stream.closed
+	return nil, io.EOF
+}
+
+func (stream *grpcTransport) writeParts(parts [][]byte, _ bool) error {
+	message, ok := decodeMessageFrame(bytes.Join(parts, nil))
+	if !ok {
+		return nil
+	}
+
+	return stream.stream.Send(message)
+}
+
+func (stream *grpcTransport) writePrepared(frame *preparedFrame) error {
+	return stream.writeParts([][]byte{frame.payload}, false)
+}
+
+// setWriteDeadline does nothing, as gRPC streams have no write deadlines; a stalled caller holds up only its
+// own queue, which SlowConsumerPolicy deals with.
+func (stream *grpcTransport) setWriteDeadline(time.Time) error {
+	return nil
+}
+
+func (stream *grpcTransport) setReadLimit(int64) {}
+
+func (stream *grpcTransport) Close() error {
+	stream.closeOnce.Do(func() {
+		close(stream.closed)
+	})
+
+	return nil
+}
+
+// decodeMessageFrame turns a MESSAGE frame into a protobuf Message, with every header besides destination,
+// content-type, content-length and subscription in Headers.
+func decodeMessageFrame(frame []byte) (*stomperpb.Message, bool) {
+	header, body, ok := bytes.Cut(frame, []byte("\n\n"))
+	if !ok {
+		return nil, false
+	}
+
+	lines := strings.Split(string(header), "\n")
+	if lines[0] != string(Message) {
+		return nil, false
+	}
+
+	message := &stomperpb.Message{Headers: make(map[string]string)}
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+
+		name, _ = unescapeHeader(name, "1.2")
+		value, _ = unescapeHeader(value, "1.2")
+		switch name {
+		case "destination":
+			message.Destination = value
+		case "content-type":
+			message.ContentType = value
+		case "content-length", "subscription":
+		default:
+			message.Headers[name] = value
+		}
+	}
+
+	message.Body = bytes.TrimSuffix(body, nullTerminator)
+	return message, true
+}
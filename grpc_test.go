@@ -0,0 +1,111 @@
+package stomper
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/hfoxy/stomper/stomperpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newGRPCClient serves server's GRPCHandler over HTTP/2, accepting the bearer token "s3cret".
+func newGRPCClient(t *testing.T, server *Server) stomperpb.BrokerClient {
+	t.Helper()
+	handler := server.GRPCHandler(func(request *http.Request) error {
+		if request.Header.Get("Authorization") != "Bearer s3cret" {
+			return errors.New("bad token")
+		}
+
+		return nil
+	})
+
+	httpServer := httptest.NewUnstartedServer(handler)
+	httpServer.EnableHTTP2 = true
+	httpServer.StartTLS()
+	t.Cleanup(httpServer.Close)
+
+	config := &tls.Config{RootCAs: httpServer.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+	conn, err := grpc.Dial(strings.TrimPrefix(httpServer.URL, "https://"), grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	if err != nil {
+		t.Fatalf("unable to dial: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return stomperpb.NewBrokerClient(conn)
+}
+
+func authorized(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer s3cret")
+}
+
+func TestGRPCPublish(t *testing.T) {
+	server, addr := newTestServer(t, nil)
+	broker := newGRPCClient(t, server)
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("0", "/topic/orders")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	message := &stomperpb.Message{Destination: "/topic/orders", ContentType: "text/plain", Body: []byte("hello"), Headers: map[string]string{"trace-id": "abc"}}
+	if _, err := broker.Publish(authorized(ctx), message); err != nil {
+		t.Fatalf("unable to publish: %v", err)
+	}
+
+	frame := c.read()
+	if string(*frame.Body) != "hello" || frame.Headers["trace-id"] != "abc" || frame.Headers["content-type"] != "text/plain" {
+		t.Fatalf("unexpected message %v %q", frame.Headers, *frame.Body)
+	}
+
+	if _, err := broker.Publish(ctx, message); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected an unauthenticated call to be refused, got %v", err)
+	}
+
+	if _, err := broker.Publish(authorized(ctx), &stomperpb.Message{Destination: "/user/alice/inbox"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected a user destination to be refused, got %v", err)
+	}
+}
+
+func TestGRPCSubscribe(t *testing.T) {
+	server, _ := newTestServer(t, nil)
+	broker := newGRPCClient(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	stream, err := broker.Subscribe(authorized(ctx), &stomperpb.SubscribeRequest{Destination: "/topic/orders"})
+	if err != nil {
+		t.Fatalf("unable to subscribe: %v", err)
+	}
+
+	// the subscription is registered once headers have been sent
+	if _, err = stream.Header(); err != nil {
+		t.Fatalf("subscription failed: %v", err)
+	}
+
+	server.SendMessageWithHeaders("/topic/orders", "application/json", `{"id":1}`, map[string]string{"region": "eu"}, nil)
+	message, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("expected a message: %v", err)
+	}
+
+	if message.Destination != "/topic/orders" || message.ContentType != "application/json" || string(message.Body) != `{"id":1}` || message.Headers["region"] != "eu" {
+		t.Fatalf("unexpected message %v", message)
+	}
+
+	if _, ok := message.Headers["subscription"]; ok {
+		t.Fatalf("expected STOMP-only headers to be left out, got %v", message.Headers)
+	}
+}
@@ -2,155 +2,609 @@ package stomper
 
 import (
 	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"github.com/gorilla/websocket"
+	"io"
 	"net/http"
 	"reflect"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var endOfHeaders []byte
-var heartBeatPayload = []byte("\n")
 
 // Client is a wrapper over ws connection.
 type Client struct {
+	// Conn is the client's websocket connection, and nil for clients connected over TCP (see ListenTCP).
 	Conn    *websocket.Conn
 	Uid     uint64
 	Headers map[string]string
+
+	// RemoteAddr is the client's IP, resolved through Server.TrustedProxies when behind a load balancer.
+	RemoteAddr string
+
+	// Attributes are set by enrich handlers before the CONNECT frame is processed (country, user agent, etc.).
+	Attributes map[string]string
+
+	// Version is the STOMP protocol version negotiated on CONNECT.
+	Version string
+
+	// HeartBeat is the negotiated heart-beat schedule, available to connect handlers.
+	HeartBeat HeartBeat
+
+	// Identity is set by Server.Authenticator on CONNECT, and nil without one.
+	Identity *Identity
+
+	// VerifiedChains holds the client certificate chains verified during a mutual TLS handshake.
+	VerifiedChains [][]*x509.Certificate
+
+	// Endpoint is the name of the Endpoint the client connected through, and empty for WssHandler and TCP.
+	Endpoint string
+
+	lastHeartBeat atomic.Int64
+	lastReceived  atomic.Int64
+	lastSent      atomic.Int64
+	usage         clientUsage
+	session       sync.Map
+	transport     transport
+	endpoint      *Endpoint
+	timeline      *sessionTimeline
+	readOnly      bool
+	connected     bool
+	accepts       sync.Map
+	ackModes      sync.Map
+	congested     atomic.Bool
+	evicted       atomic.Bool
+	digests       sync.Map
+	digestCount   atomic.Int32
+	replays       sync.Map
+	replayCount   atomic.Int32
+	conflation    sync.Map
+	outbound      chan outboundFrame
+	done          chan struct{}
+}
+
+func newClient(transport transport, uid uint64, remoteAddr string, headers map[string]string, now time.Time, queueSize int) *Client {
+	client := &Client{transport: transport, Uid: uid, Headers: headers, RemoteAddr: remoteAddr}
+	if ws, ok := transport.(*websocketTransport); ok {
+		client.Conn = ws.conn
+	}
+
+	client.Attributes = make(map[string]string)
+	client.done = make(chan struct{})
+	client.outbound = make(chan outboundFrame, queueSize)
+	client.lastReceived.Store(now.UnixNano())
+	client.usage.since.Store(now.UnixNano())
+	return client
+}
+
+// LastHeartBeat returns when the client last sent a heart-beat, or the zero time if it never has.
+func (client *Client) LastHeartBeat() time.Time {
+	return unixNanoTime(client.lastHeartBeat.Load())
+}
+
+// LastSent returns when a frame or heart-beat was last written to the client.
+func (client *Client) LastSent() time.Time {
+	return unixNanoTime(client.lastSent.Load())
+}
+
+// LastReceived returns when anything (a frame or a heart-beat) was last read from the client.
+func (client *Client) LastReceived() time.Time {
+	return unixNanoTime(client.lastReceived.Load())
+}
+
+func (client *Client) received(heartBeat bool, now time.Time) {
+	client.lastReceived.Store(now.UnixNano())
+	if heartBeat {
+		client.lastHeartBeat.Store(now.UnixNano())
+	}
+}
+
+// gone reports whether the client's connection has been closed.
+func (client *Client) gone() bool {
+	select {
+	case <-client.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (client *Client) sent(now time.Time) {
+	client.lastSent.Store(now.UnixNano())
+}
+
+func unixNanoTime(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, nanos)
 }
 
-var _mutex sync.Mutex
-var clientUid uint64 = 0
+// isHeartBeat reports whether the payload consists only of EOLs, each either "\n" or "\r\n".
+func isHeartBeat(message []byte) bool {
+	if len(message) == 0 {
+		return false
+	}
 
-func newClient(conn *websocket.Conn, headers map[string]string) *Client {
-	_mutex.Lock()
-	defer _mutex.Unlock()
+	for i := 0; i < len(message); i++ {
+		switch message[i] {
+		case '\n':
+		case '\r':
+			if i+1 >= len(message) || message[i+1] != '\n' {
+				return false
+			}
+		default:
+			return false
+		}
+	}
 
-	clientUid++
-	return &Client{conn, clientUid, headers}
+	return true
 }
 
 func (server *Server) WssHandler(writer http.ResponseWriter, request *http.Request) {
+	server.serveWebsocket(writer, request, nil)
+}
+
+// serveWebsocket upgrades the request and serves the client, held to the endpoint's policies when it's set.
+func (server *Server) serveWebsocket(writer http.ResponseWriter, request *http.Request, endpoint *Endpoint) {
+	server.init()
 	if !server.setup {
-		server.Sugar.Errorf("server not setup")
+		server.Sugar.Errorf("unable to accept connection: %v", ErrNotSetup)
+		http.Error(writer, ErrNotSetup.Error(), http.StatusServiceUnavailable)
 		return
 	}
 
-	_conn, err := server.upgrader.Upgrade(writer, request, nil)
+	ip := server.clientIP(request)
+	if !server.admit(writer, request, ip) {
+		return
+	}
+
+	upgrader := &server.upgrader
+	if endpoint != nil {
+		if !endpoint.acquire() {
+			server.Sugar.Infof("rejected connection to endpoint '%s': too many connections", endpoint.config.Name)
+			http.Error(writer, "too many connections", http.StatusServiceUnavailable)
+			return
+		}
+
+		upgrader = endpoint.upgrader()
+	}
+
+	_conn, err := upgrader.Upgrade(writer, request, nil)
 	if err != nil {
 		server.Sugar.Warnf("failed to upgrade: %v", err)
-		writer.Write([]byte(fmt.Sprintf("%v", err)))
+		if endpoint != nil {
+			endpoint.release()
+		}
+
 		return
 	}
 
-	client := newClient(_conn, make(map[string]string))
-	go server.clientHandler(client, request.Header)
+	client := newClient(&websocketTransport{conn: _conn}, server.clientUid.Add(1), ip, make(map[string]string), server.clock().Now(), server.outboundQueueSize())
+	if request.TLS != nil {
+		client.VerifiedChains = request.TLS.VerifiedChains
+	}
+
+	server.trackSession(client)
+	if endpoint != nil {
+		client.endpoint = endpoint
+		client.Endpoint = endpoint.config.Name
+		go func() {
+			<-client.done
+			endpoint.release()
+		}()
+	}
+
+	go server.writePump(client)
+	go server.clientHandler(client, request)
 }
 
-func (server *Server) clientHandler(client *Client, header http.Header) {
+func (server *Server) clientHandler(client *Client, request *http.Request) {
 	defer func() {
-		defer client.Conn.Close()
-		for _, handler := range server.disconnectHandlers {
-			handler(client)
-		}
+		defer client.transport.Close()
 
+		// let queued frames (a final ERROR or RECEIPT) reach the client before the connection goes away
+		server.flush(client, time.Second)
+		close(client.done)
+		server.disconnected(client)
 		server.removeClient(client)
+		server.saveSession(client)
+		server.dropAcks(client, "")
+		server.forgetExpiries(client)
+		server.stopQueries(client)
+		server.flushUsage(client)
+		if server.Recorder != nil {
+			server.Recorder.Close(client)
+		}
+
+		if server.DevConsole != nil {
+			server.DevConsole.Close(client)
+		}
 	}()
 
+	server.enrich(client, request)
+	if limit := server.maxFrameSize(client); limit > 0 {
+		client.transport.setReadLimit(limit)
+	}
+
+	// frames are read on another goroutine and processed here in order, so a handler doing I/O (an auth
+	// lookup, say) doesn't stop heart-beats from being read meanwhile
+	inbound := make(chan inboundFrame, server.inboundQueueSize())
+	go server.readFrames(client, inbound)
+
+	for frame := range inbound {
+		if frame.err != nil {
+			server.Sugar.Warnf("[%d] %v", client.Uid, frame.err)
+			server.reportError(client, frame.err)
+			server.sendFrameError(client, frame.err, nil)
+			break
+		}
+
+		if !server.handleFrame(client, request, frame.message, frame.readAt) {
+			break
+		}
+	}
+}
+
+// inboundFrame is a frame read from a client waiting to be processed, or a read error to report once the
+// frames before it have been.
+type inboundFrame struct {
+	message []byte
+	readAt  time.Time
+	err     *FrameError
+}
+
+func (server *Server) inboundQueueSize() int {
+	if server.InboundQueueSize > 0 {
+		return server.InboundQueueSize
+	}
+
+	return defaultInboundQueueSize
+}
+
+// readFrames reads frames from the client until the connection fails or the client is done, tracking
+// heart-beats itself and passing everything else on to be processed.
+func (server *Server) readFrames(client *Client, inbound chan<- inboundFrame) {
+	defer close(inbound)
 	for {
-		mt, message, err := client.Conn.ReadMessage()
+		message, err := client.transport.readFrame()
 		if err != nil {
-			if _, ok := err.(*websocket.CloseError); ok {
-				break
+			select {
+			case <-client.done:
+				// the connection was closed after processing stopped
+				return
+			default:
 			}
 
-			server.Sugar.Warnf("failed to read: (%d) (%s) %v", mt, reflect.TypeOf(err), err)
-			break
+			if errors.Is(err, io.EOF) {
+				return
+			}
+
+			// a frame which can't be told apart from the next is answered with an ERROR before closing
+			var frameErr *FrameError
+			if errors.Is(err, ErrFrameTooLarge) {
+				frameErr = &FrameError{Code: ErrorCodeFrameTooLarge, Message: "frame is too large", Err: ErrFrameTooLarge}
+				if limit := server.maxFrameSize(client); limit > 0 {
+					frameErr.Message = fmt.Sprintf("frame exceeds %d bytes", limit)
+				}
+			} else if !errors.As(err, &frameErr) {
+				server.Sugar.Warnf("failed to read: (%s) %v", reflect.TypeOf(err), err)
+				return
+			}
+
+			select {
+			case inbound <- inboundFrame{err: frameErr}:
+			case <-client.done:
+			}
+
+			return
 		}
 
-		if mt != websocket.TextMessage {
+		server.record(client, DirectionInbound, message)
+		heartBeat := isHeartBeat(message)
+		if heartBeat && server.Faults.dropHeartBeat() {
 			continue
 		}
 
-		if bytes.Equal(message, heartBeatPayload) {
+		readAt := server.clock().Now()
+		client.received(heartBeat, readAt)
+		if !heartBeat && client.timeline != nil {
+			client.timeline.framesIn.Add(1)
+		}
+
+		if heartBeat {
 			continue
 		}
 
-		result, err := server.parseMessage(message)
+		select {
+		case inbound <- inboundFrame{message: message, readAt: readAt}:
+		case <-client.done:
+			return
+		}
+	}
+}
+
+// handleFrame parses and processes one frame from the client, returning false once the connection should close.
+func (server *Server) handleFrame(client *Client, request *http.Request, message []byte, readAt time.Time) bool {
+	result, err := server.parseMessage(message, client.Version)
+	if err != nil {
+		_, parseSpan := server.startSpan(context.Background(), "stomper.parse", readAt, nil)
+		parseSpan.End(err)
+		server.metrics.parseErrors.Add(1)
+		server.Sugar.Warnf("[%d] error parsing message: %v", client.Uid, err)
+		return server.rejectFrame(client, err, message)
+	}
+
+	stompMsg := *result
+	command := stompMsg.Command
+	headers := stompMsg.Headers
+	server.metrics.frameReceived(command)
+
+	// STOMP is the 1.1+ name for CONNECT, used by clients that want to avoid confusion with HTTP CONNECT
+	if command == Stomp {
+		command = Connect
+	}
+
+	ctx := server.extractTrace(context.Background(), headers)
+	_, parseSpan := server.startSpan(ctx, "stomper.parse", readAt, nil)
+	parseSpan.End(nil)
+
+	var frameErr error
+	ctx, span := server.startSpan(ctx, "stomper."+strings.ToLower(string(command)), server.clock().Now(), map[string]string{
+		"stomper.client":      strconv.FormatUint(client.Uid, 10),
+		"stomper.destination": headers["destination"],
+	})
+	defer func() {
+		span.End(frameErr)
+	}()
+
+	reject := func(err error, frame []byte) bool {
+		frameErr = err
+		return server.rejectFrame(client, err, frame)
+	}
+
+	if server.disabledCommands[command] {
+		server.Sugar.Warnf("[%d] rejected disabled command %s", client.Uid, command)
+		return reject(frameErrorf(ErrorCodeCommandDisabled, "%s is disabled on this server", command), message)
+	}
+
+	// whatever the ErrorPolicy, nothing but a single CONNECT is accepted until the client has connected
+	if command != Connect && !client.connected {
+		server.Sugar.Warnf("[%d] rejected %s before CONNECT", client.Uid, command)
+		reject(frameErrorf(ErrorCodeNotConnected, "%s sent before CONNECT", command), message)
+		return false
+	} else if command == Connect && client.connected {
+		server.Sugar.Warnf("[%d] rejected second CONNECT", client.Uid)
+		reject(frameErrorf(ErrorCodeAlreadyConnected, "already connected"), message)
+		return false
+	}
+
+	if command == Connect {
+		version, err := negotiateVersion(headers["accept-version"])
 		if err != nil {
-			server.Sugar.Warnf("error parsing message: %v", err)
-			break
+			server.Sugar.Warnf("[%d] %v", client.Uid, err)
+			reject(err, message)
+			return false
+		}
+
+		client.Version = version
+		clientSend, clientReceive, err := parseHeartBeat(headers["heart-beat"])
+		if version == "1.0" {
+			// heart-beating was introduced in STOMP 1.1
+			clientSend, clientReceive = 0, 0
+		}
+
+		if err != nil {
+			server.Sugar.Warnf("[%d] %v", client.Uid, err)
+			reject(frameErrorf(ErrorCodeInvalidHeader, "%v", err), message)
+			return false
 		}
 
-		stompMsg := *result
-		command := stompMsg.Command
-		headers := stompMsg.Headers
+		client.HeartBeat = server.negotiateHeartBeat(clientSend, clientReceive)
+		copiedHeaders := make(map[string]string)
+		for k, v := range stompMsg.Headers {
+			copiedHeaders[k] = v
+		}
 
-		if command == Connect {
-			err = connect(client.Conn)
-			if err != nil {
-				server.Sugar.Warnf("unable to connect: %v", err)
-				break
+		client.Headers = copiedHeaders
+
+		if err := server.checkClientVersion(client); err != nil {
+			server.Sugar.Infof("[%d] %v", client.Uid, err)
+			reject(err, nil)
+			return false
+		}
+
+		if err := server.authenticate(client, request, copiedHeaders); err != nil {
+			server.Sugar.Infof("[%d] authentication failed: %v", client.Uid, err)
+			reject(&FrameError{Code: ErrorCodeUnauthorized, Message: "authentication failed", Err: ErrUnauthorized}, nil)
+			return false
+		}
+
+		handlers := server.traceHandlers(ctx, "connect", client, "")
+		for _, handler := range server.connectHandlers {
+			if !handler(client, request.Header, &stompMsg) {
+				refused := &FrameError{Code: ErrorCodeUnauthorized, Message: "connection refused", Err: ErrUnauthorized}
+				handlers.End(refused)
+				reject(refused, nil)
+				return false
 			}
+		}
+
+		handlers.End(nil)
+
+		// CONNECTED only goes out once every connect handler has accepted the client
+		err = server.connect(client)
+		if err != nil {
+			server.Sugar.Warnf("unable to connect: %v", err)
+			return false
+		}
+
+		client.connected = true
 
-			copiedHeaders := make(map[string]string)
-			for k, v := range stompMsg.Headers {
-				copiedHeaders[k] = v
+		server.addClient(client)
+		server.sessionEvent(client, SessionEventConnect, "", "stomp "+client.Version)
+		server.resume(client)
+		go server.heartBeat(client)
+		if client.Identity != nil && !client.Identity.ExpiresAt.IsZero() {
+			go server.expireSession(client, client.Identity.ExpiresAt)
+		}
+	} else if command == Send || command == Subscribe || command == Unsubscribe {
+		destination, ok := headers["destination"]
+		if !ok {
+			destination = ""
+		}
+
+		if command == Send && server.isTimeDestination(destination) {
+			server.answerTimeRequest(client, &stompMsg)
+			server.sendReceipt(client, headers)
+		} else if command == Send {
+			if client.readOnly {
+				// read-only clients keep their connection, whatever the ErrorPolicy
+				err := server.ClientVersion.upgradeRequired(client.Headers[server.ClientVersion.header()])
+				server.Sugar.Debugf("[%d] refused send from read-only client", client.Uid)
+				frameErr = err
+				server.reportError(client, err)
+				server.sendFrameError(client, err, message)
+				return true
 			}
 
-			client.Headers = copiedHeaders
+			stripReservedHeaders(stompMsg.Headers)
 
-			for _, handler := range server.connectHandlers {
-				if !handler(client, header, &stompMsg) {
-					return
-				}
+			size := 0
+			if stompMsg.Body != nil {
+				size = len(*stompMsg.Body)
 			}
 
-			server.addClient(client)
-		} else if command == Send || command == Subscribe || command == Unsubscribe {
-			destination, ok := headers["destination"]
-			if !ok {
-				destination = ""
+			if err := server.chargeSend(client, size); err != nil {
+				server.audit(client, AuditSendQuotaExceeded, err.Error())
+				return reject(err, nil)
 			}
 
-			if command == Send {
-				for _, handler := range server.messageHandlers {
-					handler(client, destination, &stompMsg)
-				}
-			} else if command == Subscribe {
-				subscribe := true
-				for _, handler := range server.subscribeHandlers {
-					if !handler(client, destination) {
-						subscribe = false
-						break
-					}
-				}
+			if err := server.authorize(client, ActionSend, destination); err != nil {
+				server.Sugar.Infof("[%d] %v", client.Uid, err)
+				return reject(err, message)
+			}
 
-				if subscribe {
-					server.addSubscription(client, stompMsg)
-				}
-			} else if command == Unsubscribe {
-				for _, handler := range server.unsubscribeHandlers {
-					handler(client, destination)
-				}
+			if err := server.checkDestinationPolicy(destination, &stompMsg); err != nil {
+				server.Sugar.Infof("[%d] rejected message to '%s': %v", client.Uid, destination, err)
+				return reject(err, message)
+			}
 
-				server.removeSubscription(client, stompMsg)
+			handlers := server.traceHandlers(ctx, "message", client, destination)
+			for _, handler := range server.messageHandlers {
+				handler(client, destination, &stompMsg)
+			}
+
+			handlers.End(nil)
+
+			server.routeMessage(client, destination, &stompMsg)
+
+			// relayed messages continue this frame's trace
+			stompMsg.Headers = server.injectTrace(ctx, stompMsg.Headers)
+			server.relay(client, destination, &stompMsg)
+			server.sendReceipt(client, headers)
+		} else if command == Subscribe {
+			if to := server.resolveAlias(destination); to != destination {
+				server.sendReceipt(client, headers)
+				server.sendMovedAdvisory(client, destination, headers["id"], to)
+				return true
+			}
+
+			if err := server.authorize(client, ActionSubscribe, destination); err != nil {
+				server.Sugar.Infof("[%d] %v", client.Uid, err)
+				return reject(err, message)
+			}
+
+			if err := server.checkQuota(client); err != nil {
+				frameErr = err
+				server.Sugar.Infof("[%d] subscription to '%s' refused: %v", client.Uid, destination, err)
+				server.reportError(client, err)
+				server.sendAdvisory(client, destination, headers["id"], AdvisoryQuotaExceeded, "quota exceeded")
+			} else if !server.authorizeSubscription(ctx, client, destination) {
+				server.Sugar.Infof("[%d] subscription to '%s' refused", client.Uid, destination)
+				frameErr = fmt.Errorf("subscription to '%s' refused: %w", destination, ErrUnauthorized)
+				server.reportError(client, frameErr)
+			} else if unlock := server.lockRetained(destination); server.subscribeWithHistory(client, stompMsg) {
+				server.sessionEvent(client, SessionEventSubscribe, destination, headers["id"])
+				server.scheduleExpiry(client, destination, headers["id"], headers)
+				server.rememberAccept(client, destination, headers["id"], headers)
+				server.rememberAckMode(client, headers["id"], headers)
+				server.startDigest(client, destination, headers["id"], headers)
+				server.sendReceipt(client, headers)
+				server.sendCompositeSnapshot(client, destination, headers["id"])
+				server.sendRetained(client, destination, headers["id"])
+				unlock()
+				server.startQuery(client, destination, headers["id"])
+			} else {
+				unlock()
+			}
+		} else if command == Unsubscribe {
+			// UNSUBSCRIBE only names the subscription, so handlers are given the destination it was for
+			if subscribed := server.subscriptionDestination(client, headers["id"]); subscribed != "" {
+				destination = subscribed
+			}
+
+			for _, handler := range server.unsubscribeHandlers {
+				handler(client, destination)
+			}
+
+			if subId, ok := headers["id"]; ok {
+				server.endSubscription(client, destination, subId)
+				server.sessionEvent(client, SessionEventUnsubscribe, destination, subId)
+				server.sendReceipt(client, headers)
 			}
-		} else if command == Disconnect {
-			return
 		}
+	} else if command == Ack || command == Nack {
+		server.acknowledge(client, headers["id"], command == Ack)
+		server.sendReceipt(client, headers)
+	} else if command == Disconnect {
+		server.sessionEvent(client, SessionEventDisconnect, "", "")
+		// the receipt is flushed by the deferred cleanup before the connection is closed
+		server.sendReceipt(client, headers)
+		return false
+	}
+
+	return true
+}
+
+// sendReceipt acknowledges a processed frame with a RECEIPT if the client asked for one.
+func (server *Server) sendReceipt(client *Client, headers map[string]string) {
+	receipt, ok := headers["receipt"]
+	if !ok {
+		return
+	}
+
+	message := StompMessage{
+		Command: Receipt,
+		Headers: map[string]string{
+			"receipt-id": receipt,
+		},
+	}
+
+	if err := server.writeFrame(client, message.payload(client.Version)); err != nil {
+		server.Sugar.Warnf("[%d] unable to write receipt: %v", client.Uid, err)
 	}
 }
 
-func (server *Server) parseMessage(message []byte) (*StompMessage, error) {
+func (server *Server) parseMessage(message []byte, version string) (*StompMessage, error) {
 	split := bytes.Split(message, []byte("\n"))
 	if len(split) < 2 {
-		server.Sugar.Warnf("invalid command: %s", message)
-		return nil, nil
+		return nil, frameErrorf(ErrorCodeInvalidFrame, "invalid command: %s", message)
+	}
+
+	command := StompCommand(bytes.TrimSuffix(split[0], []byte("\r")))
+	if !isClientCommand(command) {
+		return nil, frameErrorf(ErrorCodeUnknownCommand, "unknown command (%s)", command)
 	}
 
-	command := StompCommand(split[0])
 	headers := make(map[string]string)
 
 	lastHeader := 0
@@ -159,6 +613,7 @@ func (server *Server) parseMessage(message []byte) (*StompMessage, error) {
 			continue
 		}
 
+		line = bytes.TrimSuffix(line, []byte("\r"))
 		if bytes.Equal(line, endOfHeaders) {
 			lastHeader = index
 			break
@@ -166,11 +621,26 @@ func (server *Server) parseMessage(message []byte) (*StompMessage, error) {
 
 		header := bytes.SplitN(line, []byte(":"), 2)
 		if len(header) != 2 {
-			server.Sugar.Warnf("invalid header (%s)", line)
-			break
+			return nil, frameErrorf(ErrorCodeInvalidHeader, "invalid header (%s)", line)
 		}
 
-		headers[string(header[0])] = string(header[1])
+		name, value := string(header[0]), string(header[1])
+		if command != Connect && command != Stomp {
+			var err error
+			if name, err = unescapeHeader(name, version); err == nil {
+				value, err = unescapeHeader(value, version)
+			}
+
+			if err != nil {
+				if server.Strict {
+					return nil, frameErrorf(ErrorCodeInvalidHeader, "%v (%s)", err, line)
+				}
+
+				name, value = string(header[0]), string(header[1])
+			}
+		}
+
+		headers[name] = value
 	}
 
 	var body []byte
@@ -179,27 +649,41 @@ func (server *Server) parseMessage(message []byte) (*StompMessage, error) {
 		l, err := strconv.ParseInt(val, 10, 32)
 		length := int(l)
 
-		if err != nil {
-			server.Sugar.Warnf("invalid content-length (%s)", val)
-			return nil, nil
+		if err != nil || length < 0 {
+			return nil, frameErrorf(ErrorCodeInvalidContentLength, "invalid content-length (%s)", val)
 		}
 
 		receivedLength := len(bodyWithNull) - 1
-		if length < receivedLength {
-			server.Sugar.Warnf(
+		if length > receivedLength {
+			return nil, frameErrorf(
+				ErrorCodeInvalidContentLength,
 				"invalid content-length exceeds body size. expected %d got %d (%s)",
 				length, receivedLength, val,
 			)
-
-			return nil, nil
 		}
 
 		body = bodyWithNull[:length]
 	} else {
 		nullIndex := bytes.IndexByte(bodyWithNull, 0x00)
+		if nullIndex < 0 {
+			if server.Strict {
+				return nil, frameErrorf(ErrorCodeInvalidFrame, "frame is not null terminated")
+			}
+
+			nullIndex = len(bodyWithNull)
+		}
+
 		body = bodyWithNull[:nullIndex]
 	}
 
+	if server.Strict {
+		for _, name := range requiredHeaders[command] {
+			if _, ok := headers[name]; !ok {
+				return nil, frameErrorf(ErrorCodeMissingHeader, "missing required header '%s' on %s", name, command)
+			}
+		}
+	}
+
 	return &StompMessage{
 		Command: command,
 		Headers: headers,
@@ -207,15 +691,20 @@ func (server *Server) parseMessage(message []byte) (*StompMessage, error) {
 	}, nil
 }
 
-func connect(conn *websocket.Conn) error {
+func (server *Server) connect(client *Client) error {
 	stompMessage := StompMessage{
 		Command: Connected,
 		Headers: map[string]string{
-			"version":    "1.2",
-			"heart-beat": "10000,10000",
+			"version":    client.Version,
+			"heart-beat": formatHeartBeat(client.HeartBeat.Send, client.HeartBeat.Receive),
+			"server":     serverHeader(),
 		},
 		Body: nil,
 	}
 
-	return conn.WriteMessage(websocket.TextMessage, stompMessage.ToPayload())
+	if client.readOnly {
+		stompMessage.Headers[MinimumVersionHeader] = server.ClientVersion.Minimum
+	}
+
+	return server.writeFrame(client, stompMessage.payload(client.Version))
 }
@@ -0,0 +1,94 @@
+package stomper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFramesBeforeConnectAreRejected(t *testing.T) {
+	_, addr := newTestServer(t, func(server *Server) {
+		server.ErrorPolicy = ErrorPolicyContinue
+	})
+
+	for _, command := range []string{"SUBSCRIBE", "SEND", "UNSUBSCRIBE", "ACK"} {
+		t.Run(command, func(t *testing.T) {
+			c := dialTestClient(t, addr)
+			c.send(command, []string{"id:0", "destination:/topic/a"}, "")
+			frame := c.read()
+			if frame.Command != Error || frame.Headers["error-code"] != ErrorCodeNotConnected {
+				t.Fatalf("expected a not-connected ERROR, got %s %v", frame.Command, frame.Headers)
+			}
+
+			c.closed()
+		})
+	}
+}
+
+func TestSecondConnectIsRejected(t *testing.T) {
+	_, addr := newTestServer(t, func(server *Server) {
+		server.ErrorPolicy = ErrorPolicyContinue
+	})
+
+	c := dialTestClient(t, addr).connect()
+	c.send("CONNECT", []string{"accept-version:1.2"}, "")
+	frame := c.read()
+	if frame.Command != Error || frame.Headers["error-code"] != ErrorCodeAlreadyConnected {
+		t.Fatalf("expected an already-connected ERROR, got %s %v", frame.Command, frame.Headers)
+	}
+
+	c.closed()
+}
+
+func TestSubscribeAfterConnect(t *testing.T) {
+	server, addr := newTestServer(t, nil)
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("0", "/topic/a")
+	server.SendMessage("/topic/a", "text/plain", "hello")
+	frame := c.read()
+	if frame.Command != Message || string(*frame.Body) != "hello" {
+		t.Fatalf("expected the message, got %s %v", frame.Command, frame.Headers)
+	}
+
+	c.quiet(50 * time.Millisecond)
+}
+
+func TestMalformedFramesFollowErrorPolicy(t *testing.T) {
+	frames := map[string]struct {
+		command string
+		headers []string
+		code    string
+	}{
+		"unknown command":  {"PUBLISH", []string{"destination:/topic/a"}, ErrorCodeUnknownCommand},
+		"server command":   {"MESSAGE", []string{"destination:/topic/a"}, ErrorCodeUnknownCommand},
+		"header without :": {"SEND", []string{"destination:/topic/a", "no-colon", "receipt:1"}, ErrorCodeInvalidHeader},
+	}
+
+	for name, frame := range frames {
+		t.Run(name, func(t *testing.T) {
+			_, addr := newTestServer(t, func(server *Server) {
+				server.ErrorPolicy = ErrorPolicyContinue
+			})
+
+			c := dialTestClient(t, addr).connect()
+			c.send(frame.command, frame.headers, "")
+			if reply := c.read(); reply.Command != Error || reply.Headers["error-code"] != frame.code {
+				t.Fatalf("expected a %s ERROR, got %s %v", frame.code, reply.Command, reply.Headers)
+			}
+
+			// the connection carries on, and the frame was dropped rather than half-processed
+			c.subscribe("0", "/topic/a")
+			c.quiet(50 * time.Millisecond)
+		})
+
+		t.Run(name+" closes", func(t *testing.T) {
+			_, addr := newTestServer(t, nil)
+			c := dialTestClient(t, addr).connect()
+			c.send(frame.command, frame.headers, "")
+			if reply := c.read(); reply.Command != Error || reply.Headers["error-code"] != frame.code {
+				t.Fatalf("expected a %s ERROR, got %s %v", frame.code, reply.Command, reply.Headers)
+			}
+
+			c.closed()
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package stomper
+
+import (
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const defaultSlowHandlerThreshold = 100 * time.Millisecond
+
+// handlerName identifies a handler in metrics and logs by its function name, e.g. main.(*chat).join.
+func handlerName(handler any) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(handler).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+
+	return strings.TrimSuffix(fn.Name(), "-fm")
+}
+
+func (server *Server) slowHandlerThreshold() time.Duration {
+	if server.SlowHandlerThreshold == 0 {
+		return defaultSlowHandlerThreshold
+	}
+
+	return server.SlowHandlerThreshold
+}
+
+// timeHandler starts timing one invocation of a handler; calling the returned func records it, warning if
+// the handler was slow, since it held up the client's read loop for that long.
+func (server *Server) timeHandler(client *Client, kind string, name string) func() {
+	start := server.clock().Now()
+	return func() {
+		duration := server.clock().Now().Sub(start)
+		server.metrics.handlerObserved(kind, name, duration)
+
+		if threshold := server.slowHandlerThreshold(); threshold > 0 && duration > threshold {
+			server.Sugar.Warnf("[%d] slow %s handler %s took %s", client.Uid, kind, name, duration)
+		}
+	}
+}
+
+func (server *Server) timedMessageHandler(kind string, handler MessageHandler) MessageHandler {
+	name := handlerName(handler)
+	return func(client *Client, destination string, message *StompMessage) {
+		defer server.timeHandler(client, kind, name)()
+		handler(client, destination, message)
+	}
+}
+
+func (server *Server) timedSubscribeHandler(handler SubscribeHandler) SubscribeHandler {
+	name := handlerName(handler)
+	return func(client *Client, destination string) bool {
+		defer server.timeHandler(client, "subscribe", name)()
+		return handler(client, destination)
+	}
+}
+
+func (server *Server) timedUnsubscribeHandler(handler UnsubscribeHandler) UnsubscribeHandler {
+	name := handlerName(handler)
+	return func(client *Client, destination string) {
+		defer server.timeHandler(client, "unsubscribe", name)()
+		handler(client, destination)
+	}
+}
+
+func (server *Server) timedConnectHandler(handler ConnectHandler) ConnectHandler {
+	name := handlerName(handler)
+	return func(client *Client, header http.Header, message *StompMessage) bool {
+		defer server.timeHandler(client, "connect", name)()
+		return handler(client, header, message)
+	}
+}
+
+func (server *Server) timedDisconnectHandler(handler DisconnectHandler) DisconnectHandler {
+	name := handlerName(handler)
+	return func(client *Client) {
+		defer server.timeHandler(client, "disconnect", name)()
+		handler(client)
+	}
+}
+
+func (server *Server) timedEnrichHandler(handler EnrichHandler) EnrichHandler {
+	name := handlerName(handler)
+	return func(client *Client, request *http.Request) {
+		defer server.timeHandler(client, "enrich", name)()
+		handler(client, request)
+	}
+}
+
+func (server *Server) timedResumeHandler(handler ResumeHandler) ResumeHandler {
+	name := handlerName(handler)
+	return func(previous *Client, current *Client) {
+		defer server.timeHandler(current, "resume", name)()
+		handler(previous, current)
+	}
+}
@@ -0,0 +1,147 @@
+package stomper
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultHeartBeat = 10 * time.Second
+
+// parseHeartBeat reads a `heart-beat: cx,cy` header value. A missing header means no heart-beats.
+func parseHeartBeat(value string) (time.Duration, time.Duration, error) {
+	if value == "" {
+		return 0, 0, nil
+	}
+
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid heart-beat (%s)", value)
+	}
+
+	send, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid heart-beat (%s)", value)
+	}
+
+	receive, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid heart-beat (%s)", value)
+	}
+
+	return time.Duration(send) * time.Millisecond, time.Duration(receive) * time.Millisecond, nil
+}
+
+func formatHeartBeat(send time.Duration, receive time.Duration) string {
+	return fmt.Sprintf("%d,%d", send.Milliseconds(), receive.Milliseconds())
+}
+
+// heartBeatIntervals are the server's own heart-beat capabilities: how often it can send heart-beats and
+// how often it wants to receive them. Negative values on the Server disable that direction.
+func (server *Server) heartBeatIntervals() (time.Duration, time.Duration) {
+	send := server.HeartBeatSend
+	if send == 0 {
+		send = defaultHeartBeat
+	} else if send < 0 {
+		send = 0
+	}
+
+	receive := server.HeartBeatReceive
+	if receive == 0 {
+		receive = defaultHeartBeat
+	} else if receive < 0 {
+		receive = 0
+	}
+
+	return send, receive
+}
+
+// HeartBeat is a negotiated heart-beat schedule: how often the server sends heart-beats to the client and how
+// often it expects to receive them. Zero means no heart-beats in that direction.
+type HeartBeat struct {
+	Send    time.Duration
+	Receive time.Duration
+}
+
+// negotiateHeartBeat applies the STOMP 1.2 rules to the client's offer and the server's configuration. The
+// result is also what CONNECTED advertises, so the client arrives at the same schedule.
+func (server *Server) negotiateHeartBeat(clientSend time.Duration, clientReceive time.Duration) HeartBeat {
+	serverSend, serverReceive := server.heartBeatIntervals()
+
+	var send, receive time.Duration
+	if serverSend > 0 && clientReceive > 0 {
+		send = maxDuration(serverSend, clientReceive)
+	}
+
+	if serverReceive > 0 && clientSend > 0 {
+		receive = maxDuration(serverReceive, clientSend)
+	}
+
+	return HeartBeat{Send: send, Receive: receive}
+}
+
+func maxDuration(a time.Duration, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+func minNonZero(a time.Duration, b time.Duration) time.Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+
+	return a
+}
+
+func (server *Server) heartBeatPayload() []byte {
+	if server.HeartBeatPayload == "\r\n" {
+		return []byte("\r\n")
+	}
+
+	return []byte("\n")
+}
+
+// heartBeat sends heart-beats to the client when it's been quiet for the negotiated interval, and closes the
+// connection when nothing has been received from it within the receive interval plus HeartBeatGrace.
+func (server *Server) heartBeat(client *Client) {
+	send, receive := client.HeartBeat.Send, client.HeartBeat.Receive
+	interval := minNonZero(send, receive)
+	if interval == 0 {
+		return
+	}
+
+	grace := server.HeartBeatGrace
+	if grace <= 0 {
+		grace = receive
+	}
+
+	clock := server.clock()
+	ticker := clock.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	payload := server.heartBeatPayload()
+	for {
+		select {
+		case <-client.done:
+			return
+		case <-ticker.C():
+		}
+
+		now := clock.Now()
+		if receive > 0 && now.Sub(client.LastReceived()) > receive+grace {
+			server.Sugar.Warnf("[%d] no heart-beat received for %s, closing connection", client.Uid, now.Sub(client.LastReceived()))
+			_ = client.transport.Close()
+			return
+		}
+
+		if send > 0 && now.Sub(client.LastSent()) >= send-interval/2 {
+			if err := server.writeFrame(client, payload); err != nil {
+				server.Sugar.Debugf("[%d] unable to write heart-beat: %v", client.Uid, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package stomper
+
+import (
+	"strconv"
+	"sync"
+)
+
+// History headers: every MESSAGE to one of Server.HistoryDestinations carries an EventIdHeader, counting up
+// per destination. A SUBSCRIBE with ReplayHeader (the last N messages) or LastEventIdHeader (every message
+// after that id) is sent the buffered messages it asks for, marked with ReplayedHeader, before live traffic.
+const (
+	EventIdHeader     = "event-id"
+	ReplayHeader      = "replay"
+	LastEventIdHeader = "last-event-id"
+	ReplayedHeader    = "replayed"
+)
+
+const defaultHistorySize = 100
+
+type historyMessage struct {
+	id          uint64
+	contentType string
+	body        []byte
+	headers     map[string]string
+}
+
+// topicHistory is the ring buffer of one destination. mux is only held to add a message or to snapshot a
+// replay and add its subscriber; publishing holds order across the broadcast too, so messages go out in event
+// id order without a slow broadcast holding up subscribers.
+type topicHistory struct {
+	mux        sync.Mutex
+	publishing sync.Mutex
+	seq        uint64
+	messages   []historyMessage
+	next       int
+}
+
+// replayGate sits between a subscription and live traffic while its replay is sent. Live messages the replay
+// already covers are dropped, and newer ones are held until the replay has been enqueued.
+type replayGate struct {
+	mux     sync.Mutex
+	last    uint64
+	held    []historyMessage
+	pending bool
+}
+
+// admit decides what happens to a live message for the subscription: false if it's been held or dropped.
+func (gate *replayGate) admit(id uint64, contentType string, body []byte, headers map[string]string) bool {
+	gate.mux.Lock()
+	defer gate.mux.Unlock()
+	if id <= gate.last {
+		return false
+	}
+
+	if !gate.pending {
+		return true
+	}
+
+	// the broadcast body is pooled, so keep a copy
+	gate.held = append(gate.held, historyMessage{id: id, contentType: contentType, body: append([]byte(nil), body...), headers: headers})
+	return false
+}
+
+// release returns the live messages held since the last call, and opens the gate once there are none.
+func (gate *replayGate) release() []historyMessage {
+	gate.mux.Lock()
+	defer gate.mux.Unlock()
+	held := gate.held
+	gate.held = nil
+	if len(held) == 0 {
+		gate.pending = false
+	}
+
+	return held
+}
+
+// replayGateFor returns the gate of a subscription which asked for a replay, or nil.
+func (client *Client) replayGateFor(subId string) *replayGate {
+	if client.replayCount.Load() == 0 {
+		return nil
+	}
+
+	if gate, ok := client.replays.Load(subId); ok {
+		return gate.(*replayGate)
+	}
+
+	return nil
+}
+
+func (client *Client) dropReplay(subId string) {
+	if _, ok := client.replays.LoadAndDelete(subId); ok {
+		client.replayCount.Add(-1)
+	}
+}
+
+type histories struct {
+	mux    sync.Mutex
+	topics map[string]*topicHistory
+}
+
+func (server *Server) parseHistoryDestinations() []*DestinationTemplate {
+	var templates []*DestinationTemplate
+	for _, destination := range server.HistoryDestinations {
+		template, err := ParseDestinationTemplate(destination)
+		if err != nil {
+			server.Sugar.Warnf("invalid history destination (%s): %v", destination, err)
+			continue
+		}
+
+		templates = append(templates, template)
+	}
+
+	return templates
+}
+
+func (server *Server) historySize() int {
+	if server.HistorySize > 0 {
+		return server.HistorySize
+	}
+
+	return defaultHistorySize
+}
+
+// historyOf returns the destination's history, or nil if it doesn't keep one.
+func (server *Server) historyOf(destination string) *topicHistory {
+	if isWildcard(destination) {
+		return nil
+	}
+
+	matched := false
+	for _, template := range server.historyTemplates {
+		if _, ok := template.Match(destination); ok {
+			matched = true
+			break
+		}
+	}
+
+	if !matched {
+		return nil
+	}
+
+	server.histories.mux.Lock()
+	defer server.histories.mux.Unlock()
+	if server.histories.topics == nil {
+		server.histories.topics = make(map[string]*topicHistory)
+	}
+
+	history, ok := server.histories.topics[destination]
+	if !ok {
+		history = &topicHistory{messages: make([]historyMessage, 0, server.historySize())}
+		server.histories.topics[destination] = history
+	}
+
+	return history
+}
+
+// add buffers a message, returning its headers with its event id added. The caller holds the lock.
+func (history *topicHistory) add(contentType string, body string, extraHeaders map[string]string) map[string]string {
+	history.seq++
+	headers := make(map[string]string, len(extraHeaders)+1)
+	for k, v := range extraHeaders {
+		headers[k] = v
+	}
+
+	headers[EventIdHeader] = strconv.FormatUint(history.seq, 10)
+	message := historyMessage{id: history.seq, contentType: contentType, body: []byte(body), headers: headers}
+	if len(history.messages) < cap(history.messages) {
+		history.messages = append(history.messages, message)
+	} else {
+		history.messages[history.next] = message
+		history.next = (history.next + 1) % len(history.messages)
+	}
+
+	return headers
+}
+
+// since returns the buffered messages after id, oldest first. The caller holds the lock.
+func (history *topicHistory) since(id uint64) []historyMessage {
+	var messages []historyMessage
+	for i := range history.messages {
+		message := history.messages[(history.next+i)%len(history.messages)]
+		if message.id > id {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages
+}
+
+// subscribeWithHistory adds a subscription, first sending it the replay its SUBSCRIBE asked for when the
+// destination keeps a history.
+func (server *Server) subscribeWithHistory(client *Client, message StompMessage) bool {
+	destination := message.Headers["destination"]
+	history := server.historyOf(destination)
+	if history == nil {
+		return server.addSubscription(client, message)
+	}
+
+	subId := message.Headers["id"]
+	client.dropReplay(subId)
+
+	history.mux.Lock()
+	var replay []historyMessage
+	requested := false
+	replaying := server.extensionEnabled(FeatureReplay, destination, client)
+	if lastId, err := strconv.ParseUint(message.Headers[LastEventIdHeader], 10, 64); replaying && err == nil {
+		replay, requested = history.since(lastId), true
+	} else if count, err := strconv.Atoi(message.Headers[ReplayHeader]); replaying && err == nil && count > 0 {
+		replay, requested = history.since(0), true
+		if len(replay) > count {
+			replay = replay[len(replay)-count:]
+		}
+	}
+
+	// the gate goes up before the subscription, since messages added before the snapshot may still be
+	// on their way to subscribers
+	var gate *replayGate
+	if requested {
+		gate = &replayGate{last: history.seq, pending: true}
+		client.replays.Store(subId, gate)
+		client.replayCount.Add(1)
+	}
+
+	if !server.addSubscription(client, message) {
+		history.mux.Unlock()
+		client.dropReplay(subId)
+		return false
+	}
+
+	history.mux.Unlock()
+	if gate == nil {
+		return true
+	}
+
+	for _, buffered := range replay {
+		headers := make(map[string]string, len(buffered.headers)+1)
+		for k, v := range buffered.headers {
+			headers[k] = v
+		}
+
+		headers[ReplayedHeader] = "true"
+		if err := server.sendToSubscription(client, destination, subId, buffered.contentType, buffered.body, headers); err != nil {
+			server.Sugar.Warnf("[%d] unable to replay history of '%s': %v", client.Uid, destination, err)
+			break
+		}
+	}
+
+	for held := gate.release(); len(held) > 0; held = gate.release() {
+		for _, live := range held {
+			if err := server.sendToSubscription(client, destination, subId, live.contentType, live.body, live.headers); err != nil {
+				server.Sugar.Debugf("[%d] unable to send '%s' after its replay: %v", client.Uid, destination, err)
+			}
+		}
+	}
+
+	return true
+}
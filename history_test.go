@@ -0,0 +1,105 @@
+package stomper
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHistoryReplay(t *testing.T) {
+	server, addr := newTestServer(t, func(server *Server) {
+		server.HistoryDestinations = []string{"/topic/history"}
+	})
+
+	for i := 1; i <= 3; i++ {
+		server.SendMessage("/topic/history", "text/plain", strconv.Itoa(i))
+	}
+
+	c := dialTestClient(t, addr).connect()
+	c.send("SUBSCRIBE", []string{"id:0", "destination:/topic/history", "replay:2"}, "")
+	for _, want := range []string{"2", "3"} {
+		frame := c.read()
+		if frame.Headers[EventIdHeader] != want || frame.Headers[ReplayedHeader] != "true" {
+			t.Fatalf("expected event %s replayed, got %v", want, frame.Headers)
+		}
+	}
+
+	server.SendMessage("/topic/history", "text/plain", "4")
+	if frame := c.read(); frame.Headers[EventIdHeader] != "4" || frame.Headers[ReplayedHeader] != "" {
+		t.Fatalf("expected event 4 live, got %v", frame.Headers)
+	}
+}
+
+func TestHistoryReplayDuringPublishing(t *testing.T) {
+	const messages = 300
+	server, addr := newTestServer(t, func(server *Server) {
+		server.HistoryDestinations = []string{"/topic/history"}
+		server.HistorySize = messages
+	})
+
+	c := dialTestClient(t, addr).connect()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= messages; i++ {
+			server.SendMessage("/topic/history", "text/plain", strconv.Itoa(i))
+		}
+	}()
+
+	time.Sleep(time.Millisecond)
+	c.send("SUBSCRIBE", []string{"id:0", "destination:/topic/history", "last-event-id:0"}, "")
+	wg.Wait()
+
+	// whatever was replayed and whatever arrived live, every message comes once and in order
+	for want := 1; want <= messages; want++ {
+		if frame := c.read(); frame.Headers[EventIdHeader] != strconv.Itoa(want) {
+			t.Fatalf("expected event %d, got %v", want, frame.Headers)
+		}
+	}
+
+	c.quiet(50 * time.Millisecond)
+}
+
+func TestHistorySubscribeDuringBroadcast(t *testing.T) {
+	server, addr := newTestServer(t, func(server *Server) {
+		server.HistoryDestinations = []string{"/topic/history"}
+	})
+
+	first := dialTestClient(t, addr).connect()
+	first.subscribe("0", "/topic/history")
+
+	// a message added to the history whose broadcast hasn't got as far as the subscriptions yet
+	history := server.historyOf("/topic/history")
+	history.publishing.Lock()
+	history.mux.Lock()
+	headers := history.add("text/plain", "1", nil)
+	history.mux.Unlock()
+
+	second := dialTestClient(t, addr).connect()
+	second.send("SUBSCRIBE", []string{"id:0", "destination:/topic/history", "replay:10", "receipt:subscribed"}, "")
+	if frame := second.read(); frame.Headers[EventIdHeader] != "1" || frame.Headers[ReplayedHeader] != "true" {
+		t.Fatalf("expected event 1 replayed, got %v", frame.Headers)
+	}
+
+	if frame := second.read(); frame.Command != Receipt {
+		t.Fatalf("expected the subscription not to wait for the broadcast, got %s %v", frame.Command, frame.Headers)
+	}
+
+	server.broadcast(context.Background(), "/topic/history", "text/plain", "1", headers, nil, nil, nil)
+	history.publishing.Unlock()
+	if frame := first.read(); frame.Headers[EventIdHeader] != "1" {
+		t.Fatalf("expected event 1, got %v", frame.Headers)
+	}
+
+	// the replay covered the message that was still being broadcast
+	second.quiet(50 * time.Millisecond)
+	server.SendMessage("/topic/history", "text/plain", "2")
+	for _, c := range []*testClient{first, second} {
+		if frame := c.read(); frame.Headers[EventIdHeader] != "2" {
+			t.Fatalf("expected event 2, got %v", frame.Headers)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package stomper
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultJWKSRefresh = time.Hour
+
+// JWTAuthenticator is an Authenticator accepting a signed JWT as a bearer token, sent either in a CONNECT
+// header (`Authorization: Bearer <token>` by default) or a query parameter of the websocket URL
+// (`access_token` by default), for browsers which can't set headers on the upgrade request. HS*, RS* and
+// ES* signatures are supported. The token's expiry, plus Leeway, becomes the session's: the client is
+// disconnected once it passes. Times are told by the server's Clock.
+type JWTAuthenticator struct {
+	// Keys verify tokens by key id ("" matches tokens without a kid): []byte for HMAC, *rsa.PublicKey or
+	// *ecdsa.PublicKey.
+	Keys map[string]any
+
+	// JWKSURL is fetched for keys not in Keys, and refetched every JWKSRefresh (default 1h).
+	JWKSURL     string
+	JWKSRefresh time.Duration
+
+	// Header and QueryParameter name where the token is looked for.
+	Header         string
+	QueryParameter string
+
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string
+	Audience string
+
+	// Leeway allows for clock skew when checking exp and nbf.
+	Leeway time.Duration
+
+	// RequireExpiry refuses tokens without an exp claim, which would otherwise never expire.
+	RequireExpiry bool
+
+	// PrincipalClaim (default sub) and RolesClaim (default roles) populate the Identity.
+	PrincipalClaim string
+	RolesClaim     string
+
+	mux        sync.Mutex
+	jwks       map[string]any
+	jwksLoaded time.Time
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyId     string `json:"kid"`
+}
+
+func (auth *JWTAuthenticator) Authenticate(_ *Client, credentials Credentials) (*Identity, error) {
+	token := auth.token(credentials)
+	if token == "" {
+		return nil, fmt.Errorf("missing bearer token")
+	}
+
+	clock := credentials.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+
+	now := clock.Now()
+	claims, err := auth.verify(token, now)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{}
+	if exp, ok := claims["exp"].(float64); ok {
+		// the session lasts as long as the token would still be accepted
+		identity.ExpiresAt = time.Unix(int64(exp), 0).Add(auth.Leeway)
+		if now.After(identity.ExpiresAt) {
+			return nil, fmt.Errorf("token expired")
+		}
+	} else if auth.RequireExpiry {
+		return nil, fmt.Errorf("token has no expiry")
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(auth.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("token not valid yet")
+	}
+
+	if auth.Issuer != "" && claims["iss"] != auth.Issuer {
+		return nil, fmt.Errorf("unexpected issuer (%v)", claims["iss"])
+	}
+
+	if auth.Audience != "" && !hasAudience(claims["aud"], auth.Audience) {
+		return nil, fmt.Errorf("unexpected audience (%v)", claims["aud"])
+	}
+
+	principalClaim := auth.PrincipalClaim
+	if principalClaim == "" {
+		principalClaim = "sub"
+	}
+
+	identity.Principal, _ = claims[principalClaim].(string)
+
+	rolesClaim := auth.RolesClaim
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+
+	switch roles := claims[rolesClaim].(type) {
+	case []any:
+		for _, role := range roles {
+			if role, ok := role.(string); ok {
+				identity.Roles = append(identity.Roles, role)
+			}
+		}
+	case string:
+		identity.Roles = strings.Fields(roles)
+	}
+
+	return identity, nil
+}
+
+func (auth *JWTAuthenticator) token(credentials Credentials) string {
+	header := auth.Header
+	if header == "" {
+		header = "Authorization"
+	}
+
+	for name, value := range credentials.Headers {
+		if strings.EqualFold(name, header) {
+			if len(value) > 7 && strings.EqualFold(value[:7], "bearer ") {
+				return strings.TrimSpace(value[7:])
+			}
+
+			return strings.TrimSpace(value)
+		}
+	}
+
+	parameter := auth.QueryParameter
+	if parameter == "" {
+		parameter = "access_token"
+	}
+
+	if credentials.Request != nil {
+		return credentials.Request.URL.Query().Get(parameter)
+	}
+
+	return ""
+}
+
+// verify checks the token's signature and returns its claims.
+func (auth *JWTAuthenticator) verify(token string, now time.Time) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %v", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %v", err)
+	}
+
+	key, err := auth.key(header.KeyId, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = verifyJWTSignature(header.Algorithm, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err = decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %v", err)
+	}
+
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func verifyJWTSignature(algorithm string, key any, signed []byte, signature []byte) error {
+	if len(algorithm) != 5 {
+		return fmt.Errorf("unsupported token algorithm (%s)", algorithm)
+	}
+
+	var hash crypto.Hash
+	switch algorithm[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported token algorithm (%s)", algorithm)
+	}
+
+	digest := hash.New()
+	digest.Write(signed)
+	sum := digest.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(algorithm, "HS"):
+		secret, ok := key.([]byte)
+		if !ok {
+			return fmt.Errorf("key can't verify %s tokens", algorithm)
+		}
+
+		mac := hmac.New(hash.New, secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return fmt.Errorf("invalid token signature")
+		}
+	case strings.HasPrefix(algorithm, "RS"):
+		public, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key can't verify %s tokens", algorithm)
+		}
+
+		if err := rsa.VerifyPKCS1v15(public, hash, sum, signature); err != nil {
+			return fmt.Errorf("invalid token signature")
+		}
+	case strings.HasPrefix(algorithm, "ES"):
+		public, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key can't verify %s tokens", algorithm)
+		}
+
+		size := (public.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid token signature")
+		}
+
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(public, sum, r, s) {
+			return fmt.Errorf("invalid token signature")
+		}
+	default:
+		return fmt.Errorf("unsupported token algorithm (%s)", algorithm)
+	}
+
+	return nil
+}
+
+func hasAudience(claim any, audience string) bool {
+	switch aud := claim.(type) {
+	case string:
+		return aud == audience
+	case []any:
+		for _, value := range aud {
+			if value == audience {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// key finds the verification key for a key id, (re)loading the JWKS when it is stale or doesn't have it.
+func (auth *JWTAuthenticator) key(keyId string, now time.Time) (any, error) {
+	if key, ok := auth.Keys[keyId]; ok {
+		return key, nil
+	}
+
+	if auth.JWKSURL == "" {
+		return nil, fmt.Errorf("unknown token key (%s)", keyId)
+	}
+
+	auth.mux.Lock()
+	defer auth.mux.Unlock()
+
+	refresh := auth.JWKSRefresh
+	if refresh <= 0 {
+		refresh = defaultJWKSRefresh
+	}
+
+	key, ok := auth.jwks[keyId]
+	age := now.Sub(auth.jwksLoaded)
+
+	// unknown keys trigger a reload, at most once a minute, to pick up rotations between refreshes
+	if age > refresh || (!ok && age > time.Minute) {
+		keys, err := fetchJWKS(auth.JWKSURL)
+		if err != nil {
+			if !ok {
+				return nil, err
+			}
+		} else {
+			auth.jwks = keys
+			auth.jwksLoaded = now
+			key, ok = keys[keyId]
+		}
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("unknown token key (%s)", keyId)
+	}
+
+	return key, nil
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyId   string `json:"kid"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+var jwksClient = &http.Client{Timeout: 10 * time.Second}
+
+func fetchJWKS(url string) (map[string]any, error) {
+	response, err := jwksClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch jwks: %v", err)
+	}
+
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch jwks: status %d", response.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	if err = json.NewDecoder(response.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("invalid jwks: %v", err)
+	}
+
+	keys := make(map[string]any)
+	for _, jwk := range set.Keys {
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.KeyId] = key
+		}
+	}
+
+	return keys, nil
+}
+
+func (jwk jsonWebKey) publicKey() (any, error) {
+	decode := func(value string) (*big.Int, error) {
+		data, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return nil, err
+		}
+
+		return new(big.Int).SetBytes(data), nil
+	}
+
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve (%s)", jwk.Curve)
+		}
+
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type (%s)", jwk.KeyType)
+}
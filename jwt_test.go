@@ -0,0 +1,127 @@
+package stomper_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/hfoxy/stomper"
+	"github.com/hfoxy/stomper/stompertest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var jwtSecret = []byte("s3cret")
+
+// signJWT makes an HS256 token with the given claims.
+func signJWT(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	encode := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	signed := encode(map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + encode(claims)
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func authenticateJWT(auth *stomper.JWTAuthenticator, token string, clock stomper.Clock) (*stomper.Identity, error) {
+	return auth.Authenticate(&stomper.Client{}, stomper.Credentials{
+		Headers: map[string]string{"authorization": "Bearer " + token},
+		Clock:   clock,
+	})
+}
+
+func TestJWTIdentity(t *testing.T) {
+	clock := stompertest.NewFakeClock(time.Unix(1_700_000_000, 0))
+	auth := &stomper.JWTAuthenticator{Keys: map[string]any{"": jwtSecret}, Issuer: "https://issuer", Audience: "stomper"}
+	token := signJWT(t, map[string]any{
+		"sub":   "alice",
+		"roles": []string{"admin", "ops"},
+		"iss":   "https://issuer",
+		"aud":   []string{"other", "stomper"},
+		"exp":   1_700_000_060,
+	})
+
+	identity, err := authenticateJWT(auth, token, clock)
+	if err != nil {
+		t.Fatalf("expected the token to be accepted: %v", err)
+	}
+
+	if identity.Principal != "alice" || !identity.HasRole("ops") || !identity.ExpiresAt.Equal(time.Unix(1_700_000_060, 0)) {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+}
+
+func TestJWTRejections(t *testing.T) {
+	clock := stompertest.NewFakeClock(time.Unix(1_700_000_000, 0))
+	auth := &stomper.JWTAuthenticator{Keys: map[string]any{"": jwtSecret}, Issuer: "https://issuer"}
+
+	tests := map[string]string{
+		"expired":        signJWT(t, map[string]any{"iss": "https://issuer", "exp": 1_699_999_999}),
+		"not valid yet":  signJWT(t, map[string]any{"iss": "https://issuer", "nbf": 1_700_000_100}),
+		"wrong issuer":   signJWT(t, map[string]any{"iss": "https://elsewhere"}),
+		"bad signature":  signJWT(t, map[string]any{"iss": "https://issuer"}) + "x",
+		"malformed":      "not.a-token",
+		"missing bearer": "",
+	}
+
+	for name, token := range tests {
+		if _, err := authenticateJWT(auth, token, clock); err == nil {
+			t.Errorf("%s: expected the token to be refused", name)
+		}
+	}
+
+	unsigned := strings.Split(signJWT(t, map[string]any{"iss": "https://issuer"}), ".")
+	unsigned[0] = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	if _, err := authenticateJWT(auth, strings.Join(unsigned[:2], ".")+".", clock); err == nil {
+		t.Error("expected an unsigned token to be refused")
+	}
+}
+
+func TestJWTExpiryFollowsTheServerClock(t *testing.T) {
+	clock := stompertest.NewFakeClock(time.Unix(1_700_000_000, 0))
+	auth := &stomper.JWTAuthenticator{Keys: map[string]any{"": jwtSecret}, Leeway: 30 * time.Second}
+	token := signJWT(t, map[string]any{"sub": "alice", "exp": 1_700_000_060})
+
+	identity, err := authenticateJWT(auth, token, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the session lasts as long as the token is accepted, leeway included
+	if want := time.Unix(1_700_000_090, 0); !identity.ExpiresAt.Equal(want) {
+		t.Fatalf("expected the session to expire at %s, got %s", want, identity.ExpiresAt)
+	}
+
+	clock.Advance(80 * time.Second)
+	if _, err = authenticateJWT(auth, token, clock); err != nil {
+		t.Fatalf("expected the token to be accepted within the leeway: %v", err)
+	}
+
+	clock.Advance(11 * time.Second)
+	if _, err = authenticateJWT(auth, token, clock); err == nil {
+		t.Fatal("expected the token to be refused after the leeway")
+	}
+}
+
+func TestJWTRequireExpiry(t *testing.T) {
+	clock := stompertest.NewFakeClock(time.Unix(1_700_000_000, 0))
+	token := signJWT(t, map[string]any{"sub": "alice"})
+
+	identity, err := authenticateJWT(&stomper.JWTAuthenticator{Keys: map[string]any{"": jwtSecret}}, token, clock)
+	if err != nil || !identity.ExpiresAt.IsZero() {
+		t.Fatalf("expected a token without exp to be accepted by default, got %+v (%v)", identity, err)
+	}
+
+	if _, err = authenticateJWT(&stomper.JWTAuthenticator{Keys: map[string]any{"": jwtSecret}, RequireExpiry: true}, token, clock); err == nil {
+		t.Fatal("expected a token without exp to be refused")
+	}
+}
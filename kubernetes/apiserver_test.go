@@ -0,0 +1,193 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testToken = "t0ken"
+
+// fakeAPIServer serves the parts of the Kubernetes API the package uses, holding requests to the same rules
+// as a real API server: bearer authentication, JSON bodies, apiVersion and kind on created objects,
+// optimistic concurrency through resourceVersion, and label selectors on lists.
+type fakeAPIServer struct {
+	t       *testing.T
+	mux     sync.Mutex
+	version int
+	leases  map[string]map[string]any
+	slices  []map[string]any
+	puts    int
+}
+
+func newFakeAPIServer(t *testing.T) (*fakeAPIServer, *Client) {
+	t.Helper()
+	api := &fakeAPIServer{t: t, leases: make(map[string]map[string]any)}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte(testToken+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return api, &Client{Host: server.URL, TokenFile: tokenFile}
+}
+
+func (api *fakeAPIServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	api.mux.Lock()
+	defer api.mux.Unlock()
+
+	if request.Header.Get("Authorization") != "Bearer "+testToken {
+		api.status(writer, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if request.Header.Get("Accept") != "application/json" {
+		api.status(writer, http.StatusNotAcceptable, "NotAcceptable")
+		return
+	}
+
+	const leases = "/apis/coordination.k8s.io/v1/namespaces/default/leases"
+	switch {
+	case request.URL.Path == leases && request.Method == http.MethodPost:
+		lease, ok := api.decodeLease(writer, request)
+		if !ok {
+			return
+		}
+
+		name := lease["metadata"].(map[string]any)["name"].(string)
+		if _, exists := api.leases[name]; exists {
+			api.status(writer, http.StatusConflict, "AlreadyExists")
+			return
+		}
+
+		api.store(name, lease)
+		api.write(writer, http.StatusCreated, lease)
+	case strings.HasPrefix(request.URL.Path, leases+"/"):
+		name := strings.TrimPrefix(request.URL.Path, leases+"/")
+		current, exists := api.leases[name]
+		switch request.Method {
+		case http.MethodGet:
+			if !exists {
+				api.status(writer, http.StatusNotFound, "NotFound")
+				return
+			}
+
+			api.write(writer, http.StatusOK, current)
+		case http.MethodPut:
+			lease, ok := api.decodeLease(writer, request)
+			if !ok {
+				return
+			}
+
+			version := lease["metadata"].(map[string]any)["resourceVersion"]
+			if !exists || version != current["metadata"].(map[string]any)["resourceVersion"] {
+				api.status(writer, http.StatusConflict, "Conflict")
+				return
+			}
+
+			api.puts++
+			api.store(name, lease)
+			api.write(writer, http.StatusOK, lease)
+		default:
+			api.status(writer, http.StatusMethodNotAllowed, "MethodNotAllowed")
+		}
+	case request.URL.Path == "/apis/discovery.k8s.io/v1/namespaces/default/endpointslices" && request.Method == http.MethodGet:
+		selector := request.URL.Query().Get("labelSelector")
+		name, ok := strings.CutPrefix(selector, "kubernetes.io/service-name=")
+		if !ok {
+			api.status(writer, http.StatusBadRequest, "BadRequest")
+			return
+		}
+
+		items := []map[string]any{}
+		for _, slice := range api.slices {
+			labels := slice["metadata"].(map[string]any)["labels"].(map[string]any)
+			if labels["kubernetes.io/service-name"] == name {
+				items = append(items, slice)
+			}
+		}
+
+		api.write(writer, http.StatusOK, map[string]any{"apiVersion": "discovery.k8s.io/v1", "kind": "EndpointSliceList", "items": items})
+	default:
+		api.status(writer, http.StatusNotFound, "NotFound")
+	}
+}
+
+// decodeLease reads a Lease from the request body, refusing anything a real API server would.
+func (api *fakeAPIServer) decodeLease(writer http.ResponseWriter, request *http.Request) (map[string]any, bool) {
+	if request.Header.Get("Content-Type") != "application/json" {
+		api.status(writer, http.StatusUnsupportedMediaType, "UnsupportedMediaType")
+		return nil, false
+	}
+
+	var lease map[string]any
+	if err := json.NewDecoder(request.Body).Decode(&lease); err != nil {
+		api.status(writer, http.StatusBadRequest, "BadRequest")
+		return nil, false
+	}
+
+	metadata, _ := lease["metadata"].(map[string]any)
+	spec, _ := lease["spec"].(map[string]any)
+	if lease["apiVersion"] != "coordination.k8s.io/v1" || lease["kind"] != "Lease" || metadata == nil || spec == nil || metadata["name"] == "" {
+		api.status(writer, http.StatusBadRequest, "Invalid")
+		return nil, false
+	}
+
+	for _, field := range []string{"acquireTime", "renewTime"} {
+		if value, ok := spec[field].(string); ok {
+			if _, err := time.Parse(microTime, value); err != nil {
+				api.status(writer, http.StatusBadRequest, "Invalid")
+				return nil, false
+			}
+		}
+	}
+
+	return lease, true
+}
+
+func (api *fakeAPIServer) store(name string, lease map[string]any) {
+	api.version++
+	lease["metadata"].(map[string]any)["resourceVersion"] = strconv.Itoa(api.version)
+	api.leases[name] = lease
+}
+
+func (api *fakeAPIServer) write(writer http.ResponseWriter, code int, body any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(code)
+	_ = json.NewEncoder(writer).Encode(body)
+}
+
+// status answers with a metav1.Status, as the API server does for errors.
+func (api *fakeAPIServer) status(writer http.ResponseWriter, code int, reason string) {
+	api.write(writer, code, map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Status",
+		"status":     "Failure",
+		"reason":     reason,
+		"message":    fmt.Sprintf("%d %s", code, reason),
+		"code":       code,
+	})
+}
+
+func (api *fakeAPIServer) holder(name string) string {
+	api.mux.Lock()
+	defer api.mux.Unlock()
+
+	lease, ok := api.leases[name]
+	if !ok {
+		return ""
+	}
+
+	holder, _ := lease["spec"].(map[string]any)["holderIdentity"].(string)
+	return holder
+}
@@ -0,0 +1,125 @@
+// Package kubernetes helps run stomper servers on Kubernetes: leader election, so that singletons such as an
+// Outbox run on one replica only, and discovery of peer replicas. It's a module of its own, talking to the API
+// server with the standard library, and its tests hold every request to the API's rules against a fake API
+// server. The pod's service account needs get, create and update on leases and list on endpointslices.
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Client makes requests to the Kubernetes API server.
+type Client struct {
+	// Host is the API server's URL, e.g. https://10.0.0.1:443
+	Host string
+
+	// TokenFile is read before every request, as projected service account tokens are rotated
+	TokenFile string
+
+	HTTP *http.Client
+}
+
+// InClusterClient returns a client authenticated as the pod's service account.
+func InClusterClient() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a kubernetes cluster")
+	}
+
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read cluster ca: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in cluster ca")
+	}
+
+	return &Client{
+		Host:      "https://" + net.JoinHostPort(host, port),
+		TokenFile: serviceAccountDir + "/token",
+		HTTP: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}},
+		},
+	}, nil
+}
+
+// InClusterNamespace returns the namespace the pod runs in.
+func InClusterNamespace() (string, error) {
+	namespace, err := os.ReadFile(serviceAccountDir + "/namespace")
+	if err != nil {
+		return "", fmt.Errorf("unable to read namespace: %v", err)
+	}
+
+	return strings.TrimSpace(string(namespace)), nil
+}
+
+// do sends a request with an optional JSON body, decoding a successful response into out. It returns the
+// response status, along with an error for anything but 2xx.
+func (client *Client) do(ctx context.Context, method string, path string, body any, out any) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+
+		reader = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, client.Host+path, reader)
+	if err != nil {
+		return 0, err
+	}
+
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	if client.TokenFile != "" {
+		token, err := os.ReadFile(client.TokenFile)
+		if err != nil {
+			return 0, fmt.Errorf("unable to read token: %v", err)
+		}
+
+		request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	httpClient := client.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return response.StatusCode, fmt.Errorf("%s %s: %s: %s", method, path, response.Status, bytes.TrimSpace(message))
+	}
+
+	if out == nil {
+		return response.StatusCode, nil
+	}
+
+	return response.StatusCode, json.NewDecoder(response.Body).Decode(out)
+}
@@ -0,0 +1,3 @@
+module github.com/hfoxy/stomper/kubernetes
+
+go 1.20
@@ -0,0 +1,223 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// microTime is the format of Lease timestamps.
+const microTime = "2006-01-02T15:04:05.000000Z07:00"
+
+type lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions"`
+}
+
+// LeaseElector elects a single leader among replicas through a coordination.k8s.io Lease, e.g. to run an
+// Outbox, or consume an upstream that can't be shared, on one replica at a time.
+type LeaseElector struct {
+	Client    *Client
+	Namespace string
+	Name      string
+
+	// Identity names this replica in the lease (default: the hostname, which is the pod name)
+	Identity string
+
+	// LeaseDuration is how long other replicas wait after the last renewal before taking over (default 15s);
+	// the leader renews every RetryPeriod (default 2s) and steps down if it can't for RenewDeadline
+	// (default 10s)
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+
+	observed     leaseSpec
+	observedAt   time.Time
+	lastRenewed  time.Time
+	resourcePath string
+}
+
+func (elector *LeaseElector) defaults() error {
+	if elector.Client == nil || elector.Namespace == "" || elector.Name == "" {
+		return fmt.Errorf("lease elector requires a client, namespace and name")
+	}
+
+	if elector.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("unable to determine identity: %v", err)
+		}
+
+		elector.Identity = hostname
+	}
+
+	if elector.LeaseDuration <= 0 {
+		elector.LeaseDuration = 15 * time.Second
+	}
+
+	if elector.RenewDeadline <= 0 {
+		elector.RenewDeadline = 10 * time.Second
+	}
+
+	if elector.RetryPeriod <= 0 {
+		elector.RetryPeriod = 2 * time.Second
+	}
+
+	elector.resourcePath = fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", elector.Namespace)
+	return nil
+}
+
+// Run campaigns for leadership until ctx is cancelled. Whenever this replica becomes leader, lead is called
+// with a context cancelled as soon as leadership is lost; Run waits for lead to return before campaigning
+// again. On the way out the lease is released so another replica can take over straight away.
+func (elector *LeaseElector) Run(ctx context.Context, lead func(ctx context.Context)) error {
+	if err := elector.defaults(); err != nil {
+		return err
+	}
+
+	for {
+		if elector.tryAcquireOrRenew(ctx) {
+			elector.leadUntilLost(ctx, lead)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(elector.RetryPeriod):
+		}
+	}
+}
+
+func (elector *LeaseElector) leadUntilLost(ctx context.Context, lead func(ctx context.Context)) {
+	leading, stop := context.WithCancel(ctx)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		lead(leading)
+	}()
+
+	ticker := time.NewTicker(elector.RetryPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			stop()
+			<-finished
+			elector.release()
+			return
+		case <-finished:
+			stop()
+			elector.release()
+			return
+		case <-ticker.C:
+		}
+
+		if !elector.tryAcquireOrRenew(ctx) && time.Since(elector.lastRenewed) > elector.RenewDeadline {
+			stop()
+			<-finished
+			return
+		}
+	}
+}
+
+// tryAcquireOrRenew takes or renews the lease, returning whether this replica holds it.
+func (elector *LeaseElector) tryAcquireOrRenew(ctx context.Context) bool {
+	now := time.Now()
+	spec := leaseSpec{
+		HolderIdentity:       elector.Identity,
+		LeaseDurationSeconds: int(elector.LeaseDuration / time.Second),
+		AcquireTime:          now.UTC().Format(microTime),
+		RenewTime:            now.UTC().Format(microTime),
+	}
+
+	var current lease
+	status, err := elector.Client.do(ctx, http.MethodGet, elector.resourcePath+"/"+elector.Name, nil, &current)
+	if status == http.StatusNotFound {
+		created := lease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   leaseMetadata{Name: elector.Name, Namespace: elector.Namespace},
+			Spec:       spec,
+		}
+
+		if _, err = elector.Client.do(ctx, http.MethodPost, elector.resourcePath, created, nil); err != nil {
+			return false
+		}
+
+		elector.observe(spec, now)
+		elector.lastRenewed = now
+		return true
+	} else if err != nil {
+		return false
+	}
+
+	// expiry is judged by when this replica saw the lease change, not the holder's clock
+	if current.Spec != elector.observed {
+		elector.observe(current.Spec, now)
+	}
+
+	held := current.Spec.HolderIdentity != "" && current.Spec.HolderIdentity != elector.Identity
+	if held && now.Before(elector.observedAt.Add(elector.LeaseDuration)) {
+		return false
+	}
+
+	if current.Spec.HolderIdentity == elector.Identity {
+		spec.AcquireTime = current.Spec.AcquireTime
+		spec.LeaseTransitions = current.Spec.LeaseTransitions
+	} else {
+		spec.LeaseTransitions = current.Spec.LeaseTransitions + 1
+	}
+
+	current.Spec = spec
+	if _, err = elector.Client.do(ctx, http.MethodPut, elector.resourcePath+"/"+elector.Name, current, nil); err != nil {
+		// a conflict means another replica updated the lease first
+		return false
+	}
+
+	elector.observe(spec, now)
+	elector.lastRenewed = now
+	return true
+}
+
+func (elector *LeaseElector) observe(spec leaseSpec, at time.Time) {
+	elector.observed = spec
+	elector.observedAt = at
+}
+
+// release gives up the lease if this replica still holds it.
+func (elector *LeaseElector) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), elector.RetryPeriod)
+	defer cancel()
+
+	var current lease
+	if _, err := elector.Client.do(ctx, http.MethodGet, elector.resourcePath+"/"+elector.Name, nil, &current); err != nil {
+		return
+	}
+
+	if current.Spec.HolderIdentity != elector.Identity {
+		return
+	}
+
+	current.Spec.HolderIdentity = ""
+	current.Spec.LeaseDurationSeconds = 1
+	current.Spec.RenewTime = time.Now().UTC().Format(microTime)
+	_, _ = elector.Client.do(ctx, http.MethodPut, elector.resourcePath+"/"+elector.Name, current, nil)
+}
@@ -0,0 +1,134 @@
+package kubernetes
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testElector(client *Client, identity string) *LeaseElector {
+	return &LeaseElector{
+		Client:        client,
+		Namespace:     "default",
+		Name:          "stomper-outbox",
+		Identity:      identity,
+		LeaseDuration: time.Second,
+		RenewDeadline: 500 * time.Millisecond,
+		RetryPeriod:   20 * time.Millisecond,
+	}
+}
+
+func TestElectorCreatesAndRenewsLease(t *testing.T) {
+	api, client := newFakeAPIServer(t)
+	elector := testElector(client, "pod-a")
+	if err := elector.defaults(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if !elector.tryAcquireOrRenew(ctx) {
+		t.Fatal("expected the lease to be created")
+	}
+
+	if holder := api.holder("stomper-outbox"); holder != "pod-a" {
+		t.Fatalf("expected pod-a to hold the lease, got %q", holder)
+	}
+
+	// renewing needs the resourceVersion just read, which the API server checks
+	if !elector.tryAcquireOrRenew(ctx) || !elector.tryAcquireOrRenew(ctx) {
+		t.Fatal("expected the lease to be renewed")
+	}
+
+	if api.puts != 2 {
+		t.Fatalf("expected two renewals, got %d", api.puts)
+	}
+
+	var current lease
+	if _, err := client.do(ctx, http.MethodGet, elector.resourcePath+"/stomper-outbox", nil, &current); err != nil {
+		t.Fatal(err)
+	}
+
+	if current.Spec.LeaseTransitions != 0 || current.Spec.LeaseDurationSeconds != 1 {
+		t.Fatalf("unexpected spec %+v", current.Spec)
+	}
+}
+
+func TestElectorWaitsForAHeldLease(t *testing.T) {
+	_, client := newFakeAPIServer(t)
+	leader, follower := testElector(client, "pod-a"), testElector(client, "pod-b")
+	_ = leader.defaults()
+	_ = follower.defaults()
+
+	ctx := context.Background()
+	if !leader.tryAcquireOrRenew(ctx) {
+		t.Fatal("expected pod-a to take the lease")
+	}
+
+	if follower.tryAcquireOrRenew(ctx) {
+		t.Fatal("expected pod-b to wait while pod-a holds the lease")
+	}
+
+	// once pod-a stops renewing, pod-b takes over after the lease duration
+	time.Sleep(leader.LeaseDuration + 50*time.Millisecond)
+	if !follower.tryAcquireOrRenew(ctx) {
+		t.Fatal("expected pod-b to take over an expired lease")
+	}
+
+	var current lease
+	if _, err := client.do(ctx, http.MethodGet, follower.resourcePath+"/stomper-outbox", nil, &current); err != nil {
+		t.Fatal(err)
+	}
+
+	if current.Spec.HolderIdentity != "pod-b" || current.Spec.LeaseTransitions != 1 {
+		t.Fatalf("unexpected spec after takeover %+v", current.Spec)
+	}
+}
+
+func TestElectorHandsOverOnShutdown(t *testing.T) {
+	api, client := newFakeAPIServer(t)
+	var leading atomic.Int32
+
+	run := func(ctx context.Context, identity string, led chan<- string) {
+		_ = testElector(client, identity).Run(ctx, func(ctx context.Context) {
+			if leading.Add(1) > 1 {
+				t.Errorf("%s is leading alongside another replica", identity)
+			}
+
+			led <- identity
+			<-ctx.Done()
+			leading.Add(-1)
+		})
+	}
+
+	led := make(chan string, 2)
+	ctxA, stopA := context.WithCancel(context.Background())
+	ctxB, stopB := context.WithCancel(context.Background())
+	defer stopB()
+
+	go run(ctxA, "pod-a", led)
+	first := <-led
+	go run(ctxB, "pod-b", led)
+
+	if first != "pod-a" {
+		t.Fatalf("expected pod-a to lead first, got %s", first)
+	}
+
+	// released on the way out, so pod-b doesn't wait out the lease duration
+	stopA()
+	select {
+	case next := <-led:
+		if next != "pod-b" {
+			t.Fatalf("expected pod-b to take over, got %s", next)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("expected pod-b to take over promptly, lease held by %q", api.holder("stomper-outbox"))
+	}
+}
+
+func TestElectorRequiresConfiguration(t *testing.T) {
+	if err := (&LeaseElector{}).Run(context.Background(), func(context.Context) {}); err == nil {
+		t.Fatal("expected an error without a client, namespace and name")
+	}
+}
@@ -0,0 +1,66 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+type endpointSliceList struct {
+	Items []struct {
+		Endpoints []struct {
+			Addresses  []string `json:"addresses"`
+			Conditions struct {
+				Ready *bool `json:"ready"`
+			} `json:"conditions"`
+		} `json:"endpoints"`
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"items"`
+}
+
+// Peers returns the host:port addresses of the ready replicas behind a service, from its EndpointSlices,
+// sorted. port names the service port, or may be empty when it has only one.
+func Peers(ctx context.Context, client *Client, namespace string, service string, port string) ([]string, error) {
+	query := url.Values{"labelSelector": {"kubernetes.io/service-name=" + service}}
+	path := fmt.Sprintf("/apis/discovery.k8s.io/v1/namespaces/%s/endpointslices?%s", namespace, query.Encode())
+
+	var slices endpointSliceList
+	if _, err := client.do(ctx, http.MethodGet, path, nil, &slices); err != nil {
+		return nil, fmt.Errorf("unable to list peers: %w", err)
+	}
+
+	var peers []string
+	for _, slice := range slices.Items {
+		number := 0
+		for _, p := range slice.Ports {
+			if p.Name == port || (port == "" && len(slice.Ports) == 1) {
+				number = p.Port
+			}
+		}
+
+		if number == 0 {
+			continue
+		}
+
+		for _, endpoint := range slice.Endpoints {
+			// a missing ready condition means ready
+			if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+				continue
+			}
+
+			for _, address := range endpoint.Addresses {
+				peers = append(peers, net.JoinHostPort(address, strconv.Itoa(number)))
+			}
+		}
+	}
+
+	sort.Strings(peers)
+	return peers, nil
+}
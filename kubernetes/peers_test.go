@@ -0,0 +1,66 @@
+package kubernetes
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func endpointSlice(service string, ports []map[string]any, endpoints ...map[string]any) map[string]any {
+	return map[string]any{
+		"apiVersion":  "discovery.k8s.io/v1",
+		"kind":        "EndpointSlice",
+		"metadata":    map[string]any{"labels": map[string]any{"kubernetes.io/service-name": service}},
+		"addressType": "IPv4",
+		"ports":       ports,
+		"endpoints":   endpoints,
+	}
+}
+
+func endpoint(address string, ready *bool) map[string]any {
+	conditions := map[string]any{}
+	if ready != nil {
+		conditions["ready"] = *ready
+	}
+
+	return map[string]any{"addresses": []string{address}, "conditions": conditions}
+}
+
+func TestPeers(t *testing.T) {
+	api, client := newFakeAPIServer(t)
+	yes, no := true, false
+	stomp := []map[string]any{{"name": "stomp", "port": 61613}, {"name": "http", "port": 8080}}
+	api.slices = []map[string]any{
+		endpointSlice("stomper", stomp, endpoint("10.0.0.2", &yes), endpoint("10.0.0.1", nil), endpoint("10.0.0.3", &no)),
+		endpointSlice("stomper", stomp, endpoint("10.0.1.1", &yes)),
+		endpointSlice("other", stomp, endpoint("10.0.2.1", &yes)),
+	}
+
+	peers, err := Peers(context.Background(), client, "default", "stomper", "stomp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"10.0.0.1:61613", "10.0.0.2:61613", "10.0.1.1:61613"}
+	if !reflect.DeepEqual(peers, want) {
+		t.Fatalf("expected %v, got %v", want, peers)
+	}
+}
+
+func TestPeersWithSinglePort(t *testing.T) {
+	api, client := newFakeAPIServer(t)
+	api.slices = []map[string]any{endpointSlice("stomper", []map[string]any{{"name": "", "port": 61613}}, endpoint("10.0.0.1", nil))}
+
+	peers, err := Peers(context.Background(), client, "default", "stomper", "")
+	if err != nil || !reflect.DeepEqual(peers, []string{"10.0.0.1:61613"}) {
+		t.Fatalf("unexpected peers %v (%v)", peers, err)
+	}
+}
+
+func TestPeersReportsAPIErrors(t *testing.T) {
+	_, client := newFakeAPIServer(t)
+	client.TokenFile = ""
+	if _, err := Peers(context.Background(), client, "default", "stomper", ""); err == nil {
+		t.Fatal("expected an unauthenticated request to fail")
+	}
+}
@@ -0,0 +1,143 @@
+package stomper
+
+import (
+	"sync"
+	"time"
+)
+
+// ExpiresHeader on a SUBSCRIBE frame is the time, in unix milliseconds, at which the server ends the
+// subscription; on a message, when it stops being delivered.
+const ExpiresHeader = "expires"
+
+const AdvisorySubscriptionExpired = "subscription-expired"
+
+// SubscriptionLifetime caps how long subscriptions to destinations matching a DestinationTemplate last.
+type SubscriptionLifetime struct {
+	Destination string
+	MaxLifetime time.Duration
+}
+
+type subscriptionLifetime struct {
+	SubscriptionLifetime
+	template *DestinationTemplate
+}
+
+// subscriptionExpiries tracks the pending expiry of each subscription by client uid and subscription id, so
+// that unsubscribing (or subscribing again with the same id) cancels it.
+type subscriptionExpiries struct {
+	mux     sync.Mutex
+	pending map[uint64]map[string]chan struct{}
+}
+
+func (server *Server) parseSubscriptionLifetimes() []subscriptionLifetime {
+	var lifetimes []subscriptionLifetime
+	for _, lifetime := range server.SubscriptionLifetimes {
+		template, err := ParseDestinationTemplate(lifetime.Destination)
+		if err != nil {
+			server.Sugar.Warnf("invalid subscription lifetime (%s): %v", lifetime.Destination, err)
+			continue
+		}
+
+		lifetimes = append(lifetimes, subscriptionLifetime{SubscriptionLifetime: lifetime, template: template})
+	}
+
+	return lifetimes
+}
+
+// subscriptionExpiry returns when a new subscription should end: the earlier of its expires header and the
+// first matching configured lifetime, or the zero time if neither applies.
+func (server *Server) subscriptionExpiry(destination string, headers map[string]string) time.Time {
+	expiry := expiresAt(headers)
+
+	for _, lifetime := range server.subscriptionLifetimes {
+		if _, ok := lifetime.template.Match(destination); !ok {
+			continue
+		}
+
+		limit := server.clock().Now().Add(lifetime.MaxLifetime)
+		if expiry.IsZero() || limit.Before(expiry) {
+			expiry = limit
+		}
+
+		break
+	}
+
+	return expiry
+}
+
+func (server *Server) scheduleExpiry(client *Client, destination string, subId string, headers map[string]string) {
+	expiry := server.subscriptionExpiry(destination, headers)
+	server.cancelExpiry(client, subId)
+	if expiry.IsZero() {
+		return
+	}
+
+	cancel := make(chan struct{})
+	expiries := &server.expiries
+	expiries.mux.Lock()
+	if expiries.pending == nil {
+		expiries.pending = make(map[uint64]map[string]chan struct{})
+	}
+
+	if expiries.pending[client.Uid] == nil {
+		expiries.pending[client.Uid] = make(map[string]chan struct{})
+	}
+
+	expiries.pending[client.Uid][subId] = cancel
+	expiries.mux.Unlock()
+
+	go server.expireSubscription(client, destination, subId, expiry, cancel)
+}
+
+func (server *Server) cancelExpiry(client *Client, subId string) {
+	expiries := &server.expiries
+	expiries.mux.Lock()
+	defer expiries.mux.Unlock()
+
+	if cancel, ok := expiries.pending[client.Uid][subId]; ok {
+		close(cancel)
+		delete(expiries.pending[client.Uid], subId)
+	}
+}
+
+// forgetExpiries drops a departed client's pending expiries; their goroutines end with the client.
+func (server *Server) forgetExpiries(client *Client) {
+	expiries := &server.expiries
+	expiries.mux.Lock()
+	defer expiries.mux.Unlock()
+	delete(expiries.pending, client.Uid)
+}
+
+func (server *Server) expireSubscription(client *Client, destination string, subId string, expiry time.Time, cancel chan struct{}) {
+	timer := server.clock().NewTimer(expiry.Sub(server.clock().Now()))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+	case <-cancel:
+		return
+	case <-client.done:
+		return
+	}
+
+	expiries := &server.expiries
+	expiries.mux.Lock()
+	if expiries.pending[client.Uid][subId] != cancel {
+		expiries.mux.Unlock()
+		return
+	}
+
+	delete(expiries.pending[client.Uid], subId)
+	expiries.mux.Unlock()
+
+	if !server.endSubscription(client, destination, subId) {
+		return
+	}
+
+	server.Sugar.Infof("[%d] subscription to '%s' (%s) expired", client.Uid, destination, subId)
+	for _, handler := range server.unsubscribeHandlers {
+		handler(client, destination)
+	}
+
+	server.sendAdvisory(client, destination, subId, AdvisorySubscriptionExpired, "subscription expired")
+}
@@ -0,0 +1,26 @@
+package stomper
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestExpiredQuerySubscriptionsStop(t *testing.T) {
+	query := newLiveQuery()
+	_, addr := newTestServer(t, func(server *Server) {
+		server.QueryProvider = query
+	})
+
+	c := dialTestClient(t, addr).connect()
+	expires := time.Now().Add(200 * time.Millisecond).UnixMilli()
+	c.subscribe("q", "/query/orders?status=open", ExpiresHeader+":"+strconv.FormatInt(expires, 10))
+	query.rows <- "first"
+	if frame := c.read(); frame.Command != Message || string(*frame.Body) != "first" {
+		t.Fatalf("expected the first row, got %s %v", frame.Command, frame.Headers)
+	}
+
+	expectAdvisory(t, c, "q", AdvisorySubscriptionExpired)
+	query.stopped(t)
+	c.quiet(50 * time.Millisecond)
+}
@@ -0,0 +1,39 @@
+package stomper
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// UnixAddressPrefix marks a unix socket address for Listen, e.g. unix:/tmp/stomper.sock.
+const UnixAddressPrefix = "unix:"
+
+// Listen opens a listener for an http.Server serving WssHandler. The address is host:port ([::1]:8449 for
+// IPv6; :8448 listens on every interface, dual-stack where available) or unix:<path>, in which case a stale
+// socket file is replaced. With a TLS config, connections are served over TLS.
+func Listen(address string, config *tls.Config) (net.Listener, error) {
+	var listener net.Listener
+	var err error
+	if path, ok := strings.CutPrefix(address, UnixAddressPrefix); ok {
+		if info, statErr := os.Stat(path); statErr == nil && info.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(path)
+		}
+
+		listener, err = net.Listen("unix", path)
+	} else {
+		listener, err = net.Listen("tcp", address)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s: %v", address, err)
+	}
+
+	if config != nil {
+		listener = tls.NewListener(listener, config)
+	}
+
+	return listener, nil
+}
@@ -0,0 +1,48 @@
+package stomper
+
+import (
+	"fmt"
+	"log"
+)
+
+// Logger is what the server logs through. A *zap.SugaredLogger, and logrus' *Logger and *Entry, satisfy it
+// as they are; StdLogger and SlogLogger adapt the standard library's loggers.
+type Logger interface {
+	Debugf(template string, args ...any)
+	Infof(template string, args ...any)
+	Warnf(template string, args ...any)
+	Errorf(template string, args ...any)
+}
+
+// StdLogger adapts a standard library logger, prefixing each line with its level. Debug messages are dropped
+// unless debug is set.
+func StdLogger(logger *log.Logger, debug bool) Logger {
+	return stdLogger{logger: logger, debug: debug}
+}
+
+type stdLogger struct {
+	logger *log.Logger
+	debug  bool
+}
+
+func (l stdLogger) Debugf(template string, args ...any) {
+	if l.debug {
+		l.output("DEBUG", template, args)
+	}
+}
+
+func (l stdLogger) Infof(template string, args ...any) {
+	l.output("INFO", template, args)
+}
+
+func (l stdLogger) Warnf(template string, args ...any) {
+	l.output("WARN", template, args)
+}
+
+func (l stdLogger) Errorf(template string, args ...any) {
+	l.output("ERROR", template, args)
+}
+
+func (l stdLogger) output(level string, template string, args []any) {
+	_ = l.logger.Output(3, level+" "+fmt.Sprintf(template, args...))
+}
@@ -0,0 +1,42 @@
+//go:build go1.21
+
+package stomper
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// SlogLogger adapts a log/slog logger; messages are formatted before they reach it, so they carry no
+// attributes of their own.
+func SlogLogger(logger *slog.Logger) Logger {
+	return slogLogger{logger: logger}
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l slogLogger) Debugf(template string, args ...any) {
+	l.log(slog.LevelDebug, template, args)
+}
+
+func (l slogLogger) Infof(template string, args ...any) {
+	l.log(slog.LevelInfo, template, args)
+}
+
+func (l slogLogger) Warnf(template string, args ...any) {
+	l.log(slog.LevelWarn, template, args)
+}
+
+func (l slogLogger) Errorf(template string, args ...any) {
+	l.log(slog.LevelError, template, args)
+}
+
+func (l slogLogger) log(level slog.Level, template string, args []any) {
+	ctx := context.Background()
+	if l.logger.Enabled(ctx, level) {
+		l.logger.Log(ctx, level, fmt.Sprintf(template, args...))
+	}
+}
@@ -0,0 +1,171 @@
+package stomper
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var placeholderPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)(\*?)\}`)
+
+// DestinationTemplate is a name with `{placeholder}` captures, e.g. `orders.{region}`. A plain placeholder
+// matches a single segment (no '.', '/' or ':'); `{rest*}` matches anything, including separators.
+type DestinationTemplate struct {
+	template string
+	pattern  *regexp.Regexp
+	names    []string
+	greedy   map[string]bool
+}
+
+func ParseDestinationTemplate(template string) (*DestinationTemplate, error) {
+	return parseDestinationTemplate(template, nil)
+}
+
+// parseDestinationTemplate parses a template, treating the placeholders in greedy as `{name*}` regardless of
+// how they are written, so both sides of a mapping agree on what each placeholder matches.
+func parseDestinationTemplate(template string, greedy map[string]bool) (*DestinationTemplate, error) {
+	var expr strings.Builder
+	var names []string
+	seen := make(map[string]bool)
+	isGreedy := make(map[string]bool)
+
+	expr.WriteString("^")
+	last := 0
+	for _, match := range placeholderPattern.FindAllStringSubmatchIndex(template, -1) {
+		expr.WriteString(regexp.QuoteMeta(template[last:match[0]]))
+		name := template[match[2]:match[3]]
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate placeholder '%s' in template (%s)", name, template)
+		}
+
+		seen[name] = true
+		names = append(names, name)
+		if match[5] > match[4] || greedy[name] {
+			isGreedy[name] = true
+			expr.WriteString("(.+)")
+		} else {
+			expr.WriteString("([^./:]+)")
+		}
+
+		last = match[1]
+	}
+
+	expr.WriteString(regexp.QuoteMeta(template[last:]))
+	expr.WriteString("$")
+
+	if strings.ContainsAny(placeholderPattern.ReplaceAllString(template, ""), "{}") {
+		return nil, fmt.Errorf("invalid placeholder in template (%s)", template)
+	}
+
+	return &DestinationTemplate{template: template, pattern: regexp.MustCompile(expr.String()), names: names, greedy: isGreedy}, nil
+}
+
+// Match returns the captured placeholder values if name matches the template.
+func (t *DestinationTemplate) Match(name string) (map[string]string, bool) {
+	match := t.pattern.FindStringSubmatch(name)
+	if match == nil {
+		return nil, false
+	}
+
+	values := make(map[string]string, len(t.names))
+	for i, placeholder := range t.names {
+		values[placeholder] = match[i+1]
+	}
+
+	return values, true
+}
+
+// Expand substitutes values into the template's placeholders.
+func (t *DestinationTemplate) Expand(values map[string]string) string {
+	return placeholderPattern.ReplaceAllStringFunc(t.template, func(placeholder string) string {
+		name := strings.TrimSuffix(strings.Trim(placeholder, "{}"), "*")
+		return values[name]
+	})
+}
+
+func (t *DestinationTemplate) String() string {
+	return t.template
+}
+
+// DestinationMapping maps names between a bridged system and STOMP destinations in both directions,
+// e.g. Redis channel `orders.{region}` to `/topic/orders.{region}`.
+type DestinationMapping struct {
+	From *DestinationTemplate
+	To   *DestinationTemplate
+}
+
+func NewDestinationMapping(from string, to string) (*DestinationMapping, error) {
+	fromTemplate, err := ParseDestinationTemplate(from)
+	if err != nil {
+		return nil, err
+	}
+
+	toTemplate, err := parseDestinationTemplate(to, fromTemplate.greedy)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(toTemplate.greedy) > len(fromTemplate.greedy) {
+		fromTemplate, _ = parseDestinationTemplate(from, toTemplate.greedy)
+	}
+
+	if len(fromTemplate.names) != len(toTemplate.names) {
+		return nil, fmt.Errorf("mapping (%s -> %s) must use the same placeholders on both sides", from, to)
+	}
+
+	for _, name := range toTemplate.names {
+		found := false
+		for _, other := range fromTemplate.names {
+			found = found || other == name
+		}
+
+		if !found {
+			return nil, fmt.Errorf("placeholder '%s' in (%s) is not captured by (%s)", name, to, from)
+		}
+	}
+
+	return &DestinationMapping{From: fromTemplate, To: toTemplate}, nil
+}
+
+// Map converts a bridged name (e.g. a Redis channel) into a STOMP destination.
+func (mapping *DestinationMapping) Map(name string) (string, bool) {
+	values, ok := mapping.From.Match(name)
+	if !ok {
+		return "", false
+	}
+
+	return mapping.To.Expand(values), true
+}
+
+// Reverse converts a STOMP destination back into the bridged name.
+func (mapping *DestinationMapping) Reverse(destination string) (string, bool) {
+	values, ok := mapping.To.Match(destination)
+	if !ok {
+		return "", false
+	}
+
+	return mapping.From.Expand(values), true
+}
+
+// DestinationMapper applies an ordered list of mappings; the first match wins.
+type DestinationMapper []*DestinationMapping
+
+func (mapper DestinationMapper) Map(name string) (string, bool) {
+	for _, mapping := range mapper {
+		if destination, ok := mapping.Map(name); ok {
+			return destination, true
+		}
+	}
+
+	return "", false
+}
+
+func (mapper DestinationMapper) Reverse(destination string) (string, bool) {
+	for _, mapping := range mapper {
+		if name, ok := mapping.Reverse(destination); ok {
+			return name, true
+		}
+	}
+
+	return "", false
+}
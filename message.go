@@ -1,6 +1,9 @@
 package stomper
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type StompMessage struct {
 	Command StompCommand
@@ -17,26 +20,160 @@ func (m *StompMessage) ToString() string {
 	return fmt.Sprintf("%s: headers(%s): '%s'", m.Command, m.Headers, body)
 }
 
+// ToPayload serializes the frame, escaping headers as STOMP 1.2 does.
 func (m *StompMessage) ToPayload() []byte {
+	return m.payload("1.2")
+}
+
+// payload serializes the frame for a client that negotiated version.
+func (m *StompMessage) payload(version string) []byte {
+	data := m.frameHeader(version)
+	if m.Body != nil {
+		body := m.Body
+		data = append(data, *body...)
+	}
+
+	data = append(data, 0x00)
+	return data
+}
+
+// frameHeader serializes the command and headers, up to and including the blank line before the body.
+func (m *StompMessage) frameHeader(version string) []byte {
 	var data []byte
 	data = append(data, []byte(m.Command)...)
 	data = append(data, []byte("\n")...)
 
+	// CONNECT and CONNECTED frames aren't escaped, for compatibility with STOMP 1.0
+	escaper := escaperFor(version)
+	if m.Command == Connect || m.Command == Connected {
+		escaper = nil
+	}
+
 	for name, value := range m.Headers {
-		data = append(data, []byte(name)...)
-		data = append(data, []byte(":")...)
-		data = append(data, []byte(value)...)
-		data = append(data, []byte("\n")...)
+		data = appendHeader(data, name, value, escaper)
 	}
 
 	data = append(data, []byte("\n")...)
-	if m.Body != nil {
-		body := m.Body
-		data = append(data, *body...)
+	return data
+}
+
+func appendHeader(data []byte, name string, value string, escaper *strings.Replacer) []byte {
+	if escaper != nil {
+		name, value = escapeHeader(escaper, name), escapeHeader(escaper, value)
 	}
 
-	data = append(data, 0x00)
-	return data
+	data = append(data, name...)
+	data = append(data, ':')
+	data = append(data, value...)
+	return append(data, '\n')
+}
+
+// messageTemplate is a MESSAGE frame header serialized once per destination and protocol version, missing
+// only the subscription header, which is patched in per recipient.
+type messageTemplate struct {
+	headers  map[string]string
+	prefixes map[string][]byte
+}
+
+func newMessageTemplate(headers map[string]string) *messageTemplate {
+	return &messageTemplate{headers: headers, prefixes: make(map[string][]byte, 1)}
+}
+
+// header returns the complete frame header for a subscription of a client that negotiated version.
+func (template *messageTemplate) header(subId string, version string) []byte {
+	escaper := escaperFor(version)
+	prefix, ok := template.prefixes[version]
+	if !ok {
+		prefix = append([]byte(Message), '\n')
+		for name, value := range template.headers {
+			if name == "subscription" {
+				continue
+			}
+
+			prefix = appendHeader(prefix, name, value, escaper)
+		}
+
+		template.prefixes[version] = prefix
+	}
+
+	if escaper != nil {
+		subId = escapeHeader(escaper, subId)
+	}
+
+	data := make([]byte, 0, len(prefix)+len("subscription:\n\n")+len(subId))
+	data = append(data, prefix...)
+	data = append(data, "subscription:"...)
+	data = append(data, subId...)
+	return append(data, '\n', '\n')
+}
+
+var (
+	// STOMP 1.1 introduced escaping without \r, which 1.2 added; 1.0 has none
+	headerEscaper11 = strings.NewReplacer("\\", "\\\\", "\n", "\\n", ":", "\\c")
+	headerEscaper12 = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+)
+
+// escaperFor returns how headers are escaped for a protocol version, or nil for STOMP 1.0. Clients that
+// haven't negotiated a version yet are answered as STOMP 1.2.
+func escaperFor(version string) *strings.Replacer {
+	switch version {
+	case "1.0":
+		return nil
+	case "1.1":
+		return headerEscaper11
+	default:
+		return headerEscaper12
+	}
+}
+
+// escapeHeader escapes a header name or value with escaper.
+func escapeHeader(escaper *strings.Replacer, value string) string {
+	if !strings.ContainsAny(value, "\\\r\n:") {
+		return value
+	}
+
+	return escaper.Replace(value)
+}
+
+// unescapeHeader reverses escapeHeader for a protocol version, rejecting undefined escape sequences. STOMP 1.0
+// values are taken as they are.
+func unescapeHeader(value string, version string) (string, error) {
+	if version == "1.0" || !strings.Contains(value, "\\") {
+		return value, nil
+	}
+
+	var unescaped strings.Builder
+	unescaped.Grow(len(value))
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			unescaped.WriteByte(value[i])
+			continue
+		}
+
+		if i+1 >= len(value) {
+			return "", fmt.Errorf("invalid escape sequence at end of header")
+		}
+
+		i++
+		switch value[i] {
+		case 'r':
+			if version == "1.1" {
+				return "", fmt.Errorf("invalid escape sequence \\r in STOMP 1.1 header")
+			}
+
+			unescaped.WriteByte('\r')
+		case 'n':
+			unescaped.WriteByte('\n')
+		case 'c':
+			unescaped.WriteByte(':')
+		case '\\':
+			unescaped.WriteByte('\\')
+		default:
+			return "", fmt.Errorf("invalid escape sequence \\%c in header", value[i])
+		}
+	}
+
+	return unescaped.String(), nil
 }
 
 type StompCommand string
@@ -0,0 +1,85 @@
+package stomper
+
+import (
+	"testing"
+	"time"
+)
+
+// connectVersion connects with a single accepted protocol version.
+func connectVersion(t *testing.T, addr string, version string) *testClient {
+	t.Helper()
+	c := dialTestClient(t, addr)
+	c.send("CONNECT", []string{"accept-version:" + version}, "")
+	if frame := c.read(); frame.Command != Connected || frame.Headers["version"] != version {
+		t.Fatalf("expected CONNECTED for %s, got %s %v", version, frame.Command, frame.Headers)
+	}
+
+	return c
+}
+
+func TestHeadersAreEscapedPerVersion(t *testing.T) {
+	server, addr := newTestServer(t, nil)
+	want := map[string][2]string{
+		"1.0": {`C:\temp`, "a\rb"},
+		"1.1": {`C\c\\temp`, "a\rb"},
+		"1.2": {`C\c\\temp`, `a\rb`},
+	}
+
+	clients := make(map[string]*testClient)
+	for version := range want {
+		clients[version] = connectVersion(t, addr, version)
+		clients[version].subscribe("sub:"+version, "/topic/files")
+	}
+
+	server.SendMessageWithHeaders("/topic/files", "text/plain", "x", map[string]string{"path": `C:\temp`, "note": "a\rb"}, nil)
+	for version, headers := range want {
+		frame := clients[version].read()
+		subscription := "sub\\c" + version
+		if version == "1.0" {
+			subscription = "sub:" + version
+		}
+
+		if frame.Headers["path"] != headers[0] || frame.Headers["note"] != headers[1] || frame.Headers["subscription"] != subscription {
+			t.Errorf("%s: unexpected headers %q", version, frame.Headers)
+		}
+	}
+}
+
+func TestHeadersAreUnescapedPerVersion(t *testing.T) {
+	received := make(chan map[string]string, 1)
+	_, addr := newTestServer(t, func(server *Server) {
+		server.Strict = true
+		_ = server.AddMessageHandler(func(client *Client, destination string, message *StompMessage) {
+			received <- message.Headers
+		})
+	})
+
+	path := func() string {
+		t.Helper()
+		select {
+		case headers := <-received:
+			return headers["path"]
+		case <-time.After(time.Second):
+			t.Fatal("expected the SEND to be handled")
+			return ""
+		}
+	}
+
+	// STOMP 1.0 has no escaping, so a backslash is just a backslash
+	connectVersion(t, addr, "1.0").send("SEND", []string{"destination:/queue/files", `path:C:\temp`}, "x")
+	if value := path(); value != `C:\temp` {
+		t.Fatalf("expected the 1.0 value as sent, got %q", value)
+	}
+
+	c := connectVersion(t, addr, "1.1")
+	c.send("SEND", []string{"destination:/queue/files", `path:C\c\\temp`}, "x")
+	if value := path(); value != `C:\temp` {
+		t.Fatalf("expected the 1.1 value to be unescaped, got %q", value)
+	}
+
+	// \r is only defined from STOMP 1.2
+	c.send("SEND", []string{"destination:/queue/files", `note:a\rb`}, "x")
+	if frame := c.read(); frame.Command != Error || frame.Headers["error-code"] != ErrorCodeInvalidHeader {
+		t.Fatalf("expected an invalid-header ERROR, got %s %v", frame.Command, frame.Headers)
+	}
+}
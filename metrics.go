@@ -0,0 +1,301 @@
+package stomper
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the broadcast and handler duration histograms.
+var durationBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+type metrics struct {
+	mux      sync.Mutex
+	received map[string]uint64
+	sent     map[string]uint64
+
+	broadcast histogram
+	handlers  map[handlerKey]*histogram
+
+	parseErrors atomic.Uint64
+	writeErrors atomic.Uint64
+	conflated   atomic.Uint64
+
+	slowConsumers map[string]uint64
+
+	sourcePauses    atomic.Uint64
+	sourcePausedFor atomic.Int64
+}
+
+// frameReceived counts an inbound frame, lumping unknown commands together to keep the label set bounded.
+func (m *metrics) frameReceived(command StompCommand) {
+	if !isClientCommand(command) {
+		command = "UNKNOWN"
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.received == nil {
+		m.received = make(map[string]uint64)
+	}
+
+	m.received[string(command)]++
+}
+
+// frameSent counts an outbound frame by the command on its first line; heart-beats aren't counted.
+func (m *metrics) frameSent(frame []byte) {
+	end := bytes.IndexByte(frame, '\n')
+	if end <= 0 {
+		return
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.sent == nil {
+		m.sent = make(map[string]uint64)
+	}
+
+	m.sent[string(frame[:end])]++
+}
+
+// slowConsumer counts a message which found a client's queue full, by the policy applied to it.
+func (m *metrics) slowConsumer(policy SlowConsumerPolicy) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.slowConsumers == nil {
+		m.slowConsumers = make(map[string]uint64)
+	}
+
+	m.slowConsumers[policy.String()]++
+}
+
+// sourcePaused counts a data source held up by WaitForCapacity, and for how long.
+func (m *metrics) sourcePaused(duration time.Duration) {
+	m.sourcePauses.Add(1)
+	m.sourcePausedFor.Add(int64(duration))
+}
+
+func (m *metrics) broadcastObserved(duration time.Duration) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.broadcast.observe(duration)
+}
+
+type handlerKey struct {
+	kind string
+	name string
+}
+
+func (m *metrics) handlerObserved(kind string, name string, duration time.Duration) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.handlers == nil {
+		m.handlers = make(map[handlerKey]*histogram)
+	}
+
+	key := handlerKey{kind: kind, name: name}
+	h, ok := m.handlers[key]
+	if !ok {
+		h = &histogram{}
+		m.handlers[key] = h
+	}
+
+	h.observe(duration)
+}
+
+// histogram counts durations into durationBuckets.
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(duration time.Duration) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(durationBuckets))
+	}
+
+	seconds := duration.Seconds()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+
+	h.sum += seconds
+	h.count++
+}
+
+func (h histogram) copy() histogram {
+	h.counts = append([]uint64(nil), h.counts...)
+	return h
+}
+
+// MetricsHandler serves the server's metrics in the Prometheus text exposition format, so it can be scraped
+// directly or mounted next to a promhttp handler.
+func (server *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		server.init()
+		writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		server.writeMetrics(writer)
+	})
+}
+
+func (server *Server) writeMetrics(w io.Writer) {
+	server.clientMux.RLock()
+	clients := len(server.clients)
+	server.clientMux.RUnlock()
+
+	subscriptions := make(map[string]int)
+	for _, shard := range server.subscriptions {
+		shard.mux.RLock()
+		for topic, subs := range shard.topics {
+			for _, clientSubs := range subs {
+				subscriptions[topic] += len(clientSubs)
+			}
+		}
+		shard.mux.RUnlock()
+	}
+
+	server.patterns.mux.RLock()
+	server.patterns.each(func(pattern string, subs map[uint64]map[string]*Client) {
+		for _, clientSubs := range subs {
+			subscriptions[pattern] += len(clientSubs)
+		}
+	})
+	server.patterns.mux.RUnlock()
+
+	m := &server.metrics
+	m.mux.Lock()
+	received := sortedCounts(m.received)
+	sent := sortedCounts(m.sent)
+	slowConsumers := sortedCounts(m.slowConsumers)
+	broadcast := m.broadcast.copy()
+	handlerKeys := make([]handlerKey, 0, len(m.handlers))
+	handlers := make(map[handlerKey]histogram, len(m.handlers))
+	for key, h := range m.handlers {
+		handlerKeys = append(handlerKeys, key)
+		handlers[key] = h.copy()
+	}
+	m.mux.Unlock()
+
+	sort.Slice(handlerKeys, func(i, j int) bool {
+		if handlerKeys[i].kind != handlerKeys[j].kind {
+			return handlerKeys[i].kind < handlerKeys[j].kind
+		}
+
+		return handlerKeys[i].name < handlerKeys[j].name
+	})
+
+	writeMetricHeader(w, "stomper_connected_clients", "gauge", "Number of connected clients.")
+	fmt.Fprintf(w, "stomper_connected_clients %d\n", clients)
+
+	writeMetricHeader(w, "stomper_subscriptions", "gauge", "Number of active subscriptions by destination.")
+	for _, topic := range sortedKeys(subscriptions) {
+		fmt.Fprintf(w, "stomper_subscriptions{destination=\"%s\"} %d\n", escapeLabel(topic), subscriptions[topic])
+	}
+
+	writeMetricHeader(w, "stomper_frames_received_total", "counter", "Frames received from clients by command.")
+	for _, entry := range received {
+		fmt.Fprintf(w, "stomper_frames_received_total{command=\"%s\"} %d\n", escapeLabel(entry.name), entry.count)
+	}
+
+	writeMetricHeader(w, "stomper_frames_sent_total", "counter", "Frames written to clients by command.")
+	for _, entry := range sent {
+		fmt.Fprintf(w, "stomper_frames_sent_total{command=\"%s\"} %d\n", escapeLabel(entry.name), entry.count)
+	}
+
+	writeMetricHeader(w, "stomper_broadcast_duration_seconds", "histogram", "Time taken to fan a message out to its subscribers.")
+	writeHistogram(w, "stomper_broadcast_duration_seconds", "", broadcast)
+
+	writeMetricHeader(w, "stomper_handler_duration_seconds", "histogram", "Time taken by each registered handler, by kind and handler.")
+	for _, key := range handlerKeys {
+		labels := fmt.Sprintf("kind=\"%s\",handler=\"%s\"", escapeLabel(key.kind), escapeLabel(key.name))
+		writeHistogram(w, "stomper_handler_duration_seconds", labels, handlers[key])
+	}
+
+	writeMetricHeader(w, "stomper_parse_errors_total", "counter", "Client frames that could not be parsed.")
+	fmt.Fprintf(w, "stomper_parse_errors_total %d\n", m.parseErrors.Load())
+
+	writeMetricHeader(w, "stomper_write_errors_total", "counter", "Frames that could not be written to a client.")
+	fmt.Fprintf(w, "stomper_write_errors_total %d\n", m.writeErrors.Load())
+
+	writeMetricHeader(w, "stomper_conflated_total", "counter", "Messages replaced by a newer one for the same subscription before being written.")
+	fmt.Fprintf(w, "stomper_conflated_total %d\n", m.conflated.Load())
+
+	writeMetricHeader(w, "stomper_slow_consumer_total", "counter", "Messages which found a client's outbound queue full, by the policy applied.")
+	for _, entry := range slowConsumers {
+		fmt.Fprintf(w, "stomper_slow_consumer_total{policy=\"%s\"} %d\n", escapeLabel(entry.name), entry.count)
+	}
+
+	writeMetricHeader(w, "stomper_source_pauses_total", "counter", "Times a data source waited for clients to catch up before taking more messages.")
+	fmt.Fprintf(w, "stomper_source_pauses_total %d\n", m.sourcePauses.Load())
+
+	writeMetricHeader(w, "stomper_source_paused_seconds_total", "counter", "Time data sources spent waiting for clients to catch up.")
+	fmt.Fprintf(w, "stomper_source_paused_seconds_total %g\n", time.Duration(m.sourcePausedFor.Load()).Seconds())
+}
+
+func writeMetricHeader(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// writeHistogram writes a histogram's series, with labels (if any) ahead of each bucket's le label.
+func writeHistogram(w io.Writer, name string, labels string, h histogram) {
+	prefix, suffix := "", ""
+	if labels != "" {
+		prefix, suffix = labels+",", "{"+labels+"}"
+	}
+
+	for i, bound := range durationBuckets {
+		var bucket uint64
+		if h.counts != nil {
+			bucket = h.counts[i]
+		}
+
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%g\"} %d\n", name, prefix, bound, bucket)
+	}
+
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, prefix, h.count)
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, suffix, h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, suffix, h.count)
+}
+
+type namedCount struct {
+	name  string
+	count uint64
+}
+
+func sortedCounts(counts map[string]uint64) []namedCount {
+	entries := make([]namedCount, 0, len(counts))
+	for name, count := range counts {
+		entries = append(entries, namedCount{name: name, count: count})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+
+	return entries
+}
+
+func sortedKeys(values map[string]int) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
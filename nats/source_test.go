@@ -0,0 +1,134 @@
+package nats
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/hfoxy/stomper"
+	"github.com/hfoxy/stomper/stompertest"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testServer() *stomper.Server {
+	server := &stomper.Server{Sugar: zap.NewNop().Sugar()}
+	server.Setup()
+	return server
+}
+
+// fakeNATS accepts one client, answers its handshake and PINGs, and sends it a MSG for every line written
+// to publish once it has subscribed.
+func fakeNATS(t *testing.T) (string, chan<- string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	publish := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+		_, _ = fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"version\":\"2.10.0\",\"proto\":1,\"headers\":true,\"max_payload\":1048576}\r\n")
+
+		reader := bufio.NewReader(conn)
+		subscribed := make(chan string, 1)
+		go func() {
+			sid := <-subscribed
+			for payload := range publish {
+				subject, data, _ := strings.Cut(payload, " ")
+				_, _ = fmt.Fprintf(conn, "MSG %s %s %d\r\n%s\r\n", subject, sid, len(data), data)
+			}
+		}()
+
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			switch fields := strings.Fields(line); fields[0] {
+			case "PING":
+				_, _ = fmt.Fprintf(conn, "PONG\r\n")
+			case "SUB":
+				subscribed <- fields[len(fields)-1]
+			}
+		}
+	}()
+
+	return "nats://" + listener.Addr().String(), publish
+}
+
+func TestRunBroadcastsSubjects(t *testing.T) {
+	server := testServer()
+	url, publish := fakeNATS(t)
+	mapping, err := stomper.NewDestinationMapping("orders.{region}", "/topic/orders/{region}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := &Source{Server: server, URL: url, Subjects: []string{"orders.*"}, Mapping: stomper.DestinationMapper{mapping}}
+	subscriber := stompertest.Subscribe(t, server, "/topic/orders/eu")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go source.Run(ctx)
+
+	publish <- `orders.eu {"id":1}`
+	message := subscriber.Message()
+	if string(*message.Body) != `{"id":1}` || message.Headers[SubjectHeader] != "orders.eu" {
+		t.Fatalf("unexpected message %v %q", message.Headers, *message.Body)
+	}
+
+	if err = source.Health(); err != nil {
+		t.Fatalf("expected the source to be healthy, got %v", err)
+	}
+}
+
+func TestPublishCopiesHeaders(t *testing.T) {
+	server := testServer()
+	source := &Source{Server: server}
+	subscriber := stompertest.Subscribe(t, server, "/topic/orders.eu")
+
+	msg := nats.NewMsg("orders.eu")
+	msg.Data = []byte("<order/>")
+	msg.Header.Set("Content-Type", "application/xml")
+	msg.Header.Set("Trace-Id", "abc")
+	source.publish(msg, nil)
+
+	message := subscriber.Message()
+	if message.Headers["content-type"] != "application/xml" || message.Headers["trace-id"] != "abc" {
+		t.Fatalf("unexpected headers %v", message.Headers)
+	}
+}
+
+func TestRunReportsConnectionFailures(t *testing.T) {
+	source := &Source{
+		Server:        testServer(),
+		URL:           "nats://127.0.0.1:1",
+		Subjects:      []string{"orders.*"},
+		ReconnectWait: 10 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := source.Run(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected Run to stop with ctx, got %v", err)
+	}
+
+	if err := source.Health(); err == nil || !strings.Contains(err.Error(), "unable to connect") {
+		t.Fatalf("expected the connection failure as health, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package stomper
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// checkOrigin decides whether a websocket upgrade is allowed from the request's Origin. Server.CheckOrigin
+// takes precedence; otherwise, with AllowedOrigins set, only same-origin requests, requests without an
+// Origin (non-browser clients) and origins matching an entry are accepted. With neither set every origin is.
+func (server *Server) checkOrigin(request *http.Request) bool {
+	if server.CheckOrigin != nil {
+		return server.CheckOrigin(request)
+	}
+
+	server.reloadMux.RLock()
+	allowedOrigins := server.AllowedOrigins
+	server.reloadMux.RUnlock()
+
+	return server.allowOrigin(request, allowedOrigins)
+}
+
+// allowOrigin applies an AllowedOrigins list to the request's Origin, allowing any origin when it's empty.
+func (server *Server) allowOrigin(request *http.Request, allowedOrigins []string) bool {
+	if len(allowedOrigins) == 0 {
+		return true
+	}
+
+	origin := request.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if parsed, err := url.Parse(origin); err == nil && strings.EqualFold(parsed.Host, request.Host) {
+		return true
+	}
+
+	for _, allowed := range allowedOrigins {
+		if matchOrigin(allowed, origin) {
+			return true
+		}
+	}
+
+	server.Sugar.Infof("rejected upgrade from origin %s", origin)
+	return false
+}
+
+// matchOrigin matches an origin against an exact origin or a pattern containing one `*`, such as
+// `https://*.example.com`; a lone `*` allows any origin.
+func matchOrigin(pattern string, origin string) bool {
+	pattern = strings.ToLower(pattern)
+	origin = strings.ToLower(origin)
+	if pattern == "*" || pattern == origin {
+		return true
+	}
+
+	prefix, suffix, ok := strings.Cut(pattern, "*")
+	if !ok {
+		return false
+	}
+
+	return len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix)
+}
@@ -0,0 +1,81 @@
+// Package otel adapts OpenTelemetry to stomper's Tracer, so frame handling, handlers and broadcasts are traced
+// with spans exported wherever the application's TracerProvider sends them. It's a module of its own, so the
+// stomper module doesn't depend on OpenTelemetry.
+package otel
+
+import (
+	"context"
+	"github.com/hfoxy/stomper"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+const defaultHeader = "traceparent"
+
+// Tracer is a stomper.Tracer starting spans with an OpenTelemetry tracer and propagating their context with a
+// TextMapPropagator.
+type Tracer struct {
+	Tracer trace.Tracer
+
+	// Propagator encodes span contexts in frame headers (default W3C trace context), and Header is the key it
+	// reads and writes (default traceparent). The server carries the value in its own Server.TraceHeader.
+	Propagator propagation.TextMapPropagator
+	Header     string
+}
+
+// New returns a Tracer starting spans with tracer and propagating W3C trace context.
+func New(tracer trace.Tracer) *Tracer {
+	return &Tracer{Tracer: tracer}
+}
+
+func (tracer *Tracer) propagator() propagation.TextMapPropagator {
+	if tracer.Propagator == nil {
+		return propagation.TraceContext{}
+	}
+
+	return tracer.Propagator
+}
+
+func (tracer *Tracer) header() string {
+	if tracer.Header == "" {
+		return defaultHeader
+	}
+
+	return tracer.Header
+}
+
+func (tracer *Tracer) StartSpan(ctx context.Context, name string, start time.Time, attributes map[string]string) (context.Context, stomper.Span) {
+	attrs := make([]attribute.KeyValue, 0, len(attributes))
+	for k, v := range attributes {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+
+	ctx, span := tracer.Tracer.Start(ctx, name, trace.WithTimestamp(start), trace.WithAttributes(attrs...))
+	return ctx, otelSpan{span: span}
+}
+
+func (tracer *Tracer) Extract(ctx context.Context, value string) context.Context {
+	return tracer.propagator().Extract(ctx, propagation.MapCarrier{tracer.header(): value})
+}
+
+func (tracer *Tracer) Inject(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	tracer.propagator().Inject(ctx, carrier)
+	return carrier[tracer.header()]
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (span otelSpan) End(err error) {
+	if err != nil {
+		span.span.RecordError(err)
+		span.span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.span.End()
+}
@@ -0,0 +1,114 @@
+package otel
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/hfoxy/stomper"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newRecordedTracer() (*Tracer, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	return New(provider.Tracer("stomper")), recorder
+}
+
+func TestSpansRecordAttributesAndErrors(t *testing.T) {
+	tracer, recorder := newRecordedTracer()
+	start := time.Unix(1_700_000_000, 0)
+	_, span := tracer.StartSpan(context.Background(), "stomper.send", start, map[string]string{"stomper.destination": "/queue/a"})
+	span.End(errors.New("refused"))
+
+	ended := recorder.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("expected one span, got %d", len(ended))
+	}
+
+	got := ended[0]
+	if got.Name() != "stomper.send" || !got.StartTime().Equal(start) || got.Status().Code != codes.Error || got.Status().Description != "refused" {
+		t.Fatalf("unexpected span %s started %s with status %v", got.Name(), got.StartTime(), got.Status())
+	}
+
+	if attrs := got.Attributes(); len(attrs) != 1 || attrs[0].Value.AsString() != "/queue/a" {
+		t.Fatalf("expected the destination attribute, got %v", attrs)
+	}
+}
+
+func TestTraceContextRoundTrips(t *testing.T) {
+	tracer, _ := newRecordedTracer()
+	ctx, span := tracer.StartSpan(context.Background(), "stomper.broadcast", time.Now(), nil)
+	defer span.End(nil)
+
+	value := tracer.Inject(ctx)
+	if !strings.HasPrefix(value, "00-") {
+		t.Fatalf("expected a W3C traceparent, got %q", value)
+	}
+
+	if again := tracer.Inject(tracer.Extract(context.Background(), value)); again != value {
+		t.Fatalf("expected %q to survive extraction, got %q", value, again)
+	}
+}
+
+func TestFramesContinueThePropagatedTrace(t *testing.T) {
+	tracer, recorder := newRecordedTracer()
+	server := &stomper.Server{Sugar: zap.NewNop().Sugar(), Tracer: tracer}
+	_ = server.AddMessageHandler(func(*stomper.Client, string, *stomper.StompMessage) {})
+	server.Setup()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go server.ServeTCP(listener)
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	const traceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	_, _ = conn.Write([]byte("CONNECT\naccept-version:1.2\n\n\x00"))
+	_, _ = conn.Write([]byte("SEND\ndestination:/queue/a\nreceipt:r\ntraceparent:00-" + traceId + "-00f067aa0ba902b7-01\n\nx\x00"))
+	reader := bufio.NewReader(conn)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		frame, err := reader.ReadString(0)
+		if err != nil {
+			t.Fatalf("expected a RECEIPT: %v", err)
+		}
+
+		if strings.HasPrefix(strings.TrimLeft(frame, "\n"), "RECEIPT") {
+			break
+		}
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+
+	send, handlers := spans["stomper.send"], spans["stomper.handlers.message"]
+	if send == nil || send.SpanContext().TraceID().String() != traceId {
+		t.Fatalf("expected the SEND to continue trace %s, got %v", traceId, send)
+	}
+
+	if handlers == nil || handlers.Parent().SpanID() != send.SpanContext().SpanID() {
+		t.Fatalf("expected the message handlers traced within the SEND, got %v", handlers)
+	}
+}
@@ -0,0 +1,132 @@
+package stomper
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// OutboxIdHeader carries an outbox entry's ID on the MESSAGE frames it's broadcast as, for subscribers to
+// drop the duplicates at-least-once delivery allows.
+const OutboxIdHeader = "outbox-id"
+
+// OutboxEntry is a message an application wrote to its outbox, typically in the same database transaction as
+// the change it describes.
+type OutboxEntry struct {
+	ID          string
+	Destination string
+	ContentType string
+	Body        []byte
+	Headers     map[string]string
+}
+
+// OutboxReader reads unpublished entries from an application's outbox table or stream, oldest first, and
+// marks them as published once they have been broadcast.
+type OutboxReader interface {
+	Fetch(ctx context.Context, limit int) ([]OutboxEntry, error)
+	MarkPublished(ctx context.Context, ids []string) error
+}
+
+// Outbox publishes entries from an OutboxReader, at least once. While it runs, an entry is broadcast once even
+// if marking it published fails: its id is remembered until a later MarkPublished succeeds. That memory isn't
+// kept anywhere else, so an entry broadcast but not yet marked when the process stops, or when another replica
+// takes over, is broadcast again; subscribers that mind dedupe on OutboxIdHeader.
+type Outbox struct {
+	Server *Server
+	Reader OutboxReader
+
+	// PollInterval is how long to wait when the outbox is empty (default 1s); BatchSize caps each Fetch (default 100).
+	PollInterval time.Duration
+	BatchSize    int
+
+	unmarked map[string]bool
+}
+
+// Run publishes outbox entries until ctx is cancelled, returning ctx's error.
+func (outbox *Outbox) Run(ctx context.Context) error {
+	if outbox.Server == nil || outbox.Reader == nil {
+		return fmt.Errorf("outbox requires a server and a reader")
+	}
+
+	interval := outbox.PollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	clock := outbox.Server.clock()
+	for {
+		published, err := outbox.PublishBatch(ctx)
+		if err != nil {
+			outbox.Server.init()
+			outbox.Server.Sugar.Warnf("outbox: %v", err)
+		}
+
+		if published > 0 && err == nil {
+			continue
+		}
+
+		timer := clock.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C():
+		}
+	}
+}
+
+// PublishBatch fetches one batch of entries, broadcasts those not already published and marks them all
+// published. It returns how many entries were broadcast.
+func (outbox *Outbox) PublishBatch(ctx context.Context) (int, error) {
+	if outbox.unmarked == nil {
+		outbox.unmarked = make(map[string]bool)
+	}
+
+	limit := outbox.BatchSize
+	if limit <= 0 {
+		limit = 100
+	}
+
+	entries, err := outbox.Reader.Fetch(ctx, limit)
+	if err != nil {
+		return 0, fmt.Errorf("unable to fetch entries: %w", err)
+	}
+
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	published := 0
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+		if outbox.unmarked[entry.ID] {
+			continue
+		}
+
+		contentType := entry.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+
+		headers := make(map[string]string, len(entry.Headers)+1)
+		for k, v := range entry.Headers {
+			headers[k] = v
+		}
+
+		headers[OutboxIdHeader] = entry.ID
+		outbox.Server.SendMessageWithHeaders(entry.Destination, contentType, string(entry.Body), headers, nil)
+		outbox.unmarked[entry.ID] = true
+		published++
+	}
+
+	if err = outbox.Reader.MarkPublished(ctx, ids); err != nil {
+		return published, fmt.Errorf("unable to mark %d entries published: %w", len(ids), err)
+	}
+
+	for _, id := range ids {
+		delete(outbox.unmarked, id)
+	}
+
+	return published, nil
+}
@@ -0,0 +1,67 @@
+package stomper
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// flakyOutbox is an OutboxReader holding its entries until they're marked, failing the first few marks.
+type flakyOutbox struct {
+	entries []OutboxEntry
+	fails   int
+}
+
+func (reader *flakyOutbox) Fetch(context.Context, int) ([]OutboxEntry, error) {
+	return reader.entries, nil
+}
+
+func (reader *flakyOutbox) MarkPublished(context.Context, []string) error {
+	if reader.fails > 0 {
+		reader.fails--
+		return errors.New("database unavailable")
+	}
+
+	reader.entries = nil
+	return nil
+}
+
+func TestOutboxDeliversAtLeastOnce(t *testing.T) {
+	server, addr := newTestServer(t, nil)
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("0", "/topic/orders")
+
+	entry := OutboxEntry{ID: "42", Destination: "/topic/orders", Body: []byte(`{"id":42}`), Headers: map[string]string{"tenant": "acme"}}
+	reader := &flakyOutbox{entries: []OutboxEntry{entry}, fails: 2}
+	outbox := &Outbox{Server: server, Reader: reader}
+	for i := 0; i < 2; i++ {
+		if _, err := outbox.PublishBatch(context.Background()); err == nil {
+			t.Fatal("expected the failed mark to be reported")
+		}
+	}
+
+	frame := c.read()
+	if frame.Headers[OutboxIdHeader] != "42" || frame.Headers["tenant"] != "acme" {
+		t.Fatalf("expected the entry with its outbox id, got %v", frame.Headers)
+	}
+
+	// still unmarked, but not broadcast again by the same outbox
+	c.quiet(50 * time.Millisecond)
+	if len(entry.Headers) != 1 {
+		t.Fatalf("expected the entry's headers to be left alone, got %v", entry.Headers)
+	}
+
+	// a restarted outbox doesn't know it was broadcast, so subscribers see it again
+	if published, err := (&Outbox{Server: server, Reader: reader}).PublishBatch(context.Background()); err != nil || published != 1 {
+		t.Fatalf("expected the entry to be broadcast again, got %d (%v)", published, err)
+	}
+
+	if frame = c.read(); frame.Headers[OutboxIdHeader] != "42" {
+		t.Fatalf("expected the duplicate to carry the same outbox id, got %v", frame.Headers)
+	}
+
+	if published, _ := outbox.PublishBatch(context.Background()); published != 0 {
+		t.Fatalf("expected nothing left to publish, got %d", published)
+	}
+}
@@ -0,0 +1,91 @@
+package stomper
+
+import (
+	"mime"
+	"strings"
+)
+
+// DestinationPolicy restricts what clients may SEND to destinations matching a DestinationTemplate, e.g.
+// `/state/{id}`. The first matching policy applies; frames that break it are rejected with an ERROR.
+type DestinationPolicy struct {
+	Destination string
+
+	// ContentTypes lists the accepted media types, ignoring parameters such as charset. Empty accepts any.
+	ContentTypes []string
+
+	// MaxBodySize is the largest accepted body in bytes. Zero or less is unlimited.
+	MaxBodySize int
+}
+
+type destinationPolicy struct {
+	DestinationPolicy
+	template *DestinationTemplate
+}
+
+func (server *Server) parseDestinationPolicies() []destinationPolicy {
+	var policies []destinationPolicy
+	for _, policy := range server.DestinationPolicies {
+		template, err := ParseDestinationTemplate(policy.Destination)
+		if err != nil {
+			server.Sugar.Warnf("invalid destination policy (%s): %v", policy.Destination, err)
+			continue
+		}
+
+		policies = append(policies, destinationPolicy{DestinationPolicy: policy, template: template})
+	}
+
+	return policies
+}
+
+// checkDestinationPolicy returns a FrameError if the message breaks the policy for its destination.
+func (server *Server) checkDestinationPolicy(destination string, message *StompMessage) error {
+	server.reloadMux.RLock()
+	policies := server.destinationPolicies
+	server.reloadMux.RUnlock()
+
+	for _, policy := range policies {
+		if _, ok := policy.template.Match(destination); !ok {
+			continue
+		}
+
+		size := 0
+		if message.Body != nil {
+			size = len(*message.Body)
+		}
+
+		if policy.MaxBodySize > 0 && size > policy.MaxBodySize {
+			return &FrameError{
+				Code:    ErrorCodePolicyViolation,
+				Message: "body exceeds the limit for " + destination,
+				Err:     ErrPolicyViolation,
+			}
+		}
+
+		if len(policy.ContentTypes) > 0 && !acceptsContentType(policy.ContentTypes, message.Headers["content-type"]) {
+			return &FrameError{
+				Code:    ErrorCodePolicyViolation,
+				Message: "content-type not accepted for " + destination,
+				Err:     ErrPolicyViolation,
+			}
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
+func acceptsContentType(accepted []string, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, candidate := range accepted {
+		if strings.EqualFold(candidate, mediaType) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,81 @@
+package postgres
+
+import (
+	"context"
+	"github.com/hfoxy/stomper"
+	"github.com/hfoxy/stomper/stompertest"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testServer() *stomper.Server {
+	server := &stomper.Server{Sugar: zap.NewNop().Sugar()}
+	server.Setup()
+	return server
+}
+
+func TestPublishMapsChannels(t *testing.T) {
+	server := testServer()
+	mapping, err := stomper.NewDestinationMapping("orders_{region}", "/topic/orders.{region}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := &Source{Server: server, Mapping: stomper.DestinationMapper{mapping}}
+	subscriber := stompertest.Subscribe(t, server, "/topic/orders.eu")
+
+	source.publish(&pgconn.Notification{Channel: "invoices", Payload: `{"id":0}`})
+	source.publish(&pgconn.Notification{Channel: "orders_eu", Payload: `{"id":1}`})
+
+	message := subscriber.Message()
+	if string(*message.Body) != `{"id":1}` {
+		t.Errorf("expected the orders_eu payload, got %q", *message.Body)
+	}
+
+	if message.Headers[ChannelHeader] != "orders_eu" || message.Headers["content-type"] != "application/json" {
+		t.Errorf("unexpected headers %v", message.Headers)
+	}
+
+	subscriber.Quiet(50 * time.Millisecond)
+}
+
+func TestPublishWithoutMapping(t *testing.T) {
+	server := testServer()
+	source := &Source{Server: server, ContentType: "text/plain"}
+	subscriber := stompertest.Subscribe(t, server, "/topic/orders")
+
+	source.publish(&pgconn.Notification{Channel: "orders", Payload: "hello"})
+	message := subscriber.Message()
+	if string(*message.Body) != "hello" || message.Headers["content-type"] != "text/plain" {
+		t.Errorf("unexpected message %v %q", message.Headers, *message.Body)
+	}
+}
+
+func TestRunRequiresChannels(t *testing.T) {
+	source := &Source{Server: testServer(), URL: "postgres://localhost/app"}
+	if err := source.Run(context.Background()); err == nil {
+		t.Fatal("expected an error without channels")
+	}
+}
+
+func TestRunReportsConnectionFailures(t *testing.T) {
+	source := &Source{
+		Server:        testServer(),
+		URL:           "postgres://app@127.0.0.1:1/app?sslmode=disable&connect_timeout=1",
+		Channels:      []string{"orders"},
+		ReconnectWait: 10 * time.Millisecond,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := source.Run(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected Run to stop with ctx, got %v", err)
+	}
+
+	if err := source.Health(); err == nil || !strings.Contains(err.Error(), "unable to connect") {
+		t.Fatalf("expected the connection failure as health, got %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package stomper
+
+import "strings"
+
+// Profile presets a group of options for a common kind of deployment.
+type Profile string
+
+const (
+	ProfileDefault Profile = ""
+
+	// ProfilePushOnly is for market-data style fan-out: clients can only subscribe, SEND, ACK/NACK and
+	// transactions are disabled, and subscriptions to PushDestinations are approved without running the
+	// subscribe handlers while all others are refused. Messages are conflated (see Server.Conflate) and
+	// there are no queues: QueuePrefixes are ignored, so every destination is a topic.
+	ProfilePushOnly Profile = "push-only"
+)
+
+var pushOnlyDisabledCommands = []StompCommand{Send, Ack, Nack, Begin, Commit, Abort}
+
+func (server *Server) applyProfile() {
+	switch server.Profile {
+	case ProfileDefault:
+	case ProfilePushOnly:
+		server.DisabledCommands = append(server.DisabledCommands, pushOnlyDisabledCommands...)
+		server.Conflate = true
+		if len(server.QueuePrefixes) > 0 {
+			server.Sugar.Warnf("push-only profile has no queues, ignoring queue prefixes %v", server.QueuePrefixes)
+			server.QueuePrefixes = nil
+		}
+
+		if len(server.PushDestinations) == 0 {
+			server.Sugar.Warnf("push-only profile without push destinations, all subscriptions will be refused")
+		}
+	default:
+		server.Sugar.Warnf("unknown profile (%s)", server.Profile)
+	}
+}
+
+func (server *Server) isPushDestination(destination string) bool {
+	for _, prefix := range server.PushDestinations {
+		if strings.HasPrefix(destination, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
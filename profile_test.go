@@ -0,0 +1,112 @@
+package stomper
+
+import (
+	"bufio"
+	"go.uber.org/zap"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// pipeListener accepts a single connection over a synchronous pipe, so nothing is written to the client
+// until the test reads it.
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+}
+
+func (listener *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.conns:
+		return conn, nil
+	case <-listener.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (listener *pipeListener) Close() error {
+	close(listener.closed)
+	return nil
+}
+
+func (listener *pipeListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+// dialPipe serves server over a pipe, returning a connected client which only receives what it reads.
+func dialPipe(t *testing.T, server *Server) *testClient {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	listener := &pipeListener{conns: make(chan net.Conn, 1), closed: make(chan struct{})}
+	listener.conns <- serverConn
+	t.Cleanup(func() {
+		_ = clientConn.Close()
+	})
+
+	go server.ServeTCP(listener)
+	return &testClient{t: t, conn: clientConn, reader: bufio.NewReader(clientConn)}
+}
+
+func TestPushOnlyProfile(t *testing.T) {
+	server := &Server{Sugar: zap.NewNop().Sugar(), Profile: ProfilePushOnly, PushDestinations: []string{"/topic/prices/"}, QueuePrefixes: []string{"/topic/prices/"}}
+	server.Setup()
+	if !server.Conflate || server.isQueue("/topic/prices/eu") {
+		t.Fatal("expected the push-only profile to conflate messages and have no queues")
+	}
+
+	c := dialPipe(t, server).connect()
+	c.subscribe("0", "/topic/prices/eu")
+
+	// the client isn't reading, so the first message holds up the rest, which are conflated
+	for i := 1; i <= 10; i++ {
+		server.SendMessage("/topic/prices/eu", "text/plain", strconv.Itoa(i))
+		time.Sleep(time.Millisecond)
+	}
+
+	var received []string
+	for {
+		frame, err := c.next(50 * time.Millisecond)
+		if err != nil {
+			break
+		}
+
+		received = append(received, string(*frame.Body))
+	}
+
+	if len(received) == 0 || len(received) > 2 || received[len(received)-1] != "10" {
+		t.Fatalf("expected at most the first and the latest message, got %v", received)
+	}
+
+	c.send("SEND", []string{"destination:/topic/prices/eu"}, "x")
+	if frame := c.read(); frame.Command != Error {
+		t.Fatalf("expected SEND to be refused, got %s %v", frame.Command, frame.Headers)
+	}
+}
+
+func TestConflationKeepsSubscriptionsApart(t *testing.T) {
+	server := &Server{Sugar: zap.NewNop().Sugar(), Conflate: true}
+	server.Setup()
+
+	c := dialPipe(t, server).connect()
+	c.subscribe("eu", "/topic/prices/eu")
+	c.subscribe("us", "/topic/prices/us")
+	for i := 1; i <= 5; i++ {
+		server.SendMessage("/topic/prices/eu", "text/plain", "eu"+strconv.Itoa(i))
+		server.SendMessage("/topic/prices/us", "text/plain", "us"+strconv.Itoa(i))
+	}
+
+	latest := make(map[string]string)
+	for {
+		frame, err := c.next(50 * time.Millisecond)
+		if err != nil {
+			break
+		}
+
+		latest[frame.Headers["subscription"]] = string(*frame.Body)
+	}
+
+	if latest["eu"] != "eu5" || latest["us"] != "us5" {
+		t.Fatalf("expected each subscription to end on its latest message, got %v", latest)
+	}
+}
@@ -0,0 +1,166 @@
+package stomper
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Values of the `error-code` header on ERROR frames sent for rejected frames.
+const (
+	ErrorCodeInvalidFrame         = "invalid-frame"
+	ErrorCodeUnknownCommand       = "unknown-command"
+	ErrorCodeInvalidHeader        = "invalid-header"
+	ErrorCodeInvalidContentLength = "invalid-content-length"
+	ErrorCodeMissingHeader        = "missing-header"
+	ErrorCodeCommandDisabled      = "command-disabled"
+	ErrorCodeUnsupportedVersion   = "unsupported-version"
+	ErrorCodeFrameTooLarge        = "frame-too-large"
+	ErrorCodeUnauthorized         = "unauthorized"
+	ErrorCodePolicyViolation      = "policy-violation"
+	ErrorCodeUpgradeRequired      = "upgrade-required"
+	ErrorCodeQuotaExceeded        = "quota-exceeded"
+	ErrorCodeSlowConsumer         = "slow-consumer"
+	ErrorCodeNotConnected         = "not-connected"
+	ErrorCodeAlreadyConnected     = "already-connected"
+)
+
+const defaultErrorEchoLimit = 256
+
+// FrameError describes why a client frame was rejected.
+type FrameError struct {
+	Code    string
+	Message string
+
+	// Headers are added to the ERROR frame, e.g. the supported `version` list after a failed negotiation.
+	Headers map[string]string
+
+	// Err is the class of error, ErrProtocol when unset.
+	Err error
+}
+
+func (e *FrameError) Error() string {
+	return e.Message
+}
+
+func (e *FrameError) Unwrap() error {
+	if e.Err == nil {
+		return ErrProtocol
+	}
+
+	return e.Err
+}
+
+func frameErrorf(code string, format string, args ...any) *FrameError {
+	return &FrameError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// SupportedVersions are the STOMP protocol versions the server speaks, lowest first.
+var SupportedVersions = []string{"1.0", "1.1", "1.2"}
+
+// negotiateVersion picks the highest supported version from a CONNECT frame's accept-version header.
+// Clients that don't send one are STOMP 1.0 clients.
+func negotiateVersion(acceptVersion string) (string, error) {
+	if acceptVersion == "" {
+		return "1.0", nil
+	}
+
+	offered := make(map[string]bool)
+	for _, version := range strings.Split(acceptVersion, ",") {
+		offered[strings.TrimSpace(version)] = true
+	}
+
+	for i := len(SupportedVersions) - 1; i >= 0; i-- {
+		if offered[SupportedVersions[i]] {
+			return SupportedVersions[i], nil
+		}
+	}
+
+	return "", &FrameError{
+		Code:    ErrorCodeUnsupportedVersion,
+		Message: fmt.Sprintf("unsupported protocol version (%s)", acceptVersion),
+		Headers: map[string]string{"version": strings.Join(SupportedVersions, ",")},
+	}
+}
+
+var requiredHeaders = map[StompCommand][]string{
+	Send:        {"destination"},
+	Subscribe:   {"destination", "id"},
+	Unsubscribe: {"id"},
+	Ack:         {"id"},
+	Nack:        {"id"},
+	Begin:       {"transaction"},
+	Commit:      {"transaction"},
+	Abort:       {"transaction"},
+}
+
+func isClientCommand(command StompCommand) bool {
+	switch command {
+	case Connect, Stomp, Send, Subscribe, Unsubscribe, Ack, Nack, Begin, Commit, Abort, Disconnect:
+		return true
+	}
+
+	return false
+}
+
+// ErrorPolicy decides what happens to a connection after a frame is rejected with an ERROR.
+type ErrorPolicy int
+
+const (
+	// ErrorPolicyClose closes the connection after the ERROR frame, as the STOMP specification requires.
+	ErrorPolicyClose ErrorPolicy = iota
+
+	// ErrorPolicyContinue drops the offending frame but keeps the connection open. This is lenient towards
+	// buggy clients but not spec compliant; clients may treat any ERROR as fatal regardless.
+	ErrorPolicyContinue
+)
+
+// rejectFrame reports a protocol violation to the client, returning true if the connection should carry on.
+func (server *Server) rejectFrame(client *Client, err error, frame []byte) bool {
+	server.reportError(client, err)
+	server.sendFrameError(client, err, frame)
+	return server.ErrorPolicy == ErrorPolicyContinue
+}
+
+// sendFrameError sends an ERROR frame describing err, echoing up to ErrorEchoLimit bytes of the offending frame.
+func (server *Server) sendFrameError(client *Client, err error, frame []byte) {
+	code := ErrorCodeInvalidFrame
+	var extraHeaders map[string]string
+	if frameErr, ok := err.(*FrameError); ok {
+		code = frameErr.Code
+		extraHeaders = frameErr.Headers
+	}
+
+	limit := server.ErrorEchoLimit
+	if limit == 0 {
+		limit = defaultErrorEchoLimit
+	}
+
+	if limit > 0 && len(frame) > limit {
+		frame = frame[:limit]
+	} else if limit < 0 {
+		frame = nil
+	}
+
+	body := make([]byte, len(frame))
+	copy(body, frame)
+
+	message := StompMessage{
+		Command: Error,
+		Headers: map[string]string{
+			"message":        err.Error(),
+			"error-code":     code,
+			"content-type":   "text/plain",
+			"content-length": strconv.Itoa(len(body)),
+		},
+		Body: &body,
+	}
+
+	for k, v := range extraHeaders {
+		message.Headers[k] = v
+	}
+
+	if writeErr := server.writeFrame(client, message.payload(client.Version)); writeErr != nil {
+		server.Sugar.Warnf("[%d] unable to write error frame: %v", client.Uid, writeErr)
+	}
+}
@@ -0,0 +1,72 @@
+package stomper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVersionNegotiation(t *testing.T) {
+	_, addr := newTestServer(t, nil)
+	tests := map[string]string{
+		"":            "1.0",
+		"1.0":         "1.0",
+		"1.0,1.1":     "1.1",
+		"1.2, 1.1":    "1.2",
+		"1.1,2.0,1.0": "1.1",
+	}
+
+	for accept, version := range tests {
+		c := dialTestClient(t, addr)
+		var headers []string
+		if accept != "" {
+			headers = append(headers, "accept-version:"+accept)
+		}
+
+		c.send("CONNECT", headers, "")
+		if frame := c.read(); frame.Command != Connected || frame.Headers["version"] != version {
+			t.Errorf("accept-version %q: expected %s, got %s %v", accept, version, frame.Command, frame.Headers)
+		}
+	}
+
+	c := dialTestClient(t, addr)
+	c.send("CONNECT", []string{"accept-version:2.0,3.0"}, "")
+	frame := c.read()
+	if frame.Command != Error || frame.Headers["error-code"] != ErrorCodeUnsupportedVersion || frame.Headers["version"] != "1.0,1.1,1.2" {
+		t.Fatalf("expected an unsupported-version ERROR listing the supported versions, got %s %v", frame.Command, frame.Headers)
+	}
+
+	c.closed()
+}
+
+func TestHeartBeatNegotiation(t *testing.T) {
+	server := &Server{HeartBeatSend: time.Second, HeartBeatReceive: 2 * time.Second}
+	tests := []struct {
+		clientSend, clientReceive time.Duration
+		want                      HeartBeat
+	}{
+		{0, 0, HeartBeat{}},
+		{500 * time.Millisecond, 500 * time.Millisecond, HeartBeat{Send: time.Second, Receive: 2 * time.Second}},
+		{5 * time.Second, 3 * time.Second, HeartBeat{Send: 3 * time.Second, Receive: 5 * time.Second}},
+		{time.Second, 0, HeartBeat{Receive: 2 * time.Second}},
+	}
+
+	for _, test := range tests {
+		if got := server.negotiateHeartBeat(test.clientSend, test.clientReceive); got != test.want {
+			t.Errorf("client %s,%s: expected %+v, got %+v", test.clientSend, test.clientReceive, test.want, got)
+		}
+	}
+
+	server.HeartBeatSend = -1
+	if got := server.negotiateHeartBeat(time.Second, time.Second); got.Send != 0 || got.Receive != 2*time.Second {
+		t.Errorf("expected the server not to send heart-beats, got %+v", got)
+	}
+
+	send, receive, err := parseHeartBeat("100,200")
+	if err != nil || send != 100*time.Millisecond || receive != 200*time.Millisecond {
+		t.Errorf("unexpected parse of 100,200: %s %s %v", send, receive, err)
+	}
+
+	if _, _, err = parseHeartBeat("100"); err == nil {
+		t.Error("expected a heart-beat header without a comma to be refused")
+	}
+}
@@ -0,0 +1,330 @@
+package stomper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// parseTrustedProxies turns a list of IPs and CIDRs into networks, skipping (and logging) invalid entries.
+func (server *Server) parseTrustedProxies() []*net.IPNet {
+	networks, errs := parseNetworks(server.TrustedProxies)
+	for _, err := range errs {
+		server.Sugar.Warnf("invalid trusted proxy %v", err)
+	}
+
+	return networks
+}
+
+// parseNetworks turns a list of IPs and CIDRs into networks, returning an error for each invalid entry.
+func parseNetworks(entries []string) ([]*net.IPNet, []error) {
+	var networks []*net.IPNet
+	var errs []error
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				errs = append(errs, fmt.Errorf("(%s)", entry))
+				continue
+			}
+
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("(%s): %v", entry, err))
+			continue
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, errs
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (server *Server) isTrustedProxy(ip net.IP) bool {
+	return containsIP(server.trustedProxies, ip)
+}
+
+// clientIP resolves the address of the client that opened the request. Forwarding headers are only honoured
+// when the direct peer is a trusted proxy; X-Forwarded-For is walked from the right, skipping trusted hops.
+func (server *Server) clientIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+
+	peer := net.ParseIP(host)
+	if peer == nil || !server.isTrustedProxy(peer) {
+		return host
+	}
+
+	if forwarded := request.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break
+			}
+
+			if i == 0 || !server.isTrustedProxy(hop) {
+				return hop.String()
+			}
+		}
+	}
+
+	if realIP := net.ParseIP(strings.TrimSpace(request.Header.Get("X-Real-IP"))); realIP != nil {
+		return realIP.String()
+	}
+
+	return host
+}
+
+var proxyV1Prefix = []byte("PROXY ")
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocolListener wraps a net.Listener and strips HAProxy PROXY protocol (v1 or v2) headers from accepted
+// connections, so that RemoteAddr (and therefore http.Request.RemoteAddr) reports the original client.
+//
+// A PROXY header is only believed from TrustedProxies. Without any, every connection is assumed to come
+// through a proxy, so with Required false a client reaching the listener directly can claim any address.
+type ProxyProtocolListener struct {
+	net.Listener
+
+	// HeaderTimeout bounds how long a connection may take to send its PROXY header. Defaults to 5 seconds.
+	HeaderTimeout time.Duration
+
+	// Required rejects connections that don't start with a PROXY header. When false they are passed through.
+	Required bool
+
+	// TrustedProxies are the IPs and CIDRs of the proxies allowed to send a PROXY header; connections from
+	// anywhere else are rejected if Required, and otherwise passed through as they are, header and all.
+	TrustedProxies []string
+
+	once     sync.Once
+	networks []*net.IPNet
+}
+
+func NewProxyProtocolListener(listener net.Listener) *ProxyProtocolListener {
+	return &ProxyProtocolListener{Listener: listener, Required: true}
+}
+
+func (listener *ProxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := listener.HeaderTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn), timeout: timeout, required: listener.Required, trusted: listener.trusts(conn.RemoteAddr())}, nil
+}
+
+// trusts reports whether a connection from addr may send a PROXY header.
+func (listener *ProxyProtocolListener) trusts(addr net.Addr) bool {
+	listener.once.Do(func() {
+		// invalid entries trust nothing, which fails safe
+		listener.networks, _ = parseNetworks(listener.TrustedProxies)
+	})
+
+	if len(listener.TrustedProxies) == 0 {
+		return true
+	}
+
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && containsIP(listener.networks, tcp.IP)
+}
+
+type proxyConn struct {
+	net.Conn
+	reader   *bufio.Reader
+	timeout  time.Duration
+	required bool
+	trusted  bool
+	once     sync.Once
+	remote   net.Addr
+	local    net.Addr
+	err      error
+}
+
+// init reads the PROXY header lazily, on first use, so a slow client can't block Accept for everyone else.
+func (conn *proxyConn) init() {
+	conn.once.Do(func() {
+		if !conn.trusted {
+			if conn.required {
+				conn.err = fmt.Errorf("proxy protocol header from untrusted address %s", conn.Conn.RemoteAddr())
+			}
+
+			return
+		}
+
+		_ = conn.Conn.SetReadDeadline(time.Now().Add(conn.timeout))
+		conn.remote, conn.local, conn.err = readProxyHeader(conn.reader, conn.required)
+		_ = conn.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+func (conn *proxyConn) Read(b []byte) (int, error) {
+	conn.init()
+	if conn.err != nil {
+		return 0, conn.err
+	}
+
+	return conn.reader.Read(b)
+}
+
+func (conn *proxyConn) RemoteAddr() net.Addr {
+	conn.init()
+	if conn.remote != nil {
+		return conn.remote
+	}
+
+	return conn.Conn.RemoteAddr()
+}
+
+func (conn *proxyConn) LocalAddr() net.Addr {
+	conn.init()
+	if conn.local != nil {
+		return conn.local
+	}
+
+	return conn.Conn.LocalAddr()
+}
+
+func readProxyHeader(reader *bufio.Reader, required bool) (net.Addr, net.Addr, error) {
+	peek, err := reader.Peek(len(proxyV1Prefix))
+	if err == nil && bytes.Equal(peek, proxyV1Prefix) {
+		return readProxyV1(reader)
+	}
+
+	peek, err = reader.Peek(len(proxyV2Signature))
+	if err == nil && bytes.Equal(peek, proxyV2Signature) {
+		return readProxyV2(reader)
+	}
+
+	if required {
+		return nil, nil, fmt.Errorf("missing proxy protocol header")
+	}
+
+	return nil, nil, nil
+}
+
+func readProxyV1(reader *bufio.Reader) (net.Addr, net.Addr, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read proxy header: %v", err)
+	}
+
+	if len(line) > 107 || !strings.HasSuffix(line, "\r\n") {
+		return nil, nil, fmt.Errorf("invalid proxy header")
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("invalid proxy header (%s)", strings.TrimSpace(line))
+	}
+
+	source, err := proxyTCPAddr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	destination, err := proxyTCPAddr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return source, destination, nil
+}
+
+func proxyTCPAddr(host string, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid proxy address (%s)", host)
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy port (%s)", port)
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+func readProxyV2(reader *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, nil, fmt.Errorf("unable to read proxy header: %v", err)
+	}
+
+	if header[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("unsupported proxy protocol version (%d)", header[12]>>4)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, nil, fmt.Errorf("unable to read proxy header: %v", err)
+	}
+
+	// LOCAL commands (health checks from the proxy itself) keep the real connection addresses
+	if header[12]&0x0f == 0 {
+		return nil, nil, nil
+	}
+
+	switch header[13] >> 4 {
+	case 1:
+		if len(payload) < 12 {
+			return nil, nil, fmt.Errorf("invalid proxy header length")
+		}
+
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))},
+			&net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}, nil
+	case 2:
+		if len(payload) < 36 {
+			return nil, nil, fmt.Errorf("invalid proxy header length")
+		}
+
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))},
+			&net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}, nil
+	}
+
+	return nil, nil, nil
+}
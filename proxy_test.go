@@ -0,0 +1,178 @@
+package stomper
+
+import (
+	"bufio"
+	"encoding/binary"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientIPOnlyTrustsForwardedHeadersFromProxies(t *testing.T) {
+	server := &Server{Sugar: zap.NewNop().Sugar(), TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1", "bogus"}}
+	server.trustedProxies = server.parseTrustedProxies()
+
+	for _, c := range []struct {
+		name      string
+		remote    string
+		forwarded string
+		realIP    string
+		want      string
+	}{
+		{"untrusted peer", "203.0.113.5:1234", "198.51.100.1", "", "203.0.113.5"},
+		{"trusted peer", "10.0.0.1:1234", "198.51.100.1", "", "198.51.100.1"},
+		{"spoofed left hop", "10.0.0.1:1234", "1.2.3.4, 198.51.100.1", "", "198.51.100.1"},
+		{"trusted hops skipped", "10.0.0.1:1234", "198.51.100.1, 192.168.1.1, 10.0.0.2", "", "198.51.100.1"},
+		{"all hops trusted", "10.0.0.1:1234", "10.0.0.3, 10.0.0.2", "", "10.0.0.3"},
+		{"garbage hop", "10.0.0.1:1234", "nonsense", "", "10.0.0.1"},
+		{"real ip", "192.168.1.1:1234", "", "198.51.100.2", "198.51.100.2"},
+		{"untrusted real ip", "203.0.113.5:1234", "", "198.51.100.2", "203.0.113.5"},
+	} {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.RemoteAddr = c.remote
+		if c.forwarded != "" {
+			request.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+
+		if c.realIP != "" {
+			request.Header.Set("X-Real-IP", c.realIP)
+		}
+
+		if got := server.clientIP(request); got != c.want {
+			t.Errorf("%s: expected %s, got %s", c.name, c.want, got)
+		}
+	}
+}
+
+// proxyV2Header builds a PROXY protocol v2 header for a TCP over IPv4 connection.
+func proxyV2Header(command byte, source string, destination string) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x20|command, 0x11, 0, 12)
+	header = append(header, net.ParseIP(source).To4()...)
+	header = append(header, net.ParseIP(destination).To4()...)
+	header = binary.BigEndian.AppendUint16(header, 5000)
+	return binary.BigEndian.AppendUint16(header, 61613)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	truncated := proxyV2Header(1, "198.51.100.1", "10.0.0.1")
+	truncated[15] = 200
+	for _, c := range []struct {
+		name     string
+		header   string
+		required bool
+		source   string
+		invalid  bool
+	}{
+		{"v1 tcp4", "PROXY TCP4 198.51.100.1 10.0.0.1 5000 61613\r\n", true, "198.51.100.1:5000", false},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 5000 61613\r\n", true, "[2001:db8::1]:5000", false},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", true, "", false},
+		{"v1 bad address", "PROXY TCP4 nonsense 10.0.0.1 5000 61613\r\n", true, "", true},
+		{"v1 bad port", "PROXY TCP4 198.51.100.1 10.0.0.1 70000 61613\r\n", true, "", true},
+		{"v1 missing fields", "PROXY TCP4 198.51.100.1\r\n", true, "", true},
+		{"v1 no crlf", "PROXY TCP4 198.51.100.1 10.0.0.1 5000 61613\n", true, "", true},
+		{"v2 proxy", string(proxyV2Header(1, "198.51.100.1", "10.0.0.1")), true, "198.51.100.1:5000", false},
+		{"v2 local", string(proxyV2Header(0, "198.51.100.1", "10.0.0.1")), true, "", false},
+		{"v2 truncated", string(truncated), true, "", true},
+		{"missing", "CONNECT\n\n\x00", true, "", true},
+		{"optional", "CONNECT\n\n\x00", false, "", false},
+	} {
+		reader := bufio.NewReader(strings.NewReader(c.header + "CONNECT\n\n\x00"))
+		source, _, err := readProxyHeader(reader, c.required)
+		if c.invalid {
+			if err == nil {
+				t.Errorf("%s: expected an error", c.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+
+		got := ""
+		if source != nil {
+			got = source.String()
+		}
+
+		if got != c.source {
+			t.Errorf("%s: expected source %q, got %q", c.name, c.source, got)
+		}
+
+		if c.header != "CONNECT\n\n\x00" {
+			if rest, _ := io.ReadAll(reader); string(rest) != "CONNECT\n\n\x00" {
+				t.Errorf("%s: expected the header consumed, left %q", c.name, rest)
+			}
+		}
+	}
+}
+
+// acceptProxied sends header over a fresh connection to a ProxyProtocolListener and returns what the
+// accepted side sees.
+func acceptProxied(t *testing.T, listener *ProxyProtocolListener, header string) (net.Addr, string, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unable to dial: %v", err)
+	}
+
+	defer conn.Close()
+	if _, err := conn.Write([]byte(header + "CONNECT")); err != nil {
+		t.Fatalf("unable to write: %v", err)
+	}
+
+	accepted, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("unable to accept: %v", err)
+	}
+
+	defer accepted.Close()
+	_ = accepted.SetReadDeadline(time.Now().Add(time.Second))
+	buffer := make([]byte, 128)
+	n, err := accepted.Read(buffer)
+	return accepted.RemoteAddr(), string(buffer[:n]), err
+}
+
+func newProxyListener(t *testing.T, required bool, trusted ...string) *ProxyProtocolListener {
+	t.Helper()
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = inner.Close()
+	})
+
+	return &ProxyProtocolListener{Listener: inner, Required: required, TrustedProxies: trusted}
+}
+
+func TestProxyProtocolListenerOnlyTrustsProxies(t *testing.T) {
+	header := "PROXY TCP4 198.51.100.1 10.0.0.1 5000 61613\r\n"
+
+	remote, data, err := acceptProxied(t, newProxyListener(t, false, "127.0.0.1"), header)
+	if err != nil || data != "CONNECT" || remote.String() != "198.51.100.1:5000" {
+		t.Fatalf("expected the trusted proxy's header honoured, got %s %q %v", remote, data, err)
+	}
+
+	remote, data, err = acceptProxied(t, newProxyListener(t, false, "10.0.0.0/8"), header)
+	if err != nil || data != header+"CONNECT" || !strings.HasPrefix(remote.String(), "127.0.0.1:") {
+		t.Fatalf("expected the untrusted header passed through, got %s %q %v", remote, data, err)
+	}
+
+	_, _, err = acceptProxied(t, newProxyListener(t, true, "10.0.0.0/8"), header)
+	if err == nil {
+		t.Fatal("expected a required header from an untrusted address to be rejected")
+	}
+
+	remote, _, err = acceptProxied(t, newProxyListener(t, false), header)
+	if err != nil || remote.String() != "198.51.100.1:5000" {
+		t.Fatalf("expected every peer trusted without TrustedProxies, got %s %v", remote, err)
+	}
+}
@@ -0,0 +1,73 @@
+package stomper
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const defaultPublishBodyLimit = 1 << 20
+
+// PublishHandler lets backend services broadcast without speaking STOMP: a POST to the prefix followed by a
+// destination, e.g. POST /publish/topic/orders for /topic/orders, broadcasts the request body with its
+// Content-Type. Requests are refused unless authenticate accepts them, and are held to the same destination
+// policies as SENDs and to MaxFrameSize (default 1MiB).
+func (server *Server) PublishHandler(prefix string, authenticate func(*http.Request) error) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		server.init()
+		if request.Method != http.MethodPost {
+			http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if authenticate == nil || authenticate(request) != nil {
+			http.Error(writer, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		destination, ok := strings.CutPrefix(request.URL.Path, prefix)
+		if !ok || strings.Trim(destination, "/") == "" {
+			http.Error(writer, "missing destination", http.StatusNotFound)
+			return
+		}
+
+		destination = "/" + strings.TrimPrefix(destination, "/")
+		if isUserDestination(destination) {
+			http.Error(writer, "user destinations can't be published to", http.StatusBadRequest)
+			return
+		}
+
+		limit := server.MaxFrameSize
+		if limit <= 0 {
+			limit = defaultPublishBodyLimit
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, limit))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(writer, "body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		contentType := request.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		message := &StompMessage{Command: Send, Headers: map[string]string{"destination": destination, "content-type": contentType}, Body: &body}
+		if err = server.checkDestinationPolicy(destination, message); err != nil {
+			http.Error(writer, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		server.Sugar.Debugf("published %d bytes to '%s' over http", len(body), destination)
+		server.SendMessage(destination, contentType, string(body))
+		writer.WriteHeader(http.StatusNoContent)
+	})
+}
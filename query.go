@@ -0,0 +1,124 @@
+package stomper
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// QueryDestinationPrefix marks live query destinations, e.g. `/query/orders?status=open`.
+const QueryDestinationPrefix = "/query/"
+
+const AdvisoryQueryFailed = "query-failed"
+
+// Query is a live query a client subscribed to: the destination after QueryDestinationPrefix, split into a
+// name and the parameters of its query string.
+type Query struct {
+	Name   string
+	Params url.Values
+	Client *Client
+}
+
+// QueryProvider runs live queries against a data source such as a SQL database or Redis. Run pushes the
+// initial results and then each change with publish, and returns once ctx is cancelled, which happens when
+// the client unsubscribes or disconnects.
+type QueryProvider interface {
+	Run(ctx context.Context, query Query, publish func(contentType string, body []byte)) error
+}
+
+type QueryProviderFunc func(ctx context.Context, query Query, publish func(contentType string, body []byte)) error
+
+func (f QueryProviderFunc) Run(ctx context.Context, query Query, publish func(contentType string, body []byte)) error {
+	return f(ctx, query, publish)
+}
+
+// liveQueries tracks the running queries by client uid and subscription id.
+type liveQueries struct {
+	mux     sync.Mutex
+	running map[uint64]map[string]context.CancelFunc
+}
+
+func isQueryDestination(destination string) bool {
+	return strings.HasPrefix(destination, QueryDestinationPrefix)
+}
+
+func parseQuery(destination string) (Query, error) {
+	name, rawQuery, _ := strings.Cut(strings.TrimPrefix(destination, QueryDestinationPrefix), "?")
+	params, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return Query{}, err
+	}
+
+	return Query{Name: name, Params: params}, nil
+}
+
+// startQuery runs the live query for a new subscription to a query destination.
+func (server *Server) startQuery(client *Client, destination string, subId string) {
+	if server.QueryProvider == nil || !isQueryDestination(destination) {
+		return
+	}
+
+	query, err := parseQuery(destination)
+	if err != nil {
+		server.Sugar.Infof("[%d] invalid query '%s': %v", client.Uid, destination, err)
+		server.sendAdvisory(client, destination, subId, AdvisoryQueryFailed, "invalid query")
+		return
+	}
+
+	query.Client = client
+	ctx, cancel := context.WithCancel(context.Background())
+	server.stopQuery(client, subId)
+
+	queries := &server.queries
+	queries.mux.Lock()
+	if queries.running == nil {
+		queries.running = make(map[uint64]map[string]context.CancelFunc)
+	}
+
+	if queries.running[client.Uid] == nil {
+		queries.running[client.Uid] = make(map[string]context.CancelFunc)
+	}
+
+	queries.running[client.Uid][subId] = cancel
+	queries.mux.Unlock()
+
+	go func() {
+		defer cancel()
+		publish := func(contentType string, body []byte) {
+			headers := map[string]string{"subscription": subId}
+			if err := server.SendToClient(client, destination, contentType, body, headers); err != nil {
+				server.Sugar.Debugf("[%d] unable to publish query results: %v", client.Uid, err)
+			}
+		}
+
+		err := server.QueryProvider.Run(ctx, query, publish)
+		if err != nil && ctx.Err() == nil {
+			server.Sugar.Warnf("[%d] query '%s' failed: %v", client.Uid, destination, err)
+			server.sendAdvisory(client, destination, subId, AdvisoryQueryFailed, "query failed")
+		}
+	}()
+}
+
+func (server *Server) stopQuery(client *Client, subId string) {
+	queries := &server.queries
+	queries.mux.Lock()
+	defer queries.mux.Unlock()
+
+	if cancel, ok := queries.running[client.Uid][subId]; ok {
+		cancel()
+		delete(queries.running[client.Uid], subId)
+	}
+}
+
+func (server *Server) stopQueries(client *Client) {
+	queries := &server.queries
+	queries.mux.Lock()
+	defer queries.mux.Unlock()
+
+	for _, cancel := range queries.running[client.Uid] {
+		cancel()
+	}
+
+	delete(queries.running, client.Uid)
+}
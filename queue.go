@@ -0,0 +1,104 @@
+package stomper
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// QueueStrategy picks which subscriber of a queue destination gets each message.
+type QueueStrategy string
+
+const (
+	// QueueRoundRobin takes turns between subscribers
+	QueueRoundRobin QueueStrategy = "round-robin"
+
+	// QueueLeastLoaded picks the subscriber with the fewest frames waiting to be written
+	QueueLeastLoaded QueueStrategy = "least-loaded"
+)
+
+type queueCandidate struct {
+	client   *Client
+	subId    string
+	wildcard bool
+}
+
+// queueCursors holds the turn of each queue destination with subscribers.
+type queueCursors struct {
+	cursors sync.Map
+}
+
+func (server *Server) isQueue(destination string) bool {
+	for _, prefix := range server.QueuePrefixes {
+		if strings.HasPrefix(destination, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// chooseQueueSubscriber picks the one subscription a message to a queue destination is delivered to, out of
+// those the broadcast's check and the Authorizer allow.
+func (server *Server) chooseQueueSubscriber(destination string, check func(client *Client) bool) (queueCandidate, bool) {
+	var candidates []queueCandidate
+	add := func(subs map[uint64]map[string]*Client, wildcard bool) {
+		for _, clientSubs := range subs {
+			for subId, client := range clientSubs {
+				if check != nil && !check(client) {
+					continue
+				}
+
+				if wildcard && server.authorize(client, ActionSubscribe, destination) != nil {
+					continue
+				}
+
+				candidates = append(candidates, queueCandidate{client: client, subId: subId, wildcard: wildcard})
+			}
+		}
+	}
+
+	shard := server.subscriptionShard(destination)
+	shard.mux.RLock()
+	add(shard.topics[destination], false)
+	shard.mux.RUnlock()
+
+	server.patterns.mux.RLock()
+	server.patterns.match(destination, func(subs map[uint64]map[string]*Client) {
+		add(subs, true)
+	})
+	server.patterns.mux.RUnlock()
+
+	if len(candidates) == 0 {
+		// a destination only wildcard subscriptions matched has no last subscriber to forget its cursor
+		server.queues.cursors.Delete(destination)
+		return queueCandidate{}, false
+	}
+
+	// a stable order, so turns are taken fairly however the maps iterate
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].client.Uid != candidates[j].client.Uid {
+			return candidates[i].client.Uid < candidates[j].client.Uid
+		}
+
+		return candidates[i].subId < candidates[j].subId
+	})
+
+	cursor, _ := server.queues.cursors.LoadOrStore(destination, &atomic.Uint64{})
+	turn := int(cursor.(*atomic.Uint64).Add(1) % uint64(len(candidates)))
+	if server.QueueStrategy != QueueLeastLoaded {
+		return candidates[turn], true
+	}
+
+	// least loaded, starting from this turn so ties rotate
+	chosen := candidates[turn]
+	for i := 1; i < len(candidates); i++ {
+		candidate := candidates[(turn+i)%len(candidates)]
+		if len(candidate.client.outbound) < len(chosen.client.outbound) {
+			chosen = candidate
+		}
+	}
+
+	return chosen, true
+}
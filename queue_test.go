@@ -0,0 +1,83 @@
+package stomper
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestQueueRoundRobinTakesTurns(t *testing.T) {
+	server, addr := newTestServer(t, func(server *Server) {
+		server.QueuePrefixes = []string{"/queue/"}
+	})
+
+	var consumers []*testClient
+	for i := 0; i < 3; i++ {
+		c := dialTestClient(t, addr).connect()
+		c.subscribe("jobs", "/queue/jobs")
+		consumers = append(consumers, c)
+	}
+
+	for i := 0; i < 6; i++ {
+		server.SendMessage("/queue/jobs", "text/plain", "job "+strconv.Itoa(i))
+	}
+
+	for i, c := range consumers {
+		for j := 0; j < 2; j++ {
+			if frame := c.read(); frame.Command != Message {
+				t.Fatalf("consumer %d: expected a job, got %s %v", i, frame.Command, frame.Headers)
+			}
+		}
+
+		c.quiet(50 * time.Millisecond)
+	}
+}
+
+func TestQueueLeastLoadedSkipsBusySubscribers(t *testing.T) {
+	server, addr := newTestServer(t, func(server *Server) {
+		server.QueuePrefixes = []string{"/queue/"}
+		server.QueueStrategy = QueueLeastLoaded
+	})
+
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("jobs", "/queue/jobs")
+	client := onlyClient(t, server)
+
+	// a subscriber with frames already waiting, which nothing drains
+	busy := &Client{Uid: client.Uid + 1, Version: "1.2", outbound: make(chan outboundFrame, 16), done: make(chan struct{})}
+	for i := 0; i < 8; i++ {
+		busy.outbound <- outboundFrame{}
+	}
+
+	server.subscribe(busy, "/queue/jobs", "jobs", nil)
+	for i := 0; i < 4; i++ {
+		server.SendMessage("/queue/jobs", "text/plain", "job "+strconv.Itoa(i))
+		if frame := c.read(); frame.Command != Message {
+			t.Fatalf("expected job %d, got %s %v", i, frame.Command, frame.Headers)
+		}
+	}
+
+	if queued := len(busy.outbound); queued != 8 {
+		t.Fatalf("expected nothing more queued for the busy subscriber, got %d", queued)
+	}
+}
+
+func TestQueueCursorsAreForgottenWithTheLastSubscriber(t *testing.T) {
+	server, addr := newTestServer(t, func(server *Server) {
+		server.QueuePrefixes = []string{"/queue/"}
+	})
+
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("jobs", "/queue/jobs")
+	server.SendMessage("/queue/jobs", "text/plain", "job")
+	c.read()
+	if _, ok := server.queues.cursors.Load("/queue/jobs"); !ok {
+		t.Fatal("expected a cursor for the queue")
+	}
+
+	c.send(Unsubscribe, []string{"id:jobs", "receipt:unsub"}, "")
+	c.read()
+	if _, ok := server.queues.cursors.Load("/queue/jobs"); ok {
+		t.Fatal("expected the cursor to be forgotten")
+	}
+}
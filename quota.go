@@ -0,0 +1,185 @@
+package stomper
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultQuotaFlushInterval = time.Minute
+
+const AdvisoryQuotaExceeded = "quota-exceeded"
+
+// Usage is what a principal has consumed: MESSAGE frames and bytes delivered to its clients, and how long
+// they have been connected.
+type Usage struct {
+	Messages       uint64
+	Bytes          uint64
+	ConnectionTime time.Duration
+}
+
+func (usage *Usage) add(other Usage) {
+	usage.Messages += other.Messages
+	usage.Bytes += other.Bytes
+	usage.ConnectionTime += other.ConnectionTime
+}
+
+// QuotaBackend meters usage per principal, e.g. in a shared database for billing. Usage is batched per client
+// and recorded every Server.QuotaFlushInterval and when a client disconnects.
+type QuotaBackend interface {
+	Record(principal string, usage Usage) error
+
+	// Exceeded reports whether the principal is over quota, in which case new subscriptions are refused.
+	Exceeded(principal string) (bool, error)
+}
+
+// InMemoryQuotas is a QuotaBackend for a single server. A zero field in a limit means unlimited.
+type InMemoryQuotas struct {
+	DefaultLimit Usage
+	Limits       map[string]Usage
+
+	mux   sync.Mutex
+	usage map[string]Usage
+}
+
+func (quotas *InMemoryQuotas) Record(principal string, usage Usage) error {
+	quotas.mux.Lock()
+	defer quotas.mux.Unlock()
+	if quotas.usage == nil {
+		quotas.usage = make(map[string]Usage)
+	}
+
+	total := quotas.usage[principal]
+	total.add(usage)
+	quotas.usage[principal] = total
+	return nil
+}
+
+func (quotas *InMemoryQuotas) Exceeded(principal string) (bool, error) {
+	quotas.mux.Lock()
+	defer quotas.mux.Unlock()
+
+	limit, ok := quotas.Limits[principal]
+	if !ok {
+		limit = quotas.DefaultLimit
+	}
+
+	usage := quotas.usage[principal]
+	return (limit.Messages > 0 && usage.Messages >= limit.Messages) ||
+		(limit.Bytes > 0 && usage.Bytes >= limit.Bytes) ||
+		(limit.ConnectionTime > 0 && usage.ConnectionTime >= limit.ConnectionTime), nil
+}
+
+// Usage returns what has been recorded for a principal so far.
+func (quotas *InMemoryQuotas) Usage(principal string) Usage {
+	quotas.mux.Lock()
+	defer quotas.mux.Unlock()
+	return quotas.usage[principal]
+}
+
+// Reset forgets all recorded usage, e.g. at the start of a billing period.
+func (quotas *InMemoryQuotas) Reset() {
+	quotas.mux.Lock()
+	defer quotas.mux.Unlock()
+	quotas.usage = nil
+}
+
+// clientUsage accumulates a client's usage between flushes to the quota backend.
+type clientUsage struct {
+	messages atomic.Uint64
+	bytes    atomic.Uint64
+	since    atomic.Int64
+}
+
+func (usage *clientUsage) take(now time.Time) Usage {
+	since := usage.since.Swap(now.UnixNano())
+	return Usage{
+		Messages:       usage.messages.Swap(0),
+		Bytes:          usage.bytes.Swap(0),
+		ConnectionTime: time.Duration(now.UnixNano() - since),
+	}
+}
+
+// principal identifies the user a client acts for, for quotas and user destinations: Server.Principal, or
+// else the authenticated identity. It's empty for anonymous clients; the CONNECT login isn't trusted, as
+// nothing has verified it.
+func (server *Server) principal(client *Client) string {
+	if server.Principal != nil {
+		return server.Principal(client)
+	}
+
+	if client.Identity != nil {
+		return client.Identity.Principal
+	}
+
+	return ""
+}
+
+// quotaKey is who a client's usage is metered against: its principal, or for an anonymous client its
+// address, so changing the CONNECT login or reconnecting doesn't start a fresh quota.
+func (server *Server) quotaKey(client *Client) string {
+	if principal := server.principal(client); principal != "" {
+		return principal
+	}
+
+	return "anonymous@" + client.RemoteAddr
+}
+
+// flushUsage records a client's usage since the last flush.
+func (server *Server) flushUsage(client *Client) {
+	if server.Quotas == nil {
+		return
+	}
+
+	usage := client.usage.take(server.clock().Now())
+	principal := server.quotaKey(client)
+	if err := server.Quotas.Record(principal, usage); err != nil {
+		server.Sugar.Warnf("[%d] unable to record usage for '%s': %v", client.Uid, principal, err)
+	}
+}
+
+func (server *Server) usageLoop() {
+	interval := server.QuotaFlushInterval
+	if interval <= 0 {
+		interval = defaultQuotaFlushInterval
+	}
+
+	ticker := server.clock().NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C() {
+		server.clientMux.RLock()
+		clients := make([]*Client, 0, len(server.clients))
+		for _, client := range server.clients {
+			clients = append(clients, client)
+		}
+		server.clientMux.RUnlock()
+
+		for _, client := range clients {
+			server.flushUsage(client)
+		}
+	}
+}
+
+// checkQuota returns an error if the client's principal (or address) is over quota. Backend errors are
+// logged and the client is let through.
+func (server *Server) checkQuota(client *Client) error {
+	if server.Quotas == nil {
+		return nil
+	}
+
+	principal := server.quotaKey(client)
+
+	exceeded, err := server.Quotas.Exceeded(principal)
+	if err != nil {
+		server.Sugar.Warnf("[%d] unable to check quota for '%s': %v", client.Uid, principal, err)
+		return nil
+	}
+
+	if exceeded {
+		return fmt.Errorf("'%s' is over quota: %w", principal, ErrQuotaExceeded)
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package stomper
+
+import "testing"
+
+func TestQuotaKeyIgnoresLogin(t *testing.T) {
+	server := &Server{}
+	alice := &Client{RemoteAddr: "10.0.0.1", Headers: map[string]string{"login": "alice"}}
+	bob := &Client{RemoteAddr: "10.0.0.1", Headers: map[string]string{"login": "bob"}}
+	if server.quotaKey(alice) != server.quotaKey(bob) {
+		t.Fatalf("anonymous clients at one address got different keys: %s, %s", server.quotaKey(alice), server.quotaKey(bob))
+	}
+
+	identified := &Client{RemoteAddr: "10.0.0.1", Identity: &Identity{Principal: "alice"}}
+	if key := server.quotaKey(identified); key != "alice" {
+		t.Fatalf("expected the principal, got %s", key)
+	}
+}
+
+func TestSendQuotaCountsAnonymousClientsByAddress(t *testing.T) {
+	_, addr := newTestServer(t, func(server *Server) {
+		server.SendQuota = &SendQuota{Hourly: SendLimit{Frames: 1}}
+		server.ErrorPolicy = ErrorPolicyContinue
+	})
+
+	first := dialTestClient(t, addr).connect("login:one")
+	first.send("SEND", []string{"destination:/topic/a", "receipt:1"}, "x")
+	if frame := first.read(); frame.Command != Receipt {
+		t.Fatalf("expected RECEIPT, got %s %v", frame.Command, frame.Headers)
+	}
+
+	second := dialTestClient(t, addr).connect("login:two")
+	second.send("SEND", []string{"destination:/topic/a", "receipt:2"}, "x")
+	if frame := second.read(); frame.Command != Error || frame.Headers["error-code"] != ErrorCodeQuotaExceeded {
+		t.Fatalf("expected a quota-exceeded ERROR, got %s %v", frame.Command, frame.Headers)
+	}
+}
@@ -0,0 +1,154 @@
+package stomper
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// UpgradeHandler runs before the websocket upgrade and can refuse it, e.g. to verify a proof-of-work or
+// CAPTCHA token header. Returning false rejects the request with 403.
+type UpgradeHandler func(*http.Request) bool
+
+type ConnectRateLimit struct {
+	// Attempts is how many connection attempts a single IP may make per Window.
+	Attempts int
+	Window   time.Duration
+
+	// BanAfter bans an IP once it has exceeded the limit in this many windows. Zero never bans.
+	BanAfter    int
+	BanDuration time.Duration
+}
+
+type connectAttempts struct {
+	windowStart time.Time
+	count       int
+	violations  int
+	bannedUntil time.Time
+}
+
+type connectLimiter struct {
+	config    ConnectRateLimit
+	mux       sync.Mutex
+	attempts  map[string]*connectAttempts
+	lastSweep time.Time
+}
+
+func newConnectLimiter(config ConnectRateLimit) *connectLimiter {
+	if config.Attempts <= 0 {
+		config.Attempts = 10
+	}
+
+	if config.Window <= 0 {
+		config.Window = time.Minute
+	}
+
+	if config.BanDuration <= 0 {
+		config.BanDuration = 10 * time.Minute
+	}
+
+	return &connectLimiter{config: config, attempts: make(map[string]*connectAttempts)}
+}
+
+// allow records a connection attempt from ip, reporting whether it may proceed and whether the ip is banned.
+func (limiter *connectLimiter) allow(ip string, now time.Time) (bool, bool) {
+	limiter.mux.Lock()
+	defer limiter.mux.Unlock()
+
+	limiter.sweep(now)
+
+	entry, ok := limiter.attempts[ip]
+	if !ok {
+		entry = &connectAttempts{windowStart: now}
+		limiter.attempts[ip] = entry
+	}
+
+	if now.Before(entry.bannedUntil) {
+		return false, true
+	}
+
+	if now.Sub(entry.windowStart) >= limiter.config.Window {
+		entry.windowStart = now
+		entry.count = 0
+	}
+
+	entry.count++
+	if entry.count <= limiter.config.Attempts {
+		return true, false
+	}
+
+	// only count the first rejected attempt of each window as a violation
+	if entry.count == limiter.config.Attempts+1 {
+		entry.violations++
+		if limiter.config.BanAfter > 0 && entry.violations >= limiter.config.BanAfter {
+			entry.bannedUntil = now.Add(limiter.config.BanDuration)
+			entry.violations = 0
+			return false, true
+		}
+	}
+
+	return false, false
+}
+
+// sweep drops idle entries at most once per window so the map doesn't grow with every IP ever seen.
+func (limiter *connectLimiter) sweep(now time.Time) {
+	if now.Sub(limiter.lastSweep) < limiter.config.Window {
+		return
+	}
+
+	limiter.lastSweep = now
+	for ip, entry := range limiter.attempts {
+		idle := now.Sub(entry.windowStart) >= limiter.config.Window*time.Duration(limiter.config.BanAfter+1)
+		if idle && !now.Before(entry.bannedUntil) {
+			delete(limiter.attempts, ip)
+		}
+	}
+}
+
+func (server *Server) AddUpgradeHandler(handler UpgradeHandler) error {
+	if server.setup {
+		return fmt.Errorf("unable to add upgrade handler after %w", ErrAlreadySetup)
+	}
+
+	server.upgradeHandlers = append(server.upgradeHandlers, handler)
+	return nil
+}
+
+// admit applies connect rate limiting and upgrade handlers, writing an HTTP error if the request is refused.
+func (server *Server) admit(writer http.ResponseWriter, request *http.Request, ip string) bool {
+	if status, reason := server.refuse(request, ip); status != 0 {
+		http.Error(writer, reason, status)
+		return false
+	}
+
+	return true
+}
+
+// refuse returns the HTTP status and reason a connection is refused with, or zero when it's admitted.
+func (server *Server) refuse(request *http.Request, ip string) (int, string) {
+	server.reloadMux.RLock()
+	limiter := server.connectLimiter
+	server.reloadMux.RUnlock()
+
+	if limiter != nil {
+		allowed, banned := limiter.allow(ip, server.clock().Now())
+		if !allowed {
+			if banned {
+				server.Sugar.Warnf("rejected connection from banned ip %s", ip)
+			} else {
+				server.Sugar.Debugf("rate limited connection from %s", ip)
+			}
+
+			return http.StatusTooManyRequests, "too many connection attempts"
+		}
+	}
+
+	for _, handler := range server.upgradeHandlers {
+		if !handler(request) {
+			return http.StatusForbidden, "forbidden"
+		}
+	}
+
+	return 0, ""
+}
@@ -0,0 +1,122 @@
+package stomper_test
+
+import (
+	"bufio"
+	"github.com/gorilla/websocket"
+	"github.com/hfoxy/stomper"
+	"github.com/hfoxy/stomper/stompertest"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tcpConnects reports whether a fresh TCP connection to addr is answered with CONNECTED.
+func tcpConnects(t *testing.T, addr string) bool {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+	_, _ = conn.Write([]byte("CONNECT\naccept-version:1.2\n\n\x00"))
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	connected, err := bufio.NewReader(conn).ReadString(0)
+	return err == nil && strings.HasPrefix(connected, "CONNECTED\n")
+}
+
+func TestConnectRateLimitThrottlesAndBansTCPClients(t *testing.T) {
+	clock := stompertest.NewFakeClock(epoch)
+	server := &stomper.Server{
+		Sugar: zap.NewNop().Sugar(),
+		Clock: clock,
+		ConnectRateLimit: &stomper.ConnectRateLimit{
+			Attempts:    2,
+			Window:      time.Minute,
+			BanAfter:    2,
+			BanDuration: 10 * time.Minute,
+		},
+	}
+
+	server.Setup()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go server.ServeTCP(listener)
+	addr := listener.Addr().String()
+
+	for window := 1; window <= 2; window++ {
+		for attempt := 1; attempt <= 2; attempt++ {
+			if !tcpConnects(t, addr) {
+				t.Fatalf("expected attempt %d in window %d to connect", attempt, window)
+			}
+		}
+
+		if tcpConnects(t, addr) {
+			t.Fatalf("expected the third attempt in window %d to be refused", window)
+		}
+
+		clock.Advance(time.Minute)
+	}
+
+	// the second window over the limit banned the ip
+	if tcpConnects(t, addr) {
+		t.Fatal("expected the banned ip to be refused in a fresh window")
+	}
+
+	clock.Advance(10 * time.Minute)
+	if !tcpConnects(t, addr) {
+		t.Fatal("expected the ban to have lifted")
+	}
+}
+
+func TestConnectRateLimitAndUpgradeHandlersRefuseWebsockets(t *testing.T) {
+	server := &stomper.Server{
+		Sugar:            zap.NewNop().Sugar(),
+		ConnectRateLimit: &stomper.ConnectRateLimit{Attempts: 2, Window: time.Hour},
+	}
+
+	_ = server.AddUpgradeHandler(func(request *http.Request) bool {
+		return request.Header.Get("X-Proof") == "solved"
+	})
+
+	server.Setup()
+	httpServer := httptest.NewServer(http.HandlerFunc(server.WssHandler))
+	t.Cleanup(httpServer.Close)
+
+	upgrade := func(proof string) int {
+		dialer := websocket.Dialer{Subprotocols: []string{"v12.stomp"}}
+		conn, response, err := dialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http"), http.Header{"X-Proof": {proof}})
+		if err == nil {
+			_ = conn.Close()
+		}
+
+		if response == nil {
+			t.Fatalf("expected a response: %v", err)
+		}
+
+		return response.StatusCode
+	}
+
+	if status := upgrade("guessed"); status != http.StatusForbidden {
+		t.Fatalf("expected an upgrade without proof to be forbidden, got %d", status)
+	}
+
+	if status := upgrade("solved"); status != http.StatusSwitchingProtocols {
+		t.Fatalf("expected an upgrade with proof to succeed, got %d", status)
+	}
+
+	if status := upgrade("solved"); status != http.StatusTooManyRequests {
+		t.Fatalf("expected a third attempt to be rate limited, got %d", status)
+	}
+}
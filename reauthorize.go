@@ -0,0 +1,98 @@
+package stomper
+
+import (
+	"context"
+	"time"
+)
+
+type activeSubscription struct {
+	client *Client
+	topic  string
+	subId  string
+}
+
+func (server *Server) activeSubscriptions() []activeSubscription {
+	var active []activeSubscription
+	for _, shard := range server.subscriptions {
+		shard.mux.RLock()
+		for topic, subs := range shard.topics {
+			for _, clientSubs := range subs {
+				for subId, client := range clientSubs {
+					active = append(active, activeSubscription{client: client, topic: topic, subId: subId})
+				}
+			}
+		}
+		shard.mux.RUnlock()
+	}
+
+	server.patterns.mux.RLock()
+	server.patterns.each(func(pattern string, subs map[uint64]map[string]*Client) {
+		for _, clientSubs := range subs {
+			for subId, client := range clientSubs {
+				active = append(active, activeSubscription{client: client, topic: pattern, subId: subId})
+			}
+		}
+	})
+	server.patterns.mux.RUnlock()
+
+	return active
+}
+
+// authorizeSubscription decides whether a client may subscribe to a destination, applying the server profile
+// before the subscribe handlers.
+func (server *Server) authorizeSubscription(ctx context.Context, client *Client, destination string) bool {
+	if server.isTimeDestination(destination) {
+		return true
+	}
+
+	if server.Profile == ProfilePushOnly {
+		return server.isPushDestination(destination)
+	}
+
+	handlers := server.traceHandlers(ctx, "subscribe", client, destination)
+	for _, handler := range server.subscribeHandlers {
+		if !handler(client, destination) {
+			handlers.End(ErrUnauthorized)
+			return false
+		}
+	}
+
+	handlers.End(nil)
+	return true
+}
+
+// Reauthorize re-runs the subscribe handlers for every active subscription, unsubscribing clients which are
+// no longer allowed and notifying them with a subscription-revoked advisory. It returns how many were revoked.
+// Call it when permissions change, or set ReauthorizeInterval to run it periodically.
+func (server *Server) Reauthorize() int {
+	server.init()
+	revoked := 0
+	for _, sub := range server.activeSubscriptions() {
+		if server.authorizeSubscription(context.Background(), sub.client, sub.topic) {
+			continue
+		}
+
+		if !server.endSubscription(sub.client, sub.topic, sub.subId) {
+			continue
+		}
+
+		revoked++
+		server.Sugar.Infof("[%d] subscription to '%s' (%s) revoked", sub.client.Uid, sub.topic, sub.subId)
+		for _, handler := range server.unsubscribeHandlers {
+			handler(sub.client, sub.topic)
+		}
+
+		server.sendAdvisory(sub.client, sub.topic, sub.subId, AdvisorySubscriptionRevoked, "subscription revoked")
+	}
+
+	return revoked
+}
+
+func (server *Server) reauthorizeLoop(interval time.Duration) {
+	ticker := server.clock().NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C() {
+		server.Reauthorize()
+	}
+}
@@ -0,0 +1,84 @@
+package stomper
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// liveQuery is a QueryProvider pushing each row sent on rows, closing cancelled once its query is stopped.
+type liveQuery struct {
+	rows      chan string
+	cancelled chan struct{}
+}
+
+func newLiveQuery() *liveQuery {
+	return &liveQuery{rows: make(chan string), cancelled: make(chan struct{})}
+}
+
+func (query *liveQuery) Run(ctx context.Context, _ Query, publish func(contentType string, body []byte)) error {
+	for {
+		select {
+		case row := <-query.rows:
+			publish("text/plain", []byte(row))
+		case <-ctx.Done():
+			close(query.cancelled)
+			return nil
+		}
+	}
+}
+
+func (query *liveQuery) stopped(t *testing.T) {
+	t.Helper()
+	select {
+	case <-query.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the live query to be stopped")
+	}
+}
+
+// subscribeQuery subscribes to a live query and checks its first row arrives.
+func subscribeQuery(t *testing.T, c *testClient, query *liveQuery) {
+	t.Helper()
+	c.subscribe("q", "/query/orders?status=open")
+	query.rows <- "first"
+	if frame := c.read(); frame.Command != Message || string(*frame.Body) != "first" {
+		t.Fatalf("expected the first row, got %s %v", frame.Command, frame.Headers)
+	}
+}
+
+// expectAdvisory reads the next frame, checking it's the advisory ending subscription subId.
+func expectAdvisory(t *testing.T, c *testClient, subId string, advisory string) *StompMessage {
+	t.Helper()
+	frame := c.read()
+	if frame.Command != Message || frame.Headers[AdvisoryHeader] != advisory || frame.Headers["subscription"] != subId {
+		t.Fatalf("expected a %s advisory for %s, got %s %v", advisory, subId, frame.Command, frame.Headers)
+	}
+
+	return frame
+}
+
+func TestRevokedQuerySubscriptionsStop(t *testing.T) {
+	var allowed atomic.Bool
+	allowed.Store(true)
+	query := newLiveQuery()
+	server, addr := newTestServer(t, func(server *Server) {
+		server.QueryProvider = query
+		_ = server.AddSubscribeHandler(func(*Client, string) bool {
+			return allowed.Load()
+		})
+	})
+
+	c := dialTestClient(t, addr).connect()
+	subscribeQuery(t, c, query)
+
+	allowed.Store(false)
+	if revoked := server.Reauthorize(); revoked != 1 {
+		t.Fatalf("expected one subscription revoked, got %d", revoked)
+	}
+
+	expectAdvisory(t, c, "q", AdvisorySubscriptionRevoked)
+	query.stopped(t)
+	c.quiet(50 * time.Millisecond)
+}
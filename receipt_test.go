@@ -0,0 +1,77 @@
+package stomper
+
+import (
+	"testing"
+	"time"
+)
+
+// expectReceipt fails the test unless the next frame is a RECEIPT for id.
+func expectReceipt(t *testing.T, c *testClient, id string) {
+	t.Helper()
+	if frame := c.read(); frame.Command != Receipt || frame.Headers["receipt-id"] != id {
+		t.Fatalf("expected RECEIPT %s, got %s %v", id, frame.Command, frame.Headers)
+	}
+}
+
+func TestFramesWithReceiptHeadersAreAcknowledged(t *testing.T) {
+	_, addr := newTestServer(t, func(server *Server) {
+		_ = server.EnableSimpleBroker("/topic/")
+	})
+
+	c := dialTestClient(t, addr).connect()
+	c.send(Subscribe, []string{"id:0", "destination:/topic/a", "ack:client", "receipt:subscribed"}, "")
+	expectReceipt(t, c, "subscribed")
+
+	c.send(Send, []string{"destination:/topic/a", "receipt:sent"}, "hello")
+	var message *StompMessage
+	for i := 0; i < 2; i++ {
+		frame := c.read()
+		switch frame.Command {
+		case Receipt:
+			if frame.Headers["receipt-id"] != "sent" {
+				t.Fatalf("expected RECEIPT sent, got %v", frame.Headers)
+			}
+		case Message:
+			message = frame
+		default:
+			t.Fatalf("expected a RECEIPT and a MESSAGE, got %s %v", frame.Command, frame.Headers)
+		}
+	}
+
+	if message == nil {
+		t.Fatal("expected the message relayed")
+	}
+
+	c.send(Ack, []string{"id:" + message.Headers[AckHeader], "receipt:acked"}, "")
+	expectReceipt(t, c, "acked")
+
+	c.send(Unsubscribe, []string{"id:0", "receipt:unsubscribed"}, "")
+	expectReceipt(t, c, "unsubscribed")
+
+	c.send(Send, []string{"destination:/topic/a"}, "unacknowledged")
+	c.quiet(100 * time.Millisecond)
+}
+
+func TestDisconnectReceiptIsFlushedBeforeClosing(t *testing.T) {
+	_, addr := newTestServer(t, nil)
+	c := dialTestClient(t, addr).connect()
+	c.send(Disconnect, []string{"receipt:bye"}, "")
+	expectReceipt(t, c, "bye")
+	c.closed()
+}
+
+func TestRefusedFramesGetNoReceipt(t *testing.T) {
+	_, addr := newTestServer(t, func(server *Server) {
+		server.Authorizer = AuthorizerFunc(func(client *Client, action string, destination string) bool {
+			return destination != "/topic/secret"
+		})
+	})
+
+	c := dialTestClient(t, addr).connect()
+	c.send(Send, []string{"destination:/topic/secret", "receipt:sent"}, "x")
+	if frame := c.read(); frame.Command != Error {
+		t.Fatalf("expected an ERROR for the refused send, got %s %v", frame.Command, frame.Headers)
+	}
+
+	c.closed()
+}
@@ -0,0 +1,110 @@
+package stomper
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	DirectionInbound  = "in"
+	DirectionOutbound = "out"
+)
+
+// RecordedFrame is one line of a session recording, as written by FileRecorder and read by stomper-replay.
+type RecordedFrame struct {
+	Time      time.Time `json:"time"`
+	Session   uint64    `json:"session"`
+	Direction string    `json:"dir"`
+	Payload   string    `json:"payload"`
+}
+
+// SessionRecorder captures every raw frame (including heart-beats) a client sends and receives.
+type SessionRecorder interface {
+	Record(client *Client, direction string, payload []byte)
+	Close(client *Client)
+}
+
+// FileRecorder writes each session to its own JSON lines file, named session-<uid>-<unix time>.jsonl, in Dir.
+type FileRecorder struct {
+	Dir string
+
+	// Clock stamps frames; Setup gives it the server's, and otherwise nil uses SystemClock
+	Clock Clock
+
+	mux   sync.Mutex
+	files map[uint64]*json.Encoder
+	raw   map[uint64]*os.File
+}
+
+func NewFileRecorder(dir string) (*FileRecorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create recording directory: %v", err)
+	}
+
+	return &FileRecorder{Dir: dir, files: make(map[uint64]*json.Encoder), raw: make(map[uint64]*os.File)}, nil
+}
+
+func (recorder *FileRecorder) clock() Clock {
+	if recorder.Clock == nil {
+		return SystemClock
+	}
+
+	return recorder.Clock
+}
+
+func (recorder *FileRecorder) useClock(clock Clock) {
+	if recorder.Clock == nil {
+		recorder.Clock = clock
+	}
+}
+
+func (recorder *FileRecorder) Record(client *Client, direction string, payload []byte) {
+	recorder.mux.Lock()
+	defer recorder.mux.Unlock()
+
+	encoder, ok := recorder.files[client.Uid]
+	if !ok {
+		name := fmt.Sprintf("session-%d-%d.jsonl", client.Uid, recorder.clock().Now().Unix())
+		file, err := os.Create(filepath.Join(recorder.Dir, name))
+		if err != nil {
+			return
+		}
+
+		encoder = json.NewEncoder(file)
+		recorder.files[client.Uid] = encoder
+		recorder.raw[client.Uid] = file
+	}
+
+	_ = encoder.Encode(RecordedFrame{
+		Time:      recorder.clock().Now(),
+		Session:   client.Uid,
+		Direction: direction,
+		Payload:   string(payload),
+	})
+}
+
+func (recorder *FileRecorder) Close(client *Client) {
+	recorder.mux.Lock()
+	defer recorder.mux.Unlock()
+
+	if file, ok := recorder.raw[client.Uid]; ok {
+		_ = file.Close()
+	}
+
+	delete(recorder.files, client.Uid)
+	delete(recorder.raw, client.Uid)
+}
+
+func (server *Server) record(client *Client, direction string, payload []byte) {
+	if server.Recorder != nil {
+		server.Recorder.Record(client, direction, payload)
+	}
+
+	if server.DevConsole != nil {
+		server.DevConsole.Record(client, direction, payload)
+	}
+}
@@ -0,0 +1,166 @@
+// Package redis forwards client SENDs from a stomper server to Redis, as PUBLISHes to a channel or entries
+// in a stream.
+package redis
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/hfoxy/stomper"
+	goredis "github.com/redis/go-redis/v9"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const commandTimeout = 5 * time.Second
+
+// Identity fields, set on every forwarded message to say who sent it.
+const (
+	PrincipalField  = "principal"
+	SessionField    = "session"
+	RemoteAddrField = "remote-addr"
+)
+
+// Forwarder publishes client SENDs to Redis, for backend consumers to act on. Register Forward as a message
+// handler, e.g. with Server.HandleDestination for the destinations backends listen to.
+type Forwarder struct {
+	Server *stomper.Server
+
+	// URL is the Redis server, e.g. redis://localhost:6379, redis://:secret@host/2 or rediss://host
+	URL       string
+	TLSConfig *tls.Config
+
+	// Stream, when set, has each message added to it with XADD, as destination, content-type, body and
+	// identity fields, trimmed to about MaxLen entries when that's set
+	Stream string
+	MaxLen int
+
+	// Channel, when Stream isn't set, is PUBLISHed a JSON envelope of each message. Without a channel, the
+	// channel is the destination mapped back through Mapping, or with /topic/ stripped.
+	Channel string
+	Mapping stomper.DestinationMapper
+
+	mux    sync.Mutex
+	client *goredis.Client
+}
+
+// envelope is what's PUBLISHed to a channel.
+type envelope struct {
+	Destination string            `json:"destination"`
+	ContentType string            `json:"contentType,omitempty"`
+	Headers     map[string]string `json:"headers"`
+	Body        string            `json:"body"`
+}
+
+// Forward is a stomper.MessageHandler forwarding SENDs to Redis. Failures are logged and the message
+// dropped.
+func (forwarder *Forwarder) Forward(client *stomper.Client, destination string, message *stomper.StompMessage) {
+	var body string
+	if message.Body != nil {
+		body = string(*message.Body)
+	}
+
+	identity := map[string]string{
+		SessionField:    strconv.FormatUint(client.Uid, 10),
+		RemoteAddrField: client.RemoteAddr,
+	}
+
+	if client.Identity != nil {
+		identity[PrincipalField] = client.Identity.Principal
+	}
+
+	conn, err := forwarder.connect()
+	if err != nil {
+		forwarder.Server.Sugar.Warnf("[%d] redis: unable to forward message to '%s': %v", client.Uid, destination, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	if forwarder.Stream != "" {
+		values := []any{"destination", destination, "content-type", message.Headers["content-type"], "body", body}
+		for name, value := range identity {
+			values = append(values, name, value)
+		}
+
+		args := &goredis.XAddArgs{Stream: forwarder.Stream, Values: values}
+		if forwarder.MaxLen > 0 {
+			args.MaxLen, args.Approx = int64(forwarder.MaxLen), true
+		}
+
+		err = conn.XAdd(ctx, args).Err()
+	} else {
+		channel, ok := forwarder.channel(destination)
+		if !ok {
+			return
+		}
+
+		payload, _ := json.Marshal(envelope{
+			Destination: destination,
+			ContentType: message.Headers["content-type"],
+			Headers:     identity,
+			Body:        body,
+		})
+
+		err = conn.Publish(ctx, channel, payload).Err()
+	}
+
+	if err != nil {
+		forwarder.Server.Sugar.Warnf("[%d] redis: unable to forward message to '%s': %v", client.Uid, destination, err)
+	}
+}
+
+func (forwarder *Forwarder) channel(destination string) (string, bool) {
+	if forwarder.Channel != "" {
+		return forwarder.Channel, true
+	}
+
+	if forwarder.Mapping != nil {
+		return forwarder.Mapping.Reverse(destination)
+	}
+
+	channel, ok := strings.CutPrefix(destination, "/topic/")
+	return channel, ok && channel != ""
+}
+
+// connect returns the Redis client, creating it on first use.
+func (forwarder *Forwarder) connect() (*goredis.Client, error) {
+	forwarder.mux.Lock()
+	defer forwarder.mux.Unlock()
+	if forwarder.client != nil {
+		return forwarder.client, nil
+	}
+
+	rawURL := forwarder.URL
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "redis://" + rawURL
+	}
+
+	options, err := goredis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+
+	if forwarder.TLSConfig != nil {
+		options.TLSConfig = forwarder.TLSConfig
+	}
+
+	forwarder.client = goredis.NewClient(options)
+	return forwarder.client, nil
+}
+
+// Close closes the connections to Redis; a later Forward reconnects.
+func (forwarder *Forwarder) Close() error {
+	forwarder.mux.Lock()
+	defer forwarder.mux.Unlock()
+	if forwarder.client == nil {
+		return nil
+	}
+
+	err := forwarder.client.Close()
+	forwarder.client = nil
+	return err
+}
@@ -0,0 +1,94 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hfoxy/stomper"
+	goredis "github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func testMessage(body string) *stomper.StompMessage {
+	bytes := []byte(body)
+	return &stomper.StompMessage{Command: stomper.Send, Headers: map[string]string{"content-type": "text/plain"}, Body: &bytes}
+}
+
+func TestForwardToStream(t *testing.T) {
+	redis := miniredis.RunT(t)
+	forwarder := &Forwarder{Server: &stomper.Server{Sugar: zap.NewNop().Sugar()}, URL: redis.Addr(), Stream: "sends", MaxLen: 100}
+	defer forwarder.Close()
+
+	client := &stomper.Client{Uid: 7, RemoteAddr: "10.0.0.1", Identity: &stomper.Identity{Principal: "alice"}}
+	forwarder.Forward(client, "/app/orders", testMessage("hello"))
+
+	entries, err := redis.Stream("sends")
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected one stream entry, got %v (%v)", entries, err)
+	}
+
+	fields := make(map[string]string)
+	for i := 0; i+1 < len(entries[0].Values); i += 2 {
+		fields[entries[0].Values[i]] = entries[0].Values[i+1]
+	}
+
+	want := map[string]string{
+		"destination":   "/app/orders",
+		"content-type":  "text/plain",
+		"body":          "hello",
+		PrincipalField:  "alice",
+		SessionField:    "7",
+		RemoteAddrField: "10.0.0.1",
+	}
+
+	for name, value := range want {
+		if fields[name] != value {
+			t.Errorf("expected %s=%s, got %q", name, value, fields[name])
+		}
+	}
+}
+
+func TestForwardToChannel(t *testing.T) {
+	redis := miniredis.RunT(t)
+	subscriber := goredis.NewClient(&goredis.Options{Addr: redis.Addr()})
+	defer subscriber.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	pubsub := subscriber.Subscribe(ctx, "orders")
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		t.Fatalf("unable to subscribe: %v", err)
+	}
+
+	forwarder := &Forwarder{Server: &stomper.Server{Sugar: zap.NewNop().Sugar()}, URL: "redis://" + redis.Addr()}
+	defer forwarder.Close()
+	forwarder.Forward(&stomper.Client{Uid: 1}, "/topic/orders", testMessage("hello"))
+
+	// not under /topic/, so there's no channel for it
+	forwarder.Forward(&stomper.Client{Uid: 1}, "/queue/orders", testMessage("dropped"))
+
+	message, err := pubsub.ReceiveMessage(ctx)
+	if err != nil {
+		t.Fatalf("expected a message: %v", err)
+	}
+
+	var received envelope
+	if err = json.Unmarshal([]byte(message.Payload), &received); err != nil {
+		t.Fatalf("invalid envelope: %v", err)
+	}
+
+	if received.Destination != "/topic/orders" || received.Body != "hello" || received.Headers[SessionField] != "1" {
+		t.Fatalf("unexpected envelope %+v", received)
+	}
+}
+
+func TestForwardWithoutRedis(t *testing.T) {
+	forwarder := &Forwarder{Server: &stomper.Server{Sugar: zap.NewNop().Sugar()}, URL: "127.0.0.1:1", Stream: "sends"}
+	defer forwarder.Close()
+
+	// failures are logged, not returned or panicked on
+	forwarder.Forward(&stomper.Client{Uid: 1}, "/app/orders", testMessage("hello"))
+}
@@ -0,0 +1,55 @@
+package stomper
+
+import (
+	"fmt"
+)
+
+// Reloadable is the part of the configuration Reload can change while the server is running. Everything else
+// is read once by Setup and needs a restart to change.
+type Reloadable struct {
+	AllowedOrigins      []string
+	ConnectRateLimit    *ConnectRateLimit
+	DestinationPolicies []DestinationPolicy
+	Authorizer          Authorizer
+}
+
+// Reload applies new settings without dropping connections: upgrades, SENDs and SUBSCRIBEs from then on use
+// them, while connected clients keep their existing subscriptions. Invalid settings are rejected as a whole.
+// Connection attempts already counted against the rate limit carry over.
+func (server *Server) Reload(settings Reloadable) error {
+	if !server.setup {
+		return fmt.Errorf("unable to reload: %w", ErrNotSetup)
+	}
+
+	policies := make([]destinationPolicy, 0, len(settings.DestinationPolicies))
+	for _, policy := range settings.DestinationPolicies {
+		template, err := ParseDestinationTemplate(policy.Destination)
+		if err != nil {
+			return fmt.Errorf("invalid destination policy (%s): %w", policy.Destination, err)
+		}
+
+		policies = append(policies, destinationPolicy{DestinationPolicy: policy, template: template})
+	}
+
+	var limiter *connectLimiter
+	if settings.ConnectRateLimit != nil {
+		limiter = newConnectLimiter(*settings.ConnectRateLimit)
+	}
+
+	server.reloadMux.Lock()
+	defer server.reloadMux.Unlock()
+
+	if limiter != nil && server.connectLimiter != nil {
+		server.connectLimiter.mux.Lock()
+		limiter.attempts = server.connectLimiter.attempts
+		server.connectLimiter.mux.Unlock()
+	}
+
+	server.AllowedOrigins = settings.AllowedOrigins
+	server.ConnectRateLimit = settings.ConnectRateLimit
+	server.connectLimiter = limiter
+	server.DestinationPolicies = settings.DestinationPolicies
+	server.destinationPolicies = policies
+	server.Authorizer = settings.Authorizer
+	return nil
+}
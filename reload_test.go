@@ -0,0 +1,110 @@
+package stomper
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReloadAppliesToConnectedClients(t *testing.T) {
+	server, addr := newTestServer(t, func(server *Server) {
+		server.ErrorPolicy = ErrorPolicyContinue
+	})
+
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("0", "/topic/admin.users")
+
+	acl, err := NewACL(ACLRule{Destination: "/topic/admin.{rest*}", Roles: []string{"admin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = server.Reload(Reloadable{
+		Authorizer:          acl,
+		DestinationPolicies: []DestinationPolicy{{Destination: "/queue/{name}", MaxBodySize: 4}},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.send("SUBSCRIBE", []string{"id:1", "destination:/topic/admin.audit"}, "")
+	if frame := c.read(); frame.Command != Error || frame.Headers["error-code"] != ErrorCodeUnauthorized {
+		t.Fatalf("expected an unauthorized ERROR, got %s %v", frame.Command, frame.Headers)
+	}
+
+	c.send("SEND", []string{"destination:/queue/jobs"}, "too long")
+	if frame := c.read(); frame.Command != Error || frame.Headers["error-code"] != ErrorCodePolicyViolation {
+		t.Fatalf("expected a policy violation ERROR, got %s %v", frame.Command, frame.Headers)
+	}
+
+	// the subscription made before the reload is kept
+	server.SendMessage("/topic/admin.users", "text/plain", "hello")
+	if frame := c.read(); frame.Command != Message || string(*frame.Body) != "hello" {
+		t.Fatalf("expected the message, got %s %v", frame.Command, frame.Headers)
+	}
+
+	c.quiet(50 * time.Millisecond)
+}
+
+func TestReloadRejectsInvalidSettingsAsAWhole(t *testing.T) {
+	server, _ := newTestServer(t, func(server *Server) {
+		server.AllowedOrigins = []string{"https://app.example.com"}
+	})
+
+	err := server.Reload(Reloadable{
+		AllowedOrigins:      []string{"https://other.example.com"},
+		DestinationPolicies: []DestinationPolicy{{Destination: "/queue/{name"}},
+	})
+
+	if err == nil {
+		t.Fatal("expected the invalid destination policy to be rejected")
+	}
+
+	request := httptest.NewRequest("GET", "http://stomper.example.com/ws", nil)
+	request.Header.Set("Origin", "https://app.example.com")
+	if !server.checkOrigin(request) {
+		t.Fatal("expected the origins from before the failed reload")
+	}
+
+	if err = server.Reload(Reloadable{AllowedOrigins: []string{"https://other.example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if server.checkOrigin(request) {
+		t.Fatal("expected the reloaded origins")
+	}
+}
+
+func TestReloadCarriesOverConnectAttempts(t *testing.T) {
+	server, _ := newTestServer(t, func(server *Server) {
+		server.ConnectRateLimit = &ConnectRateLimit{Attempts: 2, Window: time.Hour}
+	})
+
+	request := httptest.NewRequest("GET", "http://stomper.example.com/ws", nil)
+	for i := 0; i < 2; i++ {
+		if status, _ := server.refuse(request, "192.0.2.1"); status != 0 {
+			t.Fatalf("expected attempt %d to be admitted, got %d", i+1, status)
+		}
+	}
+
+	if err := server.Reload(Reloadable{ConnectRateLimit: &ConnectRateLimit{Attempts: 3, Window: time.Hour}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if status, _ := server.refuse(request, "192.0.2.1"); status != 0 {
+		t.Fatalf("expected the raised limit to admit a third attempt, got %d", status)
+	}
+
+	if status, _ := server.refuse(request, "192.0.2.1"); status == 0 {
+		t.Fatal("expected a fourth attempt to be refused")
+	}
+}
+
+func TestReloadBeforeSetup(t *testing.T) {
+	server := &Server{}
+	if err := server.Reload(Reloadable{}); !errors.Is(err, ErrNotSetup) {
+		t.Fatalf("expected ErrNotSetup, got %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package stomper
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// RetainedHeader is set to true on a retained message delivered on SUBSCRIBE, to tell it from live ones.
+const RetainedHeader = "retained"
+
+const retainLockCount = 32
+
+// retainLocks order broadcasts to retained destinations against new subscriptions to them, so that a new
+// subscriber gets the retained message ahead of every later message, and never as well as the live copy.
+type retainLocks [retainLockCount]sync.Mutex
+
+// RetainedMessage is the last message sent to a destination listed in Server.RetainDestinations.
+type RetainedMessage struct {
+	Destination string
+	ContentType string
+	Body        []byte
+	Headers     map[string]string
+	Time        time.Time
+
+	// Expires is when the message stops being sent to new subscribers, or zero if it never does
+	Expires time.Time
+}
+
+// RetentionStore holds the last message of each retained destination; Retained returns false for a
+// destination without one. PurgeExpired removes the messages which expired by now, returning how many.
+type RetentionStore interface {
+	Retain(message RetainedMessage) error
+	Retained(destination string) (RetainedMessage, bool, error)
+	Clear(destination string) error
+	PurgeExpired(now time.Time) (int, error)
+}
+
+// MemoryRetentionStore is the default RetentionStore, holding messages in memory.
+type MemoryRetentionStore struct {
+	mux      sync.RWMutex
+	messages map[string]RetainedMessage
+}
+
+func (store *MemoryRetentionStore) Retain(message RetainedMessage) error {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	if store.messages == nil {
+		store.messages = make(map[string]RetainedMessage)
+	}
+
+	store.messages[message.Destination] = message
+	return nil
+}
+
+func (store *MemoryRetentionStore) Retained(destination string) (RetainedMessage, bool, error) {
+	store.mux.RLock()
+	defer store.mux.RUnlock()
+	message, ok := store.messages[destination]
+	return message, ok, nil
+}
+
+func (store *MemoryRetentionStore) Clear(destination string) error {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	delete(store.messages, destination)
+	return nil
+}
+
+func (store *MemoryRetentionStore) PurgeExpired(now time.Time) (int, error) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	purged := 0
+	for destination, message := range store.messages {
+		if expired(message.Expires, now) {
+			delete(store.messages, destination)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+func (server *Server) parseRetainDestinations() []*DestinationTemplate {
+	var templates []*DestinationTemplate
+	for _, destination := range server.RetainDestinations {
+		template, err := ParseDestinationTemplate(destination)
+		if err != nil {
+			server.Sugar.Warnf("invalid retained destination (%s): %v", destination, err)
+			continue
+		}
+
+		templates = append(templates, template)
+	}
+
+	if len(templates) > 0 && server.RetentionStore == nil {
+		server.RetentionStore = &MemoryRetentionStore{}
+	}
+
+	return templates
+}
+
+func (server *Server) isRetained(destination string) bool {
+	for _, template := range server.retainTemplates {
+		if _, ok := template.Match(destination); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lockRetained holds back broadcasts to destination, if it's retained, returning the function releasing them.
+func (server *Server) lockRetained(destination string) func() {
+	if isWildcard(destination) || !server.isRetained(destination) {
+		return func() {}
+	}
+
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(destination))
+	lock := &server.retainLocks[hash.Sum32()%retainLockCount]
+	lock.Lock()
+	return lock.Unlock
+}
+
+// retain stores a broadcast to a retained destination as its last value.
+func (server *Server) retain(destination string, contentType string, body string, extraHeaders map[string]string) {
+	if !server.isRetained(destination) {
+		return
+	}
+
+	headers := make(map[string]string, len(extraHeaders))
+	for k, v := range extraHeaders {
+		headers[k] = v
+	}
+
+	err := server.RetentionStore.Retain(RetainedMessage{
+		Destination: destination,
+		ContentType: contentType,
+		Body:        []byte(body),
+		Headers:     headers,
+		Time:        server.clock().Now(),
+		Expires:     expiresAt(headers),
+	})
+
+	if err != nil {
+		server.Sugar.Warnf("unable to retain message to '%s': %v", destination, err)
+	}
+}
+
+// sendRetained delivers a new subscription's destination's retained message, if it has one; the subscription
+// is added and sent it under lockRetained. Wildcard subscriptions aren't sent any.
+func (server *Server) sendRetained(client *Client, destination string, subId string) {
+	if isWildcard(destination) || !server.isRetained(destination) {
+		return
+	}
+
+	message, ok, err := server.RetentionStore.Retained(destination)
+	if err != nil {
+		server.Sugar.Warnf("[%d] unable to load retained message of '%s': %v", client.Uid, destination, err)
+		return
+	} else if !ok || expired(message.Expires, server.clock().Now()) {
+		return
+	}
+
+	headers := make(map[string]string, len(message.Headers)+1)
+	for k, v := range message.Headers {
+		headers[k] = v
+	}
+
+	headers[RetainedHeader] = "true"
+	if err = server.sendToSubscription(client, destination, subId, message.ContentType, message.Body, headers); err != nil {
+		server.Sugar.Warnf("[%d] unable to write retained message: %v", client.Uid, err)
+	}
+}
@@ -0,0 +1,56 @@
+package stomper
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestRetainedMessageIsNeverOlderThanLiveOnes(t *testing.T) {
+	server, addr := newTestServer(t, func(server *Server) {
+		server.RetainDestinations = []string{"/topic/price"}
+	})
+
+	server.SendMessage("/topic/price", "text/plain", "0")
+	stop := make(chan struct{})
+	var publishing sync.WaitGroup
+	publishing.Add(1)
+	go func() {
+		defer publishing.Done()
+		for i := 1; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			server.SendMessage("/topic/price", "text/plain", strconv.Itoa(i))
+		}
+	}()
+
+	defer func() {
+		close(stop)
+		publishing.Wait()
+	}()
+
+	for i := 0; i < 20; i++ {
+		c := dialTestClient(t, addr).connect()
+		c.send(Subscribe, []string{"id:p", "destination:/topic/price"}, "")
+		last := -1
+		for j := 0; j < 20; j++ {
+			frame := c.read()
+			if frame.Command != Message {
+				continue
+			}
+
+			price, _ := strconv.Atoi(string(*frame.Body))
+			if price <= last {
+				t.Fatalf("got price %d (retained: %s) after %d", price, frame.Headers[RetainedHeader], last)
+			}
+
+			last = price
+		}
+
+		_ = c.conn.Close()
+	}
+}
@@ -0,0 +1,73 @@
+package stomper
+
+import (
+	"fmt"
+)
+
+// destinationRoute is a message handler registered for a destination or wildcard pattern.
+type destinationRoute struct {
+	pattern []segment
+	handler MessageHandler
+}
+
+// HandleDestination registers a message handler invoked only for SENDs to destinations matching pattern,
+// which may use the same `*` and `#` wildcards as subscriptions. Handlers run in registration order, after
+// those added with AddMessageHandler.
+func (server *Server) HandleDestination(pattern string, handler MessageHandler) error {
+	if server.setup {
+		return fmt.Errorf("unable to add destination handler after %w", ErrAlreadySetup)
+	}
+
+	server.routes = append(server.routes, destinationRoute{
+		pattern: destinationSegments(pattern),
+		handler: server.timedMessageHandler("destination", handler),
+	})
+
+	return nil
+}
+
+func (server *Server) routeMessage(client *Client, destination string, message *StompMessage) {
+	if len(server.routes) == 0 {
+		return
+	}
+
+	segments := destinationSegments(destination)
+	for _, route := range server.routes {
+		if matchSegments(route.pattern, segments) {
+			route.handler(client, destination, message)
+		}
+	}
+}
+
+// matchSegments matches destination segments against the segments of a destination or wildcard pattern,
+// in time proportional to the product of their lengths.
+func matchSegments(pattern []segment, segments []segment) bool {
+	// matched[j] is whether the rest of the pattern matches segments[j:], working back from its end
+	matched := make([]bool, len(segments)+1)
+	matched[len(segments)] = true
+	for i := len(pattern) - 1; i >= 0; i-- {
+		p := pattern[i]
+		next := make([]bool, len(segments)+1)
+		anyAfter := matched[len(segments)]
+		for j := len(segments); j >= 0; j-- {
+			if j < len(segments) {
+				anyAfter = anyAfter || matched[j+1]
+			}
+
+			switch {
+			case p.name == wildcardMany:
+				// nothing, or segments from one starting with #'s separator
+				next[j] = matched[j] || (j < len(segments) && segments[j].separator == p.separator && anyAfter)
+			case j == len(segments) || segments[j].separator != p.separator:
+			case p.name == wildcardOne:
+				next[j] = matched[j+1]
+			default:
+				next[j] = segments[j].name == p.name && matched[j+1]
+			}
+		}
+
+		matched = next
+	}
+
+	return matched[0]
+}
@@ -0,0 +1,174 @@
+package stomper
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SendLimit caps the SEND frames, and their body bytes, a client may send within a window; zero is unlimited.
+type SendLimit struct {
+	Frames uint64
+	Bytes  uint64
+}
+
+// SendQuota limits how much each client sends per hour and per (UTC) day. Clients are counted by principal,
+// so a quota survives reconnects, or by address when anonymous. A SEND over quota is rejected with an ERROR
+// and an audit event.
+type SendQuota struct {
+	Hourly SendLimit
+	Daily  SendLimit
+}
+
+// SendUsage is what a client has sent in the current hour and day.
+type SendUsage struct {
+	Client string
+	Hour   SendLimit
+	Day    SendLimit
+}
+
+const AuditSendQuotaExceeded = "send-quota-exceeded"
+
+// AuditEvent records an action taken against a client, for operators to follow up on.
+type AuditEvent struct {
+	Time      time.Time
+	Client    *Client
+	Principal string
+	Action    string
+	Reason    string
+}
+
+type AuditHandler func(AuditEvent)
+
+func (server *Server) AddAuditHandler(handler AuditHandler) error {
+	if server.setup {
+		return fmt.Errorf("unable to add audit handler after %w", ErrAlreadySetup)
+	}
+
+	server.auditHandlers = append(server.auditHandlers, handler)
+	return nil
+}
+
+func (server *Server) audit(client *Client, action string, reason string) {
+	event := AuditEvent{
+		Time:      server.clock().Now(),
+		Client:    client,
+		Principal: server.principal(client),
+		Action:    action,
+		Reason:    reason,
+	}
+
+	server.Sugar.Warnf("[%d] audit: %s: %s", client.Uid, action, reason)
+	for _, handler := range server.auditHandlers {
+		handler(event)
+	}
+}
+
+// sendUsage tracks what one client has sent in the current windows.
+type sendUsage struct {
+	hourStart time.Time
+	dayStart  time.Time
+	hour      SendLimit
+	day       SendLimit
+}
+
+type sendQuotas struct {
+	mux   sync.Mutex
+	usage map[string]*sendUsage
+}
+
+func (limit SendLimit) allows(used SendLimit, size uint64) bool {
+	return (limit.Frames == 0 || used.Frames+1 <= limit.Frames) && (limit.Bytes == 0 || used.Bytes+size <= limit.Bytes)
+}
+
+// chargeSend counts a SEND against the client's quota, returning an error without counting it if it would
+// go over.
+func (server *Server) chargeSend(client *Client, size int) error {
+	quota := server.SendQuota
+	if quota == nil {
+		return nil
+	}
+
+	now := server.clock().Now().UTC()
+	hourStart, dayStart := now.Truncate(time.Hour), time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	key := server.quotaKey(client)
+
+	quotas := &server.sendQuotas
+	quotas.mux.Lock()
+	defer quotas.mux.Unlock()
+	if quotas.usage == nil {
+		quotas.usage = make(map[string]*sendUsage)
+	}
+
+	usage, ok := quotas.usage[key]
+	if !ok {
+		usage = &sendUsage{}
+		quotas.usage[key] = usage
+	}
+
+	if !usage.hourStart.Equal(hourStart) {
+		usage.hourStart, usage.hour = hourStart, SendLimit{}
+	}
+
+	if !usage.dayStart.Equal(dayStart) {
+		usage.dayStart, usage.day = dayStart, SendLimit{}
+	}
+
+	if !quota.Hourly.allows(usage.hour, uint64(size)) {
+		return &FrameError{Code: ErrorCodeQuotaExceeded, Message: "hourly send quota exceeded", Err: ErrQuotaExceeded}
+	}
+
+	if !quota.Daily.allows(usage.day, uint64(size)) {
+		return &FrameError{Code: ErrorCodeQuotaExceeded, Message: "daily send quota exceeded", Err: ErrQuotaExceeded}
+	}
+
+	usage.hour.Frames++
+	usage.hour.Bytes += uint64(size)
+	usage.day.Frames++
+	usage.day.Bytes += uint64(size)
+	return nil
+}
+
+// SendUsages reports what each client has sent this hour and day, heaviest senders today first.
+func (server *Server) SendUsages() []SendUsage {
+	now := server.clock().Now().UTC()
+	quotas := &server.sendQuotas
+	quotas.mux.Lock()
+	defer quotas.mux.Unlock()
+
+	usages := make([]SendUsage, 0, len(quotas.usage))
+	for key, usage := range quotas.usage {
+		if now.Sub(usage.dayStart) >= 24*time.Hour {
+			// idle since an earlier day
+			delete(quotas.usage, key)
+			continue
+		}
+
+		report := SendUsage{Client: key, Day: usage.day}
+		if usage.hourStart.Equal(now.Truncate(time.Hour)) {
+			report.Hour = usage.hour
+		}
+
+		usages = append(usages, report)
+	}
+
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].Day.Frames != usages[j].Day.Frames {
+			return usages[i].Day.Frames > usages[j].Day.Frames
+		}
+
+		return usages[i].Client < usages[j].Client
+	})
+
+	return usages
+}
+
+// ResetSendUsage clears a client's send usage (by principal or address), lifting its quota until it sends
+// that much again.
+func (server *Server) ResetSendUsage(client string) {
+	quotas := &server.sendQuotas
+	quotas.mux.Lock()
+	defer quotas.mux.Unlock()
+	delete(quotas.usage, client)
+}
@@ -1,95 +1,375 @@
 package stomper
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-var _subscriptionMux sync.Mutex
-var _clientMux sync.Mutex
-
 type SubscribeHandler func(*Client, string) bool
 type UnsubscribeHandler func(*Client, string)
 type ConnectHandler func(*Client, http.Header, *StompMessage) bool
 type DisconnectHandler func(*Client)
 type MessageHandler func(*Client, string, *StompMessage)
 
+// DeliveryHandler is consulted for every MESSAGE about to be delivered to a subscribed client, with the
+// destination and frame headers; returning false suppresses delivery of that message to that client only.
+type DeliveryHandler func(*Client, string, map[string]string) bool
+
 type Server struct {
-	Sugar               *zap.SugaredLogger
-	Compression         bool
-	ReadBufferSize      int
-	WriteBufferSize     int
-	setup               bool
-	upgrader            websocket.Upgrader
-	messageHandlers     []MessageHandler
-	subscribeHandlers   []SubscribeHandler
-	unsubscribeHandlers []UnsubscribeHandler
-	connectHandlers     []ConnectHandler
-	disconnectHandlers  []DisconnectHandler
-	clients             map[uint64]*Client
-	subscriptions       map[string]map[uint64]map[string]*Client
+	// Sugar is the server's logger, by default a *zap.SugaredLogger writing to stdout and stderr
+	Sugar           Logger
+	Compression     bool
+	ReadBufferSize  int
+	WriteBufferSize int
+	TrustedProxies  []string
+	FeatureFlags    FeatureFlags
+	Faults          *FaultInjector
+	Recorder        SessionRecorder
+
+	// Strict rejects frames with bad escapes, no NULL terminator or missing required headers, which are
+	// otherwise let through. Unknown commands and malformed header lines are rejected either way, with
+	// ErrorPolicy deciding what happens to the connection.
+	Strict bool
+
+	// AllowedOrigins, when set, limits browser websocket upgrades to the same origin and these origins, which
+	// may contain a `*` (e.g. https://*.example.com); CheckOrigin overrides it entirely
+	AllowedOrigins []string
+	CheckOrigin    func(*http.Request) bool
+
+	// DevConsole, when set, pretty-prints every frame; for local development only
+	DevConsole *DevConsole
+
+	// OutboundQueueSize is how many frames may wait to be written to each client (default 256), and
+	// WriteTimeout bounds each write (default 10s)
+	OutboundQueueSize int
+	WriteTimeout      time.Duration
+
+	// CongestionHighWater is how many frames may wait in a client's outbound queue before it's sent a
+	// congestion advisory (default three quarters of OutboundQueueSize, negative disables), and
+	// CongestionLowWater how few before it's told the congestion has cleared (default a third of that); see
+	// CongestionDestination
+	CongestionHighWater int
+	CongestionLowWater  int
+
+	// SourceBackpressure pauses data sources which call WaitForCapacity, such as JetStream consumers, while
+	// at least this share of connected clients (e.g. 0.5) have congested outbound queues; zero disables
+	SourceBackpressure float64
+
+	// SlowConsumerPolicy decides what happens to messages for a client whose outbound queue is full: by
+	// default they wait for space, holding up the broadcast
+	SlowConsumerPolicy SlowConsumerPolicy
+
+	// InboundQueueSize is how many frames read from each client may wait while an earlier one is processed
+	// (default 64); once it's full, reading stops until there's space again
+	InboundQueueSize int
+
+	// MaxFrameSize limits the size of frames read from clients, in bytes; zero means no limit, though TCP
+	// clients are still held to 64 MiB
+	MaxFrameSize int64
+
+	// ErrorPolicy decides whether a connection is closed (default) or kept open after a rejected frame
+	ErrorPolicy ErrorPolicy
+
+	// ErrorEchoLimit caps how much of a rejected frame is echoed in the ERROR body (default 256, negative for none)
+	ErrorEchoLimit int
+
+	// CloudEvents, when set, adds CloudEvents binary-mode (ce-*) headers to outbound MESSAGE frames
+	CloudEvents *CloudEventsConfig
+
+	// TimeSync stamps outbound MESSAGE frames with a server-time header and answers clock sync requests on
+	// TimeDestination
+	TimeSync bool
+
+	// Tracer, when set, traces frame handling and broadcasts, continuing traces propagated in the TraceHeader
+	// header (default traceparent) of SEND frames and published messages and passing them on to recipients
+	Tracer      Tracer
+	TraceHeader string
+
+	// Authenticator, when set, checks the credentials of every CONNECT before the connect handlers run
+	Authenticator Authenticator
+
+	// Authorizer, when set, decides who may SEND and SUBSCRIBE to which destinations, e.g. an ACL
+	Authorizer Authorizer
+
+	// Quotas meters what each principal (Principal, or else the authenticated identity, or for anonymous
+	// clients "anonymous@" and their address) consumes, refusing new subscriptions once it is over quota;
+	// usage is recorded every QuotaFlushInterval (default 1m)
+	Quotas             QuotaBackend
+	QuotaFlushInterval time.Duration
+	Principal          func(*Client) string
+
+	// Analytics, when set, samples published messages into an analytics sink, hashing rather than keeping bodies
+	Analytics *AnalyticsConfig
+
+	// Shadows mirror a share of the traffic to some destinations to shadow destinations or webhooks
+	Shadows []ShadowRule
+
+	// SubscriptionLifetimes end subscriptions to matching destinations after a while, as does an expires
+	// header on SUBSCRIBE
+	SubscriptionLifetimes []SubscriptionLifetime
+
+	// UpstreamHeaders are the SUBSCRIBE headers passed on to a SubscribingSource when a destination gets its
+	// first subscriber (default selector and replay)
+	UpstreamHeaders []string
+
+	// QueryProvider, when set, runs a live query for every subscription to a /query/ destination
+	QueryProvider QueryProvider
+
+	// ClientVersion, when set, refuses (or limits to read-only access) clients older than a minimum app version
+	ClientVersion *ClientVersionPolicy
+
+	// Codecs re-encode JSON messages for clients which ask for another content type with an accept header,
+	// e.g. MsgpackCodec and CBORCodec
+	Codecs []Codec
+
+	// DisconnectGrace delays the disconnect handlers of clients with a session-token, so that a page reload
+	// resumes their session (see AddResumeHandler) rather than leaving and joining again
+	DisconnectGrace time.Duration
+
+	// SlowHandlerThreshold is how long a connect, subscribe, unsubscribe, message, disconnect, resume or enrich
+	// handler may take before it's logged as a warning, since it holds up the client's read loop (default
+	// 100ms, negative disables); every invocation is timed in the stomper_handler_duration_seconds metric
+	SlowHandlerThreshold time.Duration
+
+	// AckTimeout is how long a message published with PublishWithAck waits for client-ack subscribers to
+	// acknowledge it before it's nacked (default 30s)
+	AckTimeout time.Duration
+
+	// SendQuota, when set, limits how many SEND frames and bytes each client may send per hour and day
+	SendQuota *SendQuota
+
+	// DigestLimit caps how many messages a digest subscription holds between deliveries, dropping the oldest
+	// (default 1000)
+	DigestLimit int
+
+	// DestinationPolicies limit the content-type and body size clients may SEND to matching destinations
+	DestinationPolicies []DestinationPolicy
+
+	// RetainDestinations are destination templates, e.g. `/topic/prices.{symbol}`, whose last message is kept
+	// in RetentionStore (default in memory) and sent to each new subscriber straight away
+	RetainDestinations []string
+	RetentionStore     RetentionStore
+
+	// ExpirySweepInterval is how often expired messages are purged from the RetentionStore, default a minute;
+	// see ExpiresHeader
+	ExpirySweepInterval time.Duration
+
+	// HistoryDestinations are destination templates whose last HistorySize (default 100) messages are kept
+	// for SUBSCRIBEs asking for a replay; see ReplayHeader
+	HistoryDestinations []string
+	HistorySize         int
+
+	// QueuePrefixes mark queue destinations, e.g. /queue/, whose messages each go to just one subscriber,
+	// picked by QueueStrategy (default QueueRoundRobin), to share work between consumers. Messages sent while
+	// a queue has no subscribers are dropped.
+	QueuePrefixes []string
+	QueueStrategy QueueStrategy
+
+	// BrokerExcludeSender stops the simple broker echoing a SEND back to the client that sent it
+	BrokerExcludeSender bool
+
+	// MaxBrokerHops is how many times the simple broker, or a handler re-publishing with NextHop, relays the same
+	// message before it's dropped (default 8)
+	MaxBrokerHops int
+
+	// ConnectRateLimit throttles (and optionally bans) IPs which open connections too quickly
+	ConnectRateLimit *ConnectRateLimit
+
+	// ReauthorizeInterval, when set, periodically re-checks active subscriptions (see Reauthorize)
+	ReauthorizeInterval time.Duration
+
+	// DeliveryShards is how many goroutines share the work of writing broadcasts; clients are assigned to a
+	// shard by uid. Defaults to GOMAXPROCS.
+	DeliveryShards int
+
+	// Conflate keeps at most one MESSAGE per subscription waiting in each client's outbound queue: a newer
+	// one replaces it where it stands, so clients that fall behind skip to the latest value
+	Conflate bool
+
+	// Profile presets options for a kind of deployment; PushDestinations is the subscription allowlist
+	// (destination prefixes) for ProfilePushOnly
+	Profile          Profile
+	PushDestinations []string
+
+	// DisabledCommands are rejected with an ERROR frame, e.g. Send for a push-only deployment or Begin, Commit
+	// and Abort to turn off transactions. CONNECT, STOMP and DISCONNECT can't be disabled.
+	DisabledCommands []StompCommand
+
+	// HeartBeatSend and HeartBeatReceive are how often the server offers to send heart-beats and wants to
+	// receive them (default 10s, negative disables). Clients silent for longer than the negotiated receive
+	// interval plus HeartBeatGrace (default: one more interval) are disconnected. HeartBeatPayload is the EOL
+	// sent as a heart-beat, "\n" (default) or "\r\n".
+	HeartBeatSend    time.Duration
+	HeartBeatReceive time.Duration
+	HeartBeatGrace   time.Duration
+	HeartBeatPayload string
+
+	// Clock drives every time-dependent feature; nil uses SystemClock
+	Clock Clock
+
+	// SessionStore, when set, is sent each connection's timeline once it ends; see SessionsHandler
+	SessionStore SessionStore
+
+	setup                 bool
+	initOnce              sync.Once
+	setupOnce             sync.Once
+	upgrader              websocket.Upgrader
+	destinationPolicies   []destinationPolicy
+	shadowRules           []shadowRule
+	retainTemplates       []*DestinationTemplate
+	retainLocks           retainLocks
+	historyTemplates      []*DestinationTemplate
+	histories             histories
+	queues                queueCursors
+	subscriptionLifetimes []subscriptionLifetime
+	expiries              subscriptionExpiries
+	trustedProxies        []*net.IPNet
+	messageHandlers       []MessageHandler
+	routes                []destinationRoute
+	subscribeHandlers     []SubscribeHandler
+	unsubscribeHandlers   []UnsubscribeHandler
+	connectHandlers       []ConnectHandler
+	disconnectHandlers    []DisconnectHandler
+	enrichHandlers        []EnrichHandler
+	deliveryHandlers      []DeliveryHandler
+	errorHandlers         []ErrorHandler
+	brokerPrefixes        []string
+	upgradeHandlers       []UpgradeHandler
+	auditHandlers         []AuditHandler
+	resumeHandlers        []ResumeHandler
+	pendingDisconnects    pendingDisconnects
+	acks                  pendingAcks
+	dataSources           dataSources
+	sendQuotas            sendQuotas
+	connectLimiter        *connectLimiter
+	reloadMux             sync.RWMutex
+	disabledCommands      map[StompCommand]bool
+	deliveryShards        []*deliveryShard
+	aggregates            map[string][]string
+	composites            map[string]*composite
+	compositeSources      map[string][]*composite
+	metrics               metrics
+	clientUid             atomic.Uint64
+	clientMux             sync.RWMutex
+	users                 map[string]map[uint64]*Client
+	clients               map[uint64]*Client
+	subscriptions         []*subscriptionShard
+	subscriptionIndex     subscriptionIndex
+	queries               liveQueries
+	aliases               destinationAliases
+	patterns              patternIndex
 }
 
 func (server *Server) AddMessageHandler(handler MessageHandler) error {
 	if server.setup {
-		return fmt.Errorf("unable to add message handler after server is setup")
+		return fmt.Errorf("unable to add message handler after %w", ErrAlreadySetup)
 	}
 
-	server.messageHandlers = append(server.messageHandlers, handler)
+	server.messageHandlers = append(server.messageHandlers, server.timedMessageHandler("message", handler))
 	return nil
 }
 
 func (server *Server) AddSubscribeHandler(handler SubscribeHandler) error {
 	if server.setup {
-		return fmt.Errorf("unable to add subscribe handler after server is setup")
+		return fmt.Errorf("unable to add subscribe handler after %w", ErrAlreadySetup)
 	}
 
-	server.subscribeHandlers = append(server.subscribeHandlers, handler)
+	server.subscribeHandlers = append(server.subscribeHandlers, server.timedSubscribeHandler(handler))
 	return nil
 }
 
+// AddUnsubscribeHandler adds a handler called with the destination of each subscription a client ends; the
+// server looks it up from the UNSUBSCRIBE's id.
 func (server *Server) AddUnsubscribeHandler(handler UnsubscribeHandler) error {
 	if server.setup {
-		return fmt.Errorf("unable to add unsubscribe handler after server is setup")
+		return fmt.Errorf("unable to add unsubscribe handler after %w", ErrAlreadySetup)
 	}
 
-	server.unsubscribeHandlers = append(server.unsubscribeHandlers, handler)
+	server.unsubscribeHandlers = append(server.unsubscribeHandlers, server.timedUnsubscribeHandler(handler))
 	return nil
 }
 
 func (server *Server) AddConnectHandler(handler ConnectHandler) error {
 	if server.setup {
-		return fmt.Errorf("unable to add connect handler after server is setup")
+		return fmt.Errorf("unable to add connect handler after %w", ErrAlreadySetup)
 	}
 
-	server.connectHandlers = append(server.connectHandlers, handler)
+	server.connectHandlers = append(server.connectHandlers, server.timedConnectHandler(handler))
 	return nil
 }
 
 func (server *Server) AddDisconnectHandler(handler DisconnectHandler) error {
 	if server.setup {
-		return fmt.Errorf("unable to add disconnect handler after server is setup")
+		return fmt.Errorf("unable to add disconnect handler after %w", ErrAlreadySetup)
+	}
+
+	server.disconnectHandlers = append(server.disconnectHandlers, server.timedDisconnectHandler(handler))
+	return nil
+}
+
+func (server *Server) AddDeliveryHandler(handler DeliveryHandler) error {
+	if server.setup {
+		return fmt.Errorf("unable to add delivery handler after %w", ErrAlreadySetup)
 	}
 
-	server.disconnectHandlers = append(server.disconnectHandlers, handler)
+	server.deliveryHandlers = append(server.deliveryHandlers, handler)
 	return nil
 }
 
+// init creates the logger and internal state on first use, so that a server which is used before (or
+// without) Setup fails with ErrNotSetup rather than a nil pointer panic.
+func (server *Server) init() {
+	server.initOnce.Do(func() {
+		if server.Sugar == nil {
+			server.Sugar = logInit(false)
+		}
+
+		server.clients = make(map[uint64]*Client)
+		server.users = make(map[string]map[uint64]*Client)
+		server.subscriptions = newSubscriptionShards()
+	})
+}
+
+// Setup validates the configuration and starts the server's background work. Calling it again has no effect.
 func (server *Server) Setup() {
+	server.init()
+	server.setupOnce.Do(server.doSetup)
+}
+
+func (server *Server) doSetup() {
 	sugar := server.Sugar
-	if sugar == nil {
-		sugar = logInit(false)
+	server.trustedProxies = server.parseTrustedProxies()
+	server.destinationPolicies = server.parseDestinationPolicies()
+	server.shadowRules = server.parseShadowRules()
+	server.retainTemplates = server.parseRetainDestinations()
+	server.historyTemplates = server.parseHistoryDestinations()
+	server.subscriptionLifetimes = server.parseSubscriptionLifetimes()
+	server.applyProfile()
+	server.shareClock()
+	server.disabledCommands = make(map[StompCommand]bool)
+	for _, command := range server.DisabledCommands {
+		if command == Connect || command == Stomp || command == Disconnect {
+			sugar.Warnf("%s can't be disabled", command)
+			continue
+		}
+
+		server.disabledCommands[command] = true
 	}
 
-	server.Sugar = sugar
-	server.clients = make(map[uint64]*Client)
-	server.subscriptions = make(map[string]map[uint64]map[string]*Client)
+	if server.ConnectRateLimit != nil {
+		server.connectLimiter = newConnectLimiter(*server.ConnectRateLimit)
+	}
 
 	readBufferSize := server.ReadBufferSize
 	if readBufferSize <= 0 {
@@ -106,35 +386,67 @@ func (server *Server) Setup() {
 		WriteBufferSize:   writeBufferSize,
 		WriteBufferPool:   &sync.Pool{},
 		EnableCompression: server.Compression,
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
+		CheckOrigin:       server.checkOrigin,
 		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
 			server.Sugar.Errorf("error: %v", reason)
+			http.Error(w, http.StatusText(status), status)
 		},
 		Subprotocols: []string{"v10.stomp", "v11.stomp", "v12.stomp"},
 	}
 
 	server.upgrader = upgrader
 	server.setup = true
+
+	server.startDeliveryShards()
+	if server.ReauthorizeInterval > 0 {
+		go server.reauthorizeLoop(server.ReauthorizeInterval)
+	}
+
+	if server.Quotas != nil {
+		go server.usageLoop()
+	}
+
+	if len(server.retainTemplates) > 0 {
+		go server.expiryLoop()
+	}
+
+	server.startDataSources()
 }
 
 func (server *Server) addClient(client *Client) {
-	_clientMux.Lock()
-	defer _clientMux.Unlock()
+	server.clientMux.Lock()
+	defer server.clientMux.Unlock()
 	server.clients[client.Uid] = client
+
+	if user := server.principal(client); user != "" {
+		if server.users[user] == nil {
+			server.users[user] = make(map[uint64]*Client)
+		}
+
+		server.users[user][client.Uid] = client
+	}
 }
 
 func (server *Server) removeClient(client *Client) {
-	_clientMux.Lock()
-	defer _clientMux.Unlock()
+	server.clientMux.Lock()
 	delete(server.clients, client.Uid)
+	if user := server.principal(client); user != "" {
+		delete(server.users[user], client.Uid)
+		if len(server.users[user]) == 0 {
+			delete(server.users, user)
+		}
+	}
+	server.clientMux.Unlock()
 
-	_subscriptionMux.Lock()
-	defer _subscriptionMux.Unlock()
-	for _, subs := range server.subscriptions {
-		delete(subs, client.Uid)
+	for subId, topics := range server.subscriptionIndex.takeClient(client) {
+		for topic := range topics {
+			server.deleteSubscription(client, topic, subId)
+		}
 	}
+
+	server.patterns.mux.Lock()
+	server.patterns.deleteClient(client)
+	server.patterns.mux.Unlock()
 }
 
 func (server *Server) addSubscription(client *Client, message StompMessage) bool {
@@ -149,93 +461,330 @@ func (server *Server) addSubscription(client *Client, message StompMessage) bool
 		return false
 	}
 
-	_clientMux.Lock()
-	_subscriptionMux.Lock()
-	defer _clientMux.Unlock()
-	defer _subscriptionMux.Unlock()
+	server.subscribe(client, topic, subId, message.Headers)
+	server.Sugar.Infof("[%d] subscribed to '%s' (%s)", client.Uid, topic, subId)
+	return true
+}
 
-	subs, sok := server.subscriptions[topic]
-	if !sok {
-		subs = make(map[uint64]map[string]*Client)
-		server.subscriptions[topic] = subs
-	}
+func (server *Server) SendMessageWithCheck(topic string, contentType string, body string, check func(client *Client) bool) {
+	server.SendMessageWithHeaders(topic, contentType, body, nil, check)
+}
+
+// SendMessageWithHeaders broadcasts like SendMessageWithCheck, adding extra headers to every MESSAGE frame.
+// The content-type, subscription, destination and content-length headers are always set by the server.
+func (server *Server) SendMessageWithHeaders(topic string, contentType string, body string, extraHeaders map[string]string, check func(client *Client) bool) {
+	server.publish(context.Background(), topic, contentType, body, extraHeaders, check, nil, nil)
+}
 
-	clientSubs, csok := subs[client.Uid]
-	if !csok {
-		clientSubs = make(map[string]*Client)
-		subs[client.Uid] = clientSubs
+// SendMessageWithReport broadcasts like SendMessageWithHeaders, waiting until the message has been queued for
+// every subscriber and reporting how that went. If ctx is done first it stops waiting on clients which
+// aren't keeping up, returning the context's error and a report of what happened until then, in which each
+// subscription it gave up on has the context's error.
+func (server *Server) SendMessageWithReport(ctx context.Context, topic string, contentType string, body string, extraHeaders map[string]string, check func(client *Client) bool) (DeliveryReport, error) {
+	report := DeliveryReport{Errors: make(map[SubscriptionKey]error)}
+	if err := ctx.Err(); err != nil {
+		return report, err
 	}
 
-	clientSubs[subId] = client
-	server.subscriptions[topic] = subs
-	server.Sugar.Infof("[%d] subscribed to '%s' (%s)", client.Uid, topic, subId)
-	return true
+	server.publish(ctx, topic, contentType, body, extraHeaders, check, nil, &report)
+	return report, ctx.Err()
 }
 
-func (server *Server) removeSubscription(client *Client, message StompMessage) bool {
-	var subId string
-	var ok bool
-	if subId, ok = message.Headers["id"]; !ok {
-		return false
+func (server *Server) publish(ctx context.Context, topic string, contentType string, body string, extraHeaders map[string]string, check func(client *Client) bool, ack *ackGroup, report *DeliveryReport) {
+	topic = server.resolveAlias(topic)
+	if isUserDestination(topic) {
+		server.Sugar.Warnf("not broadcasting to user destination '%s', use SendToUser", topic)
+		if ack != nil {
+			server.settle(ack, true)
+		}
+
+		return
 	}
 
-	_clientMux.Lock()
-	_subscriptionMux.Lock()
-	defer _clientMux.Unlock()
-	defer _subscriptionMux.Unlock()
+	if !server.checkHops(topic, extraHeaders) {
+		if ack != nil {
+			server.settle(ack, true)
+		}
+
+		return
+	}
 
-	for topic, subs := range server.subscriptions {
-		clientSubs, csok := subs[client.Uid]
-		if !csok {
-			return true
+	extraHeaders = server.applyTTL(extraHeaders)
+	if expired(expiresAt(extraHeaders), server.clock().Now()) {
+		server.Sugar.Debugf("not broadcasting expired message to '%s'", topic)
+		if ack != nil {
+			server.settle(ack, true)
 		}
 
-		delete(clientSubs, subId)
-		if len(clientSubs) == 0 {
-			delete(subs, client.Uid)
+		return
+	}
+
+	unlock := server.lockRetained(topic)
+	if history := server.historyOf(topic); history != nil {
+		history.publishing.Lock()
+		history.mux.Lock()
+		extraHeaders = history.add(contentType, body, extraHeaders)
+		history.mux.Unlock()
+
+		server.retain(topic, contentType, body, extraHeaders)
+		server.broadcast(ctx, topic, contentType, body, extraHeaders, check, ack, report)
+		history.publishing.Unlock()
+	} else {
+		// retained first, so a subscriber arriving mid-broadcast gets it one way or the other
+		server.retain(topic, contentType, body, extraHeaders)
+		server.broadcast(ctx, topic, contentType, body, extraHeaders, check, ack, report)
+	}
+
+	unlock()
+
+	server.updateComposites(topic, body)
+	server.sampleAnalytics(topic, contentType, body)
+	server.shadow(topic, contentType, body, extraHeaders)
+}
+
+func (server *Server) broadcast(ctx context.Context, topic string, contentType string, body string, extraHeaders map[string]string, check func(client *Client) bool, ack *ackGroup, report *DeliveryReport) {
+	server.init()
+	start := server.clock().Now()
+	ctx, span := server.startSpan(server.extractTrace(ctx, extraHeaders), "stomper.broadcast", start, map[string]string{
+		"stomper.destination": topic,
+	})
+	defer func() {
+		span.End(nil)
+		server.metrics.broadcastObserved(server.clock().Now().Sub(start))
+	}()
+
+	shared := newSharedBuffer(body)
+	defer shared.release()
+
+	b := &broadcast{contentType: contentType, body: shared, check: check, ack: ack, expires: expiresAt(extraHeaders)}
+
+	// with permessage-deflate, compress each distinct frame once rather than once per recipient
+	if server.Compression {
+		b.prepared = make(map[string]*preparedFrame)
+	}
+
+	extraHeaders = server.injectTrace(ctx, server.timeHeaders(server.cloudEventHeaders(topic, extraHeaders)))
+	server.collectDeliveries(b, topic, extraHeaders)
+	for _, aggregate := range server.aggregatesOf(topic) {
+		headers := make(map[string]string, len(extraHeaders)+1)
+		for k, v := range extraHeaders {
+			headers[k] = v
 		}
 
-		server.subscriptions[topic] = subs
+		headers[AggregateSourceHeader] = topic
+		server.collectDeliveries(b, aggregate, headers)
 	}
 
-	return true
+	_, fanOut := server.startSpan(ctx, "stomper.fan-out", server.clock().Now(), map[string]string{
+		"stomper.recipients": strconv.Itoa(len(b.deliveries)),
+	})
+	errs, err := server.deliver(ctx, b.deliveries)
+	fanOut.End(err)
+	if report != nil {
+		report.add(b.deliveries, errs)
+	}
+
+	if ack != nil {
+		server.sealAck(ack)
+	}
+
+	for _, encoded := range b.encoded {
+		if encoded != nil {
+			encoded.release()
+		}
+	}
 }
 
-func (server *Server) SendMessageWithCheck(topic string, contentType string, body string, check func(client *Client) bool) {
-	_clientMux.Lock()
-	_subscriptionMux.Lock()
-	defer _clientMux.Unlock()
-	defer _subscriptionMux.Unlock()
+type broadcast struct {
+	contentType string
+	body        *sharedBuffer
+	check       func(client *Client) bool
+	ack         *ackGroup
+	expires     time.Time
+	prepared    map[string]*preparedFrame
+	encoded     map[string]*sharedBuffer
+	deliveries  []delivery
+}
+
+// collectDeliveries adds a MESSAGE for every subscription to destination, including wildcard subscriptions
+// matching it.
+func (server *Server) collectDeliveries(b *broadcast, destination string, extraHeaders map[string]string) {
+	length := strconv.Itoa(len(b.body.bytes()))
+	messageHeaders := func(subId string) map[string]string {
+		headers := make(map[string]string, len(extraHeaders)+4)
+		for k, v := range extraHeaders {
+			headers[k] = v
+		}
+
+		headers["content-type"] = b.contentType
+		headers["subscription"] = subId
+		headers["destination"] = destination
+		headers["content-length"] = length
+		return headers
+	}
+
+	// the headers are serialized once for the destination; delivery handlers may rewrite them, so with any
+	// installed each recipient gets its own copy instead
+	var template *messageTemplate
+	if len(server.deliveryHandlers) == 0 {
+		template = newMessageTemplate(messageHeaders(""))
+	}
 
-	byteBody := []byte(body)
-	length := len(byteBody)
+	// subscriptions still being sent a replay take live messages through their gate
+	var eventId uint64
+	if _, aggregated := extraHeaders[AggregateSourceHeader]; !aggregated {
+		eventId, _ = strconv.ParseUint(extraHeaders[EventIdHeader], 10, 64)
+	}
 
-	subs, ok := server.subscriptions[topic]
-	if ok {
+	add := func(subs map[uint64]map[string]*Client, wildcard bool) {
 		for _, clientSubs := range subs {
 			for subId, client := range clientSubs {
-				message := StompMessage{
-					Command: Message,
-					Headers: map[string]string{
-						"content-type":   contentType,
-						"subscription":   subId,
-						"destination":    topic,
-						"content-length": strconv.Itoa(length),
-					},
-					Body: &byteBody,
+				if b.check != nil && !b.check(client) {
+					continue
+				}
+
+				// a pattern was authorized as written, so check the endpoint and Authorizer against what it matched
+				if wildcard && server.authorize(client, ActionSubscribe, destination) != nil {
+					continue
+				}
+
+				if eventId != 0 {
+					if gate := client.replayGateFor(subId); gate != nil && !gate.admit(eventId, b.contentType, b.body.bytes(), extraHeaders) {
+						continue
+					}
 				}
 
-				if check != nil && !check(client) {
+				if d := client.digestFor(subId); d != nil {
+					d.add(b.contentType, b.body.bytes())
 					continue
 				}
 
-				err := client.Conn.WriteMessage(websocket.TextMessage, message.ToPayload())
-				if err != nil {
-					server.Sugar.Errorf("unable to write message: %v", err)
+				body, contentType := b.body, b.contentType
+				if codec := server.codecFor(client, subId, b.contentType); codec != nil {
+					if encoded := b.encode(server, codec); encoded != nil {
+						body, contentType = encoded, codec.ContentType()
+					}
+				}
+
+				cumulative, acked := client.ackMode(subId)
+				var header []byte
+				if template != nil && body == b.body && !acked {
+					header = template.header(subId, client.Version)
+				} else {
+					headers := messageHeaders(subId)
+					headers["content-type"] = contentType
+					headers["content-length"] = strconv.Itoa(len(body.bytes()))
+					if !server.authorizeDelivery(client, destination, headers) {
+						continue
+					}
+
+					if acked {
+						headers[AckHeader] = server.expectAck(b.ack, client, subId, cumulative)
+					}
+
+					message := StompMessage{Command: Message, Headers: headers}
+					header = message.frameHeader(client.Version)
+				}
+
+				// a message waiting for an ack can't be replaced by a newer one
+				var conflate string
+				if server.Conflate && !acked {
+					conflate = subId
+				}
+
+				// frames with an ack id are unique to their recipient, so aren't worth preparing
+				if b.prepared == nil || acked {
+					body.retain()
+					b.deliveries = append(b.deliveries, delivery{client: client, subId: subId, conflate: conflate, header: header, body: body, expires: b.expires})
+					continue
 				}
+
+				key := destination + "\x00" + subId + "\x00" + contentType + "\x00" + client.Version
+				frame, ok := b.prepared[key]
+				if !ok {
+					var err error
+					frame, err = newPreparedFrame(bytes.Join([][]byte{header, body.bytes(), nullTerminator}, nil), body.binary)
+					if err != nil {
+						server.Sugar.Errorf("unable to prepare message: %v", err)
+						continue
+					}
+
+					b.prepared[key] = frame
+				}
+
+				b.deliveries = append(b.deliveries, delivery{client: client, subId: subId, conflate: conflate, prepared: frame, expires: b.expires})
 			}
 		}
 	}
+
+	if server.isQueue(destination) {
+		if chosen, ok := server.chooseQueueSubscriber(destination, b.check); ok {
+			add(map[uint64]map[string]*Client{chosen.client.Uid: {chosen.subId: chosen.client}}, chosen.wildcard)
+		}
+
+		return
+	}
+
+	shard := server.subscriptionShard(destination)
+	shard.mux.RLock()
+	add(shard.topics[destination], false)
+	shard.mux.RUnlock()
+
+	server.patterns.mux.RLock()
+	server.patterns.match(destination, func(subs map[uint64]map[string]*Client) {
+		add(subs, true)
+	})
+	server.patterns.mux.RUnlock()
+}
+
+func (server *Server) authorizeDelivery(client *Client, destination string, headers map[string]string) bool {
+	for _, handler := range server.deliveryHandlers {
+		if !handler(client, destination, headers) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sendToSubscription writes a MESSAGE to a single subscription of a single client.
+func (server *Server) sendToSubscription(client *Client, destination string, subId string, contentType string, body []byte, extraHeaders map[string]string) error {
+	extraHeaders = server.timeHeaders(server.applyTTL(extraHeaders))
+	expires := expiresAt(extraHeaders)
+	if expired(expires, server.clock().Now()) {
+		return nil
+	}
+
+	binary := false
+	if codec := server.codecFor(client, subId, contentType); codec != nil {
+		if encoded, err := transcode(codec, body); err != nil {
+			server.Sugar.Debugf("[%d] unable to encode message as %s: %v", client.Uid, codec.ContentType(), err)
+		} else {
+			body, contentType, binary = encoded, codec.ContentType(), true
+		}
+	}
+
+	headers := make(map[string]string, len(extraHeaders)+4)
+	for k, v := range extraHeaders {
+		headers[k] = v
+	}
+
+	headers["content-type"] = contentType
+	headers["subscription"] = subId
+	headers["destination"] = destination
+	headers["content-length"] = strconv.Itoa(len(body))
+
+	if !server.authorizeDelivery(client, destination, headers) {
+		return nil
+	}
+
+	message := StompMessage{
+		Command: Message,
+		Headers: headers,
+		Body:    &body,
+	}
+
+	return server.enqueue(client, outboundFrame{parts: [][]byte{message.payload(client.Version)}, binary: binary, expires: expires})
 }
 
 func (server *Server) SendMessage(topic string, contentType string, body string) {
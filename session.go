@@ -0,0 +1,17 @@
+package stomper
+
+// Set stores a value in the client's session, for later handlers and SendMessageWithCheck predicates to read.
+// It is safe to call from any goroutine, and the session lives as long as the connection.
+func (client *Client) Set(key string, value any) {
+	client.session.Store(key, value)
+}
+
+// Get returns a value stored with Set.
+func (client *Client) Get(key string) (any, bool) {
+	return client.session.Load(key)
+}
+
+// Delete removes a value from the client's session.
+func (client *Client) Delete(key string) {
+	client.session.Delete(key)
+}
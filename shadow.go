@@ -0,0 +1,111 @@
+package stomper
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// ShadowOfHeader names the destination a mirrored message was originally published to.
+const ShadowOfHeader = "shadow-of"
+
+// ShadowRule mirrors a percentage of the messages published to destinations matching Destination (a
+// DestinationTemplate) to a shadow destination and/or a webhook, for trying out new consumers on production
+// traffic. Shadow may reuse Destination's placeholders, e.g. `/topic/orders.{id}` to `/shadow/orders.{id}`.
+// Real subscribers are unaffected, and mirrored messages are not mirrored again.
+type ShadowRule struct {
+	Destination string
+	Shadow      string
+
+	// WebhookURL, when set, receives each mirrored message as a POST with the body and content type.
+	WebhookURL string
+
+	// Percentage of messages (0-100) to mirror.
+	Percentage float64
+}
+
+type shadowRule struct {
+	ShadowRule
+	template *DestinationTemplate
+	mapping  *DestinationMapping
+}
+
+var shadowWebhookClient = &http.Client{Timeout: 10 * time.Second}
+
+func (server *Server) parseShadowRules() []shadowRule {
+	var rules []shadowRule
+	for _, rule := range server.Shadows {
+		template, err := ParseDestinationTemplate(rule.Destination)
+		if err != nil {
+			server.Sugar.Warnf("invalid shadow rule (%s): %v", rule.Destination, err)
+			continue
+		}
+
+		parsed := shadowRule{ShadowRule: rule, template: template}
+		if rule.Shadow != "" {
+			if parsed.mapping, err = NewDestinationMapping(rule.Destination, rule.Shadow); err != nil {
+				server.Sugar.Warnf("invalid shadow rule (%s -> %s): %v", rule.Destination, rule.Shadow, err)
+				continue
+			}
+		}
+
+		rules = append(rules, parsed)
+	}
+
+	return rules
+}
+
+// shadow mirrors a published message according to the first shadow rule matching its destination.
+func (server *Server) shadow(topic string, contentType string, body string, extraHeaders map[string]string) {
+	for _, rule := range server.shadowRules {
+		if _, ok := rule.template.Match(topic); !ok {
+			continue
+		}
+
+		if rand.Float64()*100 >= rule.Percentage {
+			return
+		}
+
+		if rule.mapping != nil {
+			if destination, ok := rule.mapping.Map(topic); ok {
+				headers := make(map[string]string, len(extraHeaders)+1)
+				for k, v := range extraHeaders {
+					headers[k] = v
+				}
+
+				headers[ShadowOfHeader] = topic
+				server.broadcast(context.Background(), destination, contentType, body, headers, nil, nil, nil)
+			}
+		}
+
+		if rule.WebhookURL != "" {
+			go server.postShadow(rule.WebhookURL, topic, contentType, body)
+		}
+
+		return
+	}
+}
+
+func (server *Server) postShadow(url string, topic string, contentType string, body string) {
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+	if err != nil {
+		server.Sugar.Warnf("unable to create shadow webhook request: %v", err)
+		return
+	}
+
+	request.Header.Set("Content-Type", contentType)
+	request.Header.Set("Stomper-Shadow-Of", topic)
+
+	response, err := shadowWebhookClient.Do(request)
+	if err != nil {
+		server.Sugar.Warnf("unable to post shadow of '%s': %v", topic, err)
+		return
+	}
+
+	_ = response.Body.Close()
+	if response.StatusCode >= 300 {
+		server.Sugar.Warnf("shadow webhook for '%s' responded %d", topic, response.StatusCode)
+	}
+}
@@ -0,0 +1,125 @@
+package stomper
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// SlowConsumerPolicy decides what happens to a MESSAGE for a client whose outbound queue is full. Other
+// frames (RECEIPTs, ERRORs and the like) always wait for space.
+type SlowConsumerPolicy int
+
+const (
+	// SlowConsumerBlock waits for space in the queue, holding up the broadcast until the client catches up
+	// or WriteTimeout closes its connection.
+	SlowConsumerBlock SlowConsumerPolicy = iota
+
+	// SlowConsumerDropOldest discards the oldest queued message to make room.
+	SlowConsumerDropOldest
+
+	// SlowConsumerDropNewest discards the message which didn't fit.
+	SlowConsumerDropNewest
+
+	// SlowConsumerDisconnect discards everything queued and closes the connection with an ERROR.
+	SlowConsumerDisconnect
+)
+
+func (policy SlowConsumerPolicy) String() string {
+	switch policy {
+	case SlowConsumerDropOldest:
+		return "drop-oldest"
+	case SlowConsumerDropNewest:
+		return "drop-newest"
+	case SlowConsumerDisconnect:
+		return "disconnect"
+	}
+
+	return "block"
+}
+
+func (frame *outboundFrame) isMessage() bool {
+	return frame.flushed == nil && bytes.HasPrefix(frame.head(), messagePrefix)
+}
+
+// overflow applies the SlowConsumerPolicy to a message which didn't fit in the client's queue, returning
+// ErrBackpressure if it was dropped.
+func (server *Server) overflow(client *Client, frame outboundFrame) error {
+	server.metrics.slowConsumer(server.SlowConsumerPolicy)
+	switch server.SlowConsumerPolicy {
+	case SlowConsumerDropOldest:
+		select {
+		case oldest := <-client.outbound:
+			if !oldest.isMessage() {
+				// control frames aren't dropped, so this one goes to the back of the queue and the new message
+				// is dropped instead
+				frame.release()
+				select {
+				case client.outbound <- oldest:
+				case <-client.done:
+					oldest.release()
+				}
+
+				return ErrBackpressure
+			}
+
+			oldest.release()
+		default:
+		}
+
+		select {
+		case client.outbound <- frame:
+			return nil
+		default:
+			// another sender took the space
+			frame.release()
+			return ErrBackpressure
+		}
+	case SlowConsumerDisconnect:
+		frame.release()
+		server.evictSlowConsumer(client)
+		return ErrBackpressure
+	}
+
+	frame.release()
+	return ErrBackpressure
+}
+
+// evictSlowConsumer empties the client's queue and closes its connection once an ERROR has been written,
+// or after a second if it still isn't reading. Further messages for it are dropped.
+func (server *Server) evictSlowConsumer(client *Client) {
+	if !client.evicted.CompareAndSwap(false, true) {
+		return
+	}
+
+	server.Sugar.Warnf("[%d] disconnecting slow consumer with %d frames queued", client.Uid, len(client.outbound))
+	discardQueued(client)
+
+	err := &FrameError{
+		Code:    ErrorCodeSlowConsumer,
+		Message: fmt.Sprintf("outbound queue of %d frames is full", cap(client.outbound)),
+		Err:     ErrBackpressure,
+	}
+
+	server.reportError(client, err)
+	go func() {
+		server.sendFrameError(client, err, nil)
+		server.flush(client, time.Second)
+		_ = client.transport.Close()
+	}()
+}
+
+// discardQueued empties the client's outbound queue without writing anything.
+func discardQueued(client *Client) {
+	for {
+		select {
+		case frame := <-client.outbound:
+			frame.release()
+			if frame.flushed != nil {
+				close(frame.flushed)
+			}
+		default:
+			return
+		}
+	}
+}
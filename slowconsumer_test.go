@@ -0,0 +1,120 @@
+package stomper
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const slowConsumerMessages = 300
+
+// stalledSubscriber subscribes a client to /topic/flood and publishes more than its socket and outbound
+// queue can hold while it isn't reading. It returns the client, the numbers of the messages it then reads and
+// whatever other frame ended them.
+func stalledSubscriber(t *testing.T, policy SlowConsumerPolicy) (*Server, *testClient, []int, *StompMessage) {
+	t.Helper()
+	server, addr := newTestServer(t, func(server *Server) {
+		server.OutboundQueueSize = 4
+		server.CongestionHighWater = -1
+		server.SlowConsumerPolicy = policy
+	})
+
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("flood", "/topic/flood")
+
+	padding := strings.Repeat("x", 64*1024)
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 0; i < slowConsumerMessages; i++ {
+			server.SendMessage("/topic/flood", "text/plain", fmt.Sprintf("%d:%s", i, padding))
+		}
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected publishing to carry on past a stalled %s subscriber", policy)
+	}
+
+	var received []int
+	for {
+		frame, err := c.next(300 * time.Millisecond)
+		if err != nil || frame.Command != Message {
+			return server, c, received, frame
+		}
+
+		number, _, _ := strings.Cut(string(*frame.Body), ":")
+		n, _ := strconv.Atoi(number)
+		received = append(received, n)
+	}
+}
+
+func slowConsumerCount(server *Server, policy SlowConsumerPolicy) string {
+	var metrics strings.Builder
+	server.writeMetrics(&metrics)
+	prefix := fmt.Sprintf("stomper_slow_consumer_total{policy=\"%s\"} ", policy)
+	for _, line := range strings.Split(metrics.String(), "\n") {
+		if strings.HasPrefix(line, prefix) {
+			return strings.TrimPrefix(line, prefix)
+		}
+	}
+
+	return "0"
+}
+
+func TestSlowConsumerDropNewestKeepsTheOldest(t *testing.T) {
+	server, _, received, _ := stalledSubscriber(t, SlowConsumerDropNewest)
+	if len(received) == 0 || len(received) == slowConsumerMessages {
+		t.Fatalf("expected some but not all messages, got %d", len(received))
+	}
+
+	if received[0] != 0 || received[len(received)-1] == slowConsumerMessages-1 {
+		t.Fatalf("expected the first message kept and the last dropped, got %d to %d", received[0], received[len(received)-1])
+	}
+
+	for i := 1; i < len(received); i++ {
+		if received[i] <= received[i-1] {
+			t.Fatalf("expected messages in order, got %d after %d", received[i], received[i-1])
+		}
+	}
+
+	if slowConsumerCount(server, SlowConsumerDropNewest) == "0" {
+		t.Fatal("expected the drops to be counted")
+	}
+}
+
+func TestSlowConsumerDropOldestKeepsTheNewest(t *testing.T) {
+	server, _, received, _ := stalledSubscriber(t, SlowConsumerDropOldest)
+	if len(received) == 0 || len(received) == slowConsumerMessages {
+		t.Fatalf("expected some but not all messages, got %d", len(received))
+	}
+
+	if received[len(received)-1] != slowConsumerMessages-1 {
+		t.Fatalf("expected the last message kept, got %d", received[len(received)-1])
+	}
+
+	for i := 1; i < len(received); i++ {
+		if received[i] <= received[i-1] {
+			t.Fatalf("expected messages in order, got %d after %d", received[i], received[i-1])
+		}
+	}
+
+	if slowConsumerCount(server, SlowConsumerDropOldest) == "0" {
+		t.Fatal("expected the drops to be counted")
+	}
+}
+
+func TestSlowConsumerDisconnectSendsAnError(t *testing.T) {
+	server, c, received, last := stalledSubscriber(t, SlowConsumerDisconnect)
+	if last == nil || last.Command != Error || last.Headers["error-code"] != ErrorCodeSlowConsumer {
+		t.Fatalf("expected a slow-consumer ERROR after %d messages, got %v", len(received), last)
+	}
+
+	c.closed()
+	if slowConsumerCount(server, SlowConsumerDisconnect) == "0" {
+		t.Fatal("expected the eviction to be counted")
+	}
+}
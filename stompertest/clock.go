@@ -0,0 +1,177 @@
+// Package stompertest provides helpers for testing code built on stomper.
+package stompertest
+
+import (
+	"github.com/hfoxy/stomper"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FakeClock is a stomper.Clock whose time only moves when Advance or Set is called. Timers and tickers fire
+// synchronously during Advance, in deadline order.
+type FakeClock struct {
+	mux     sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+var _ stomper.Clock = (*FakeClock)(nil)
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (clock *FakeClock) Now() time.Time {
+	clock.mux.Lock()
+	defer clock.mux.Unlock()
+	return clock.now
+}
+
+// Sleep blocks until another goroutine advances the clock by at least d.
+func (clock *FakeClock) Sleep(d time.Duration) {
+	<-clock.NewTimer(d).C()
+}
+
+func (clock *FakeClock) NewTimer(d time.Duration) stomper.Timer {
+	return &fakeTimer{clock.newWaiter(d, false)}
+}
+
+func (clock *FakeClock) NewTicker(d time.Duration) stomper.Ticker {
+	if d <= 0 {
+		panic("stompertest: non-positive interval for NewTicker")
+	}
+
+	return &fakeTicker{clock.newWaiter(d, true)}
+}
+
+// Advance moves the clock forward, firing every timer and ticker that falls due on the way.
+func (clock *FakeClock) Advance(d time.Duration) {
+	clock.mux.Lock()
+	target := clock.now.Add(d)
+	clock.mux.Unlock()
+	clock.Set(target)
+}
+
+// Set moves the clock to t, firing every timer and ticker that falls due on the way. Time never moves backwards.
+func (clock *FakeClock) Set(t time.Time) {
+	clock.mux.Lock()
+	defer clock.mux.Unlock()
+
+	for {
+		sort.Slice(clock.waiters, func(i, j int) bool {
+			return clock.waiters[i].deadline.Before(clock.waiters[j].deadline)
+		})
+
+		if len(clock.waiters) == 0 || clock.waiters[0].deadline.After(t) {
+			break
+		}
+
+		waiter := clock.waiters[0]
+		if waiter.deadline.After(clock.now) {
+			clock.now = waiter.deadline
+		}
+
+		select {
+		case waiter.c <- clock.now:
+		default:
+		}
+
+		if waiter.period > 0 {
+			waiter.deadline = waiter.deadline.Add(waiter.period)
+		} else {
+			clock.remove(waiter)
+		}
+	}
+
+	if t.After(clock.now) {
+		clock.now = t
+	}
+}
+
+// Waiters reports how many timers and tickers are pending, useful to wait until code under test has armed one.
+func (clock *FakeClock) Waiters() int {
+	clock.mux.Lock()
+	defer clock.mux.Unlock()
+	return len(clock.waiters)
+}
+
+func (clock *FakeClock) newWaiter(d time.Duration, repeat bool) *fakeWaiter {
+	clock.mux.Lock()
+	defer clock.mux.Unlock()
+
+	waiter := &fakeWaiter{clock: clock, c: make(chan time.Time, 1), deadline: clock.now.Add(d)}
+	if repeat {
+		waiter.period = d
+	}
+
+	clock.waiters = append(clock.waiters, waiter)
+	return waiter
+}
+
+// remove drops a waiter, reporting whether it was pending; callers must hold the lock.
+func (clock *FakeClock) remove(waiter *fakeWaiter) bool {
+	for i, w := range clock.waiters {
+		if w == waiter {
+			clock.waiters = append(clock.waiters[:i], clock.waiters[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+type fakeWaiter struct {
+	clock    *FakeClock
+	c        chan time.Time
+	deadline time.Time
+	period   time.Duration
+}
+
+func (waiter *fakeWaiter) C() <-chan time.Time {
+	return waiter.c
+}
+
+func (waiter *fakeWaiter) stop() bool {
+	waiter.clock.mux.Lock()
+	defer waiter.clock.mux.Unlock()
+	return waiter.clock.remove(waiter)
+}
+
+func (waiter *fakeWaiter) reset(d time.Duration) bool {
+	waiter.clock.mux.Lock()
+	defer waiter.clock.mux.Unlock()
+
+	active := waiter.clock.remove(waiter)
+	waiter.deadline = waiter.clock.now.Add(d)
+	if waiter.period > 0 {
+		waiter.period = d
+	}
+
+	waiter.clock.waiters = append(waiter.clock.waiters, waiter)
+	return active
+}
+
+type fakeTimer struct {
+	*fakeWaiter
+}
+
+func (timer *fakeTimer) Stop() bool {
+	return timer.stop()
+}
+
+func (timer *fakeTimer) Reset(d time.Duration) bool {
+	return timer.reset(d)
+}
+
+type fakeTicker struct {
+	*fakeWaiter
+}
+
+func (ticker *fakeTicker) Stop() {
+	ticker.stop()
+}
+
+func (ticker *fakeTicker) Reset(d time.Duration) {
+	ticker.reset(d)
+}
@@ -0,0 +1,82 @@
+package stompertest
+
+import (
+	"testing"
+	"time"
+)
+
+func fired(c <-chan time.Time) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFakeClockFiresTimersOnAdvance(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	clock := NewFakeClock(start)
+	timer := clock.NewTimer(time.Second)
+	stopped := clock.NewTimer(time.Second)
+	if !stopped.Stop() {
+		t.Fatal("expected Stop to report a pending timer")
+	}
+
+	clock.Advance(999 * time.Millisecond)
+	if fired(timer.C()) {
+		t.Fatal("expected the timer not to fire early")
+	}
+
+	clock.Advance(time.Millisecond)
+	if !fired(timer.C()) || fired(stopped.C()) {
+		t.Fatal("expected only the running timer to fire")
+	}
+
+	if !clock.Now().Equal(start.Add(time.Second)) || clock.Waiters() != 0 {
+		t.Fatalf("unexpected clock state at %s with %d waiters", clock.Now(), clock.Waiters())
+	}
+}
+
+func TestFakeClockTickerRepeats(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	ticker := clock.NewTicker(time.Second)
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Second)
+		if !fired(ticker.C()) {
+			t.Fatalf("expected tick %d", i+1)
+		}
+	}
+
+	ticker.Reset(5 * time.Second)
+	clock.Advance(4 * time.Second)
+	if fired(ticker.C()) {
+		t.Fatal("expected the reset ticker to wait its new interval")
+	}
+
+	clock.Advance(time.Second)
+	ticker.Stop()
+	if !fired(ticker.C()) || clock.Waiters() != 0 {
+		t.Fatal("expected a tick at the new interval and nothing pending after Stop")
+	}
+}
+
+func TestFakeClockSleep(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	done := make(chan struct{})
+	go func() {
+		clock.Sleep(time.Minute)
+		close(done)
+	}()
+
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	clock.Advance(time.Minute)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected Sleep to return once the clock moved on")
+	}
+}
@@ -0,0 +1,121 @@
+package stompertest
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/hfoxy/stomper"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Subscriber is a STOMP 1.2 client subscribed to one destination over TCP, for tests which check what a
+// server broadcasts.
+type Subscriber struct {
+	t      testing.TB
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Subscribe serves TCP clients for server on a local port, and connects a Subscriber to it which has
+// subscribed to destination by the time it returns. The server must be set up first; everything is closed
+// when the test ends.
+func Subscribe(t testing.TB, server *stomper.Server, destination string) *Subscriber {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go server.ServeTCP(listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unable to dial: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	subscriber := &Subscriber{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	subscriber.write("CONNECT\naccept-version:1.2\n\n\x00")
+	subscriber.expect(stomper.Connected)
+	subscriber.write("SUBSCRIBE\nid:0\ndestination:" + destination + "\nreceipt:subscribed\n\n\x00")
+	subscriber.expect(stomper.Receipt)
+	return subscriber
+}
+
+// Message returns the next MESSAGE, failing the test if none arrives within a second.
+func (subscriber *Subscriber) Message() *stomper.StompMessage {
+	subscriber.t.Helper()
+	return subscriber.expect(stomper.Message)
+}
+
+// Quiet fails the test if a frame arrives within d.
+func (subscriber *Subscriber) Quiet(d time.Duration) {
+	subscriber.t.Helper()
+	if frame, err := subscriber.Next(d); err == nil {
+		subscriber.t.Fatalf("expected no frame, got %s %v", frame.Command, frame.Headers)
+	}
+}
+
+// Next returns the next frame to arrive within d, skipping heart-beats.
+func (subscriber *Subscriber) Next(d time.Duration) (*stomper.StompMessage, error) {
+	_ = subscriber.conn.SetReadDeadline(time.Now().Add(d))
+	for {
+		raw, err := subscriber.reader.ReadBytes(0)
+		if err != nil {
+			return nil, err
+		}
+
+		raw = bytes.TrimLeft(raw, "\r\n")
+		if len(raw) > 1 {
+			return parseFrame(raw), nil
+		}
+	}
+}
+
+func (subscriber *Subscriber) expect(command stomper.StompCommand) *stomper.StompMessage {
+	subscriber.t.Helper()
+	frame, err := subscriber.Next(time.Second)
+	if err != nil {
+		subscriber.t.Fatalf("expected %s: %v", command, err)
+	}
+
+	if frame.Command != command {
+		subscriber.t.Fatalf("expected %s, got %s %v", command, frame.Command, frame.Headers)
+	}
+
+	return frame
+}
+
+func (subscriber *Subscriber) write(frame string) {
+	subscriber.t.Helper()
+	if _, err := subscriber.conn.Write([]byte(frame)); err != nil {
+		subscriber.t.Fatalf("unable to write: %v", err)
+	}
+}
+
+var headerUnescaper = strings.NewReplacer(`\r`, "\r", `\n`, "\n", `\c`, ":", `\\`, `\`)
+
+// parseFrame parses a STOMP 1.2 frame, keeping the first of any repeated header as the spec requires.
+func parseFrame(raw []byte) *stomper.StompMessage {
+	head, body, _ := bytes.Cut(bytes.TrimSuffix(raw, []byte{0}), []byte("\n\n"))
+	lines := strings.Split(string(head), "\n")
+	headers := make(map[string]string)
+	for _, line := range lines[1:] {
+		name, value, _ := strings.Cut(strings.TrimSuffix(line, "\r"), ":")
+		name = headerUnescaper.Replace(name)
+		if _, ok := headers[name]; !ok {
+			headers[name] = headerUnescaper.Replace(value)
+		}
+	}
+
+	return &stomper.StompMessage{Command: stomper.StompCommand(strings.TrimSuffix(lines[0], "\r")), Headers: headers, Body: &body}
+}
@@ -0,0 +1,251 @@
+package stomper
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+const subscriptionShardCount = 32
+
+// subscriptionShard holds the subscriptions for the topics that hash to it, so broadcasts to different topics
+// don't contend with each other or with subscribes elsewhere.
+type subscriptionShard struct {
+	mux    sync.RWMutex
+	topics map[string]map[uint64]map[string]*Client
+}
+
+// subscriptionIndex maps each client's subscription ids to the topics they're on, so an UNSUBSCRIBE or a
+// disconnect only locks the shards holding that client's subscriptions.
+type subscriptionIndex struct {
+	mux      sync.Mutex
+	byClient map[uint64]map[string]map[string]bool
+}
+
+func (index *subscriptionIndex) add(client *Client, topic string, subId string) {
+	index.mux.Lock()
+	defer index.mux.Unlock()
+
+	if index.byClient == nil {
+		index.byClient = make(map[uint64]map[string]map[string]bool)
+	}
+
+	subs, ok := index.byClient[client.Uid]
+	if !ok {
+		subs = make(map[string]map[string]bool)
+		index.byClient[client.Uid] = subs
+	}
+
+	if subs[subId] == nil {
+		subs[subId] = make(map[string]bool)
+	}
+
+	subs[subId][topic] = true
+}
+
+func (index *subscriptionIndex) delete(client *Client, topic string, subId string) {
+	index.mux.Lock()
+	defer index.mux.Unlock()
+
+	subs := index.byClient[client.Uid]
+	delete(subs[subId], topic)
+	if len(subs[subId]) == 0 {
+		delete(subs, subId)
+	}
+
+	if len(subs) == 0 {
+		delete(index.byClient, client.Uid)
+	}
+}
+
+// destination returns a topic a client subscribed to with subId, or "" when it has no such subscription.
+func (index *subscriptionIndex) destination(client *Client, subId string) string {
+	index.mux.Lock()
+	defer index.mux.Unlock()
+
+	for topic := range index.byClient[client.Uid][subId] {
+		return topic
+	}
+
+	return ""
+}
+
+// takeClient removes a client from the index, returning its subscription ids and the topics they were on.
+func (index *subscriptionIndex) takeClient(client *Client) map[string]map[string]bool {
+	index.mux.Lock()
+	defer index.mux.Unlock()
+
+	subs := index.byClient[client.Uid]
+	delete(index.byClient, client.Uid)
+	return subs
+}
+
+func newSubscriptionShards() []*subscriptionShard {
+	shards := make([]*subscriptionShard, subscriptionShardCount)
+	for i := range shards {
+		shards[i] = &subscriptionShard{topics: make(map[string]map[uint64]map[string]*Client)}
+	}
+
+	return shards
+}
+
+func (server *Server) subscriptionShard(topic string) *subscriptionShard {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(topic))
+	return server.subscriptions[hash.Sum32()%uint32(len(server.subscriptions))]
+}
+
+// add records a subscription; callers must hold the shard's write lock.
+func (shard *subscriptionShard) add(client *Client, topic string, subId string) {
+	subs, ok := shard.topics[topic]
+	if !ok {
+		subs = make(map[uint64]map[string]*Client)
+		shard.topics[topic] = subs
+	}
+
+	clientSubs, ok := subs[client.Uid]
+	if !ok {
+		clientSubs = make(map[string]*Client)
+		subs[client.Uid] = clientSubs
+	}
+
+	clientSubs[subId] = client
+}
+
+// delete removes one subscription, cleaning up emptied maps; callers must hold the shard's write lock.
+func (shard *subscriptionShard) delete(client *Client, topic string, subId string) bool {
+	subs, ok := shard.topics[topic]
+	if !ok {
+		return false
+	}
+
+	clientSubs, ok := subs[client.Uid]
+	if !ok {
+		return false
+	}
+
+	if _, ok = clientSubs[subId]; !ok {
+		return false
+	}
+
+	delete(clientSubs, subId)
+	if len(clientSubs) == 0 {
+		delete(subs, client.Uid)
+	}
+
+	if len(subs) == 0 {
+		delete(shard.topics, topic)
+	}
+
+	return true
+}
+
+// subscriptionIds returns the ids of a client's subscriptions to topic, including wildcard subscriptions
+// matching it.
+func (server *Server) subscriptionIds(client *Client, topic string) []string {
+	var subIds []string
+	shard := server.subscriptionShard(topic)
+	shard.mux.RLock()
+	for subId := range shard.topics[topic][client.Uid] {
+		subIds = append(subIds, subId)
+	}
+	shard.mux.RUnlock()
+
+	server.patterns.mux.RLock()
+	server.patterns.match(topic, func(subs map[uint64]map[string]*Client) {
+		for subId := range subs[client.Uid] {
+			subIds = append(subIds, subId)
+		}
+	})
+	server.patterns.mux.RUnlock()
+
+	return subIds
+}
+
+// subscribe records a subscription, in the pattern index if the destination has wildcards. The first
+// subscription to a destination subscribes upstream with its headers.
+func (server *Server) subscribe(client *Client, topic string, subId string, headers map[string]string) {
+	if isWildcard(topic) {
+		server.patterns.mux.Lock()
+		server.patterns.add(client, topic, subId)
+		server.patterns.mux.Unlock()
+		return
+	}
+
+	shard := server.subscriptionShard(topic)
+	shard.mux.Lock()
+	first := shard.topics[topic] == nil
+	shard.add(client, topic, subId)
+	if first {
+		server.subscribeUpstream(topic, headers)
+	}
+	shard.mux.Unlock()
+
+	server.subscriptionIndex.add(client, topic, subId)
+}
+
+// unsubscribe removes one subscription to topic, reporting whether it existed.
+func (server *Server) unsubscribe(client *Client, topic string, subId string) bool {
+	if isWildcard(topic) {
+		server.patterns.mux.Lock()
+		defer server.patterns.mux.Unlock()
+		if server.patterns.byClient[client.Uid][subId] != topic {
+			return false
+		}
+
+		_, removed := server.patterns.delete(client, subId)
+		return removed
+	}
+
+	removed := server.deleteSubscription(client, topic, subId)
+	if removed {
+		server.subscriptionIndex.delete(client, topic, subId)
+	}
+
+	return removed
+}
+
+// deleteSubscription removes one subscription from its topic's shard, unsubscribing upstream and forgetting
+// the topic's queue cursor when it was the topic's last.
+func (server *Server) deleteSubscription(client *Client, topic string, subId string) bool {
+	shard := server.subscriptionShard(topic)
+	shard.mux.Lock()
+	defer shard.mux.Unlock()
+
+	removed := shard.delete(client, topic, subId)
+	if removed && shard.topics[topic] == nil {
+		server.unsubscribeUpstream(topic)
+		server.queues.cursors.Delete(topic)
+	}
+
+	return removed
+}
+
+// endSubscription removes a client's subscription to topic along with everything kept for it, reporting
+// whether it existed. Every way a subscription ends, from UNSUBSCRIBE to an administrator, goes through here.
+func (server *Server) endSubscription(client *Client, topic string, subId string) bool {
+	server.cancelExpiry(client, subId)
+	if !server.unsubscribe(client, topic, subId) {
+		return false
+	}
+
+	server.stopQuery(client, subId)
+	client.accepts.Delete(subId)
+	client.ackModes.Delete(subId)
+	server.dropAcks(client, subId)
+	server.stopDigest(client, subId)
+	client.dropReplay(subId)
+	client.conflation.Delete(subId)
+	return true
+}
+
+// subscriptionDestination returns the destination, or wildcard pattern, a client subscribed to with subId.
+func (server *Server) subscriptionDestination(client *Client, subId string) string {
+	server.patterns.mux.RLock()
+	pattern, ok := server.patterns.byClient[client.Uid][subId]
+	server.patterns.mux.RUnlock()
+	if ok {
+		return pattern
+	}
+
+	return server.subscriptionIndex.destination(client, subId)
+}
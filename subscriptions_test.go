@@ -0,0 +1,103 @@
+package stomper
+
+import (
+	"testing"
+	"time"
+)
+
+// busyShard returns a topic on a different shard to topic, with that shard's write lock held until the test ends.
+func busyShard(t *testing.T, server *Server, topic string) string {
+	t.Helper()
+	for i := 0; ; i++ {
+		other := "/topic/busy." + string(rune('a'+i))
+		if shard := server.subscriptionShard(other); shard != server.subscriptionShard(topic) {
+			shard.mux.Lock()
+			t.Cleanup(shard.mux.Unlock)
+			return other
+		}
+	}
+}
+
+func TestUnsubscribeOnlyLocksTheSubscriptionsShard(t *testing.T) {
+	server, addr := newTestServer(t, nil)
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("0", "/topic/prices")
+	c.subscribe("1", "/topic/news")
+
+	busyShard(t, server, "/topic/prices")
+	c.send("UNSUBSCRIBE", []string{"id:0", "receipt:unsub-0"}, "")
+	if frame, err := c.next(time.Second); err != nil || frame.Command != Receipt {
+		t.Fatalf("expected the UNSUBSCRIBE to complete while another shard is busy, got %v (%v)", frame, err)
+	}
+
+	shard := server.subscriptionShard("/topic/prices")
+	shard.mux.RLock()
+	defer shard.mux.RUnlock()
+	if subs := shard.topics["/topic/prices"]; len(subs) != 0 {
+		t.Fatalf("expected the subscription to be removed, got %v", subs)
+	}
+}
+
+func TestDisconnectRemovesEverySubscription(t *testing.T) {
+	server, addr := newTestServer(t, nil)
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("0", "/topic/prices")
+	c.subscribe("1", "/topic/news")
+	c.subscribe("2", "/topic/prices.*")
+
+	_ = c.conn.Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		server.clientMux.RLock()
+		clients := len(server.clients)
+		server.clientMux.RUnlock()
+		if clients == 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("expected the client to be removed")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, shard := range server.subscriptions {
+		shard.mux.RLock()
+		topics := len(shard.topics)
+		shard.mux.RUnlock()
+		if topics != 0 {
+			t.Fatalf("expected no subscriptions left, got %d topics in a shard", topics)
+		}
+	}
+
+	server.subscriptionIndex.mux.Lock()
+	defer server.subscriptionIndex.mux.Unlock()
+	if len(server.subscriptionIndex.byClient) != 0 {
+		t.Fatalf("expected the index to be empty, got %v", server.subscriptionIndex.byClient)
+	}
+}
+
+func TestUnsubscribeHandlersAreGivenTheSubscriptionsDestination(t *testing.T) {
+	ended := make(chan string, 2)
+	_, addr := newTestServer(t, func(server *Server) {
+		_ = server.AddUnsubscribeHandler(func(client *Client, destination string) {
+			ended <- destination
+		})
+	})
+
+	c := dialTestClient(t, addr).connect()
+	c.subscribe("room", "/topic/chat.lobby")
+	c.subscribe("rooms", "/topic/chat.*")
+	for id, want := range map[string]string{"room": "/topic/chat.lobby", "rooms": "/topic/chat.*"} {
+		c.send("UNSUBSCRIBE", []string{"id:" + id}, "")
+		select {
+		case destination := <-ended:
+			if destination != want {
+				t.Fatalf("expected the handler to be given %s, got %q", want, destination)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected the unsubscribe handler to be called")
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package stomper
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const tlsHandshakeTimeout = 10 * time.Second
+
+// ListenTCP accepts STOMP clients over plain TCP on addr, for clients that don't speak websocket. They share
+// handlers, clients and subscriptions with those connected through WssHandler. It blocks like
+// http.ListenAndServe.
+func (server *Server) ListenTCP(addr string) error {
+	return server.ListenTLS(addr, nil)
+}
+
+// ListenTLS is ListenTCP over TLS; with a MutualTLSConfig, client certificates are available on
+// Client.VerifiedChains.
+func (server *Server) ListenTLS(addr string, config *tls.Config) error {
+	listener, err := Listen(addr, config)
+	if err != nil {
+		return err
+	}
+
+	return server.ServeTCP(listener)
+}
+
+// ServeTCP accepts STOMP clients on a listener, e.g. one from Listen or a ProxyProtocolListener, until it fails.
+func (server *Server) ServeTCP(listener net.Listener) error {
+	defer listener.Close()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return fmt.Errorf("unable to accept connection: %w", err)
+		}
+
+		go server.serveConn(conn)
+	}
+}
+
+func (server *Server) serveConn(conn net.Conn) {
+	server.init()
+	if !server.setup {
+		server.Sugar.Errorf("unable to accept connection: %v", ErrNotSetup)
+		_ = conn.Close()
+		return
+	}
+
+	// handlers written for websocket clients see the TCP connection as a request without headers
+	request := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{},
+		Header:     make(http.Header),
+		RemoteAddr: conn.RemoteAddr().String(),
+	}
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		_ = conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			server.Sugar.Debugf("tls handshake with %s failed: %v", request.RemoteAddr, err)
+			_ = conn.Close()
+			return
+		}
+
+		_ = conn.SetDeadline(time.Time{})
+		state := tlsConn.ConnectionState()
+		request.TLS = &state
+	}
+
+	ip := server.clientIP(request)
+	if status, reason := server.refuse(request, ip); status != 0 {
+		server.Sugar.Debugf("refused tcp connection from %s: %s", ip, reason)
+		_ = conn.Close()
+		return
+	}
+
+	client := newClient(newTCPTransport(conn), server.clientUid.Add(1), ip, make(map[string]string), server.clock().Now(), server.outboundQueueSize())
+	if request.TLS != nil {
+		client.VerifiedChains = request.TLS.VerifiedChains
+	}
+
+	server.trackSession(client)
+	go server.writePump(client)
+	server.clientHandler(client, request)
+}
@@ -0,0 +1,176 @@
+package stomper
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TicketHeader on CONNECT carries a ticket issued by a TicketAuthenticator.
+const TicketHeader = "ticket"
+
+const defaultTicketTTL = 30 * time.Second
+
+// TicketAuthenticator is an Authenticator for browsers, which can't set headers on a websocket upgrade: the
+// page first asks its ServeHTTP endpoint (e.g. mounted at /ws-token) for a ticket, authenticated by whatever
+// the page's HTTP requests already carry, such as a session cookie or Authorization header, and then
+// presents the ticket in the ticket header of CONNECT. Tickets are single use and short-lived.
+type TicketAuthenticator struct {
+	// Identify authenticates a ticket request, returning who the ticket is for; returning an error refuses it
+	Identify func(*http.Request) (*Identity, error)
+
+	// TTL is how long a ticket may be redeemed for after it's issued (default 30s)
+	TTL time.Duration
+
+	// AllowedOrigins may request tickets from another origin with credentials, e.g. https://app.example.com,
+	// and may contain a `*` as for Server.AllowedOrigins
+	AllowedOrigins []string
+
+	// Next, when set, authenticates CONNECTs without a ticket, e.g. login and passcode from native clients
+	Next Authenticator
+
+	// Clock times tickets; Setup gives it the server's when it's the server's Authenticator, and otherwise
+	// nil uses SystemClock
+	Clock Clock
+
+	mux     sync.Mutex
+	tickets map[string]issuedTicket
+}
+
+type issuedTicket struct {
+	identity  *Identity
+	expiresAt time.Time
+}
+
+type ticketResponse struct {
+	Ticket    string `json:"ticket"`
+	ExpiresIn int    `json:"expiresIn"`
+}
+
+// ServeHTTP issues a ticket to an identified request, as JSON: {"ticket": "...", "expiresIn": 30}.
+func (auth *TicketAuthenticator) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if origin := request.Header.Get("Origin"); origin != "" && auth.allowOrigin(origin) {
+		writer.Header().Set("Access-Control-Allow-Origin", origin)
+		writer.Header().Set("Access-Control-Allow-Credentials", "true")
+		writer.Header().Add("Vary", "Origin")
+	}
+
+	switch request.Method {
+	case http.MethodOptions:
+		writer.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+		writer.Header().Set("Access-Control-Allow-Headers", "Authorization")
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodGet, http.MethodPost:
+	default:
+		http.Error(writer, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if auth.Identify == nil {
+		http.Error(writer, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	identity, err := auth.Identify(request)
+	if err != nil || identity == nil {
+		http.Error(writer, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	ticket, ttl, err := auth.issue(identity)
+	if err != nil {
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(writer).Encode(ticketResponse{Ticket: ticket, ExpiresIn: int(ttl / time.Second)})
+}
+
+func (auth *TicketAuthenticator) allowOrigin(origin string) bool {
+	for _, allowed := range auth.AllowedOrigins {
+		if matchOrigin(allowed, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// issue stores a new ticket for identity, sweeping out expired ones.
+func (auth *TicketAuthenticator) issue(identity *Identity) (string, time.Duration, error) {
+	data := make([]byte, 24)
+	if _, err := rand.Read(data); err != nil {
+		return "", 0, err
+	}
+
+	ticket := base64.RawURLEncoding.EncodeToString(data)
+	ttl := auth.TTL
+	if ttl <= 0 {
+		ttl = defaultTicketTTL
+	}
+
+	now := auth.clock().Now()
+	auth.mux.Lock()
+	defer auth.mux.Unlock()
+	if auth.tickets == nil {
+		auth.tickets = make(map[string]issuedTicket)
+	}
+
+	for key, issued := range auth.tickets {
+		if now.After(issued.expiresAt) {
+			delete(auth.tickets, key)
+		}
+	}
+
+	auth.tickets[ticket] = issuedTicket{identity: identity, expiresAt: now.Add(ttl)}
+	return ticket, ttl, nil
+}
+
+// Authenticate redeems the CONNECT's ticket, which can't be used again, or passes CONNECTs without one to
+// Next.
+func (auth *TicketAuthenticator) Authenticate(client *Client, credentials Credentials) (*Identity, error) {
+	ticket := credentials.Headers[TicketHeader]
+	if ticket == "" {
+		if auth.Next != nil {
+			return auth.Next.Authenticate(client, credentials)
+		}
+
+		return nil, fmt.Errorf("missing ticket")
+	}
+
+	auth.mux.Lock()
+	issued, ok := auth.tickets[ticket]
+	delete(auth.tickets, ticket)
+	auth.mux.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown ticket")
+	}
+
+	if auth.clock().Now().After(issued.expiresAt) {
+		return nil, fmt.Errorf("ticket expired")
+	}
+
+	return issued.identity, nil
+}
+
+func (auth *TicketAuthenticator) clock() Clock {
+	if auth.Clock == nil {
+		return SystemClock
+	}
+
+	return auth.Clock
+}
+
+func (auth *TicketAuthenticator) useClock(clock Clock) {
+	if auth.Clock == nil {
+		auth.Clock = clock
+	}
+}
@@ -0,0 +1,115 @@
+package stomper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// requestTicket asks a ticket endpoint for a ticket with the given session cookie, returning the status and
+// the ticket.
+func requestTicket(t *testing.T, url string, session string) (int, string) {
+	t.Helper()
+	request, _ := http.NewRequest(http.MethodPost, url, nil)
+	if session != "" {
+		request.AddCookie(&http.Cookie{Name: "session", Value: session})
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("unable to request a ticket: %v", err)
+	}
+
+	defer response.Body.Close()
+	var body ticketResponse
+	if response.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+			t.Fatalf("unable to decode the ticket: %v", err)
+		}
+	}
+
+	return response.StatusCode, body.Ticket
+}
+
+func TestTicketsAuthenticateConnect(t *testing.T) {
+	auth := &TicketAuthenticator{
+		Identify: func(request *http.Request) (*Identity, error) {
+			if cookie, err := request.Cookie("session"); err == nil && cookie.Value == "s3cret" {
+				return &Identity{Principal: "alice"}, nil
+			}
+
+			return nil, fmt.Errorf("no session")
+		},
+		Next: AuthenticatorFunc(func(client *Client, credentials Credentials) (*Identity, error) {
+			if credentials.Login == "native" {
+				return &Identity{Principal: "native"}, nil
+			}
+
+			return nil, ErrUnauthorized
+		}),
+	}
+
+	server, addr := newTestServer(t, func(server *Server) {
+		server.Authenticator = auth
+	})
+
+	endpoint := httptest.NewServer(auth)
+	t.Cleanup(endpoint.Close)
+
+	if status, _ := requestTicket(t, endpoint.URL, "guessed"); status != http.StatusUnauthorized {
+		t.Fatalf("expected a ticket request without a session to be refused, got %d", status)
+	}
+
+	status, ticket := requestTicket(t, endpoint.URL, "s3cret")
+	if status != http.StatusOK || ticket == "" {
+		t.Fatalf("expected a ticket, got %d %q", status, ticket)
+	}
+
+	dialTestClient(t, addr).connect(TicketHeader + ":" + ticket)
+	if identity := onlyClient(t, server).Identity; identity == nil || identity.Principal != "alice" {
+		t.Fatalf("expected the ticket's identity, got %+v", identity)
+	}
+
+	replayed := dialTestClient(t, addr)
+	replayed.send("CONNECT", []string{"accept-version:1.2", TicketHeader + ":" + ticket}, "")
+	if frame := replayed.read(); frame.Command != Error {
+		t.Fatalf("expected a redeemed ticket to be refused, got %s %v", frame.Command, frame.Headers)
+	}
+
+	replayed.closed()
+
+	forged := dialTestClient(t, addr)
+	forged.send("CONNECT", []string{"accept-version:1.2", TicketHeader + ":forged"}, "")
+	if frame := forged.read(); frame.Command != Error {
+		t.Fatalf("expected an unknown ticket to be refused, got %s %v", frame.Command, frame.Headers)
+	}
+
+	forged.closed()
+
+	// CONNECTs without a ticket fall through to Next
+	dialTestClient(t, addr).connect("login:native")
+}
+
+func TestTicketEndpointAllowsConfiguredOrigins(t *testing.T) {
+	auth := &TicketAuthenticator{AllowedOrigins: []string{"https://*.example.com"}}
+	for origin, allowed := range map[string]bool{
+		"https://app.example.com":  true,
+		"https://evil.example.org": false,
+	} {
+		request := httptest.NewRequest(http.MethodOptions, "/ws-token", nil)
+		request.Header.Set("Origin", origin)
+		recorder := httptest.NewRecorder()
+		auth.ServeHTTP(recorder, request)
+
+		if recorder.Code != http.StatusNoContent {
+			t.Fatalf("%s: expected the preflight answered, got %d", origin, recorder.Code)
+		}
+
+		got := recorder.Header().Get("Access-Control-Allow-Origin") == origin
+		if got != allowed || (allowed && recorder.Header().Get("Access-Control-Allow-Credentials") != "true") {
+			t.Errorf("%s: expected allowed %v, got headers %v", origin, allowed, recorder.Header())
+		}
+	}
+}
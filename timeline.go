@@ -0,0 +1,243 @@
+package stomper
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	SessionEventConnect     = "connect"
+	SessionEventSubscribe   = "subscribe"
+	SessionEventUnsubscribe = "unsubscribe"
+	SessionEventError       = "error"
+	SessionEventDisconnect  = "disconnect"
+)
+
+const (
+	defaultSessionRetention = 7 * 24 * time.Hour
+	maxSessionEvents        = 256
+)
+
+// SessionEvent is one entry of a session's timeline.
+type SessionEvent struct {
+	Time        time.Time `json:"time"`
+	Kind        string    `json:"kind"`
+	Destination string    `json:"destination,omitempty"`
+	Detail      string    `json:"detail,omitempty"`
+}
+
+// SessionTimeline is what happened to one connection, saved to Server.SessionStore once it ends so support
+// engineers can look it up by session id (the client's Uid) afterwards.
+type SessionTimeline struct {
+	Session    uint64    `json:"session"`
+	RemoteAddr string    `json:"remoteAddr"`
+	Principal  string    `json:"principal,omitempty"`
+	Started    time.Time `json:"started"`
+	Ended      time.Time `json:"ended"`
+	Reason     string    `json:"reason"`
+
+	FramesIn  uint64 `json:"framesIn"`
+	FramesOut uint64 `json:"framesOut"`
+	Errors    int    `json:"errors"`
+
+	// Events are the first maxSessionEvents events, with Dropped counting any after them
+	Events  []SessionEvent `json:"events"`
+	Dropped int            `json:"dropped,omitempty"`
+}
+
+// SessionStore persists session timelines. Session returns false for sessions it doesn't have, including
+// those it has expired.
+type SessionStore interface {
+	SaveSession(timeline SessionTimeline) error
+	Session(id uint64) (SessionTimeline, bool, error)
+}
+
+// MemorySessionStore keeps timelines in memory for Retention (default 7 days).
+type MemorySessionStore struct {
+	Retention time.Duration
+
+	// Clock ages timelines; Setup gives it the server's, and otherwise nil uses SystemClock
+	Clock Clock
+
+	mux      sync.Mutex
+	sessions map[uint64]SessionTimeline
+}
+
+func (store *MemorySessionStore) retention() time.Duration {
+	if store.Retention > 0 {
+		return store.Retention
+	}
+
+	return defaultSessionRetention
+}
+
+func (store *MemorySessionStore) clock() Clock {
+	if store.Clock == nil {
+		return SystemClock
+	}
+
+	return store.Clock
+}
+
+func (store *MemorySessionStore) useClock(clock Clock) {
+	if store.Clock == nil {
+		store.Clock = clock
+	}
+}
+
+func (store *MemorySessionStore) SaveSession(timeline SessionTimeline) error {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	if store.sessions == nil {
+		store.sessions = make(map[uint64]SessionTimeline)
+	}
+
+	now := store.clock().Now()
+	for id, saved := range store.sessions {
+		if now.Sub(saved.Ended) > store.retention() {
+			delete(store.sessions, id)
+		}
+	}
+
+	store.sessions[timeline.Session] = timeline
+	return nil
+}
+
+func (store *MemorySessionStore) Session(id uint64) (SessionTimeline, bool, error) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	timeline, ok := store.sessions[id]
+	if !ok || store.clock().Now().Sub(timeline.Ended) > store.retention() {
+		return SessionTimeline{}, false, nil
+	}
+
+	return timeline, true, nil
+}
+
+// sessionTimeline collects a connected client's timeline; it's nil without a SessionStore.
+type sessionTimeline struct {
+	framesIn  atomic.Uint64
+	framesOut atomic.Uint64
+
+	mux      sync.Mutex
+	timeline SessionTimeline
+	lastErr  string
+	closed   bool
+}
+
+// trackSession starts the client's timeline, before any frames are read or written.
+func (server *Server) trackSession(client *Client) {
+	if server.SessionStore == nil {
+		return
+	}
+
+	client.timeline = &sessionTimeline{timeline: SessionTimeline{
+		Session:    client.Uid,
+		RemoteAddr: client.RemoteAddr,
+		Started:    server.clock().Now(),
+	}}
+}
+
+func (timeline *sessionTimeline) add(now time.Time, kind string, destination string, detail string) {
+	timeline.mux.Lock()
+	defer timeline.mux.Unlock()
+	switch kind {
+	case SessionEventError:
+		timeline.timeline.Errors++
+		timeline.lastErr = detail
+	case SessionEventDisconnect:
+		timeline.closed = true
+	}
+
+	if len(timeline.timeline.Events) >= maxSessionEvents {
+		timeline.timeline.Dropped++
+		return
+	}
+
+	timeline.timeline.Events = append(timeline.timeline.Events, SessionEvent{Time: now, Kind: kind, Destination: destination, Detail: detail})
+}
+
+// sessionEvent adds an event to the client's timeline, if it has one.
+func (server *Server) sessionEvent(client *Client, kind string, destination string, detail string) {
+	if client.timeline != nil {
+		client.timeline.add(server.clock().Now(), kind, destination, detail)
+	}
+}
+
+// saveSession ends the client's timeline and saves it to the SessionStore.
+func (server *Server) saveSession(client *Client) {
+	if client.timeline == nil {
+		return
+	}
+
+	timeline := client.timeline
+	timeline.mux.Lock()
+	saved := timeline.timeline
+	saved.Events = append([]SessionEvent(nil), saved.Events...)
+	saved.Ended = server.clock().Now()
+	saved.Principal = server.principal(client)
+	saved.FramesIn = timeline.framesIn.Load()
+	saved.FramesOut = timeline.framesOut.Load()
+	switch {
+	case timeline.closed:
+		saved.Reason = "client disconnected"
+	case timeline.lastErr != "":
+		saved.Reason = timeline.lastErr
+	default:
+		saved.Reason = "connection closed"
+	}
+	timeline.mux.Unlock()
+
+	if err := server.SessionStore.SaveSession(saved); err != nil {
+		server.Sugar.Warnf("[%d] unable to save session timeline: %v", client.Uid, err)
+	}
+}
+
+// SessionsHandler exposes saved timelines as a small admin API: GET ?session=<id> returns one as JSON.
+func (server *Server) SessionsHandler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		server.init()
+		if server.SessionStore == nil {
+			http.Error(writer, "session timelines not configured", http.StatusNotFound)
+			return
+		}
+
+		if request.Method != http.MethodGet {
+			http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id, err := strconv.ParseUint(request.URL.Query().Get("session"), 10, 64)
+		if err != nil {
+			http.Error(writer, "invalid session id", http.StatusBadRequest)
+			return
+		}
+
+		timeline, ok, err := server.SessionStore.Session(id)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		} else if !ok {
+			http.Error(writer, "unknown session", http.StatusNotFound)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(writer).Encode(timeline)
+	})
+}
+
+// errorDetail describes an error for a timeline, preferring a FrameError's message.
+func errorDetail(err error) string {
+	var frameErr *FrameError
+	if errors.As(err, &frameErr) && frameErr.Message != "" {
+		return frameErr.Message
+	}
+
+	return err.Error()
+}
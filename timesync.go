@@ -0,0 +1,64 @@
+package stomper
+
+import (
+	"strconv"
+)
+
+// TimeDestination is the control destination for clock synchronisation. A client subscribes to it, then SENDs
+// to it with a client-time header (its clock, in unix milliseconds) and receives a MESSAGE echoing client-time
+// alongside server-receive-time and server-time. With t0 = client-time, t1 = server-receive-time,
+// t2 = server-time and t3 the client's clock on arrival, its offset from the server is ((t1-t0)+(t2-t3))/2.
+const TimeDestination = "/stomper/time"
+
+const (
+	ClientTimeHeader        = "client-time"
+	ServerReceiveTimeHeader = "server-receive-time"
+	ServerTimeHeader        = "server-time"
+)
+
+func (server *Server) isTimeDestination(destination string) bool {
+	return server.TimeSync && destination == TimeDestination
+}
+
+// serverTime is the server clock in unix milliseconds, as used by the time sync headers.
+func (server *Server) serverTime() string {
+	return strconv.FormatInt(server.clock().Now().UnixMilli(), 10)
+}
+
+// timeHeaders adds a server-time header to outbound MESSAGE headers when time sync is enabled.
+func (server *Server) timeHeaders(headers map[string]string) map[string]string {
+	if !server.TimeSync {
+		return headers
+	}
+
+	stamped := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		stamped[k] = v
+	}
+
+	stamped[ServerTimeHeader] = server.serverTime()
+	return stamped
+}
+
+// answerTimeRequest replies to a SEND on the time destination on each of the client's subscriptions to it.
+func (server *Server) answerTimeRequest(client *Client, message *StompMessage) {
+	received := server.serverTime()
+
+	subIds := server.subscriptionIds(client, TimeDestination)
+	if len(subIds) == 0 {
+		server.Sugar.Debugf("[%d] time request without a subscription to %s", client.Uid, TimeDestination)
+		return
+	}
+
+	headers := map[string]string{
+		ClientTimeHeader:        message.Headers[ClientTimeHeader],
+		ServerReceiveTimeHeader: received,
+	}
+
+	// server-time is stamped as each reply is written
+	for _, subId := range subIds {
+		if err := server.sendToSubscription(client, TimeDestination, subId, "text/plain", nil, headers); err != nil {
+			server.Sugar.Debugf("[%d] unable to answer time request: %v", client.Uid, err)
+		}
+	}
+}
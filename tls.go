@@ -0,0 +1,43 @@
+package stomper
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// LoadCertPool reads PEM encoded CA certificates, e.g. the CAs trusted to sign client certificates.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read certificates: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+
+	return pool, nil
+}
+
+// MutualTLSConfig returns a TLS config which requires clients to present a certificate signed by one of
+// clientCAs. Use it as the http.Server's TLSConfig; verified chains are then available on Client.VerifiedChains.
+func MutualTLSConfig(certificate tls.Certificate, clientCAs *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// PeerCertificate returns the client's verified leaf certificate, or nil when it didn't authenticate with one.
+func (client *Client) PeerCertificate() *x509.Certificate {
+	if len(client.VerifiedChains) == 0 || len(client.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return client.VerifiedChains[0][0]
+}
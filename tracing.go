@@ -0,0 +1,92 @@
+package stomper
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+const defaultTraceHeader = "traceparent"
+
+// Tracer is a minimal tracing backend, typically a thin adapter over an OpenTelemetry tracer and propagator
+// such as the one in the otel module: StartSpan maps to tracer.Start with trace.WithTimestamp, and
+// Inject/Extract to a TextMapPropagator reading and writing the single header named by Server.TraceHeader.
+type Tracer interface {
+	// StartSpan starts a span as a child of any span in ctx. Attributes may be nil.
+	StartSpan(ctx context.Context, name string, start time.Time, attributes map[string]string) (context.Context, Span)
+
+	// Extract returns ctx carrying the remote span context encoded in value, e.g. a W3C traceparent.
+	Extract(ctx context.Context, value string) context.Context
+
+	// Inject encodes the span context in ctx as a header value, or returns "" if there is none.
+	Inject(ctx context.Context) string
+}
+
+type Span interface {
+	// End finishes the span, marking it as failed when err isn't nil.
+	End(err error)
+}
+
+type noopSpan struct{}
+
+func (noopSpan) End(error) {}
+
+func (server *Server) traceHeader() string {
+	if server.TraceHeader != "" {
+		return server.TraceHeader
+	}
+
+	return defaultTraceHeader
+}
+
+// traceHandlers starts a span for running one kind of handler, e.g. the message handlers, as a child of the
+// span of the frame they're handling.
+func (server *Server) traceHandlers(ctx context.Context, kind string, client *Client, destination string) Span {
+	_, span := server.startSpan(ctx, "stomper.handlers."+kind, server.clock().Now(), map[string]string{
+		"stomper.client":      strconv.FormatUint(client.Uid, 10),
+		"stomper.destination": destination,
+	})
+
+	return span
+}
+
+func (server *Server) startSpan(ctx context.Context, name string, start time.Time, attributes map[string]string) (context.Context, Span) {
+	if server.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+
+	return server.Tracer.StartSpan(ctx, name, start, attributes)
+}
+
+// extractTrace returns ctx continuing the trace propagated in headers, if any.
+func (server *Server) extractTrace(ctx context.Context, headers map[string]string) context.Context {
+	if server.Tracer == nil {
+		return ctx
+	}
+
+	if value, ok := headers[server.traceHeader()]; ok {
+		ctx = server.Tracer.Extract(ctx, value)
+	}
+
+	return ctx
+}
+
+// injectTrace returns a copy of headers carrying the span context in ctx, or headers unchanged without a tracer.
+func (server *Server) injectTrace(ctx context.Context, headers map[string]string) map[string]string {
+	if server.Tracer == nil {
+		return headers
+	}
+
+	value := server.Tracer.Inject(ctx)
+	if value == "" {
+		return headers
+	}
+
+	traced := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		traced[k] = v
+	}
+
+	traced[server.traceHeader()] = value
+	return traced
+}
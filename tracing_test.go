@@ -0,0 +1,100 @@
+package stomper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordedSpan struct {
+	name   string
+	parent string
+	err    error
+	ended  bool
+}
+
+// recordingTracer records each span with the name of its parent.
+type recordingTracer struct {
+	mux   sync.Mutex
+	spans []*recordedSpan
+}
+
+type spanNameKey struct{}
+
+func (tracer *recordingTracer) StartSpan(ctx context.Context, name string, _ time.Time, _ map[string]string) (context.Context, Span) {
+	parent, _ := ctx.Value(spanNameKey{}).(string)
+	span := &recordedSpan{name: name, parent: parent}
+	tracer.mux.Lock()
+	tracer.spans = append(tracer.spans, span)
+	tracer.mux.Unlock()
+	return context.WithValue(ctx, spanNameKey{}, name), tracingSpan{tracer: tracer, span: span}
+}
+
+func (tracer *recordingTracer) Extract(ctx context.Context, _ string) context.Context {
+	return ctx
+}
+
+func (tracer *recordingTracer) Inject(context.Context) string {
+	return ""
+}
+
+func (tracer *recordingTracer) find(name string) *recordedSpan {
+	tracer.mux.Lock()
+	defer tracer.mux.Unlock()
+	for _, span := range tracer.spans {
+		if span.name == name {
+			copied := *span
+			return &copied
+		}
+	}
+
+	return nil
+}
+
+type tracingSpan struct {
+	tracer *recordingTracer
+	span   *recordedSpan
+}
+
+func (span tracingSpan) End(err error) {
+	span.tracer.mux.Lock()
+	defer span.tracer.mux.Unlock()
+	span.span.err, span.span.ended = err, true
+}
+
+func TestHandlersAreTracedWithinTheirFrame(t *testing.T) {
+	tracer := &recordingTracer{}
+	_, addr := newTestServer(t, func(server *Server) {
+		server.Tracer = tracer
+		_ = server.AddConnectHandler(func(*Client, http.Header, *StompMessage) bool {
+			return true
+		})
+
+		_ = server.AddSubscribeHandler(func(_ *Client, destination string) bool {
+			return destination != "/topic/secret"
+		})
+
+		_ = server.AddMessageHandler(func(*Client, string, *StompMessage) {})
+	})
+
+	c := dialTestClient(t, addr).connect()
+	c.send("SUBSCRIBE", []string{"id:0", "destination:/topic/secret"}, "")
+	c.send("SEND", []string{"destination:/queue/a", "receipt:sent"}, "x")
+	if frame := c.read(); frame.Command != Receipt {
+		t.Fatalf("expected RECEIPT, got %s %v", frame.Command, frame.Headers)
+	}
+
+	for name, want := range map[string]recordedSpan{
+		"stomper.handlers.connect":   {parent: "stomper.connect"},
+		"stomper.handlers.subscribe": {parent: "stomper.subscribe", err: ErrUnauthorized},
+		"stomper.handlers.message":   {parent: "stomper.send"},
+	} {
+		span := tracer.find(name)
+		if span == nil || !span.ended || span.parent != want.parent || !errors.Is(span.err, want.err) {
+			t.Errorf("expected %s ended as a child of %s with error %v, got %+v", name, want.parent, want.err, span)
+		}
+	}
+}
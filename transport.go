@@ -0,0 +1,234 @@
+package stomper
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// transport carries frames between the server and one client, over a websocket or a plain TCP connection.
+// readFrame returns one frame, or a heart-beat EOL, at a time; io.EOF once the client has closed the
+// connection and ErrFrameTooLarge when a frame exceeds the read limit.
+type transport interface {
+	readFrame() ([]byte, error)
+	writeParts(parts [][]byte, binary bool) error
+	writePrepared(frame *preparedFrame) error
+	setWriteDeadline(deadline time.Time) error
+	setReadLimit(limit int64)
+	Close() error
+}
+
+// websocketTransport sends each frame as a websocket text message.
+type websocketTransport struct {
+	conn *websocket.Conn
+}
+
+func (ws *websocketTransport) readFrame() ([]byte, error) {
+	for {
+		mt, message, err := ws.conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				return nil, io.EOF
+			}
+
+			if errors.Is(err, websocket.ErrReadLimit) {
+				return nil, ErrFrameTooLarge
+			}
+
+			return nil, err
+		}
+
+		if mt == websocket.TextMessage {
+			return message, nil
+		}
+	}
+}
+
+// writeParts writes the concatenation of parts as a single websocket message without joining them first;
+// binary frames, whose bodies aren't UTF-8, are sent as binary messages.
+func (ws *websocketTransport) writeParts(parts [][]byte, binary bool) error {
+	messageType := websocket.TextMessage
+	if binary {
+		messageType = websocket.BinaryMessage
+	}
+
+	writer, err := ws.conn.NextWriter(messageType)
+	if err != nil {
+		return err
+	}
+
+	for _, part := range parts {
+		if _, err = writer.Write(part); err != nil {
+			_ = writer.Close()
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+func (ws *websocketTransport) writePrepared(frame *preparedFrame) error {
+	return ws.conn.WritePreparedMessage(frame.prepared)
+}
+
+func (ws *websocketTransport) setWriteDeadline(deadline time.Time) error {
+	return ws.conn.SetWriteDeadline(deadline)
+}
+
+func (ws *websocketTransport) setReadLimit(limit int64) {
+	ws.conn.SetReadLimit(limit)
+}
+
+func (ws *websocketTransport) Close() error {
+	return ws.conn.Close()
+}
+
+// tcpTransport reads and writes frames directly on a stream, where each frame ends with a NUL (after
+// content-length bytes of body, when that header is set) and EOLs between frames are heart-beats.
+type tcpTransport struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+	limit  int64
+}
+
+func newTCPTransport(conn net.Conn) *tcpTransport {
+	return &tcpTransport{conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn)}
+}
+
+var contentLengthPrefix = []byte("content-length:")
+
+// unlimitedFrameSize bounds frames from TCP clients when no read limit is set, so a client can't make the
+// server buffer without end, or allocate whatever its content-length claims.
+const unlimitedFrameSize = 64 << 20
+
+func (tcp *tcpTransport) frameLimit() int64 {
+	if tcp.limit > 0 {
+		return tcp.limit
+	}
+
+	return unlimitedFrameSize
+}
+
+func (tcp *tcpTransport) readFrame() ([]byte, error) {
+	first, err := tcp.reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	var frame []byte
+	switch first {
+	case '\n':
+		return []byte("\n"), nil
+	case '\r':
+		next, err := tcp.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if next == '\n' {
+			return []byte("\r\n"), nil
+		}
+
+		frame = append(frame, first)
+		_ = tcp.reader.UnreadByte()
+	default:
+		_ = tcp.reader.UnreadByte()
+	}
+
+	// the command line and headers, up to the blank line which ends them
+	contentLength := -1
+	for headers := 0; ; headers++ {
+		start := len(frame)
+		if frame, err = tcp.readUntil(frame, '\n'); err != nil {
+			return nil, err
+		}
+
+		line := bytes.TrimRight(frame[start:], "\r\n")
+		if len(line) == 0 && headers > 0 {
+			break
+		}
+
+		if value, ok := bytes.CutPrefix(line, contentLengthPrefix); ok && contentLength < 0 {
+			// without a valid length, where the body ends is anyone's guess
+			length, err := strconv.Atoi(string(value))
+			if err != nil || length < 0 {
+				return nil, frameErrorf(ErrorCodeInvalidContentLength, "invalid content-length (%s)", value)
+			}
+
+			contentLength = length
+		}
+	}
+
+	if contentLength < 0 {
+		return tcp.readUntil(frame, 0)
+	}
+
+	if int64(contentLength) >= tcp.frameLimit()-int64(len(frame)) {
+		return nil, ErrFrameTooLarge
+	}
+
+	// the body is read as it arrives rather than allocated up front, so a content-length alone costs nothing
+	buffer := bytes.NewBuffer(frame)
+	if _, err = io.CopyN(buffer, tcp.reader, int64(contentLength)+1); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return nil, err
+	}
+
+	frame = buffer.Bytes()
+	if frame[len(frame)-1] != 0 {
+		return nil, frameErrorf(ErrorCodeInvalidFrame, "frame body is longer than its content-length")
+	}
+
+	return frame, nil
+}
+
+// readUntil appends everything up to and including delim to frame, enforcing the read limit as it goes.
+func (tcp *tcpTransport) readUntil(frame []byte, delim byte) ([]byte, error) {
+	for {
+		chunk, err := tcp.reader.ReadSlice(delim)
+		frame = append(frame, chunk...)
+		if int64(len(frame)) > tcp.frameLimit() {
+			return nil, ErrFrameTooLarge
+		}
+
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return frame, err
+		}
+	}
+}
+
+func (tcp *tcpTransport) writeParts(parts [][]byte, _ bool) error {
+	for _, part := range parts {
+		if _, err := tcp.writer.Write(part); err != nil {
+			return err
+		}
+	}
+
+	return tcp.writer.Flush()
+}
+
+func (tcp *tcpTransport) writePrepared(frame *preparedFrame) error {
+	return tcp.writeParts([][]byte{frame.payload}, false)
+}
+
+func (tcp *tcpTransport) setWriteDeadline(deadline time.Time) error {
+	return tcp.conn.SetWriteDeadline(deadline)
+}
+
+func (tcp *tcpTransport) setReadLimit(limit int64) {
+	tcp.limit = limit
+}
+
+func (tcp *tcpTransport) Close() error {
+	return tcp.conn.Close()
+}
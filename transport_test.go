@@ -0,0 +1,103 @@
+package stomper
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func readTCPFrame(t *testing.T, raw string, limit int64) ([]byte, error) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = client.Close()
+	})
+
+	go func() {
+		_, _ = client.Write([]byte(raw))
+		_ = client.Close()
+	}()
+
+	tcp := newTCPTransport(server)
+	tcp.setReadLimit(limit)
+	return tcp.readFrame()
+}
+
+func TestTCPFrameWithContentLength(t *testing.T) {
+	frame, err := readTCPFrame(t, "SEND\ndestination:/a\ncontent-length:3\n\na\x00b\x00", 0)
+	if err != nil {
+		t.Fatalf("unable to read frame: %v", err)
+	}
+
+	if want := "SEND\ndestination:/a\ncontent-length:3\n\na\x00b\x00"; string(frame) != want {
+		t.Fatalf("expected %q, got %q", want, frame)
+	}
+}
+
+func TestTCPFrameContentLengthIsBounded(t *testing.T) {
+	for _, length := range []string{"9223372036854775807", "4294967296", "67108864"} {
+		_, err := readTCPFrame(t, "SEND\ncontent-length:"+length+"\n\nab\x00", 0)
+		if !errors.Is(err, ErrFrameTooLarge) {
+			t.Errorf("content-length %s: expected ErrFrameTooLarge, got %v", length, err)
+		}
+	}
+
+	_, err := readTCPFrame(t, "SEND\ncontent-length:100\n\nab\x00", 64)
+	if !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("expected ErrFrameTooLarge over the read limit, got %v", err)
+	}
+}
+
+func TestTCPFrameShortBody(t *testing.T) {
+	_, err := readTCPFrame(t, "SEND\ncontent-length:1000\n\nab\x00", 0)
+	if err == nil || errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected a read error, got %v", err)
+	}
+}
+
+func TestTCPFrameWithoutContentLengthIsBounded(t *testing.T) {
+	_, err := readTCPFrame(t, "SEND\n\n"+strings.Repeat("x", 200)+"\x00", 100)
+	if !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected ErrFrameTooLarge, got %v", err)
+	}
+}
+
+func TestHugeContentLengthIsRejected(t *testing.T) {
+	server, addr := newTestServer(t, nil)
+	c := dialTestClient(t, addr).connect()
+	c.send("SEND", []string{"destination:/topic/a", "content-length:9223372036854775807"}, "x")
+	frame := c.read()
+	if frame.Command != Error || frame.Headers["error-code"] != ErrorCodeFrameTooLarge {
+		t.Fatalf("expected a frame-too-large ERROR, got %s %v", frame.Command, frame.Headers)
+	}
+
+	c.closed()
+
+	// the server carries on
+	dialTestClient(t, addr).connect()
+	server.SendMessage("/topic/a", "text/plain", "still here")
+}
+
+func TestTCPFramingErrorsAreReported(t *testing.T) {
+	for _, c := range []struct {
+		headers []string
+		body    string
+		code    string
+	}{
+		{[]string{"destination:/topic/a", "content-length:2"}, "hello", ErrorCodeInvalidFrame},
+		{[]string{"destination:/topic/a", "content-length:two"}, "hi", ErrorCodeInvalidContentLength},
+		{[]string{"destination:/topic/a", "content-length:-1"}, "hi", ErrorCodeInvalidContentLength},
+	} {
+		_, addr := newTestServer(t, nil)
+		client := dialTestClient(t, addr).connect()
+		client.send("SEND", c.headers, c.body)
+		frame := client.read()
+		if frame.Command != Error || frame.Headers["error-code"] != c.code || frame.Headers["message"] == "" {
+			t.Fatalf("%v: expected a %s ERROR, got %s %v", c.headers, c.code, frame.Command, frame.Headers)
+		}
+
+		client.closed()
+	}
+}
@@ -0,0 +1,56 @@
+package stomper
+
+// SelectorHeader on a SUBSCRIBE is a filter for the upstream system to apply, passed on to subscribing
+// sources as it is.
+const SelectorHeader = "selector"
+
+var defaultUpstreamHeaders = []string{SelectorHeader, ReplayHeader}
+
+// SubscribingSource is a DataSource which only subscribes upstream to the destinations clients are
+// subscribed to. SubscribeUpstream is called when a destination gets its first subscriber, with the headers
+// of that SUBSCRIBE named by Server.UpstreamHeaders, so e.g. a Kafka or Redis stream source can start from
+// the offset asked for; UnsubscribeUpstream is called when its last subscriber leaves. Both are called with
+// the destination's subscriptions locked, so they must return quickly and mustn't publish.
+type SubscribingSource interface {
+	DataSource
+	SubscribeUpstream(destination string, headers map[string]string)
+	UnsubscribeUpstream(destination string)
+}
+
+// upstreamHeaders picks the headers passed on to subscribing sources from a SUBSCRIBE's.
+func (server *Server) upstreamHeaders(headers map[string]string) map[string]string {
+	names := server.UpstreamHeaders
+	if names == nil {
+		names = defaultUpstreamHeaders
+	}
+
+	picked := make(map[string]string)
+	for _, name := range names {
+		if value, ok := headers[name]; ok {
+			picked[name] = value
+		}
+	}
+
+	return picked
+}
+
+// subscribeUpstream tells subscribing sources a destination has its first subscriber; callers must hold the
+// destination's shard lock.
+func (server *Server) subscribeUpstream(destination string, headers map[string]string) {
+	if len(server.dataSources.subscribing) == 0 {
+		return
+	}
+
+	headers = server.upstreamHeaders(headers)
+	for _, source := range server.dataSources.subscribing {
+		source.SubscribeUpstream(destination, headers)
+	}
+}
+
+// unsubscribeUpstream tells subscribing sources a destination's last subscriber has gone; callers must hold
+// the destination's shard lock.
+func (server *Server) unsubscribeUpstream(destination string) {
+	for _, source := range server.dataSources.subscribing {
+		source.UnsubscribeUpstream(destination)
+	}
+}
@@ -0,0 +1,78 @@
+package stomper
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// upstreamSource is a SubscribingSource recording what it's asked to subscribe to upstream.
+type upstreamSource struct {
+	mux    sync.Mutex
+	events []string
+	last   map[string]string
+}
+
+func (source *upstreamSource) Start(context.Context, Publisher) error { return nil }
+func (source *upstreamSource) Stop()                                  {}
+
+func (source *upstreamSource) SubscribeUpstream(destination string, headers map[string]string) {
+	source.mux.Lock()
+	defer source.mux.Unlock()
+	source.events = append(source.events, "subscribe "+destination)
+	source.last = headers
+}
+
+func (source *upstreamSource) UnsubscribeUpstream(destination string) {
+	source.mux.Lock()
+	defer source.mux.Unlock()
+	source.events = append(source.events, "unsubscribe "+destination)
+}
+
+func (source *upstreamSource) seen() ([]string, map[string]string) {
+	source.mux.Lock()
+	defer source.mux.Unlock()
+	return append([]string(nil), source.events...), source.last
+}
+
+func TestSubscribingSourcesFollowTheFirstAndLastSubscriber(t *testing.T) {
+	source := &upstreamSource{}
+	_, addr := newTestServer(t, func(server *Server) {
+		_ = server.AddDataSource(source)
+	})
+
+	first := dialTestClient(t, addr).connect()
+	first.subscribe("a", "/topic/orders", SelectorHeader+":region = 'eu'", ReplayHeader+":10", "x-other:1")
+	second := dialTestClient(t, addr).connect()
+	second.subscribe("b", "/topic/orders", SelectorHeader+":region = 'us'")
+
+	events, headers := source.seen()
+	if len(events) != 1 || events[0] != "subscribe /topic/orders" {
+		t.Fatalf("expected one upstream subscription, got %v", events)
+	}
+
+	if len(headers) != 2 || headers[SelectorHeader] != "region = 'eu'" || headers[ReplayHeader] != "10" {
+		t.Fatalf("expected the first subscriber's selector and replay headers, got %v", headers)
+	}
+
+	first.send(Unsubscribe, []string{"id:a", "receipt:unsub"}, "")
+	first.read()
+	if events, _ := source.seen(); len(events) != 1 {
+		t.Fatalf("expected no upstream change while a subscriber remains, got %v", events)
+	}
+
+	_ = second.conn.Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if events, _ := source.seen(); len(events) == 2 && events[1] == "unsubscribe /topic/orders" {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the upstream subscription to end with its last subscriber, got %v", events)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
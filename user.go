@@ -0,0 +1,45 @@
+package stomper
+
+import (
+	"strings"
+)
+
+// UserDestinationPrefix marks destinations private to a user. A client subscribes to e.g.
+// `/user/queue/notifications`, and SendToUser(user, "/queue/notifications", ...) reaches every session of that
+// user subscribed to it. Ordinary sends to user destinations are dropped, so users can't see each other's.
+const UserDestinationPrefix = "/user"
+
+func isUserDestination(destination string) bool {
+	return strings.HasPrefix(destination, UserDestinationPrefix+"/")
+}
+
+// SendToUser delivers a MESSAGE to every session of a user (see Server.Principal) subscribed to the user
+// destination for destination, returning how many sessions it was sent to. Anonymous clients, with neither
+// a Principal nor an authenticated identity, are never sent to.
+func (server *Server) SendToUser(user string, destination string, contentType string, body string) int {
+	server.init()
+	userDestination := UserDestinationPrefix + destination
+
+	server.clientMux.RLock()
+	sessions := make([]*Client, 0, len(server.users[user]))
+	for _, client := range server.users[user] {
+		sessions = append(sessions, client)
+	}
+	server.clientMux.RUnlock()
+
+	sent := 0
+	for _, client := range sessions {
+		subIds := server.subscriptionIds(client, userDestination)
+		for _, subId := range subIds {
+			if err := server.sendToSubscription(client, userDestination, subId, contentType, []byte(body), nil); err != nil {
+				server.Sugar.Debugf("[%d] unable to send to user '%s': %v", client.Uid, user, err)
+			}
+		}
+
+		if len(subIds) > 0 {
+			sent++
+		}
+	}
+
+	return sent
+}
@@ -0,0 +1,46 @@
+package stomper
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSendToUserIgnoresUnverifiedLogin(t *testing.T) {
+	server, addr := newTestServer(t, nil)
+	c := dialTestClient(t, addr).connect("login:alice")
+	c.subscribe("0", "/user/queue/notifications")
+
+	if sent := server.SendToUser("alice", "/queue/notifications", "text/plain", "secret"); sent != 0 {
+		t.Fatalf("expected no sessions, sent to %d", sent)
+	}
+
+	c.quiet(50 * time.Millisecond)
+}
+
+func TestSendToUserByIdentity(t *testing.T) {
+	server, addr := newTestServer(t, func(server *Server) {
+		server.Authenticator = AuthenticatorFunc(func(client *Client, credentials Credentials) (*Identity, error) {
+			if credentials.Passcode != "pw" {
+				return nil, errors.New("wrong passcode")
+			}
+
+			return &Identity{Principal: credentials.Login}, nil
+		})
+	})
+
+	alice := dialTestClient(t, addr).connect("login:alice", "passcode:pw")
+	alice.subscribe("0", "/user/queue/notifications")
+	bob := dialTestClient(t, addr).connect("login:bob", "passcode:pw")
+	bob.subscribe("0", "/user/queue/notifications")
+
+	if sent := server.SendToUser("alice", "/queue/notifications", "text/plain", "for alice"); sent != 1 {
+		t.Fatalf("expected one session, sent to %d", sent)
+	}
+
+	if frame := alice.read(); string(*frame.Body) != "for alice" {
+		t.Fatalf("expected alice's message, got %q", *frame.Body)
+	}
+
+	bob.quiet(50 * time.Millisecond)
+}
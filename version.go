@@ -0,0 +1,83 @@
+package stomper
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// BuildVersion and BuildCommit are set at build time, e.g.
+//
+//	go build -ldflags "-X github.com/hfoxy/stomper.BuildVersion=1.2.0 -X github.com/hfoxy/stomper.BuildCommit=$(git rev-parse --short HEAD)"
+//
+// When left unset, BuildVersion falls back to the module version recorded in the binary's build info.
+var (
+	BuildVersion = ""
+	BuildCommit  = ""
+)
+
+const modulePath = "github.com/hfoxy/stomper"
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+var buildInfo BuildInfo
+var buildInfoOnce sync.Once
+
+func GetBuildInfo() BuildInfo {
+	buildInfoOnce.Do(func() {
+		buildInfo = readBuildInfo()
+	})
+
+	return buildInfo
+}
+
+func readBuildInfo() BuildInfo {
+	info := BuildInfo{Version: BuildVersion, Commit: BuildCommit, GoVersion: runtime.Version()}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" {
+			if build.Main.Path == modulePath && build.Main.Version != "(devel)" {
+				info.Version = build.Main.Version
+			}
+
+			for _, dep := range build.Deps {
+				if dep.Path == modulePath {
+					info.Version = dep.Version
+				}
+			}
+		}
+
+		if info.Commit == "" {
+			for _, setting := range build.Settings {
+				if setting.Key == "vcs.revision" {
+					info.Commit = setting.Value
+				}
+			}
+		}
+	}
+
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+
+	return info
+}
+
+// serverHeader is the value of the `server` header sent in CONNECTED frames.
+func serverHeader() string {
+	return "stomper/" + GetBuildInfo().Version
+}
+
+// VersionHandler serves the build info as JSON, intended for `GET /version`.
+func VersionHandler(writer http.ResponseWriter, _ *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(writer).Encode(GetBuildInfo())
+	if err != nil {
+		return
+	}
+}
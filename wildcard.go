@@ -0,0 +1,247 @@
+package stomper
+
+import (
+	"sync"
+)
+
+// Wildcard subscriptions: in a SUBSCRIBE destination, a `*` segment matches exactly one segment of a
+// published destination and a `#` segment matches zero or more, e.g. `/topic/prices.*` or `/topic/orders.#`.
+// Segments are separated by '/' or '.', and the separator is part of the segment: `/topic/*` matches
+// `/topic/a` but not `/topic.a`, and a `#` only matches segments starting with its own separator first.
+const (
+	wildcardOne  = "*"
+	wildcardMany = "#"
+)
+
+// separators are the ways a segment can start; 0 is a leading segment without a separator.
+var separators = []byte{'/', '.', 0}
+
+// segment is one part of a destination, along with the separator before it.
+type segment struct {
+	separator byte
+	name      string
+}
+
+// isWildcard reports whether a destination is a subscription pattern rather than a plain destination.
+func isWildcard(destination string) bool {
+	for _, segment := range destinationSegments(destination) {
+		if segment.name == wildcardOne || segment.name == wildcardMany {
+			return true
+		}
+	}
+
+	return false
+}
+
+// destinationSegments splits a destination into segments, keeping empty ones, so that only identical
+// destinations have identical segments.
+func destinationSegments(destination string) []segment {
+	var segments []segment
+	var separator byte
+	start := 0
+	for i := 0; i < len(destination); i++ {
+		if c := destination[i]; c == '/' || c == '.' {
+			if i > 0 {
+				segments = append(segments, segment{separator: separator, name: destination[start:i]})
+			}
+
+			separator, start = c, i+1
+		}
+	}
+
+	if len(destination) > 0 {
+		segments = append(segments, segment{separator: separator, name: destination[start:]})
+	}
+
+	return segments
+}
+
+// patternIndex holds wildcard subscriptions in a trie of segments, so a broadcast only visits the patterns
+// that can match its destination.
+type patternIndex struct {
+	mux      sync.RWMutex
+	root     patternNode
+	byClient map[uint64]map[string]string
+}
+
+type patternNode struct {
+	children map[segment]*patternNode
+	pattern  string
+	subs     map[uint64]map[string]*Client
+}
+
+func (node *patternNode) child(key segment, create bool) *patternNode {
+	if child, ok := node.children[key]; ok || !create {
+		return child
+	}
+
+	if node.children == nil {
+		node.children = make(map[segment]*patternNode)
+	}
+
+	child := &patternNode{}
+	node.children[key] = child
+	return child
+}
+
+// add records a wildcard subscription; callers must hold the write lock.
+func (index *patternIndex) add(client *Client, pattern string, subId string) {
+	node := &index.root
+	for _, segment := range destinationSegments(pattern) {
+		node = node.child(segment, true)
+	}
+
+	node.pattern = pattern
+	if node.subs == nil {
+		node.subs = make(map[uint64]map[string]*Client)
+	}
+
+	if node.subs[client.Uid] == nil {
+		node.subs[client.Uid] = make(map[string]*Client)
+	}
+
+	node.subs[client.Uid][subId] = client
+	if index.byClient == nil {
+		index.byClient = make(map[uint64]map[string]string)
+	}
+
+	if index.byClient[client.Uid] == nil {
+		index.byClient[client.Uid] = make(map[string]string)
+	}
+
+	index.byClient[client.Uid][subId] = pattern
+}
+
+// delete removes a wildcard subscription by id, returning the pattern it was for, and prunes the nodes left
+// with neither subscriptions nor children; callers must hold the write lock.
+func (index *patternIndex) delete(client *Client, subId string) (string, bool) {
+	pattern, ok := index.byClient[client.Uid][subId]
+	if !ok {
+		return "", false
+	}
+
+	delete(index.byClient[client.Uid], subId)
+	if len(index.byClient[client.Uid]) == 0 {
+		delete(index.byClient, client.Uid)
+	}
+
+	segments := destinationSegments(pattern)
+	path := make([]*patternNode, 0, len(segments)+1)
+	node := &index.root
+	path = append(path, node)
+	for _, segment := range segments {
+		if node = node.child(segment, false); node == nil {
+			return pattern, true
+		}
+
+		path = append(path, node)
+	}
+
+	delete(node.subs[client.Uid], subId)
+	if len(node.subs[client.Uid]) == 0 {
+		delete(node.subs, client.Uid)
+	}
+
+	if len(node.subs) == 0 {
+		node.pattern = ""
+	}
+
+	for i := len(segments); i > 0; i-- {
+		if len(path[i].subs) > 0 || len(path[i].children) > 0 {
+			break
+		}
+
+		delete(path[i-1].children, segments[i-1])
+	}
+
+	return pattern, true
+}
+
+// deleteClient removes all of a client's wildcard subscriptions; callers must hold the write lock.
+func (index *patternIndex) deleteClient(client *Client) {
+	for subId := range index.byClient[client.Uid] {
+		index.delete(client, subId)
+	}
+}
+
+// match calls visit with the subscribers of every pattern matching destination; callers must hold the read
+// lock.
+func (index *patternIndex) match(destination string, visit func(subs map[uint64]map[string]*Client)) {
+	walk := &patternWalk{segments: destinationSegments(destination), visited: make(map[patternStep]bool)}
+	walk.match(&index.root, 0)
+	for _, node := range walk.matched {
+		if len(node.subs) > 0 {
+			visit(node.subs)
+		}
+	}
+}
+
+// patternStep is a node of the trie reached having matched the destination up to offset.
+type patternStep struct {
+	node   *patternNode
+	offset int
+}
+
+// patternWalk matches a destination against the trie, visiting each node at each offset at most once, so
+// patterns full of `#` can't make matching take exponential time.
+type patternWalk struct {
+	segments []segment
+	visited  map[patternStep]bool
+	matched  []*patternNode
+}
+
+func (walk *patternWalk) match(node *patternNode, offset int) {
+	step := patternStep{node: node, offset: offset}
+	if walk.visited[step] {
+		return
+	}
+
+	walk.visited[step] = true
+	for _, separator := range separators {
+		many := node.children[segment{separator: separator, name: wildcardMany}]
+		if many == nil {
+			continue
+		}
+
+		// # absorbs nothing, or segments from one starting with its separator
+		walk.match(many, offset)
+		if offset < len(walk.segments) && walk.segments[offset].separator == separator {
+			for end := offset + 1; end <= len(walk.segments); end++ {
+				walk.match(many, end)
+			}
+		}
+	}
+
+	if offset == len(walk.segments) {
+		if node.pattern != "" {
+			walk.matched = append(walk.matched, node)
+		}
+
+		return
+	}
+
+	next := walk.segments[offset]
+	if child := node.children[next]; child != nil {
+		walk.match(child, offset+1)
+	}
+
+	if one := node.children[segment{separator: next.separator, name: wildcardOne}]; one != nil {
+		walk.match(one, offset+1)
+	}
+}
+
+// each calls visit for every wildcard subscription; callers must hold the read lock.
+func (index *patternIndex) each(visit func(pattern string, subs map[uint64]map[string]*Client)) {
+	var walk func(node *patternNode)
+	walk = func(node *patternNode) {
+		if len(node.subs) > 0 {
+			visit(node.pattern, node.subs)
+		}
+
+		for _, child := range node.children {
+			walk(child)
+		}
+	}
+
+	walk(&index.root)
+}
@@ -0,0 +1,117 @@
+package stomper
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var wildcardCases = []struct {
+	pattern     string
+	destination string
+	match       bool
+}{
+	{"/topic/prices.*", "/topic/prices.eu", true},
+	{"/topic/prices.*", "/topic/prices.eu.gbp", false},
+	{"/topic/prices.*", "/topic/prices/eu", false},
+	{"/topic/orders.#", "/topic/orders", true},
+	{"/topic/orders.#", "/topic/orders.eu", true},
+	{"/topic/orders.#", "/topic/orders.eu.gbp", true},
+	{"/topic/orders.#", "/topic/orders.eu/gbp", true},
+	{"/topic/orders.#", "/topic/orders/eu", false},
+	{"/topic/#", "/topic/a.b", true},
+	{"/#", "/topic/a", true},
+	{"/topic/a.b", "/topic.a/b", false},
+	{"/topic/*", "/topic.a", false},
+	{"/topic/*/x", "/topic//x", true},
+	{"/topic/a", "/topic//a", false},
+	{"/topic/#/x", "/topic/x", true},
+	{"/topic/#/x", "/topic/a/b/x", true},
+	{"/topic/#/x", "/topic/a/b/y", false},
+	{"/topic/#.#", "/topic/a.b", true},
+}
+
+func TestMatchSegments(t *testing.T) {
+	for _, c := range wildcardCases {
+		if got := matchSegments(destinationSegments(c.pattern), destinationSegments(c.destination)); got != c.match {
+			t.Errorf("%s against %s: expected %v, got %v", c.pattern, c.destination, c.match, got)
+		}
+	}
+}
+
+func TestPatternIndexMatch(t *testing.T) {
+	for _, c := range wildcardCases {
+		var index patternIndex
+		client := &Client{Uid: 1}
+		index.add(client, c.pattern, "0")
+		got := false
+		index.match(c.destination, func(subs map[uint64]map[string]*Client) {
+			got = true
+		})
+
+		if got != c.match {
+			t.Errorf("%s against %s: expected %v, got %v", c.pattern, c.destination, c.match, got)
+		}
+	}
+}
+
+func TestManyWildcardsMatchQuickly(t *testing.T) {
+	pattern := strings.Repeat("/#", 20) + "/never"
+	destination := strings.Repeat("/a", 60)
+
+	var index patternIndex
+	index.add(&Client{Uid: 1}, pattern, "0")
+
+	start := time.Now()
+	index.match(destination, func(subs map[uint64]map[string]*Client) {
+		t.Fatalf("%s shouldn't match", pattern)
+	})
+
+	if matchSegments(destinationSegments(pattern), destinationSegments(destination)) {
+		t.Fatalf("%s shouldn't match", pattern)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("matching took %s", elapsed)
+	}
+}
+
+func TestIsWildcard(t *testing.T) {
+	for destination, want := range map[string]bool{
+		"/topic/a":     false,
+		"/topic/*":     true,
+		"/topic/a.#":   true,
+		"/topic/a#":    false,
+		"/topic/*.foo": true,
+	} {
+		if got := isWildcard(destination); got != want {
+			t.Errorf("%s: expected %v, got %v", destination, want, got)
+		}
+	}
+}
+
+func TestPatternIndexDeletePrunesEmptyNodes(t *testing.T) {
+	var index patternIndex
+	client := &Client{Uid: 1}
+	index.add(client, "/topic/prices.*", "a")
+	index.add(client, "/topic/prices.*.gbp", "b")
+	index.add(client, "/topic/orders.#", "c")
+
+	index.delete(client, "b")
+	index.delete(client, "c")
+	topic := index.root.children[segment{separator: '/', name: "topic"}]
+	if topic == nil || len(topic.children) != 1 {
+		t.Fatalf("expected only the prices branch left under /topic, got %v", topic)
+	}
+
+	prices := topic.children[segment{separator: '/', name: "prices"}]
+	one := prices.children[segment{separator: '.', name: wildcardOne}]
+	if one == nil || len(one.children) != 0 || one.pattern != "/topic/prices.*" {
+		t.Fatalf("expected /topic/prices.* left without children, got %+v", one)
+	}
+
+	index.delete(client, "a")
+	if len(index.root.children) != 0 || len(index.byClient) != 0 {
+		t.Fatalf("expected an empty index, got %+v", index.root)
+	}
+}
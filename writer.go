@@ -0,0 +1,261 @@
+package stomper
+
+import (
+	"bytes"
+	"context"
+	"github.com/gorilla/websocket"
+	"time"
+)
+
+const defaultOutboundQueueSize = 256
+
+const defaultInboundQueueSize = 64
+const defaultWriteTimeout = 10 * time.Second
+
+// outboundFrame is a frame waiting in a client's outbound queue: either the parts of a frame, written
+// back-to-back as one websocket message, or a prepared frame. A shared body is released once written.
+type outboundFrame struct {
+	parts    [][]byte
+	shared   *sharedBuffer
+	prepared *preparedFrame
+
+	// binary frames carry a body that isn't UTF-8, e.g. one re-encoded by a Codec
+	binary bool
+
+	// expires, when set, is when the frame stops being worth writing
+	expires time.Time
+
+	// flushed, when set, is closed once everything queued before it has been written
+	flushed chan struct{}
+
+	// conflated, when set, stands in for whichever MESSAGE is in the slot when the frame is written
+	conflated *conflationSlot
+}
+
+func (frame *outboundFrame) payload() []byte {
+	if frame.prepared != nil {
+		return frame.prepared.payload
+	}
+
+	return bytes.Join(frame.parts, nil)
+}
+
+var messagePrefix = []byte(Message + "\n")
+
+// head returns the start of the frame, which holds at least its command line.
+func (frame *outboundFrame) head() []byte {
+	if frame.conflated != nil {
+		return messagePrefix
+	}
+
+	if frame.prepared != nil {
+		return frame.prepared.payload
+	}
+
+	return frame.parts[0]
+}
+
+func (frame *outboundFrame) size() int {
+	if frame.prepared != nil {
+		return len(frame.prepared.payload)
+	}
+
+	size := 0
+	for _, part := range frame.parts {
+		size += len(part)
+	}
+
+	return size
+}
+
+func (frame *outboundFrame) release() {
+	if frame.conflated != nil {
+		if conflated, ok := frame.conflated.take(); ok {
+			conflated.release()
+		}
+
+		frame.conflated = nil
+	}
+
+	if frame.shared != nil {
+		frame.shared.release()
+		frame.shared = nil
+	}
+}
+
+type preparedFrame struct {
+	payload  []byte
+	prepared *websocket.PreparedMessage
+}
+
+func newPreparedFrame(payload []byte, binary bool) (*preparedFrame, error) {
+	messageType := websocket.TextMessage
+	if binary {
+		messageType = websocket.BinaryMessage
+	}
+
+	prepared, err := websocket.NewPreparedMessage(messageType, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &preparedFrame{payload: payload, prepared: prepared}, nil
+}
+
+func (server *Server) outboundQueueSize() int {
+	if server.OutboundQueueSize > 0 {
+		return server.OutboundQueueSize
+	}
+
+	return defaultOutboundQueueSize
+}
+
+// enqueue adds a frame to the client's outbound queue, waiting for space if it's full unless it's a MESSAGE
+// and the SlowConsumerPolicy says otherwise.
+func (server *Server) enqueue(client *Client, frame outboundFrame) error {
+	return server.enqueueContext(context.Background(), client, frame)
+}
+
+// enqueueContext is enqueue giving up on waiting for space once ctx is done.
+func (server *Server) enqueueContext(ctx context.Context, client *Client, frame outboundFrame) error {
+	select {
+	case <-client.done:
+		frame.release()
+		return ErrClientGone
+	default:
+	}
+
+	if frame.isMessage() && client.evicted.Load() {
+		frame.release()
+		return ErrBackpressure
+	}
+
+	select {
+	case client.outbound <- frame:
+		server.checkCongestion(client)
+		return nil
+	default:
+	}
+
+	if frame.isMessage() && server.SlowConsumerPolicy != SlowConsumerBlock {
+		return server.overflow(client, frame)
+	}
+
+	select {
+	case client.outbound <- frame:
+		server.checkCongestion(client)
+		return nil
+	case <-client.done:
+		frame.release()
+		return ErrClientGone
+	case <-ctx.Done():
+		frame.release()
+		return ctx.Err()
+	}
+}
+
+// writeFrame queues a serialized frame for the client.
+func (server *Server) writeFrame(client *Client, payload []byte) error {
+	return server.enqueue(client, outboundFrame{parts: [][]byte{payload}})
+}
+
+// flush waits until every frame queued so far has been written, the client has gone, or the timeout passes.
+func (server *Server) flush(client *Client, timeout time.Duration) {
+	flushed := make(chan struct{})
+	if server.enqueue(client, outboundFrame{flushed: flushed}) != nil {
+		return
+	}
+
+	timer := server.clock().NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-flushed:
+	case <-client.done:
+	case <-timer.C():
+	}
+}
+
+// writePump is the only goroutine that writes to a client's connection, as gorilla/websocket requires.
+func (server *Server) writePump(client *Client) {
+	for {
+		select {
+		case frame := <-client.outbound:
+			server.writeOutbound(client, &frame)
+			server.checkCongestionCleared(client)
+		case <-client.done:
+			discardQueued(client)
+			return
+		}
+	}
+}
+
+func (server *Server) writeOutbound(client *Client, frame *outboundFrame) {
+	if frame.conflated != nil {
+		conflated, ok := frame.conflated.take()
+		if !ok {
+			return
+		}
+
+		*frame = conflated
+	}
+
+	defer frame.release()
+	if frame.flushed != nil {
+		close(frame.flushed)
+		return
+	}
+
+	if expired(frame.expires, server.clock().Now()) {
+		server.Sugar.Debugf("[%d] dropping expired message", client.Uid)
+		return
+	}
+
+	switch server.Faults.outbound(server.clock()) {
+	case faultDrop:
+		return
+	case faultDisconnect:
+		server.Sugar.Warnf("[%d] fault injection: closing connection", client.Uid)
+		_ = client.transport.Close()
+		return
+	}
+
+	if server.Recorder != nil || server.DevConsole != nil {
+		server.record(client, DirectionOutbound, frame.payload())
+	}
+
+	timeout := server.WriteTimeout
+	if timeout <= 0 {
+		timeout = defaultWriteTimeout
+	}
+
+	// deadlines are kept by the network poller, so they're always on the system clock
+	_ = client.transport.setWriteDeadline(time.Now().Add(timeout))
+
+	var err error
+	if frame.prepared != nil {
+		err = client.transport.writePrepared(frame.prepared)
+	} else {
+		err = client.transport.writeParts(frame.parts, frame.binary || (frame.shared != nil && frame.shared.binary))
+	}
+
+	if err != nil {
+		server.metrics.writeErrors.Add(1)
+		server.Sugar.Debugf("[%d] unable to write frame: %v", client.Uid, err)
+		server.reportError(client, err)
+		return
+	}
+
+	client.sent(server.clock().Now())
+	if client.timeline != nil {
+		client.timeline.framesOut.Add(1)
+	}
+
+	head := frame.head()
+	server.metrics.frameSent(head)
+	if bytes.HasPrefix(head, messagePrefix) {
+		client.usage.messages.Add(1)
+	}
+
+	client.usage.bytes.Add(uint64(frame.size()))
+}